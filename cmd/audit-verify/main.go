@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go-delivery-app/internal/db"
+	"go-delivery-app/internal/services"
+	"log"
+	"os"
+)
+
+// audit-verify checks the hash chain of the audit log and exits with status 1 when it is broken.
+// Keep the last hash it prints outside the database and pass it with -expect to also detect
+// entries removed from the end of the log since then.
+func main() {
+	expect := flag.String("expect", "", "hash of the last entry from a previous run, which must still be in the chain")
+	flag.Parse()
+
+	if err := db.ConnectDatabase(); err != nil {
+		log.Fatalf("Could not connect to the database: %v", err)
+	}
+
+	result, err := services.VerifyAuditLog(db.DB)
+	if err != nil {
+		log.Fatalf("Failed to read the audit log: %v", err)
+	}
+
+	if result.BrokenAt != nil {
+		fmt.Printf("Audit log BROKEN at entry %d: %s\n", *result.BrokenAt, result.Problem)
+		fmt.Printf("%d entries verified before it, last good hash %s\n", result.Entries, result.LastHash)
+		os.Exit(1)
+	}
+	if *expect != "" {
+		var count int64
+		if err := db.DB.Table("audit_log").Where("hash = ?", *expect).Count(&count).Error; err != nil {
+			log.Fatalf("Failed to read the audit log: %v", err)
+		}
+		if count == 0 {
+			fmt.Printf("Audit log BROKEN: the expected hash %s is no longer in the chain\n", *expect)
+			os.Exit(1)
+		}
+	}
+	fmt.Printf("Audit log intact: %d entries verified, last hash %s\n", result.Entries, result.LastHash)
+}
@@ -4,6 +4,7 @@ import (
 	"go-delivery-app/internal/db"
 	"go-delivery-app/internal/notifications"
 	"go-delivery-app/internal/routes"
+	"go-delivery-app/internal/services"
 	"log"
 	"net/http"
 )
@@ -17,12 +18,29 @@ func main() {
 	// Run migrations
 	db.RunMigrations(db.DB)
 
+	// Give tracking codes to parcels created before they existed
+	services.BackfillTrackingCodes(db.DB)
+
 	// Initialize routes from the routes package
 	router := routes.InitializeRoutes()
 
 	// Start RabbitMQ consumers to process notifications
 	go notifications.ConsumeNotifications("notifications_sender_queue")
 	go notifications.ConsumeNotifications("notifications_motorbike_queue")
+	go notifications.ConsumeRecipientNotifications("notifications_recipient_queue")
+	go notifications.ConsumeAccountNotifications("notifications_account_queue")
+
+	// Finish the parcel imports interrupted by a restart
+	services.ResumeImportJobs(db.DB)
+
+	// Expand recurring pickup schedules into parcels
+	go services.RunScheduleExpander(db.DB)
+
+	// Watch the ETA of parcels on their way and warn senders of delays
+	go services.RunDelayMonitor(db.DB)
+
+	// Close finished conversations and delete old ones
+	go services.RunChatJanitor(db.DB)
 
 	// Start the server
 	log.Fatal(http.ListenAndServe(":8080", router))
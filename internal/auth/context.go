@@ -4,8 +4,6 @@ import (
 	"context"
 	"log"
 	"strconv"
-
-	"github.com/golang-jwt/jwt/v4"
 )
 
 type contextKey string
@@ -19,7 +17,7 @@ type UserClaims struct {
 }
 
 // AddUserToContext adds the user claims from the JWT to the context
-func AddUserToContext(ctx context.Context, claims *jwt.StandardClaims) context.Context {
+func AddUserToContext(ctx context.Context, claims *Claims) context.Context {
 	// Convert the UserID from string to uint
 	userID, err := strconv.ParseUint(claims.Subject, 10, 64)
 	if err != nil {
@@ -29,6 +27,7 @@ func AddUserToContext(ctx context.Context, claims *jwt.StandardClaims) context.C
 
 	userClaims := UserClaims{
 		UserID: uint(userID), // Cast uint64 to uint
+		Role:   claims.Role,
 	}
 
 	log.Printf("UserID added to context: %d", userClaims.UserID) // Log for debugging
@@ -8,11 +8,21 @@ import (
 	"github.com/golang-jwt/jwt/v4"
 )
 
+// Claims are the claims stored in the JWT token
+type Claims struct {
+	Role string `json:"role"`
+	jwt.StandardClaims
+}
+
 // GenerateToken creates a JWT token for a user
 func GenerateToken(userID uint, role string) (string, error) {
-	claims := &jwt.StandardClaims{
-		ExpiresAt: time.Now().Add(72 * time.Hour).Unix(), // Token expiration
-		Subject:   strconv.Itoa(int(userID)),             // Store the userID as string in the token
+	claims := &Claims{
+		Role: role,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(72 * time.Hour).Unix(), // Token expiration
+			IssuedAt:  time.Now().Unix(),                     // Tokens issued before a revocation are refused
+			Subject:   strconv.Itoa(int(userID)),             // Store the userID as string in the token
+		},
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestGenerateTokenCarriesTheRole(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	tests := []struct {
+		userID uint
+		role   string
+	}{
+		{1, "admin"},
+		{2, "sender"},
+		{3, "motorbike"},
+	}
+	for _, tt := range tests {
+		tokenStr, err := GenerateToken(tt.userID, tt.role)
+		if err != nil {
+			t.Fatalf("GenerateToken(%d, %q) error = %v", tt.userID, tt.role, err)
+		}
+		token, err := jwt.ParseWithClaims(tokenStr, &Claims{}, func(token *jwt.Token) (interface{}, error) {
+			return []byte("test-secret"), nil
+		})
+		if err != nil || !token.Valid {
+			t.Fatalf("ParseWithClaims() error = %v", err)
+		}
+
+		userClaims, ok := GetUserFromContext(AddUserToContext(context.Background(), token.Claims.(*Claims)))
+		if !ok || userClaims.UserID != tt.userID || userClaims.Role != tt.role {
+			t.Errorf("claims in context = %+v, want user %d with role %q", userClaims, tt.userID, tt.role)
+		}
+	}
+}
+
+func TestAddUserToContextRejectsInvalidSubjects(t *testing.T) {
+	for _, subject := range []string{"", "abc", "-1"} {
+		claims := &Claims{Role: "admin"}
+		claims.Subject = subject
+		if _, ok := GetUserFromContext(AddUserToContext(context.Background(), claims)); ok {
+			t.Errorf("subject %q added a user to the context", subject)
+		}
+	}
+}
@@ -1,16 +1,30 @@
 package handlers
 
 import (
+	"encoding/csv"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"go-delivery-app/internal/auth"
 	"go-delivery-app/internal/db"
 	"go-delivery-app/internal/models"
+	"go-delivery-app/internal/services"
 	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
 )
 
-// GetAllParcels allows admin to see all parcels
+// GetAllParcels allows admin to see all parcels, only the ones delivered after their window with ?late=true
 func GetAllParcels(w http.ResponseWriter, r *http.Request) {
 	var parcels []models.Parcel
-	db.DB.Find(&parcels)
+	query := db.DB
+	if r.URL.Query().Get("late") == "true" {
+		query = query.Where("delivered_late")
+	}
+	query.Find(&parcels)
 
 	// Start the response header with application/json content-type
 	w.Header().Set("Content-Type", "application/json")
@@ -47,3 +61,297 @@ func GetUsers(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(user)
 	}
 }
+
+// CreatePayoutBatch allows admin to pay out all outstanding courier earnings, exported as CSV
+func CreatePayoutBatch(w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := auth.GetUserFromContext(r.Context())
+	if !ok || userClaims.UserID == 0 {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+
+	var batch *models.PayoutBatch
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		batch, err = services.CreatePayoutBatch(tx, userClaims.UserID)
+		return err
+	})
+	if err != nil {
+		http.Error(w, "Failed to create the payout batch", http.StatusInternalServerError)
+		return
+	}
+
+	writePayoutBatchCSV(w, batch.ID, http.StatusCreated)
+}
+
+// ExportPayoutBatch allows admin to download a previously created payout batch as CSV
+func ExportPayoutBatch(w http.ResponseWriter, r *http.Request) {
+	batchID, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid payout batch ID", http.StatusBadRequest)
+		return
+	}
+
+	var batch models.PayoutBatch
+	db.DB.First(&batch, batchID)
+	if batch.ID == 0 {
+		http.Error(w, "Payout batch not found", http.StatusNotFound)
+		return
+	}
+
+	writePayoutBatchCSV(w, batch.ID, http.StatusOK)
+}
+
+// writePayoutBatchCSV writes one line per courier paid in the batch
+func writePayoutBatchCSV(w http.ResponseWriter, batchID uint, status int) {
+	lines, err := services.PayoutLines(db.DB, batchID)
+	if err != nil {
+		http.Error(w, "Failed to load the payout batch", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"payout-batch-%d.csv\"", batchID))
+	w.WriteHeader(status)
+
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"batch_id", "courier_id", "name", "email", "amount_cents"})
+	for _, line := range lines {
+		writer.Write([]string{
+			strconv.FormatUint(uint64(batchID), 10),
+			strconv.FormatUint(uint64(line.CourierID), 10),
+			line.Name,
+			line.Email,
+			strconv.FormatInt(line.AmountCents, 10),
+		})
+	}
+	writer.Flush()
+}
+
+// GetCashOnHand allows admin to see the cash on delivery money each courier still holds
+func GetCashOnHand(w http.ResponseWriter, r *http.Request) {
+	cash, err := services.CashOnHand(db.DB)
+	if err != nil {
+		http.Error(w, "Failed to load cash on hand", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cash)
+}
+
+// RecordCashHandIn allows admin to record the cash a courier handed in at the hub
+func RecordCashHandIn(w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := auth.GetUserFromContext(r.Context())
+	if !ok || userClaims.UserID == 0 {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+
+	var input struct {
+		CourierID   uint   `json:"courier_id"`
+		AmountCents int64  `json:"amount_cents"`
+		Note        string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if input.AmountCents < 0 {
+		http.Error(w, "amount_cents cannot be negative", http.StatusBadRequest)
+		return
+	}
+
+	var courier models.User
+	db.DB.First(&courier, input.CourierID)
+	if courier.ID == 0 || courier.Role != "motorbike" {
+		http.Error(w, "Courier not found", http.StatusNotFound)
+		return
+	}
+
+	var handIn *models.CashHandIn
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		handIn, err = services.RecordCashHandIn(tx, courier.ID, input.AmountCents, input.Note, userClaims.UserID)
+		return err
+	})
+	if err != nil {
+		http.Error(w, "Failed to record the cash hand-in", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(handIn)
+}
+
+// GetCODDiscrepancies allows admin to see hand-ins that did not match the expected cash
+// and deliveries where the collected cash differs from the amount to collect
+func GetCODDiscrepancies(w http.ResponseWriter, r *http.Request) {
+	from, to, err := parseDateRange(r, 30)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	handIns := []models.CashHandIn{}
+	result := db.DB.Where("discrepancy_cents <> 0 AND created_at >= ? AND created_at < ?", from, to).
+		Order("created_at").Find(&handIns)
+	if result.Error != nil {
+		http.Error(w, "Failed to load discrepancies", http.StatusInternalServerError)
+		return
+	}
+
+	parcels, err := services.CODMismatches(db.DB, from, to)
+	if err != nil {
+		http.Error(w, "Failed to load discrepancies", http.StatusInternalServerError)
+		return
+	}
+
+	var missing int64
+	for _, handIn := range handIns {
+		missing += handIn.ShortfallCents
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"from":                  from,
+		"to":                    to,
+		"hand_ins":              handIns,
+		"parcels":               parcels,
+		"total_shortfall_cents": missing,
+	})
+}
+
+// GetCourierCancellations allows admin to see how often each motorbike cancels the parcels it picks up, worst first
+func GetCourierCancellations(w http.ResponseWriter, r *http.Request) {
+	stats, err := services.CourierCancellationRates(db.DB, 0, time.Now())
+	if err != nil {
+		http.Error(w, "Failed to load cancellation rates", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"window_days": int(services.CourierCancelWindow().Hours() / 24),
+		"max_rate":    services.CourierCancelMaxRate(),
+		"couriers":    stats,
+	})
+}
+
+// GetCourierLeaderboard allows admin to rank the motorbikes by rating score
+// (?window=30|90|all, 30 by default, ?min_ratings=, ?limit= up to 100)
+func GetCourierLeaderboard(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	days := 30
+	switch window := query.Get("window"); window {
+	case "", "30":
+	case "90":
+		days = 90
+	case "all":
+		days = 0
+	default:
+		http.Error(w, "window must be 30, 90 or all", http.StatusBadRequest)
+		return
+	}
+
+	minRatings := 1
+	if value := query.Get("min_ratings"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			http.Error(w, "min_ratings must be a positive number", http.StatusBadRequest)
+			return
+		}
+		minRatings = n
+	}
+
+	limit := 20
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > 100 {
+			http.Error(w, "limit must be between 1 and 100", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	entries, err := services.CourierLeaderboard(db.DB, days, minRatings, limit, time.Now())
+	if err != nil {
+		http.Error(w, "Failed to load the leaderboard", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"window_days":  days,
+		"prior_weight": services.RatingPriorWeight(),
+		"couriers":     entries,
+	})
+}
+
+// GetGeofenceFlags allows admin to review the pickups and deliveries confirmed outside the geofence, farthest first
+func GetGeofenceFlags(w http.ResponseWriter, r *http.Request) {
+	from, to, err := parseDateRange(r, 30)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	flags, err := services.GeofenceFlags(db.DB, from, to)
+	if err != nil {
+		http.Error(w, "Failed to load the geofence report", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"from":          from,
+		"to":            to,
+		"radius_meters": services.GeofenceRadiusMeters(),
+		"mode":          services.GeofenceMode(),
+		"scans":         flags,
+	})
+}
+
+// GetRatingModerationQueue allows admin to review the rating comments nobody moderated yet, oldest first
+func GetRatingModerationQueue(w http.ResponseWriter, r *http.Request) {
+	var ratings []models.Rating
+	if err := db.DB.Where("moderation_status = ?", services.ModerationPending).Order("created_at").Find(&ratings).Error; err != nil {
+		http.Error(w, "Failed to load the moderation queue", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ratings)
+}
+
+// HideRating allows admin to hide an abusive rating comment
+func HideRating(w http.ResponseWriter, r *http.Request) {
+	moderateRating(w, r, services.ModerationHidden)
+}
+
+// ApproveRating allows admin to approve a rating comment, removing it from the moderation queue
+func ApproveRating(w http.ResponseWriter, r *http.Request) {
+	moderateRating(w, r, services.ModerationApproved)
+}
+
+func moderateRating(w http.ResponseWriter, r *http.Request, status string) {
+	userClaims, ok := auth.GetUserFromContext(r.Context())
+	if !ok || userClaims.UserID == 0 {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+
+	rating, err := services.ModerateRating(db.DB, mux.Vars(r)["id"], userClaims.UserID, status, time.Now())
+	if errors.Is(err, services.ErrRatingNotFound) {
+		http.Error(w, "Rating comment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to moderate the rating", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rating)
+}
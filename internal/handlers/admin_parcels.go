@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"go-delivery-app/internal/auth"
+	"go-delivery-app/internal/db"
+	"go-delivery-app/internal/models"
+	"go-delivery-app/internal/services"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// AssignParcel allows admin to hand a parcel to a chosen motorbike, whether it is waiting for a courier
+// or already carried by another one
+func AssignParcel(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		MotorbikeID uint   `json:"motorbike_id"`
+		Reason      string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	applyAdminAction(w, r, func(tx *gorm.DB, parcel *models.Parcel, adminID uint, now time.Time) (*models.ParcelAdminAction, error) {
+		return services.AssignParcel(tx, parcel, adminID, input.MotorbikeID, input.Reason, now)
+	})
+}
+
+// OverrideParcelStatus allows admin to move a parcel to another status with a reason, for example to mark it lost
+func OverrideParcelStatus(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Status string `json:"status"`
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	applyAdminAction(w, r, func(tx *gorm.DB, parcel *models.Parcel, adminID uint, now time.Time) (*models.ParcelAdminAction, error) {
+		return services.OverrideParcelStatus(tx, parcel, adminID, input.Status, input.Reason, now)
+	})
+}
+
+// AddParcelNote allows admin to leave an internal note on a parcel
+func AddParcelNote(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Note string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	applyAdminAction(w, r, func(tx *gorm.DB, parcel *models.Parcel, adminID uint, now time.Time) (*models.ParcelAdminAction, error) {
+		return services.AddParcelNote(tx, parcel, adminID, input.Note, now)
+	})
+}
+
+// RefundParcel allows admin to refund part or all of what the sender paid for a parcel to their wallet
+func RefundParcel(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		AmountCents *int64 `json:"amount_cents"`
+		Reason      string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	applyAdminAction(w, r, func(tx *gorm.DB, parcel *models.Parcel, adminID uint, now time.Time) (*models.ParcelAdminAction, error) {
+		return services.RefundParcelByAdmin(tx, parcel, adminID, input.AmountCents, input.Reason, now)
+	})
+}
+
+// GetParcelAdminActions allows admin to see the actions taken on a parcel and its internal notes, oldest first
+func GetParcelAdminActions(w http.ResponseWriter, r *http.Request) {
+	var parcel models.Parcel
+	db.DB.Limit(1).Find(&parcel, mux.Vars(r)["id"])
+	if parcel.ID == 0 {
+		http.Error(w, "Parcel not found", http.StatusNotFound)
+		return
+	}
+
+	actions := []models.ParcelAdminAction{}
+	if err := db.DB.Where("parcel_id = ?", parcel.ID).Order("created_at, id").Find(&actions).Error; err != nil {
+		http.Error(w, "Failed to load the parcel actions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(actions)
+}
+
+// applyAdminAction locks the parcel in the URL, applies an admin action to it, records it in the audit log
+// and notifies the people involved
+func applyAdminAction(w http.ResponseWriter, r *http.Request,
+	apply func(tx *gorm.DB, parcel *models.Parcel, adminID uint, now time.Time) (*models.ParcelAdminAction, error)) {
+	userClaims, ok := auth.GetUserFromContext(r.Context())
+	if !ok || userClaims.UserID == 0 {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+
+	var parcel *models.Parcel
+	var action *models.ParcelAdminAction
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		parcel, err = services.LockParcel(tx, mux.Vars(r)["id"])
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		action, err = apply(tx, parcel, userClaims.UserID, now)
+		if err != nil {
+			return err
+		}
+		event := newAuditEvent(r, services.AuditParcelAdminOverride+action.Action, services.AuditTargetParcel, parcel.ID)
+		event.Before = map[string]interface{}{"status": action.StatusBefore, "motorbike_id": action.MotorbikeBefore}
+		event.After = map[string]interface{}{
+			"status":       action.StatusAfter,
+			"motorbike_id": action.MotorbikeAfter,
+			"note":         action.Note,
+			"amount_cents": action.AmountCents,
+		}
+		return services.RecordAudit(tx, event, now)
+	})
+	switch {
+	case errors.Is(err, services.ErrParcelNotFound):
+		http.Error(w, "Parcel not found", http.StatusNotFound)
+		return
+	case errors.Is(err, services.ErrInvalidStatus):
+		http.Error(w, "Unknown status, use one of: "+strings.Join(services.OverrideStatuses(), ", "), http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrAdminNoteRequired), errors.Is(err, services.ErrAssigneeNotCourier),
+		errors.Is(err, services.ErrInvalidRefundAmount):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrParcelClosed), errors.Is(err, services.ErrStatusTransition),
+		errors.Is(err, services.ErrAlreadyAssigned), errors.Is(err, services.ErrNothingToRefund):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Failed to update the parcel", http.StatusInternalServerError)
+		return
+	}
+
+	services.NotifyAdminAction(parcel, action)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"parcel": parcel,
+		"action": action,
+	})
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"encoding/json"
+	"go-delivery-app/internal/db"
+	"go-delivery-app/internal/services"
+	"net/http"
+)
+
+// GetParcelVolumeAnalytics allows admin to count the parcels created per period by their current status
+// (?from=&to= as YYYY-MM-DD, the last 30 days by default, ?group_by=day|week|month,service_level)
+func GetParcelVolumeAnalytics(w http.ResponseWriter, r *http.Request) {
+	serveAnalytics(w, r, []string{services.GroupByServiceLevel}, func(q services.AnalyticsQuery) (interface{}, error) {
+		return services.ParcelVolumes(db.DB, q)
+	})
+}
+
+// GetPickupLatencyAnalytics allows admin to see how long parcels waited from creation to pickup
+// (?group_by=day|week|month,service_level)
+func GetPickupLatencyAnalytics(w http.ResponseWriter, r *http.Request) {
+	serveAnalytics(w, r, []string{services.GroupByServiceLevel}, func(q services.AnalyticsQuery) (interface{}, error) {
+		return services.PickupLatency(db.DB, q)
+	})
+}
+
+// GetDeliveryDurationAnalytics allows admin to see how long parcels took from pickup to delivery
+// (?group_by=day|week|month,service_level,courier)
+func GetDeliveryDurationAnalytics(w http.ResponseWriter, r *http.Request) {
+	serveAnalytics(w, r, []string{services.GroupByServiceLevel, services.GroupByCourier}, func(q services.AnalyticsQuery) (interface{}, error) {
+		return services.DeliveryDuration(db.DB, q)
+	})
+}
+
+// GetCancellationAnalytics allows admin to see the share of parcels that were canceled and by whom
+// (?group_by=day|week|month,service_level)
+func GetCancellationAnalytics(w http.ResponseWriter, r *http.Request) {
+	serveAnalytics(w, r, []string{services.GroupByServiceLevel}, func(q services.AnalyticsQuery) (interface{}, error) {
+		return services.CancellationRates(db.DB, q)
+	})
+}
+
+// GetCourierUtilizationAnalytics allows admin to compare the time couriers spent carrying parcels with the
+// time they were online (?group_by=day|week|month,courier)
+func GetCourierUtilizationAnalytics(w http.ResponseWriter, r *http.Request) {
+	serveAnalytics(w, r, []string{services.GroupByCourier}, func(q services.AnalyticsQuery) (interface{}, error) {
+		return services.CourierUtilizations(db.DB, q)
+	})
+}
+
+// GetRatingAnalytics allows admin to follow the ratings given over time
+// (?direction=courier|sender, courier by default, ?group_by=day|week|month,courier)
+func GetRatingAnalytics(w http.ResponseWriter, r *http.Request) {
+	direction := r.URL.Query().Get("direction")
+	switch direction {
+	case "":
+		direction = services.RateCourier
+	case services.RateCourier, services.RateSender:
+	default:
+		http.Error(w, "direction must be courier or sender", http.StatusBadRequest)
+		return
+	}
+
+	serveAnalytics(w, r, []string{services.GroupByCourier}, func(q services.AnalyticsQuery) (interface{}, error) {
+		return services.RatingTrends(db.DB, q, direction)
+	})
+}
+
+// serveAnalytics reads the date range and the grouping of an analytics request and writes the rows of the report
+func serveAnalytics(w http.ResponseWriter, r *http.Request, dimensions []string,
+	report func(q services.AnalyticsQuery) (interface{}, error)) {
+	from, to, err := parseDateRange(r, 30)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	period, groupBy, err := services.ParseGroupBy(r.URL.Query().Get("group_by"), dimensions...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rows, err := report(services.AnalyticsQuery{From: from, To: to, Period: period, GroupBy: groupBy})
+	if err != nil {
+		http.Error(w, "Failed to compute the report", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"from":     from.Format(dateLayout),
+		"to":       to.AddDate(0, 0, -1).Format(dateLayout),
+		"group_by": append([]string{period}, groupBy...),
+		"rows":     rows,
+	})
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"encoding/json"
+	"go-delivery-app/internal/auth"
+	"go-delivery-app/internal/db"
+	"go-delivery-app/internal/services"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// newAuditEvent starts an audit event for an action taken in a request, by the authenticated user if there is one
+func newAuditEvent(r *http.Request, action, targetType string, targetID uint) services.AuditEvent {
+	event := services.AuditEvent{
+		Action:     action,
+		TargetType: targetType,
+		IP:         clientIP(r),
+		UserAgent:  r.UserAgent(),
+	}
+	if targetID != 0 {
+		event.TargetID = strconv.FormatUint(uint64(targetID), 10)
+	}
+	if userClaims, ok := auth.GetUserFromContext(r.Context()); ok && userClaims.UserID != 0 {
+		actorID := userClaims.UserID
+		event.ActorID = &actorID
+		event.ActorRole = userClaims.Role
+	}
+	return event
+}
+
+// clientIP returns the address the request came from
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// GetAuditLog allows admin to search the audit log, most recent first
+// (?actor_id=, ?action= exact or a prefix ending with a dot such as user., ?target_type=, ?target_id=,
+// ?from=&to= as YYYY-MM-DD, ?before_id= to get the next page, ?limit= up to 500)
+func GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := services.AuditFilter{
+		Action:     query.Get("action"),
+		TargetType: query.Get("target_type"),
+		TargetID:   query.Get("target_id"),
+	}
+	if value := query.Get("actor_id"); value != "" {
+		actorID, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			http.Error(w, "actor_id must be a user ID", http.StatusBadRequest)
+			return
+		}
+		id := uint(actorID)
+		filter.ActorID = &id
+	}
+	if value := query.Get("before_id"); value != "" {
+		beforeID, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			http.Error(w, "before_id must be an audit entry ID", http.StatusBadRequest)
+			return
+		}
+		filter.BeforeID = uint(beforeID)
+	}
+	if query.Get("from") != "" || query.Get("to") != "" {
+		from, to, err := parseDateRange(r, 30)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter.From, filter.To = &from, &to
+	}
+	filter.Limit, _ = strconv.Atoi(query.Get("limit"))
+
+	entries, err := services.AuditEntries(db.DB, filter)
+	if err != nil {
+		http.Error(w, "Failed to load the audit log", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{"entries": entries}
+	if len(entries) > 0 {
+		response["next_before_id"] = entries[len(entries)-1].ID
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -2,40 +2,72 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"go-delivery-app/internal/auth"
 	"go-delivery-app/internal/db"
 	"go-delivery-app/internal/models"
+	"go-delivery-app/internal/services"
+	"log"
 	"net/http"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
-// RegisterUser handles user registration
+// RegisterUser handles user registration. Anyone can sign up as a sender or a motorbike, the other roles
+// are given by admin.
 func RegisterUser(w http.ResponseWriter, r *http.Request) {
-	var user models.User
+	var req struct {
+		Name     string `json:"name"`
+		Email    string `json:"email"`
+		Password string `json:"password"`
+		Role     string `json:"role"` // sender (default) or motorbike
+	}
 
-	err := json.NewDecoder(r.Body).Decode(&user)
+	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
 		http.Error(w, "Invalid request payload", http.StatusBadRequest)
 		return
 	}
+	if req.Email == "" || req.Password == "" {
+		http.Error(w, "email and password are required", http.StatusBadRequest)
+		return
+	}
+	switch req.Role {
+	case "":
+		req.Role = "sender"
+	case "sender", services.RoleMotorbike:
+	default:
+		http.Error(w, "role must be sender or motorbike", http.StatusBadRequest)
+		return
+	}
 
-	// Check if the email is already registered
+	// Check if the email is already registered, deleted accounts included
 	var existingUser models.User
-	db.DB.Where("email = ?", user.Email).First(&existingUser)
+	db.DB.Unscoped().Where("email = ?", req.Email).First(&existingUser)
 	if existingUser.ID != 0 {
 		http.Error(w, "Email already registered", http.StatusBadRequest)
 		return
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
 		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
 		return
 	}
-	user.Password = string(hashedPassword)
+	user := models.User{
+		Name:     req.Name,
+		Email:    req.Email,
+		Password: string(hashedPassword),
+		Role:     req.Role,
+		Status:   services.UserActive,
+	}
 
-	db.DB.Create(&user)
+	if err := db.DB.Create(&user).Error; err != nil {
+		http.Error(w, "Failed to register user", http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -58,22 +90,87 @@ func LoginUser(w http.ResponseWriter, r *http.Request) {
 	var user models.User
 	db.DB.Where("email = ?", req.Email).First(&user)
 	if user.ID == 0 {
+		auditLogin(r, &user, req.Email, "unknown email")
 		http.Error(w, "Invalid email or password", http.StatusUnauthorized)
 		return
 	}
 
 	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password))
 	if err != nil {
+		auditLogin(r, &user, req.Email, "wrong password")
 		http.Error(w, "Invalid email or password", http.StatusUnauthorized)
 		return
 	}
 
+	if user.Status == services.UserSuspended {
+		auditLogin(r, &user, req.Email, "account suspended")
+		http.Error(w, services.ErrAccountSuspended.Error(), http.StatusForbidden)
+		return
+	}
+	if user.PasswordResetRequired {
+		auditLogin(r, &user, req.Email, "password reset required")
+		http.Error(w, services.ErrPasswordResetRequired.Error(), http.StatusForbidden)
+		return
+	}
+
 	token, err := auth.GenerateToken(user.ID, user.Role)
 	if err != nil {
 		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 		return
 	}
+	auditLogin(r, &user, req.Email, "")
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"token": token})
 }
+
+// ResetPassword lets a user whose password reset was forced by admin choose a new password with the
+// one-time token they were given
+func ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		user, err := services.ResetPassword(tx, req.Token, req.Password, time.Now())
+		if err != nil {
+			return err
+		}
+		event := newAuditEvent(r, services.AuditPasswordReset, services.AuditTargetUser, user.ID)
+		event.ActorID, event.ActorRole = &user.ID, user.Role
+		return services.RecordAudit(tx, event, time.Now())
+	})
+	switch {
+	case errors.Is(err, services.ErrPasswordTooShort), errors.Is(err, services.ErrInvalidResetToken):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, "Failed to reset the password", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Password reset successfully, you can now log in"})
+}
+
+// auditLogin records a login in the audit log, failed when a reason is given. A failed login has no
+// actor: whoever tried is not known to be the owner of the account.
+func auditLogin(r *http.Request, user *models.User, email, failure string) {
+	event := newAuditEvent(r, services.AuditLogin, services.AuditTargetUser, user.ID)
+	event.After = map[string]string{"email": email}
+	if failure != "" {
+		event.Action = services.AuditLoginFailed
+		event.After = map[string]string{"email": email, "reason": failure}
+	} else {
+		event.ActorID, event.ActorRole = &user.ID, user.Role
+	}
+	if err := services.RecordAudit(db.DB, event, time.Now()); err != nil {
+		log.Printf("Failed to record login of user %d in the audit log: %v", user.ID, err)
+	}
+}
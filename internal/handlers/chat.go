@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-delivery-app/internal/auth"
+	"go-delivery-app/internal/db"
+	"go-delivery-app/internal/models"
+	"go-delivery-app/internal/notifications"
+	"go-delivery-app/internal/services"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// Request body struct to capture a chat message
+type ChatMessageRequest struct {
+	Body string `json:"body"`
+}
+
+// chatReadEvent tells the author of messages that the other participant read them
+type chatReadEvent struct {
+	ConversationID uint      `json:"conversation_id"`
+	ParcelID       uint      `json:"parcel_id"`
+	LastReadID     uint      `json:"last_read_id"`
+	ReadAt         time.Time `json:"read_at"`
+}
+
+// GetChat allows the sender of a parcel and the motorbike carrying it to read their conversation
+// (?after_id= to only get newer messages, ?limit= up to 100)
+func GetChat(w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := auth.GetUserFromContext(r.Context())
+	if !ok || userClaims.UserID == 0 {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+
+	var afterID uint64
+	if value := r.URL.Query().Get("after_id"); value != "" {
+		var err error
+		if afterID, err = strconv.ParseUint(value, 10, 32); err != nil {
+			http.Error(w, "after_id must be a message ID", http.StatusBadRequest)
+			return
+		}
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	var conversation *models.Conversation
+	var messages []models.ChatMessage
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		conversation, err = loadConversation(tx, r, userClaims.UserID, userClaims.Role)
+		if err != nil {
+			return err
+		}
+		messages, err = services.ChatMessages(tx, conversation, uint(afterID), limit)
+		return err
+	})
+	if writeChatError(w, err) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"conversation": conversation,
+		"messages":     messages,
+	})
+}
+
+// SendChatMessage allows the sender of a parcel and the motorbike carrying it to write to each other
+// while the parcel is on its way. The message is pushed to the other participant's notifications stream.
+func SendChatMessage(w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := auth.GetUserFromContext(r.Context())
+	if !ok || userClaims.UserID == 0 {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+
+	var input ChatMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var conversation *models.Conversation
+	var message *models.ChatMessage
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		conversation, err = loadConversation(tx, r, userClaims.UserID, userClaims.Role)
+		if err != nil {
+			return err
+		}
+		message, err = services.SendChatMessage(tx, conversation, userClaims.UserID, input.Body, time.Now())
+		return err
+	})
+	if writeChatError(w, err) {
+		return
+	}
+
+	// Push the message to the other participant, or leave a notification when they are not connected
+	peer := services.ChatPeer(conversation, userClaims.UserID)
+	if notifications.Connected(peer) {
+		notifications.Push(peer, notifications.StreamEvent{Type: "chat_message", Data: message})
+	} else {
+		queue := "notifications_motorbike_queue"
+		if peer == conversation.SenderID {
+			queue = "notifications_sender_queue"
+		}
+		notifications.PublishNotification(queue, peer, fmt.Sprintf("New message about parcel %d", conversation.ParcelID))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(message)
+}
+
+// MarkChatRead allows a participant to mark the messages they received as read; the author is told
+// on their notifications stream
+func MarkChatRead(w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := auth.GetUserFromContext(r.Context())
+	if !ok || userClaims.UserID == 0 {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+
+	now := time.Now()
+	var conversation *models.Conversation
+	var lastReadID uint
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		conversation, err = loadConversation(tx, r, userClaims.UserID, userClaims.Role)
+		if err != nil {
+			return err
+		}
+		lastReadID, err = services.MarkChatRead(tx, conversation, userClaims.UserID, now)
+		return err
+	})
+	if writeChatError(w, err) {
+		return
+	}
+
+	if lastReadID != 0 {
+		notifications.Push(services.ChatPeer(conversation, userClaims.UserID), notifications.StreamEvent{
+			Type: "chat_read",
+			Data: chatReadEvent{
+				ConversationID: conversation.ID,
+				ParcelID:       conversation.ParcelID,
+				LastReadID:     lastReadID,
+				ReadAt:         now,
+			},
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"last_read_id": lastReadID})
+}
+
+// loadConversation returns the conversation about the parcel in the URL that the user can see
+func loadConversation(tx *gorm.DB, r *http.Request, userID uint, role string) (*models.Conversation, error) {
+	var parcel models.Parcel
+	if err := tx.Limit(1).Find(&parcel, mux.Vars(r)["id"]).Error; err != nil {
+		return nil, err
+	}
+	if parcel.ID == 0 {
+		return nil, services.ErrParcelNotFound
+	}
+	return services.GetConversation(tx, &parcel, userID, role)
+}
+
+// writeChatError answers with the status matching a chat error and reports whether there was one
+func writeChatError(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, services.ErrParcelNotFound):
+		http.Error(w, "Parcel not found", http.StatusNotFound)
+	case errors.Is(err, services.ErrChatNotAllowed):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, services.ErrChatNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, services.ErrChatMessageEmpty):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, services.ErrChatClosed):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, "Failed to load the conversation", http.StatusInternalServerError)
+	}
+	return true
+}
@@ -0,0 +1,304 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-delivery-app/internal/auth"
+	"go-delivery-app/internal/db"
+	"go-delivery-app/internal/models"
+	"go-delivery-app/internal/services"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// OpenClaim allows a sender to ask for compensation for a parcel delivered damaged, incomplete or not at all
+func OpenClaim(w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := auth.GetUserFromContext(r.Context())
+	if !ok || userClaims.UserID == 0 {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+
+	var input services.ClaimInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var claim *models.Claim
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		parcel, err := services.LockParcel(tx, mux.Vars(r)["id"])
+		if err != nil {
+			return err
+		}
+		claim, err = services.OpenClaim(tx, parcel, userClaims.UserID, input, time.Now())
+		return err
+	})
+	switch {
+	case errors.Is(err, services.ErrParcelNotFound):
+		http.Error(w, "Parcel not found", http.StatusNotFound)
+		return
+	case errors.Is(err, services.ErrInvalidClaimType):
+		http.Error(w, "Unknown claim type, use one of: "+strings.Join(services.ClaimTypes(), ", "), http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrClaimDescription), errors.Is(err, services.ErrInvalidClaimAmount):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrClaimNotAllowed), errors.Is(err, services.ErrClaimWindowClosed),
+		errors.Is(err, services.ErrClaimExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Failed to open the claim", http.StatusInternalServerError)
+		return
+	}
+
+	services.NotifyClaim(db.DB, claim,
+		fmt.Sprintf("Your claim #%d has been opened, we will review it shortly.", claim.ID),
+		fmt.Sprintf("A claim (%s) was opened on a parcel you carried.", claim.Type))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(claim)
+}
+
+// ListClaims allows a sender to see their claims, most recent first
+func ListClaims(w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := auth.GetUserFromContext(r.Context())
+	if !ok || userClaims.UserID == 0 {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+
+	claims := []models.Claim{}
+	db.DB.Where("sender_id = ?", userClaims.UserID).Order("created_at DESC").Find(&claims)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(claims)
+}
+
+// GetClaim allows a sender to follow one of their claims, with its evidence and history
+func GetClaim(w http.ResponseWriter, r *http.Request) {
+	claim, ok := loadOwnClaim(w, r)
+	if !ok {
+		return
+	}
+	writeClaimDetail(w, claim)
+}
+
+// AddClaimEvidence allows a sender to attach a photo or a document to a claim under review.
+// The file is sent as the "file" field of a multipart form, with an optional "note" field.
+func AddClaimEvidence(w http.ResponseWriter, r *http.Request) {
+	claim, ok := loadOwnClaim(w, r)
+	if !ok {
+		return
+	}
+
+	// Leave room for the other fields of the form
+	r.Body = http.MaxBytesReader(w, r.Body, services.MaxClaimAttachmentBytes+64<<10)
+	file, header, err := r.FormFile("file")
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		http.Error(w, "The file is too large", http.StatusRequestEntityTooLarge)
+		return
+	case err != nil:
+		http.Error(w, "The evidence must be in the \"file\" field of a multipart form", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, services.MaxClaimAttachmentBytes+1))
+	if err != nil {
+		http.Error(w, "Failed to read the file", http.StatusBadRequest)
+		return
+	}
+	if len(data) > services.MaxClaimAttachmentBytes {
+		http.Error(w, "The file is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	contentType, _, _ := mime.ParseMediaType(header.Header.Get("Content-Type"))
+	if contentType == "" || contentType == "application/octet-stream" {
+		contentType = http.DetectContentType(data)
+	}
+
+	var attachment *models.ClaimAttachment
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		locked, err := services.LockClaim(tx, claim.ID)
+		if err != nil {
+			return err
+		}
+		attachment, err = services.AddClaimAttachment(tx, locked, claim.SenderID, filepath.Base(header.Filename),
+			contentType, data, r.FormValue("note"), time.Now())
+		return err
+	})
+	switch {
+	case errors.Is(err, services.ErrClaimAttachmentType), errors.Is(err, services.ErrClaimAttachmentEmpty),
+		errors.Is(err, services.ErrClaimDescription):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrClaimClosed), errors.Is(err, services.ErrClaimAttachmentsLimit):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Failed to save the evidence", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(attachment)
+}
+
+// GetClaimEvidence allows a sender to download a file attached to one of their claims
+func GetClaimEvidence(w http.ResponseWriter, r *http.Request) {
+	claim, ok := loadOwnClaim(w, r)
+	if !ok {
+		return
+	}
+	writeClaimAttachment(w, r, claim)
+}
+
+// loadOwnClaim loads the claim in the URL, answering 404 unless it belongs to the authenticated sender
+func loadOwnClaim(w http.ResponseWriter, r *http.Request) (*models.Claim, bool) {
+	userClaims, ok := auth.GetUserFromContext(r.Context())
+	if !ok || userClaims.UserID == 0 {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	var claim models.Claim
+	db.DB.Where("id = ? AND sender_id = ?", mux.Vars(r)["id"], userClaims.UserID).Limit(1).Find(&claim)
+	if claim.ID == 0 {
+		http.Error(w, "Claim not found", http.StatusNotFound)
+		return nil, false
+	}
+	return &claim, true
+}
+
+// writeClaimDetail writes a claim with its evidence, its history and the history of its parcel
+func writeClaimDetail(w http.ResponseWriter, claim *models.Claim) {
+	detail, err := services.GetClaimDetail(db.DB, claim)
+	if err != nil {
+		http.Error(w, "Failed to load the claim", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(detail)
+}
+
+// writeClaimAttachment serves the file in the URL if it is attached to the claim
+func writeClaimAttachment(w http.ResponseWriter, r *http.Request, claim *models.Claim) {
+	var attachment models.ClaimAttachment
+	db.DB.Where("id = ? AND claim_id = ?", mux.Vars(r)["evidence_id"], claim.ID).Limit(1).Find(&attachment)
+	if attachment.ID == 0 {
+		http.Error(w, "Evidence not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": attachment.FileName}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write(attachment.Data)
+}
+
+// ListAllClaims allows admin to see the claims, oldest first (?status= to filter, open and investigating by default)
+func ListAllClaims(w http.ResponseWriter, r *http.Request) {
+	query := db.DB.Order("created_at")
+	if status := r.URL.Query().Get("status"); status != "" {
+		query = query.Where("status = ?", status)
+	} else {
+		query = query.Where("status IN ?", []string{services.ClaimOpen, services.ClaimInvestigating})
+	}
+
+	claims := []models.Claim{}
+	if err := query.Find(&claims).Error; err != nil {
+		http.Error(w, "Failed to load claims", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(claims)
+}
+
+// GetClaimForReview allows admin to see a claim with its evidence, its history, the parcel history
+// and the proof of delivery
+func GetClaimForReview(w http.ResponseWriter, r *http.Request) {
+	var claim models.Claim
+	db.DB.Limit(1).Find(&claim, mux.Vars(r)["id"])
+	if claim.ID == 0 {
+		http.Error(w, "Claim not found", http.StatusNotFound)
+		return
+	}
+	writeClaimDetail(w, &claim)
+}
+
+// GetClaimEvidenceForReview allows admin to download a file attached to a claim
+func GetClaimEvidenceForReview(w http.ResponseWriter, r *http.Request) {
+	var claim models.Claim
+	db.DB.Limit(1).Find(&claim, mux.Vars(r)["id"])
+	if claim.ID == 0 {
+		http.Error(w, "Claim not found", http.StatusNotFound)
+		return
+	}
+	writeClaimAttachment(w, r, &claim)
+}
+
+// ReviewClaim allows admin to move a claim to investigating, approved (with the payout) or rejected
+func ReviewClaim(w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := auth.GetUserFromContext(r.Context())
+	if !ok || userClaims.UserID == 0 {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+
+	var input services.ClaimReview
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var claim *models.Claim
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		claim, err = services.LockClaim(tx, mux.Vars(r)["id"])
+		if err != nil {
+			return err
+		}
+		return services.ReviewClaim(tx, claim, userClaims.UserID, input, time.Now())
+	})
+	switch {
+	case errors.Is(err, services.ErrClaimNotFound):
+		http.Error(w, "Claim not found", http.StatusNotFound)
+		return
+	case errors.Is(err, services.ErrClaimPayoutRequired), errors.Is(err, services.ErrInvalidClaimAmount),
+		errors.Is(err, services.ErrClaimDescription):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrClaimClosed), errors.Is(err, services.ErrInvalidClaimStatus):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Failed to update the claim", http.StatusInternalServerError)
+		return
+	}
+
+	senderMessage := fmt.Sprintf("Your claim #%d is now %s.", claim.ID, claim.Status)
+	if claim.Status == services.ClaimApproved && claim.PayoutCents != nil && *claim.PayoutCents > 0 {
+		senderMessage = fmt.Sprintf("Your claim #%d was approved, %d cents were credited to your wallet.", claim.ID, *claim.PayoutCents)
+	}
+	services.NotifyClaim(db.DB, claim, senderMessage,
+		fmt.Sprintf("The claim on a parcel you carried is now %s.", claim.Status))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(claim)
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"encoding/json"
+	"go-delivery-app/internal/auth"
+	"go-delivery-app/internal/db"
+	"go-delivery-app/internal/models"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ListContacts allows a sender to see their address book
+func ListContacts(w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := auth.GetUserFromContext(r.Context())
+	if !ok || userClaims.UserID == 0 {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+
+	contacts := []models.Contact{}
+	db.DB.Where("sender_id = ?", userClaims.UserID).Order("name").Find(&contacts)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(contacts)
+}
+
+// CreateContact allows a sender to add a recipient to their address book
+func CreateContact(w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := auth.GetUserFromContext(r.Context())
+	if !ok || userClaims.UserID == 0 {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+
+	var contact models.Contact
+	if err := json.NewDecoder(r.Body).Decode(&contact); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if msg := validateContact(&contact); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	contact.ID = 0
+	contact.SenderID = userClaims.UserID
+	contact.CreatedAt = time.Now()
+	contact.UpdatedAt = contact.CreatedAt
+	if result := db.DB.Create(&contact); result.Error != nil {
+		http.Error(w, "Failed to save the contact", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(contact)
+}
+
+// GetContact allows a sender to see one of their contacts
+func GetContact(w http.ResponseWriter, r *http.Request) {
+	contact, ok := loadOwnContact(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(contact)
+}
+
+// UpdateContact allows a sender to change one of their contacts
+func UpdateContact(w http.ResponseWriter, r *http.Request) {
+	contact, ok := loadOwnContact(w, r)
+	if !ok {
+		return
+	}
+
+	var input models.Contact
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if msg := validateContact(&input); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	contact.Name = input.Name
+	contact.Phone = input.Phone
+	contact.Email = input.Email
+	contact.Address = input.Address
+	contact.Latitude = input.Latitude
+	contact.Longitude = input.Longitude
+	contact.UpdatedAt = time.Now()
+	if result := db.DB.Save(contact); result.Error != nil {
+		http.Error(w, "Failed to save the contact", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(contact)
+}
+
+// DeleteContact allows a sender to remove a contact; parcels sent to it keep their recipient details
+func DeleteContact(w http.ResponseWriter, r *http.Request) {
+	contact, ok := loadOwnContact(w, r)
+	if !ok {
+		return
+	}
+
+	if result := db.DB.Delete(contact); result.Error != nil {
+		http.Error(w, "Failed to delete the contact", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Contact deleted"})
+}
+
+// loadOwnContact loads the contact in the URL and writes an error unless it belongs to the authenticated sender
+func loadOwnContact(w http.ResponseWriter, r *http.Request) (*models.Contact, bool) {
+	userClaims, ok := auth.GetUserFromContext(r.Context())
+	if !ok || userClaims.UserID == 0 {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	var contact models.Contact
+	db.DB.First(&contact, mux.Vars(r)["id"])
+	if contact.ID == 0 || contact.SenderID != userClaims.UserID {
+		http.Error(w, "Contact not found", http.StatusNotFound)
+		return nil, false
+	}
+	return &contact, true
+}
+
+// validateContact checks the fields of a contact and returns an error message, or "" when it is valid
+func validateContact(contact *models.Contact) string {
+	contact.Name = strings.TrimSpace(contact.Name)
+	if contact.Name == "" {
+		return "name is required"
+	}
+	if contact.Phone == nil && contact.Email == nil {
+		return "phone or email is required"
+	}
+	if (contact.Latitude == nil) != (contact.Longitude == nil) {
+		return "latitude and longitude must be given together"
+	}
+	if contact.Latitude != nil && !validCoordinates(*contact.Latitude, *contact.Longitude) {
+		return "invalid coordinates"
+	}
+	return ""
+}
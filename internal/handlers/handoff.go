@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-delivery-app/internal/auth"
+	"go-delivery-app/internal/db"
+	"go-delivery-app/internal/models"
+	"go-delivery-app/internal/notifications"
+	"go-delivery-app/internal/services"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// Request body struct to capture why a motorbike gives a parcel back
+type ReleaseParcelRequest struct {
+	Reason    string   `json:"reason"`
+	Latitude  *float64 `json:"latitude"`  // Where the parcel is left, defaults to the last reported location
+	Longitude *float64 `json:"longitude"` // Nullable field
+}
+
+// ReleaseParcel allows a motorbike that cannot finish its leg, for example after a breakdown, to return the
+// parcel to the pool for another motorbike to pick up. The parcel is not canceled.
+func ReleaseParcel(w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := auth.GetUserFromContext(r.Context())
+	if !ok || userClaims.UserID == 0 {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+	if userClaims.Role != services.RoleMotorbike {
+		http.Error(w, "Only motorbikes can release parcels", http.StatusForbidden)
+		return
+	}
+
+	var input ReleaseParcelRequest
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if (input.Latitude == nil) != (input.Longitude == nil) ||
+		(input.Latitude != nil && !validCoordinates(*input.Latitude, *input.Longitude)) {
+		http.Error(w, "Invalid coordinates", http.StatusBadRequest)
+		return
+	}
+
+	var parcel *models.Parcel
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		parcel, err = services.LockParcel(tx, mux.Vars(r)["id"])
+		if err != nil {
+			return err
+		}
+		return services.ReleaseParcel(tx, parcel, userClaims.UserID, userClaims.UserID, input.Reason, input.Latitude, input.Longitude)
+	})
+	switch {
+	case errors.Is(err, services.ErrParcelNotFound):
+		http.Error(w, "Parcel not found", http.StatusNotFound)
+		return
+	case errors.Is(err, services.ErrReleaseReason):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrNotCarrying):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Failed to release the parcel", http.StatusInternalServerError)
+		return
+	}
+
+	notifications.PublishNotification("notifications_sender_queue", parcel.SenderID,
+		"Your courier had to hand your parcel back, it is waiting for another courier.")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(parcel)
+}
+
+// Request body struct to capture a handoff offer
+type HandoffRequest struct {
+	ToMotorbikeID uint   `json:"to_motorbike_id"`
+	Note          string `json:"note"`
+}
+
+// RequestHandoff allows a motorbike to offer the parcel it carries to another motorbike, who has to accept it
+func RequestHandoff(w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := auth.GetUserFromContext(r.Context())
+	if !ok || userClaims.UserID == 0 {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+	if userClaims.Role != services.RoleMotorbike {
+		http.Error(w, "Only motorbikes can hand parcels over", http.StatusForbidden)
+		return
+	}
+
+	var input HandoffRequest
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.ToMotorbikeID == 0 {
+		http.Error(w, "to_motorbike_id is required", http.StatusBadRequest)
+		return
+	}
+
+	var handoff *models.ParcelHandoff
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		parcel, err := services.LockParcel(tx, mux.Vars(r)["id"])
+		if err != nil {
+			return err
+		}
+		handoff, err = services.RequestHandoff(tx, parcel, userClaims.UserID, input.ToMotorbikeID, input.Note)
+		return err
+	})
+	switch {
+	case errors.Is(err, services.ErrParcelNotFound):
+		http.Error(w, "Parcel not found", http.StatusNotFound)
+		return
+	case errors.Is(err, services.ErrHandoffToSelf), errors.Is(err, services.ErrReceiverNotCourier):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrNotCarrying), errors.Is(err, services.ErrHandoffPending):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Failed to offer the handoff", http.StatusInternalServerError)
+		return
+	}
+
+	notifications.PublishNotification("notifications_motorbike_queue", handoff.ToMotorbikeID,
+		fmt.Sprintf("A motorbike wants to hand you parcel #%d, accept handoff #%d to take it over.", handoff.ParcelID, handoff.ID))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(handoff)
+}
+
+// ListHandoffs allows a motorbike to see the pending handoffs offered to it or by it
+func ListHandoffs(w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := auth.GetUserFromContext(r.Context())
+	if !ok || userClaims.UserID == 0 {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+
+	handoffs := []models.ParcelHandoff{}
+	db.DB.Where("(to_motorbike_id = ? OR from_motorbike_id = ?) AND status = ? AND expires_at > NOW()",
+		userClaims.UserID, userClaims.UserID, services.HandoffPending).
+		Order("created_at").Find(&handoffs)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(handoffs)
+}
+
+// AcceptHandoff allows a motorbike to take over a parcel offered to it
+func AcceptHandoff(w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := auth.GetUserFromContext(r.Context())
+	if !ok || userClaims.UserID == 0 {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+
+	var handoff *models.ParcelHandoff
+	var parcel *models.Parcel
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		handoff, err = services.LockHandoff(tx, mux.Vars(r)["id"])
+		if err != nil {
+			return err
+		}
+		parcel, err = services.AcceptHandoff(tx, handoff, userClaims.UserID)
+		return err
+	})
+	if writeHandoffError(w, err) {
+		return
+	}
+
+	notifications.PublishNotification("notifications_sender_queue", parcel.SenderID,
+		"Your parcel has been handed over to another courier.")
+	notifications.PublishNotification("notifications_motorbike_queue", handoff.FromMotorbikeID,
+		fmt.Sprintf("Your handoff of parcel #%d has been accepted.", handoff.ParcelID))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(parcel)
+}
+
+// DeclineHandoff allows the receiving motorbike to decline a handoff, or the offering motorbike to withdraw it
+func DeclineHandoff(w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := auth.GetUserFromContext(r.Context())
+	if !ok || userClaims.UserID == 0 {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+
+	var handoff *models.ParcelHandoff
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		handoff, err = services.LockHandoff(tx, mux.Vars(r)["id"])
+		if err != nil {
+			return err
+		}
+		return services.CloseHandoff(tx, handoff, userClaims.UserID)
+	})
+	if writeHandoffError(w, err) {
+		return
+	}
+
+	// Tell the other side
+	if handoff.Status == services.HandoffDeclined {
+		notifications.PublishNotification("notifications_motorbike_queue", handoff.FromMotorbikeID,
+			fmt.Sprintf("Your handoff of parcel #%d has been declined.", handoff.ParcelID))
+	} else {
+		notifications.PublishNotification("notifications_motorbike_queue", handoff.ToMotorbikeID,
+			fmt.Sprintf("The handoff of parcel #%d has been withdrawn.", handoff.ParcelID))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(handoff)
+}
+
+// writeHandoffError writes the response for an error returned while answering a handoff and reports whether there was one
+func writeHandoffError(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, services.ErrHandoffNotFound), errors.Is(err, services.ErrParcelNotFound):
+		http.Error(w, "Handoff not found", http.StatusNotFound)
+	case errors.Is(err, services.ErrHandoffNotPending), errors.Is(err, services.ErrHandoffLegCompleted),
+		errors.Is(err, services.ErrCourierBusy):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, services.ErrCourierBlocked):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		http.Error(w, "Failed to answer the handoff", http.StatusInternalServerError)
+	}
+	return true
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"encoding/json"
+	"go-delivery-app/internal/db"
+	"go-delivery-app/internal/models"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ListHubs lets senders see the active hubs they can route parcels through
+func ListHubs(w http.ResponseWriter, r *http.Request) {
+	hubs := []models.Hub{}
+	db.DB.Where("active").Order("name").Find(&hubs)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hubs)
+}
+
+// ListAllHubs allows admin to see every hub, including inactive ones
+func ListAllHubs(w http.ResponseWriter, r *http.Request) {
+	hubs := []models.Hub{}
+	db.DB.Order("name").Find(&hubs)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hubs)
+}
+
+// CreateHub allows admin to add a hub
+func CreateHub(w http.ResponseWriter, r *http.Request) {
+	var hub models.Hub
+	if err := json.NewDecoder(r.Body).Decode(&hub); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if msg := validateHub(&hub); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	hub.ID = 0
+	hub.Active = true
+	hub.CreatedAt = time.Now()
+	hub.UpdatedAt = hub.CreatedAt
+	if result := db.DB.Create(&hub); result.Error != nil {
+		http.Error(w, "Failed to save the hub", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(hub)
+}
+
+// UpdateHub allows admin to change a hub, or reactivate it by sending "active": true
+func UpdateHub(w http.ResponseWriter, r *http.Request) {
+	var hub models.Hub
+	db.DB.First(&hub, mux.Vars(r)["id"])
+	if hub.ID == 0 {
+		http.Error(w, "Hub not found", http.StatusNotFound)
+		return
+	}
+
+	var input models.Hub
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if msg := validateHub(&input); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	hub.Name = input.Name
+	hub.Address = input.Address
+	hub.Latitude = input.Latitude
+	hub.Longitude = input.Longitude
+	hub.Active = input.Active
+	hub.UpdatedAt = time.Now()
+	if result := db.DB.Save(&hub); result.Error != nil {
+		http.Error(w, "Failed to save the hub", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hub)
+}
+
+// DeleteHub allows admin to deactivate a hub; it is kept for the legs already routed through it
+func DeleteHub(w http.ResponseWriter, r *http.Request) {
+	var hub models.Hub
+	db.DB.First(&hub, mux.Vars(r)["id"])
+	if hub.ID == 0 {
+		http.Error(w, "Hub not found", http.StatusNotFound)
+		return
+	}
+
+	hub.Active = false
+	hub.UpdatedAt = time.Now()
+	if result := db.DB.Save(&hub); result.Error != nil {
+		http.Error(w, "Failed to deactivate the hub", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Hub deactivated"})
+}
+
+// validateHub checks the fields of a hub and returns an error message, or "" when it is valid
+func validateHub(hub *models.Hub) string {
+	hub.Name = strings.TrimSpace(hub.Name)
+	hub.Address = strings.TrimSpace(hub.Address)
+	if hub.Name == "" || hub.Address == "" {
+		return "name and address are required"
+	}
+	if !validCoordinates(hub.Latitude, hub.Longitude) {
+		return "invalid coordinates"
+	}
+	return ""
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"go-delivery-app/internal/auth"
+	"go-delivery-app/internal/db"
+	"go-delivery-app/internal/models"
+	"go-delivery-app/internal/services"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// importJobResponse is an import job with the outcome of its rows
+type importJobResponse struct {
+	models.ImportJob
+	Rows []models.ImportJobRow `json:"rows"`
+}
+
+// ImportParcels allows a sender to create many parcels at once from a CSV or NDJSON upload.
+// The file is sent as the request body or as the "file" field of a multipart form. The rows are
+// processed in the background; the response holds the job ID to follow them with.
+func ImportParcels(w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := auth.GetUserFromContext(r.Context())
+	if !ok || userClaims.UserID == 0 {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, services.MaxImportBytes)
+	body, format, err := importUpload(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer body.Close()
+
+	rows, err := services.ParseImport(body, format)
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		http.Error(w, "The upload is too large", http.StatusRequestEntityTooLarge)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	job, err := services.CreateImportJob(db.DB, userClaims.UserID, format, rows)
+	if err != nil {
+		http.Error(w, "Failed to save the import", http.StatusInternalServerError)
+		return
+	}
+	go services.ProcessImportJob(db.DB, job.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// ListImportJobs allows a sender to see their imports, most recent first
+func ListImportJobs(w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := auth.GetUserFromContext(r.Context())
+	if !ok || userClaims.UserID == 0 {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+
+	jobs := []models.ImportJob{}
+	db.DB.Where("sender_id = ?", userClaims.UserID).Order("created_at DESC").Limit(100).Find(&jobs)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jobs)
+}
+
+// GetImportJob allows a sender to follow an import and see the outcome of every row (?failed=true for failed rows only)
+func GetImportJob(w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := auth.GetUserFromContext(r.Context())
+	if !ok || userClaims.UserID == 0 {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+
+	var job models.ImportJob
+	db.DB.First(&job, mux.Vars(r)["id"])
+	if job.ID == 0 || job.SenderID != userClaims.UserID {
+		http.Error(w, "Import not found", http.StatusNotFound)
+		return
+	}
+
+	response := importJobResponse{ImportJob: job, Rows: []models.ImportJobRow{}}
+	query := db.DB.Where("job_id = ?", job.ID)
+	if r.URL.Query().Get("failed") == "true" {
+		query = query.Where("status = ?", services.RowFailed)
+	}
+	query.Order("row_number").Find(&response.Rows)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// importUpload returns the uploaded file and its format, taken from ?format=, the file name or the content type
+func importUpload(r *http.Request) (io.ReadCloser, string, error) {
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	body := r.Body
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	if contentType == "multipart/form-data" {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			return nil, "", errors.New("the upload must be in the \"file\" field")
+		}
+		body = file
+		contentType = header.Header.Get("Content-Type")
+		if format == "" {
+			format = importFormatFromExtension(header.Filename)
+		}
+	}
+
+	if format == "" {
+		switch contentType {
+		case "text/csv", "application/csv":
+			format = services.ImportCSV
+		case "application/x-ndjson", "application/ndjson", "application/jsonl", "application/x-jsonlines":
+			format = services.ImportNDJSON
+		}
+	}
+	if format == "jsonl" {
+		format = services.ImportNDJSON
+	}
+	if format != services.ImportCSV && format != services.ImportNDJSON {
+		body.Close()
+		return nil, "", services.ErrUnknownImportFormat
+	}
+	return body, format, nil
+}
+
+// importFormatFromExtension guesses the format of an uploaded file from its name
+func importFormatFromExtension(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".csv":
+		return services.ImportCSV
+	case ".ndjson", ".jsonl":
+		return services.ImportNDJSON
+	}
+	return ""
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-delivery-app/internal/auth"
+	"go-delivery-app/internal/db"
+	"go-delivery-app/internal/labels"
+	"go-delivery-app/internal/models"
+	"go-delivery-app/internal/services"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+const maxLabelsPerBatch = 200
+
+// GetParcelLabel allows a sender to download the 4x6 shipping label of one of their parcels as PDF
+func GetParcelLabel(w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := auth.GetUserFromContext(r.Context())
+	if !ok || userClaims.UserID == 0 {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+
+	var parcel models.Parcel
+	db.DB.First(&parcel, mux.Vars(r)["id"])
+	if parcel.ID == 0 {
+		http.Error(w, "Parcel not found", http.StatusNotFound)
+		return
+	}
+	if parcel.SenderID != userClaims.UserID {
+		http.Error(w, "You are not authorized to view this parcel", http.StatusForbidden)
+		return
+	}
+
+	writeLabels(w, userClaims.UserID, []models.Parcel{parcel}, fmt.Sprintf("label-%d.pdf", parcel.ID))
+}
+
+// GetParcelLabels allows a sender to download the labels of many parcels as one multi-page PDF
+func GetParcelLabels(w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := auth.GetUserFromContext(r.Context())
+	if !ok || userClaims.UserID == 0 {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+
+	var input struct {
+		ParcelIDs []uint `json:"parcel_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(input.ParcelIDs) == 0 || len(input.ParcelIDs) > maxLabelsPerBatch {
+		http.Error(w, fmt.Sprintf("parcel_ids must contain between 1 and %d parcels", maxLabelsPerBatch), http.StatusBadRequest)
+		return
+	}
+
+	var parcels []models.Parcel
+	db.DB.Where("id IN ? AND sender_id = ?", input.ParcelIDs, userClaims.UserID).Order("id").Find(&parcels)
+	if len(parcels) != len(uniqueIDs(input.ParcelIDs)) {
+		http.Error(w, "Some parcels were not found", http.StatusNotFound)
+		return
+	}
+
+	writeLabels(w, userClaims.UserID, parcels, "labels.pdf")
+}
+
+// writeLabels renders the labels of the sender's parcels and writes the PDF
+func writeLabels(w http.ResponseWriter, senderID uint, parcels []models.Parcel, filename string) {
+	var sender models.User
+	db.DB.First(&sender, senderID)
+
+	pages := make([]labels.Label, 0, len(parcels))
+	for _, parcel := range parcels {
+		if parcel.TrackingCode == nil {
+			http.Error(w, "Parcel has no tracking code yet", http.StatusConflict)
+			return
+		}
+		label := labels.Label{
+			TrackingCode:   *parcel.TrackingCode,
+			TrackingURL:    services.TrackingURL(*parcel.TrackingCode),
+			SenderName:     sender.Name,
+			PickupAddress:  parcel.PickupAddress,
+			DropoffAddress: parcel.DropoffAddress,
+			ServiceLevel:   services.ServiceLevelStandard,
+		}
+		if parcel.RecipientName != nil {
+			label.RecipientName = *parcel.RecipientName
+		}
+		if parcel.RecipientPhone != nil {
+			label.RecipientPhone = *parcel.RecipientPhone
+		}
+		if parcel.ServiceLevel != nil {
+			label.ServiceLevel = *parcel.ServiceLevel
+		}
+		if parcel.WeightKg != nil {
+			label.WeightKg = *parcel.WeightKg
+		}
+		if parcel.CODAmount != nil {
+			label.CODAmount = *parcel.CODAmount
+		}
+		pages = append(pages, label)
+	}
+
+	pdf, err := labels.Render(pages)
+	if err != nil {
+		http.Error(w, "Failed to render the labels", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", filename))
+	w.Write(pdf)
+}
+
+// uniqueIDs removes duplicate IDs
+func uniqueIDs(ids []uint) []uint {
+	seen := make(map[uint]bool, len(ids))
+	unique := ids[:0:0]
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
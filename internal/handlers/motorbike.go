@@ -2,41 +2,85 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"go-delivery-app/internal/auth"
 	"go-delivery-app/internal/db"
 	"go-delivery-app/internal/models"
-	"go-delivery-app/internal/notifications"
 	"go-delivery-app/internal/services"
+	"io"
+	"log"
 	"net/http"
 	"time"
 
 	"github.com/gorilla/mux"
+	"gorm.io/gorm"
 )
 
-// ListParcels allows motorbikes to see available parcels (returns individual JSON objects instead of an array)
+// availableLeg is a leg ready for pickup, shown with the parcel it belongs to
+type availableLeg struct {
+	models.Parcel
+	Leg models.ParcelLeg `json:"leg"`
+}
+
+// ListParcels allows motorbikes to see the legs ready for pickup (returns individual JSON objects instead of an array).
+// A leg starting at a hub is picked up there, the first leg at the pickup address once its pickup window is close.
+// Motorbikes with a home zone only see the legs starting in it.
 func ListParcels(w http.ResponseWriter, r *http.Request) {
+	// First legs stay hidden until their pickup window is about to open
+	var legs []models.ParcelLeg
+	db.DB.Preload("FromHub").Preload("ToHub").
+		Joins("JOIN parcels ON parcels.id = parcel_legs.parcel_id").
+		Where("parcel_legs.status = ?", services.LegReady).
+		Where("parcel_legs.sequence > 1 OR parcels.pickup_window_start IS NULL OR parcels.pickup_window_start <= ?",
+			time.Now().Add(services.PickupWindowLead())).
+		Order("parcels.pickup_window_start NULLS FIRST, parcel_legs.created_at, parcel_legs.id").
+		Find(&legs)
+
+	parcelIDs := make([]uint, 0, len(legs))
+	for _, leg := range legs {
+		parcelIDs = append(parcelIDs, leg.ParcelID)
+	}
 	var parcels []models.Parcel
-	db.DB.Where("motorbike_id IS NULL").Find(&parcels)
+	if len(parcelIDs) > 0 {
+		db.DB.Where("id IN ?", parcelIDs).Find(&parcels)
+	}
+	parcelsByID := make(map[uint]models.Parcel, len(parcels))
+	for _, parcel := range parcels {
+		parcelsByID[parcel.ID] = parcel
+	}
+
+	if userClaims, ok := auth.GetUserFromContext(r.Context()); ok {
+		var courier models.User
+		db.DB.First(&courier, userClaims.UserID)
+		if courier.HomeZoneID != nil {
+			var zone models.ServiceZone
+			db.DB.First(&zone, *courier.HomeZoneID)
+			legs = services.LegsInZone(&zone, legs, parcelsByID)
+		}
+	}
 
 	// Set the response content type
 	w.Header().Set("Content-Type", "application/json")
 
-	// Iterate over parcels and write each one as an individual JSON object
-	for i, parcel := range parcels {
+	// Iterate over legs and write each one as an individual JSON object
+	for i, leg := range legs {
 		if i > 0 {
-			// Write a comma between objects if it's not the first parcel
+			// Write a comma between objects if it's not the first leg
 			w.Write([]byte(","))
 		}
-		json.NewEncoder(w).Encode(parcel)
+		json.NewEncoder(w).Encode(availableLeg{Parcel: parcelsByID[leg.ParcelID], Leg: leg})
 	}
 }
 
 // Request body struct to capture motorbike description
 type PickParcelRequest struct {
-	MotorbikeDescription string `json:"MotorbikeDescription"` // PascalCase for JSON field
+	MotorbikeDescription string   `json:"MotorbikeDescription"` // PascalCase for JSON field
+	Latitude             *float64 `json:"latitude"`             // Where the motorbike is, checked against the pickup point
+	Longitude            *float64 `json:"longitude"`
 }
 
-// PickParcel allows motorbikes to pick up a parcel by its ID and notify the sender and motorbike
+// PickParcel allows motorbikes to pick up a parcel by its ID and notify the sender and motorbike.
+// It is a pickup scan made without scanning the label.
 func PickParcel(w http.ResponseWriter, r *http.Request) {
 	// Get the authenticated user's claims (motorbike)
 	userClaims, ok := auth.GetUserFromContext(r.Context())
@@ -51,69 +95,88 @@ func PickParcel(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if the motorbike already has an ongoing parcel (status: "Picked up")
-	var ongoingParcel models.Parcel
-	db.DB.Where("motorbike_id = ? AND status = ?", userClaims.UserID, "Picked up").First(&ongoingParcel)
-
-	if ongoingParcel.ID != 0 {
-		http.Error(w, "You have already picked up a parcel. Deliver it before picking up another.", http.StatusForbidden)
+	// Parse the request body with the motorbike's location and description
+	var input PickParcelRequest
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
-
-	// Retrieve the parcel ID from the URL
-	parcelID := mux.Vars(r)["id"]
-	var parcel models.Parcel
-
-	// Retrieve the parcel from the database by ID
-	db.DB.First(&parcel, parcelID)
-
-	// If the parcel doesn't exist, return a 404 error
-	if parcel.ID == 0 {
-		http.Error(w, "Parcel not found", http.StatusNotFound)
+	if !validLocation(w, input.Latitude, input.Longitude) {
 		return
 	}
 
-	// If the parcel is already picked up or delivered, it cannot be picked again
-	if parcel.Status == "Picked up" || parcel.Status == "Delivered" {
-		w.Header().Set("Content-Type", "application/json")
-		http.Error(w, "Parcel already picked up or delivered", http.StatusBadRequest)
-		return
+	scan := services.Scan{
+		Type:      services.ScanPickup,
+		ActorID:   userClaims.UserID,
+		ActorRole: userClaims.Role,
+		Latitude:  input.Latitude,
+		Longitude: input.Longitude,
+	}
+	if input.MotorbikeDescription != "" {
+		scan.MotorbikeDescription = &input.MotorbikeDescription
 	}
 
-	// If the parcel is already canceled , it cannot be picked
-	if parcel.Status == "Canceled" {
-		w.Header().Set("Content-Type", "application/json")
+	// Lock the parcel and apply the pickup
+	var parcel *models.Parcel
+	var event *models.ParcelEvent
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		parcel, err = services.LockParcel(tx, mux.Vars(r)["id"])
+		if err != nil {
+			return err
+		}
+		event, err = services.ApplyScan(tx, parcel, scan)
+		return err
+	})
+	var transitionErr *services.TransitionError
+	var geofenceErr *services.GeofenceError
+	switch {
+	case errors.Is(err, services.ErrParcelNotFound):
+		http.Error(w, "Parcel not found", http.StatusNotFound)
+		return
+	case errors.Is(err, services.ErrCourierBusy):
+		http.Error(w, "You have already picked up a parcel. Deliver it before picking up another.", http.StatusForbidden)
+		return
+	case errors.Is(err, services.ErrCourierBlocked), errors.As(err, &geofenceErr):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case errors.Is(err, services.ErrLocationRequired):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrScanNotAllowed):
+		http.Error(w, "Only motorbikes can pick parcels", http.StatusForbidden)
+		return
+	case errors.Is(err, services.ErrBeforePickupWindow):
+		http.Error(w, "The pickup window of this parcel has not opened yet", http.StatusBadRequest)
+		return
+	case errors.As(err, &transitionErr) && transitionErr.Status == services.StatusCanceled:
 		http.Error(w, "Parcel already Canceled", http.StatusBadRequest)
 		return
-	}
-
-	// Update the parcel's status and assign the motorbike ID
-	pickupTime := time.Now()
-	parcel.Status = "Picked up"
-	parcel.PickupTime = &pickupTime
-	parcel.MotorbikeID = &userClaims.UserID // Assign the motorbike ID from the authenticated user
-
-	// Save the updated parcel back to the database
-	result := db.DB.Save(&parcel)
-	if result.Error != nil {
-		w.Header().Set("Content-Type", "application/json")
+	case transitionErr != nil:
+		http.Error(w, "Parcel already picked up or delivered", http.StatusBadRequest)
+		return
+	case err != nil:
 		http.Error(w, "Failed to update parcel", http.StatusInternalServerError)
 		return
 	}
 
-	// Send notifications to RabbitMQ queues
-	// Notify the sender
-	notifications.PublishNotification("notifications_sender_queue", parcel.SenderID, "Your parcel has been picked up!")
-
-	// Notify the motorbike
-	notifications.PublishNotification("notifications_motorbike_queue", userClaims.UserID, "You have picked up a parcel!")
+	// Notify the sender, the motorbike and the recipient
+	services.NotifyScan(parcel, event)
 
 	// Send the updated parcel as a JSON response
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(parcel)
 }
 
-// UpdateParcelStatus allows motorbikes to update the status of a parcel to "Delivered"
+// Request body struct to capture the cash collected on delivery
+type UpdateParcelStatusRequest struct {
+	CODCollected *int64   `json:"cod_collected"` // Required when the parcel has a cod_amount
+	Latitude     *float64 `json:"latitude"`      // Where the motorbike is, checked against the drop-off point
+	Longitude    *float64 `json:"longitude"`
+}
+
+// UpdateParcelStatus allows motorbikes to update the status of a parcel to "Delivered".
+// It is a delivery scan made without scanning the label.
 func UpdateParcelStatus(w http.ResponseWriter, r *http.Request) {
 	// Get the authenticated user's claims (motorbike)
 	userClaims, ok := auth.GetUserFromContext(r.Context())
@@ -135,48 +198,93 @@ func UpdateParcelStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Retrieve the parcel ID from the request path
-	parcelID := mux.Vars(r)["id"]
-	var parcel models.Parcel
-
-	// Retrieve the parcel from the database by ID
-	db.DB.First(&parcel, parcelID)
-
-	// If the parcel doesn't exist, return a 404 error
-	if parcel.ID == 0 {
-		http.Error(w, "Parcel not found", http.StatusNotFound)
+	// Parse the request body with the motorbike's location and the cash collected on delivery
+	var input UpdateParcelStatusRequest
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
-
-	// Check if the parcel has been picked up before updating to "Delivered"
-	if parcel.Status != "Picked up" {
-		http.Error(w, "Parcel has not been picked up yet", http.StatusBadRequest)
+	if !validLocation(w, input.Latitude, input.Longitude) {
 		return
 	}
 
-	// Update the parcel's status to "Delivered"
-	deliveryTime := time.Now()
-	parcel.Status = "Delivered"
-	parcel.DeliveryTime = &deliveryTime
+	scan := services.Scan{
+		Type:         services.ScanDelivery,
+		ActorID:      userClaims.UserID,
+		ActorRole:    userClaims.Role,
+		Latitude:     input.Latitude,
+		Longitude:    input.Longitude,
+		CODCollected: input.CODCollected,
+	}
 
-	// Save the updated parcel status to the database
-	result := db.DB.Save(&parcel)
-	if result.Error != nil {
+	// Lock the parcel, mark it as delivered and credit the motorbike's share
+	var parcel *models.Parcel
+	var event *models.ParcelEvent
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		parcel, err = services.LockParcel(tx, mux.Vars(r)["id"])
+		if err != nil {
+			return err
+		}
+		event, err = services.ApplyScan(tx, parcel, scan)
+		return err
+	})
+	var transitionErr *services.TransitionError
+	var geofenceErr *services.GeofenceError
+	switch {
+	case errors.Is(err, services.ErrParcelNotFound):
+		http.Error(w, "Parcel not found", http.StatusNotFound)
+		return
+	case errors.Is(err, services.ErrScanNotAllowed):
+		http.Error(w, "Only motorbikes can update parcel status", http.StatusForbidden)
+		return
+	case errors.Is(err, services.ErrNotAssignedCourier):
+		http.Error(w, "Parcel is assigned to another motorbike", http.StatusForbidden)
+		return
+	case errors.As(err, &geofenceErr):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case errors.Is(err, services.ErrLocationRequired):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.As(err, &transitionErr) && (transitionErr.Status == services.StatusPickedUp || transitionErr.Status == services.StatusInTransit):
+		http.Error(w, "This leg ends at a hub, scan the parcel in at the hub instead", http.StatusBadRequest)
+		return
+	case transitionErr != nil:
+		http.Error(w, "Parcel has not been picked up yet", http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrCODConfirmationRequired), errors.Is(err, services.ErrInvalidCODAmount),
+		errors.Is(err, services.ErrNotCODParcel):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
 		http.Error(w, "Failed to update parcel status", http.StatusInternalServerError)
 		return
 	}
 
-	// Notify the sender
-	notifications.PublishNotification("notifications_sender_queue", parcel.SenderID, "Your parcel has been delivered!")
-
-	// Notify the motorbike
-	notifications.PublishNotification("notifications_motorbike_queue", userClaims.UserID, "You have delivered a parcel!")
+	// Notify the sender and the motorbike
+	services.NotifyScan(parcel, event)
 
 	// Send the updated parcel status as a JSON response
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "Parcel marked as delivered"})
 }
 
+// RateSender allows a motorbike that carried a parcel to rate its sender, within RATING_WINDOW_DAYS of delivery
+func RateSender(w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := auth.GetUserFromContext(r.Context())
+	if !ok || userClaims.UserID == 0 {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+	if userClaims.Role != services.RoleMotorbike {
+		http.Error(w, "Only motorbikes can rate senders", http.StatusForbidden)
+		return
+	}
+
+	createRating(w, r, userClaims.UserID, services.RateSender)
+}
+
 // GetMotorbikeRatings allows a motorbike to see their ratings
 func GetMotorbikeRatings(w http.ResponseWriter, r *http.Request) {
 	// Get the authenticated motorbike's claims
@@ -186,37 +294,144 @@ func GetMotorbikeRatings(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Retrieve all ratings for this motorbike
-	var ratings []models.Rating
-	db.DB.Where("motorbike_id = ?", userClaims.UserID).Find(&ratings)
+	// Read the totals kept up to date as senders rate this motorbike
+	summary, err := services.CourierRatings(db.DB, userClaims.UserID, time.Now())
+	if err != nil {
+		http.Error(w, "Failed to load ratings", http.StatusInternalServerError)
+		return
+	}
 
 	// If there are no ratings, return a message
-	if len(ratings) == 0 {
+	if summary.TotalRatings == 0 {
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]string{"message": "You have no ratings yet."})
 		return
 	}
 
-	// Calculate the average rating
-	totalRatings := len(ratings)
-	totalPoints := 0
-	ratingBreakdown := map[int]int{1: 0, 2: 0, 3: 0, 4: 0, 5: 0}
-
-	for _, rating := range ratings {
-		totalPoints += rating.Rating
-		ratingBreakdown[rating.Rating]++
-	}
-
-	averageRating := float64(totalPoints) / float64(totalRatings)
+	// Add the latest comments senders left, leaving out the hidden ones
+	var comments []models.Rating
+	db.DB.Where("motorbike_id = ? AND direction = ? AND comment IS NOT NULL AND moderation_status <> ?",
+		userClaims.UserID, services.RateCourier, services.ModerationHidden).
+		Order("created_at DESC").Limit(10).Find(&comments)
 
 	// Prepare the response
 	response := map[string]interface{}{
-		"total_ratings":    totalRatings,
-		"average_rating":   averageRating,
-		"rating_breakdown": ratingBreakdown, // E.g., 5-star: X, 4-star: Y
+		"total_ratings":    summary.TotalRatings,
+		"average_rating":   summary.AverageRating,
+		"score":            summary.Score,
+		"rating_breakdown": summary.RatingBreakdown, // E.g., 5-star: X, 4-star: Y
+		"last_30_days":     summary.Last30Days,
+		"last_90_days":     summary.Last90Days,
+		"recent_comments":  comments,
 	}
 
 	// Send the response
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
+
+// UpdateLocation allows a motorbike to report their current position.
+// Recipients are told when the parcel they are waiting for gets close.
+func UpdateLocation(w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := auth.GetUserFromContext(r.Context())
+	if !ok || userClaims.UserID == 0 {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+
+	var input struct {
+		Latitude  float64 `json:"latitude"`
+		Longitude float64 `json:"longitude"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !validCoordinates(input.Latitude, input.Longitude) {
+		http.Error(w, "Valid latitude and longitude are required", http.StatusBadRequest)
+		return
+	}
+
+	location, err := services.RecordCourierLocation(db.DB, userClaims.UserID, input.Latitude, input.Longitude)
+	if err != nil {
+		http.Error(w, "Failed to save the location", http.StatusInternalServerError)
+		return
+	}
+	if err := services.NotifyNearbyRecipients(db.DB, userClaims.UserID, input.Latitude, input.Longitude); err != nil {
+		log.Printf("Failed to notify nearby recipients: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(location)
+}
+
+// earningsPeriod is the amount earned by a motorbike over one day or week
+type earningsPeriod struct {
+	Period      time.Time `json:"period"`
+	AmountCents int64     `json:"amount_cents"`
+	Deliveries  int       `json:"deliveries"`
+}
+
+// GetEarnings allows a motorbike to see their earnings with daily and weekly breakdowns
+func GetEarnings(w http.ResponseWriter, r *http.Request) {
+	// Get the authenticated motorbike's claims
+	userClaims, ok := auth.GetUserFromContext(r.Context())
+	if !ok || userClaims.UserID == 0 {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+
+	from, to, err := parseDateRange(r, 30)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	account, err := services.GetAccount(db.DB, userClaims.UserID, services.AccountCourierEarnings)
+	if err != nil {
+		http.Error(w, "Failed to load earnings", http.StatusInternalServerError)
+		return
+	}
+	balance, err := services.Balance(db.DB, account.ID)
+	if err != nil {
+		http.Error(w, "Failed to load earnings", http.StatusInternalServerError)
+		return
+	}
+
+	// Group the delivery credits of the period by day and by ISO week (starting Monday)
+	breakdown := func(unit string) ([]earningsPeriod, error) {
+		periods := []earningsPeriod{}
+		err := db.DB.Raw(`SELECT date_trunc(?, created_at AT TIME ZONE 'UTC') AS period,
+				SUM(amount_cents) AS amount_cents, COUNT(DISTINCT parcel_id) AS deliveries
+			FROM entries
+			WHERE account_id = ? AND kind = ? AND created_at >= ? AND created_at < ?
+			GROUP BY 1 ORDER BY 1`, unit, account.ID, services.EntryDelivery, from, to).Scan(&periods).Error
+		return periods, err
+	}
+	daily, err := breakdown("day")
+	if err != nil {
+		http.Error(w, "Failed to load earnings", http.StatusInternalServerError)
+		return
+	}
+	weekly, err := breakdown("week")
+	if err != nil {
+		http.Error(w, "Failed to load earnings", http.StatusInternalServerError)
+		return
+	}
+
+	var total int64
+	for _, day := range daily {
+		total += day.AmountCents
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"from":          from,
+		"to":            to,
+		"total_cents":   total,
+		"balance_cents": balance, // Earned but not paid out yet
+		"daily":         daily,
+		"weekly":        weekly,
+	})
+}
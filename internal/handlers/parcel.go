@@ -2,15 +2,27 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/gorilla/mux"
 	"go-delivery-app/internal/auth"
 	"go-delivery-app/internal/db"
 	"go-delivery-app/internal/models"
 	"go-delivery-app/internal/notifications"
+	"go-delivery-app/internal/services"
+	"gorm.io/gorm"
+	"log"
 	"net/http"
+	"strings"
 	"time"
 )
 
+var (
+	errAlreadyPickedUp  = errors.New("parcel has already been picked up")
+	errNotParcelSender  = errors.New("you can only cancel your own parcels")
+	errNotParcelCourier = errors.New("you can only cancel parcels you have picked up")
+)
+
 // CreateParcel allows a sender to create a new parcel, automatically setting the SenderID from the authenticated user
 func CreateParcel(w http.ResponseWriter, r *http.Request) {
 	var parcel models.Parcel
@@ -29,22 +41,14 @@ func CreateParcel(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Ensure that required fields are provided
-	if parcel.PickupAddress == "" || parcel.DropoffAddress == "" || parcel.Latitude == 0 || parcel.Longitude == 0 {
-		http.Error(w, "PickupAddress, DropoffAddress, Latitude, and Longitude are required", http.StatusBadRequest)
+	// Save the parcel to the database, locking the quoted price onto it when a quote is given
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		return services.CreateParcel(tx, &parcel, userClaims.UserID, time.Now())
+	})
+	if writeCreateParcelError(w, err) {
 		return
 	}
 
-	// Set the SenderID automatically from the authenticated user
-	parcel.SenderID = userClaims.UserID
-	parcel.Status = "Created" // Set initial status as "Created"
-
-	// Save the parcel to the database
-	result := db.DB.Create(&parcel)
-	if result.Error != nil {
-		http.Error(w, "Failed to save parcel", http.StatusInternalServerError)
-		return
-	}
 	// Send a notification to the sender using RabbitMQ
 	message := "Your parcel has been created successfully!"
 	notifications.PublishNotification("notifications_sender_queue", parcel.SenderID, message)
@@ -55,6 +59,40 @@ func CreateParcel(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(parcel)
 }
 
+// writeCreateParcelError writes the response for an error returned while creating a parcel and reports
+// whether there was one
+func writeCreateParcelError(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, services.ErrMissingParcelFields), errors.Is(err, services.ErrNegativeCODAmount),
+		errors.Is(err, services.ErrHubNotFound), errors.Is(err, services.ErrTooManyHubs), errors.Is(err, services.ErrRepeatedHubs),
+		errors.Is(err, services.ErrIncompleteWindow), errors.Is(err, services.ErrInvalidWindow),
+		errors.Is(err, services.ErrWindowInPast), errors.Is(err, services.ErrDeliveryBeforePickup),
+		errors.Is(err, services.ErrUnknownTimeZone), errors.Is(err, services.ErrPriceFieldsRequired),
+		errors.Is(err, services.ErrInvalidQuoteCoordinates), errors.Is(err, services.ErrInvalidWeight),
+		errors.Is(err, services.ErrInvalidServiceLevel),
+		errors.Is(err, services.ErrTooHeavy), errors.Is(err, services.ErrUnknownTier), errors.Is(err, services.ErrNoPriceRules):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, services.ErrOutsideServiceArea):
+		http.Error(w, "We do not serve the pickup or drop-off location yet", http.StatusBadRequest)
+	case errors.Is(err, services.ErrContactNotFound):
+		http.Error(w, "Contact not found", http.StatusNotFound)
+	case errors.Is(err, services.ErrInsufficientFunds):
+		http.Error(w, "Insufficient wallet balance, please top up your wallet", http.StatusPaymentRequired)
+	case errors.Is(err, services.ErrQuoteNotFound):
+		http.Error(w, "Quote not found", http.StatusNotFound)
+	case errors.Is(err, services.ErrQuoteNotOwned):
+		http.Error(w, "You can only use your own quotes", http.StatusForbidden)
+	case errors.Is(err, services.ErrQuoteExpired), errors.Is(err, services.ErrQuoteUsed),
+		errors.Is(err, services.ErrDuplicateExternalRef):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, "Failed to save parcel", http.StatusInternalServerError)
+	}
+	return true
+}
+
 // GetParcelStatus allows a sender to check the status of a parcel, but only if it's their own
 func GetParcelStatus(w http.ResponseWriter, r *http.Request) {
 	// Get the authenticated user (sender) from the request context
@@ -68,8 +106,10 @@ func GetParcelStatus(w http.ResponseWriter, r *http.Request) {
 	parcelID := mux.Vars(r)["id"]
 	var parcel models.Parcel
 
-	// Retrieve the parcel from the database by ID
-	db.DB.First(&parcel, parcelID)
+	// Retrieve the parcel and its legs from the database by ID
+	db.DB.Preload("Legs", func(tx *gorm.DB) *gorm.DB { return tx.Order("sequence") }).
+		Preload("Legs.FromHub").Preload("Legs.ToHub").
+		First(&parcel, parcelID)
 
 	// If the parcel doesn't exist, return a 404 error
 	if parcel.ID == 0 {
@@ -86,155 +126,317 @@ func GetParcelStatus(w http.ResponseWriter, r *http.Request) {
 	// Set the response header to application/json
 	w.Header().Set("Content-Type", "application/json")
 
-	// Return the parcel status as a single JSON object
-	json.NewEncoder(w).Encode(parcel)
+	// Return the parcel status as a single JSON object, with its times in the sender's time zone
+	json.NewEncoder(w).Encode(newParcelStatusResponse(&parcel))
 }
 
-// CancelParcel allows senders or motorbikes to cancel a parcel
-func CancelParcel(w http.ResponseWriter, r *http.Request) {
-	// Get the authenticated user's claims (either sender or motorbike)
+// parcelStatusResponse is a parcel with its times converted to the sender's time zone and its estimated delivery
+type parcelStatusResponse struct {
+	models.Parcel
+	LocalTimes services.LocalTimes `json:"local_times"`
+	ETA        *services.ETA       `json:"eta"` // Nil once delivered or canceled, or without drop-off coordinates
+}
+
+func newParcelStatusResponse(parcel *models.Parcel) parcelStatusResponse {
+	eta, err := services.EstimateDelivery(db.DB, parcel, time.Now())
+	if err != nil {
+		log.Printf("Failed to estimate the delivery of parcel %d: %v", parcel.ID, err)
+	}
+	return parcelStatusResponse{Parcel: *parcel, LocalTimes: services.ParcelLocalTimes(parcel), ETA: eta}
+}
+
+// EditParcel allows a sender to change the addresses, coordinates, description and windows of their parcel
+// until it is picked up. Only the fields sent are changed; a quoted price is recalculated for the new route.
+func EditParcel(w http.ResponseWriter, r *http.Request) {
 	userClaims, ok := auth.GetUserFromContext(r.Context())
 	if !ok || userClaims.UserID == 0 {
 		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
 		return
 	}
 
-	// Retrieve the parcel ID from the URL
-	parcelID := mux.Vars(r)["id"]
-	var parcel models.Parcel
-
-	// Retrieve the parcel from the database by ID
-	db.DB.First(&parcel, parcelID)
+	var changes services.ParcelChanges
+	if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
 
-	// If the parcel doesn't exist, return a 404 error
-	if parcel.ID == 0 {
+	var parcel *models.Parcel
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		parcel, err = services.LockParcel(tx, mux.Vars(r)["id"])
+		if err != nil {
+			return err
+		}
+		if parcel.SenderID != userClaims.UserID {
+			return services.ErrParcelNotFound
+		}
+		_, err = services.EditParcel(tx, parcel, changes, userClaims.UserID, time.Now())
+		return err
+	})
+	switch {
+	case errors.Is(err, services.ErrParcelNotFound):
 		http.Error(w, "Parcel not found", http.StatusNotFound)
 		return
+	case errors.Is(err, services.ErrParcelNotEditable):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, services.ErrInsufficientFunds):
+		http.Error(w, "Insufficient wallet balance to cover the new price, please top up your wallet", http.StatusPaymentRequired)
+		return
+	case errors.Is(err, services.ErrOutsideServiceArea):
+		http.Error(w, "We do not serve the pickup or drop-off location yet", http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrInvalidParcelEdit), errors.Is(err, services.ErrIncompleteWindow),
+		errors.Is(err, services.ErrInvalidWindow), errors.Is(err, services.ErrWindowInPast),
+		errors.Is(err, services.ErrDeliveryBeforePickup), errors.Is(err, services.ErrUnknownTimeZone),
+		errors.Is(err, services.ErrInvalidQuoteCoordinates), errors.Is(err, services.ErrInvalidWeight),
+		errors.Is(err, services.ErrInvalidServiceLevel),
+		errors.Is(err, services.ErrTooHeavy), errors.Is(err, services.ErrUnknownTier), errors.Is(err, services.ErrNoPriceRules):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, "Failed to update the parcel", http.StatusInternalServerError)
+		return
 	}
 
-	// Check if the parcel is already delivered, which cannot be canceled
-	if parcel.Status == "Delivered" {
-		http.Error(w, "Delivered parcels cannot be canceled", http.StatusBadRequest)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newParcelStatusResponse(parcel))
+}
+
+// RescheduleParcel allows a sender to change the pickup and delivery windows of their parcel until it is picked up.
+// Windows left out of the request are cleared.
+func RescheduleParcel(w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := auth.GetUserFromContext(r.Context())
+	if !ok || userClaims.UserID == 0 {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
 		return
 	}
 
-	// Check if the user is authorized to cancel the parcel
-	// Sender can only cancel their own parcels and if the status is "Created"
-	if userClaims.Role == "sender" && parcel.SenderID != userClaims.UserID {
-		http.Error(w, "You can only cancel your own parcels", http.StatusForbidden)
+	var input services.Windows
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	// Motorbike can cancel parcels they have picked up, but only if the status is "Picked up"
-	if userClaims.Role == "motorbike" && (parcel.MotorbikeID == nil || *parcel.MotorbikeID != userClaims.UserID) {
-		http.Error(w, "You can only cancel parcels you have picked up", http.StatusForbidden)
+	var parcel *models.Parcel
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		parcel, err = services.LockParcel(tx, mux.Vars(r)["id"])
+		if err != nil {
+			return err
+		}
+		if parcel.SenderID != userClaims.UserID {
+			return services.ErrParcelNotFound
+		}
+		if parcel.Status != services.StatusCreated {
+			return errAlreadyPickedUp
+		}
+		if input.TimeZone == nil {
+			input.TimeZone = parcel.TimeZone
+		}
+		if err := services.SetWindows(parcel, input, time.Now()); err != nil {
+			return err
+		}
+		if err := tx.Save(parcel).Error; err != nil {
+			return err
+		}
+		return services.RecordParcelEvent(tx, parcel, services.EventRescheduled, &userClaims.UserID, "")
+	})
+	switch {
+	case errors.Is(err, services.ErrParcelNotFound):
+		http.Error(w, "Parcel not found", http.StatusNotFound)
+		return
+	case errors.Is(err, errAlreadyPickedUp):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, services.ErrIncompleteWindow), errors.Is(err, services.ErrInvalidWindow),
+		errors.Is(err, services.ErrWindowInPast), errors.Is(err, services.ErrDeliveryBeforePickup),
+		errors.Is(err, services.ErrUnknownTimeZone):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, "Failed to reschedule the parcel", http.StatusInternalServerError)
 		return
 	}
 
-	// Update the parcel's status to "Canceled"
-	cancelTime := time.Now()
-	parcel.Status = "Canceled"
-	parcel.CanceledAt = &cancelTime
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newParcelStatusResponse(parcel))
+}
+
+// Request body struct to capture why a parcel is canceled
+type CancelParcelRequest struct {
+	Reason string `json:"reason"` // One of the reason codes of the canceling role
+	Note   string `json:"note"`   // Required with the reason "other"
+}
 
-	// Save the updated parcel back to the database
-	result := db.DB.Save(&parcel)
-	if result.Error != nil {
+// CancelParcel allows the sender or the motorbike carrying the parcel to cancel it with a reason.
+// Senders cancel for free before pickup and pay a fee after it; motorbikes cannot cancel once they
+// have travelled too far with the parcel.
+func CancelParcel(w http.ResponseWriter, r *http.Request) {
+	// Get the authenticated user's claims (either sender or motorbike)
+	userClaims, ok := auth.GetUserFromContext(r.Context())
+	if !ok || userClaims.UserID == 0 {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+
+	var input CancelParcelRequest
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body, a reason is required: "+strings.Join(services.CancelReasons(userClaims.Role), ", "), http.StatusBadRequest)
+		return
+	}
+
+	var parcel *models.Parcel
+	var cancellation *models.ParcelCancellation
+	var motorbikeID *uint
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		parcel, err = services.LockParcel(tx, mux.Vars(r)["id"])
+		if err != nil {
+			return err
+		}
+
+		// Sender can only cancel their own parcels
+		if userClaims.Role == "sender" && parcel.SenderID != userClaims.UserID {
+			return errNotParcelSender
+		}
+		// Motorbike can only cancel parcels they have picked up
+		if userClaims.Role == "motorbike" && (parcel.MotorbikeID == nil || *parcel.MotorbikeID != userClaims.UserID) {
+			return errNotParcelCourier
+		}
+
+		motorbikeID = parcel.MotorbikeID
+		event := newAuditEvent(r, services.AuditParcelCanceled, services.AuditTargetParcel, parcel.ID)
+		event.Before = map[string]interface{}{"status": parcel.Status, "motorbike_id": parcel.MotorbikeID}
+		now := time.Now()
+		cancellation, err = services.CancelParcel(tx, parcel, userClaims.UserID, userClaims.Role, input.Reason, input.Note, now)
+		if err != nil {
+			return err
+		}
+		event.After = map[string]interface{}{"status": parcel.Status, "reason": input.Reason, "fee_cents": cancellation.FeeCents}
+		return services.RecordAudit(tx, event, now)
+	})
+	switch {
+	case errors.Is(err, services.ErrParcelNotFound):
+		http.Error(w, "Parcel not found", http.StatusNotFound)
+		return
+	case errors.Is(err, errNotParcelSender):
+		http.Error(w, "You can only cancel your own parcels", http.StatusForbidden)
+		return
+	case errors.Is(err, errNotParcelCourier):
+		http.Error(w, "You can only cancel parcels you have picked up", http.StatusForbidden)
+		return
+	case errors.Is(err, services.ErrInvalidCancelReason):
+		http.Error(w, "Unknown reason, use one of: "+strings.Join(services.CancelReasons(userClaims.Role), ", "), http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrCancelNoteRequired):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrParcelNotCancelable), errors.Is(err, services.ErrCancelDistanceLimit):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
 		http.Error(w, "Failed to cancel the parcel", http.StatusInternalServerError)
 		return
 	}
 
 	// Send notifications to both the sender and the motorbike (if applicable)
-	if parcel.SenderID != 0 {
-		notifications.PublishNotification("notifications_sender_queue", parcel.SenderID, "Your parcel has been canceled")
+	message := "Your parcel has been canceled"
+	if cancellation.FeeCents > 0 {
+		message = fmt.Sprintf("Your parcel has been canceled, a cancellation fee of %d cents was charged", cancellation.FeeCents)
 	}
-	if parcel.MotorbikeID != nil {
-		notifications.PublishNotification("notifications_motorbike_queue", *parcel.MotorbikeID, "The parcel you picked up has been canceled")
+	notifications.PublishNotification("notifications_sender_queue", parcel.SenderID, message)
+	if motorbikeID != nil && *motorbikeID != userClaims.UserID {
+		notifications.PublishNotification("notifications_motorbike_queue", *motorbikeID, "The parcel you picked up has been canceled")
 	}
 
-	// Send the updated parcel as a JSON response
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"message": "Parcel has been canceled"})
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":      "Parcel has been canceled",
+		"cancellation": cancellation,
+	})
 }
 
-// RateMotorbike allows a sender to rate the motorbike after the parcel is delivered
+// RateMotorbike allows the sender of a parcel to rate the motorbike that delivered it, with an optional comment
+// and tags, within RATING_WINDOW_DAYS of delivery
 func RateMotorbike(w http.ResponseWriter, r *http.Request) {
 	// Get the authenticated user's claims (sender)
-
 	userClaims, ok := auth.GetUserFromContext(r.Context())
 	if !ok || userClaims.UserID == 0 {
 		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
 		return
 	}
 
-	// Ensure the user is a sender
-	//if userClaims.Role != "sender" {
-	//	http.Error(w, "Only senders can rate motorbikes", http.StatusForbidden)
-	//	return
-	//}
-
-	// Retrieve the parcel ID from the URL
-	parcelID := mux.Vars(r)["id"]
-	var parcel models.Parcel
-
-	// Retrieve the parcel from the database
-	db.DB.First(&parcel, parcelID)
+	createRating(w, r, userClaims.UserID, services.RateCourier)
+}
 
-	// Check if the parcel exists and is in "Delivered" status
-	if parcel.ID == 0 {
-		http.Error(w, "Parcel not found", http.StatusNotFound)
+// createRating records a rating of the parcel in the URL in the given direction and writes the response
+func createRating(w http.ResponseWriter, r *http.Request, raterID uint, direction string) {
+	var input services.RatingInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	if parcel.Status != "Delivered" {
-		http.Error(w, "You can only rate motorbikes after the parcel is delivered", http.StatusBadRequest)
+	var rating *models.Rating
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		parcel, err := services.LockParcel(tx, mux.Vars(r)["id"])
+		if err != nil {
+			return err
+		}
+		rating, err = services.CreateRating(tx, parcel, raterID, direction, input, time.Now())
+		return err
+	})
+	switch {
+	case errors.Is(err, services.ErrParcelNotFound):
+		http.Error(w, "Parcel not found", http.StatusNotFound)
 		return
-	}
-
-	// Ensure the sender is rating their own parcel
-	if parcel.SenderID != userClaims.UserID {
-		http.Error(w, "You can only rate motorbikes for parcels you sent", http.StatusForbidden)
+	case errors.Is(err, services.ErrRatingNotAllowed):
+		http.Error(w, "You can only rate deliveries you took part in", http.StatusForbidden)
 		return
-	}
-
-	// Check if the parcel has already been rated
-	var existingRating models.Rating
-	db.DB.Where("parcel_id = ?", parcel.ID).First(&existingRating)
-	if existingRating.ID != 0 {
+	case errors.Is(err, services.ErrInvalidRatingTag):
+		http.Error(w, "Unknown tag, use any of: "+strings.Join(services.RatingTags(direction), ", "), http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrRatingNotDelivered), errors.Is(err, services.ErrInvalidRating),
+		errors.Is(err, services.ErrRatingComment):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrAlreadyRated):
 		http.Error(w, "You have already rated this delivery", http.StatusBadRequest)
 		return
-	}
-
-	// Parse the rating from the request body
-	var input struct {
-		Rating int `json:"rating"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	case errors.Is(err, services.ErrRatingClosed):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Failed to save the rating", http.StatusInternalServerError)
 		return
 	}
 
-	// Validate the rating (must be between 1 and 5)
-	if input.Rating < 1 || input.Rating > 5 {
-		http.Error(w, "Rating must be between 1 and 5", http.StatusBadRequest)
+	// Send a success response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Rating submitted successfully",
+		"rating":  rating,
+	})
+}
+
+// GetSenderRatings allows a sender to see how the motorbikes rated them
+func GetSenderRatings(w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := auth.GetUserFromContext(r.Context())
+	if !ok || userClaims.UserID == 0 {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
 		return
 	}
 
-	// Create a new rating and save it to the database
-	rating := models.Rating{
-		SenderID:    userClaims.UserID,
-		MotorbikeID: *parcel.MotorbikeID, // MotorbikeID is fetched from the parcel
-		ParcelID:    parcel.ID,
-		Rating:      input.Rating,
-		CreatedAt:   time.Now(),
-	}
+	var ratings []models.Rating
+	db.DB.Where("sender_id = ? AND direction = ?", userClaims.UserID, services.RateSender).
+		Order("created_at DESC").Find(&ratings)
 
-	result := db.DB.Create(&rating)
-	if result.Error != nil {
-		http.Error(w, "Failed to save the rating", http.StatusInternalServerError)
-		return
+	response := make([]models.Rating, 0, len(ratings))
+	for _, rating := range ratings {
+		response = append(response, services.PublicRating(rating))
 	}
 
-	// Send a success response
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"message": "Rating submitted successfully"})
+	json.NewEncoder(w).Encode(response)
 }
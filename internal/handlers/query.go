@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+// parseDateRange reads the optional "from" and "to" query parameters (YYYY-MM-DD, UTC).
+// The range is half-open: it starts at midnight of "from" and ends at midnight after "to".
+// Without parameters it covers the last defaultDays days, today included.
+func parseDateRange(r *http.Request, defaultDays int) (time.Time, time.Time, error) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	to := today.AddDate(0, 0, 1)
+	if value := r.URL.Query().Get("to"); value != "" {
+		parsed, err := time.Parse(dateLayout, value)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("to must be a date formatted as YYYY-MM-DD")
+		}
+		to = parsed.AddDate(0, 0, 1)
+	}
+
+	from := to.AddDate(0, 0, -defaultDays)
+	if value := r.URL.Query().Get("from"); value != "" {
+		parsed, err := time.Parse(dateLayout, value)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("from must be a date formatted as YYYY-MM-DD")
+		}
+		from = parsed
+	}
+
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, errors.New("from must not be after to")
+	}
+	return from, to, nil
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"go-delivery-app/internal/auth"
+	"go-delivery-app/internal/db"
+	"go-delivery-app/internal/services"
+	"net/http"
+	"time"
+)
+
+// CreateQuote computes a price for a parcel before it is created and stores it until it expires
+func CreateQuote(w http.ResponseWriter, r *http.Request) {
+	// Retrieve the authenticated user's ID (SenderID) from the request context
+	userClaims, ok := auth.GetUserFromContext(r.Context())
+	if !ok || userClaims.UserID == 0 {
+		http.Error(w, "Could not determine authenticated user", http.StatusUnauthorized)
+		return
+	}
+
+	var req services.QuoteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Validate the coordinates, weight and service level
+	if err := services.ValidateQuoteRequest(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	quote, err := services.CalculateQuote(db.DB, userClaims.UserID, req, time.Now().UTC())
+	if errors.Is(err, services.ErrOutsideServiceArea) {
+		http.Error(w, "We do not serve the pickup or drop-off location yet", http.StatusBadRequest)
+		return
+	} else if errors.Is(err, services.ErrTooHeavy) || errors.Is(err, services.ErrUnknownTier) || errors.Is(err, services.ErrNoPriceRules) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to compute the quote", http.StatusInternalServerError)
+		return
+	}
+
+	// Save the quote so it can be referenced when creating the parcel
+	if result := db.DB.Create(quote); result.Error != nil {
+		http.Error(w, "Failed to save the quote", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(quote)
+}
+
+// validCoordinates reports whether a latitude/longitude pair is set and within range
+func validCoordinates(lat, lng float64) bool {
+	return services.ValidCoordinates(lat, lng)
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"encoding/json"
+	"go-delivery-app/internal/db"
+	"go-delivery-app/internal/models"
+	"go-delivery-app/internal/services"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const maxDeliveryInstructionsLength = 500
+
+// recipientParcelResponse is what the recipient link shows about a parcel
+type recipientParcelResponse struct {
+	TrackingCode         *string    `json:"tracking_code"`
+	Status               string     `json:"status"`
+	RecipientName        *string    `json:"recipient_name"`
+	DropoffAddress       string     `json:"dropoff_address"`
+	DeliveryInstructions *string    `json:"delivery_instructions"`
+	PickupTime           *time.Time `json:"pickup_time"`
+	DeliveryTime         *time.Time `json:"delivery_time"`
+	ETA                  *time.Time `json:"estimated_delivery"`
+}
+
+// GetRecipientParcel lets the holder of a recipient link see the parcel addressed to them
+func GetRecipientParcel(w http.ResponseWriter, r *http.Request) {
+	parcel, ok := loadRecipientParcel(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newRecipientParcelResponse(parcel))
+}
+
+// UpdateDeliveryInstructions lets the holder of a recipient link tell the courier how to deliver,
+// for example "leave at door"
+func UpdateDeliveryInstructions(w http.ResponseWriter, r *http.Request) {
+	parcel, ok := loadRecipientParcel(w, r)
+	if !ok {
+		return
+	}
+
+	if services.ParcelClosed(parcel) {
+		http.Error(w, "Instructions can no longer be changed for this parcel", http.StatusConflict)
+		return
+	}
+
+	var input struct {
+		DeliveryInstructions string `json:"delivery_instructions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	instructions := strings.TrimSpace(input.DeliveryInstructions)
+	if len(instructions) > maxDeliveryInstructionsLength {
+		http.Error(w, "Delivery instructions are too long", http.StatusBadRequest)
+		return
+	}
+
+	parcel.DeliveryInstructions = nil
+	if instructions != "" {
+		parcel.DeliveryInstructions = &instructions
+	}
+	if result := db.DB.Model(parcel).Update("delivery_instructions", parcel.DeliveryInstructions); result.Error != nil {
+		http.Error(w, "Failed to save the delivery instructions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newRecipientParcelResponse(parcel))
+}
+
+// loadRecipientParcel finds the parcel of the recipient token in the URL
+func loadRecipientParcel(w http.ResponseWriter, r *http.Request) (*models.Parcel, bool) {
+	token := mux.Vars(r)["token"]
+
+	var parcel models.Parcel
+	if token != "" {
+		db.DB.Where("recipient_token = ?", token).First(&parcel)
+	}
+	if parcel.ID == 0 {
+		http.Error(w, "Parcel not found", http.StatusNotFound)
+		return nil, false
+	}
+	return &parcel, true
+}
+
+func newRecipientParcelResponse(parcel *models.Parcel) recipientParcelResponse {
+	response := recipientParcelResponse{
+		TrackingCode:         parcel.TrackingCode,
+		Status:               parcel.Status,
+		RecipientName:        parcel.RecipientName,
+		DropoffAddress:       parcel.DropoffAddress,
+		DeliveryInstructions: parcel.DeliveryInstructions,
+		PickupTime:           parcel.PickupTime,
+		DeliveryTime:         parcel.DeliveryTime,
+	}
+	if eta, err := services.EstimateDelivery(db.DB, parcel, time.Now()); err == nil && eta != nil {
+		response.ETA = &eta.EstimatedDelivery
+	}
+	return response
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"go-delivery-app/internal/auth"
+	"go-delivery-app/internal/db"
+	"go-delivery-app/internal/models"
+	"go-delivery-app/internal/services"
+	"net/http"
+
+	"gorm.io/gorm"
+)
+
+// Request body struct to capture a barcode scan
+type ScanRequest struct {
+	TrackingCode string   `json:"tracking_code"`
+	ScanType     string   `json:"scan_type"` // pickup, hub-in, hub-out or delivery
+	Latitude     *float64 `json:"latitude"`
+	Longitude    *float64 `json:"longitude"`
+	MotorbikeID  *uint    `json:"motorbike_id"`  // Motorbike taking the parcel on a hub-out scan by hub staff
+	CODCollected *int64   `json:"cod_collected"` // Required on delivery of cash on delivery parcels
+}
+
+// ScanParcel records a barcode scan of a parcel label by a motorbike or hub staff and moves the parcel
+// to its next status
+func ScanParcel(w http.ResponseWriter, r *http.Request) {
+	// Get the authenticated user's claims
+	userClaims, ok := auth.GetUserFromContext(r.Context())
+	if !ok || userClaims.UserID == 0 {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+
+	var input ScanRequest
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	code, err := services.NormalizeTrackingCode(input.TrackingCode)
+	if err != nil {
+		http.Error(w, "Invalid tracking code", http.StatusBadRequest)
+		return
+	}
+	if !validLocation(w, input.Latitude, input.Longitude) {
+		return
+	}
+
+	scan := services.Scan{
+		Type:         input.ScanType,
+		ActorID:      userClaims.UserID,
+		ActorRole:    userClaims.Role,
+		Latitude:     input.Latitude,
+		Longitude:    input.Longitude,
+		MotorbikeID:  input.MotorbikeID,
+		CODCollected: input.CODCollected,
+	}
+
+	// A hub can only hand the parcel over to a motorbike
+	if scan.Type == services.ScanHubOut && scan.ActorRole == services.RoleHub && scan.MotorbikeID != nil {
+		var courier models.User
+		db.DB.First(&courier, *scan.MotorbikeID)
+		if courier.ID == 0 || courier.Role != services.RoleMotorbike {
+			http.Error(w, "Motorbike not found", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var parcel *models.Parcel
+	var event *models.ParcelEvent
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		parcel, err = services.LockParcelByTrackingCode(tx, code)
+		if err != nil {
+			return err
+		}
+		event, err = services.ApplyScan(tx, parcel, scan)
+		return err
+	})
+	var transitionErr *services.TransitionError
+	var geofenceErr *services.GeofenceError
+	switch {
+	case errors.Is(err, services.ErrParcelNotFound):
+		http.Error(w, "Parcel not found", http.StatusNotFound)
+		return
+	case errors.Is(err, services.ErrUnknownScanType),
+		errors.Is(err, services.ErrCourierRequired),
+		errors.Is(err, services.ErrCODConfirmationRequired),
+		errors.Is(err, services.ErrInvalidCODAmount),
+		errors.Is(err, services.ErrNotCODParcel),
+		errors.Is(err, services.ErrLocationRequired):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrScanNotAllowed), errors.Is(err, services.ErrNotAssignedCourier),
+		errors.Is(err, services.ErrCourierBlocked), errors.As(err, &geofenceErr):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case errors.As(err, &transitionErr), errors.Is(err, services.ErrCourierBusy), errors.Is(err, services.ErrBeforePickupWindow):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Failed to record scan", http.StatusInternalServerError)
+		return
+	}
+
+	services.NotifyScan(parcel, event)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(parcel)
+}
+
+// validLocation checks optional coordinates sent with a request, writing the error response when they are invalid
+func validLocation(w http.ResponseWriter, lat, lng *float64) bool {
+	if (lat == nil) != (lng == nil) {
+		http.Error(w, "latitude and longitude must be sent together", http.StatusBadRequest)
+		return false
+	}
+	if lat != nil && !validCoordinates(*lat, *lng) {
+		http.Error(w, "Invalid coordinates", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"go-delivery-app/internal/auth"
+	"go-delivery-app/internal/db"
+	"go-delivery-app/internal/models"
+	"go-delivery-app/internal/services"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// scheduleResponse is a schedule with its next occurrences
+type scheduleResponse struct {
+	models.PickupSchedule
+	Upcoming []services.UpcomingOccurrence `json:"upcoming"`
+}
+
+// ListSchedules allows a sender to see their recurring pickup schedules
+func ListSchedules(w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := auth.GetUserFromContext(r.Context())
+	if !ok || userClaims.UserID == 0 {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+
+	schedules := []models.PickupSchedule{}
+	db.DB.Where("sender_id = ?", userClaims.UserID).Order("name").Find(&schedules)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(schedules)
+}
+
+// CreateSchedule allows a sender to create parcels with the same details on a recurring schedule
+func CreateSchedule(w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := auth.GetUserFromContext(r.Context())
+	if !ok || userClaims.UserID == 0 {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+
+	var schedule models.PickupSchedule
+	if err := json.NewDecoder(r.Body).Decode(&schedule); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	schedule.ID = 0
+	schedule.SenderID = userClaims.UserID
+	schedule.Paused = false
+	schedule.GeneratedUntil = now
+	schedule.CreatedAt = now
+	schedule.UpdatedAt = now
+	if writeScheduleError(w, services.ValidateSchedule(db.DB, &schedule, now)) {
+		return
+	}
+	if result := db.DB.Create(&schedule); result.Error != nil {
+		http.Error(w, "Failed to save the schedule", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	writeSchedule(w, &schedule, 10)
+}
+
+// GetSchedule allows a sender to see one of their schedules and its next occurrences
+func GetSchedule(w http.ResponseWriter, r *http.Request) {
+	schedule, ok := loadOwnSchedule(w, r)
+	if !ok {
+		return
+	}
+	writeSchedule(w, schedule, 10)
+}
+
+// GetUpcomingOccurrences allows a sender to see the next occurrences of a schedule (?count=, up to 50)
+func GetUpcomingOccurrences(w http.ResponseWriter, r *http.Request) {
+	schedule, ok := loadOwnSchedule(w, r)
+	if !ok {
+		return
+	}
+
+	count := 10
+	if value := r.URL.Query().Get("count"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > services.MaxUpcomingOccurrences {
+			http.Error(w, "count must be between 1 and 50", http.StatusBadRequest)
+			return
+		}
+		count = n
+	}
+
+	upcoming, err := services.UpcomingOccurrences(db.DB, schedule, time.Now(), count)
+	if err != nil {
+		http.Error(w, "Failed to compute the upcoming occurrences", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(upcoming)
+}
+
+// DeleteSchedule allows a sender to stop a schedule for good; parcels already created are kept
+func DeleteSchedule(w http.ResponseWriter, r *http.Request) {
+	schedule, ok := loadOwnSchedule(w, r)
+	if !ok {
+		return
+	}
+
+	if result := db.DB.Delete(schedule); result.Error != nil {
+		http.Error(w, "Failed to delete the schedule", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Schedule deleted"})
+}
+
+// PauseSchedule allows a sender to stop a schedule from creating parcels until it is resumed
+func PauseSchedule(w http.ResponseWriter, r *http.Request) {
+	schedule, ok := loadOwnSchedule(w, r)
+	if !ok {
+		return
+	}
+
+	schedule.Paused = true
+	schedule.UpdatedAt = time.Now()
+	if result := db.DB.Save(schedule); result.Error != nil {
+		http.Error(w, "Failed to pause the schedule", http.StatusInternalServerError)
+		return
+	}
+	writeSchedule(w, schedule, 10)
+}
+
+// ResumeSchedule allows a sender to restart a paused schedule; occurrences missed while paused are not created
+func ResumeSchedule(w http.ResponseWriter, r *http.Request) {
+	schedule, ok := loadOwnSchedule(w, r)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	schedule.Paused = false
+	if schedule.GeneratedUntil.Before(now) {
+		schedule.GeneratedUntil = now
+	}
+	schedule.UpdatedAt = now
+	if result := db.DB.Save(schedule); result.Error != nil {
+		http.Error(w, "Failed to resume the schedule", http.StatusInternalServerError)
+		return
+	}
+	writeSchedule(w, schedule, 10)
+}
+
+// Request body struct to capture a date to skip
+type SkipDateRequest struct {
+	Date string `json:"date"` // YYYY-MM-DD in the time zone of the schedule
+}
+
+// SkipScheduleDate allows a sender to skip a single date of a schedule
+func SkipScheduleDate(w http.ResponseWriter, r *http.Request) {
+	schedule, ok := loadOwnSchedule(w, r)
+	if !ok {
+		return
+	}
+
+	var input SkipDateRequest
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	date, err := time.Parse("2006-01-02", input.Date)
+	if err != nil {
+		http.Error(w, "date must be YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+
+	err = services.SkipScheduleDate(db.DB, schedule, date)
+	switch {
+	case errors.Is(err, services.ErrOccurrenceAlreadyTaken):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Failed to skip the date", http.StatusInternalServerError)
+		return
+	}
+	writeSchedule(w, schedule, 10)
+}
+
+// UnskipScheduleDate allows a sender to restore a skipped date of a schedule
+func UnskipScheduleDate(w http.ResponseWriter, r *http.Request) {
+	schedule, ok := loadOwnSchedule(w, r)
+	if !ok {
+		return
+	}
+
+	date, err := time.Parse("2006-01-02", mux.Vars(r)["date"])
+	if err != nil {
+		http.Error(w, "date must be YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+	if result := db.DB.Where("schedule_id = ? AND date = ?", schedule.ID, date.Format("2006-01-02")).
+		Delete(&models.PickupScheduleSkip{}); result.Error != nil {
+		http.Error(w, "Failed to restore the date", http.StatusInternalServerError)
+		return
+	}
+	writeSchedule(w, schedule, 10)
+}
+
+// loadOwnSchedule loads the schedule in the URL and writes an error unless it belongs to the authenticated sender
+func loadOwnSchedule(w http.ResponseWriter, r *http.Request) (*models.PickupSchedule, bool) {
+	userClaims, ok := auth.GetUserFromContext(r.Context())
+	if !ok || userClaims.UserID == 0 {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	var schedule models.PickupSchedule
+	db.DB.First(&schedule, mux.Vars(r)["id"])
+	if schedule.ID == 0 || schedule.SenderID != userClaims.UserID {
+		http.Error(w, "Schedule not found", http.StatusNotFound)
+		return nil, false
+	}
+	return &schedule, true
+}
+
+// writeSchedule writes a schedule with its next occurrences
+func writeSchedule(w http.ResponseWriter, schedule *models.PickupSchedule, count int) {
+	upcoming, err := services.UpcomingOccurrences(db.DB, schedule, time.Now(), count)
+	if err != nil {
+		http.Error(w, "Failed to compute the upcoming occurrences", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(scheduleResponse{PickupSchedule: *schedule, Upcoming: upcoming})
+}
+
+// writeScheduleError writes the response for an invalid schedule and reports whether there was an error
+func writeScheduleError(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, services.ErrScheduleNameRequired), errors.Is(err, services.ErrInvalidRecurrence),
+		errors.Is(err, services.ErrNoUpcomingOccurrence), errors.Is(err, services.ErrInvalidPickupWindow),
+		errors.Is(err, services.ErrInvalidParcelTemplate), errors.Is(err, services.ErrQuoteInTemplate),
+		errors.Is(err, services.ErrUnknownTimeZone):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return true
+	}
+	// The parcel template is checked like a new parcel
+	return writeCreateParcelError(w, err)
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"encoding/json"
+	"go-delivery-app/internal/db"
+	"go-delivery-app/internal/models"
+	"go-delivery-app/internal/services"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// trackingEventDescriptions are the public descriptions of parcel events.
+// Events missing from this map are internal and never shown on the public timeline.
+var trackingEventDescriptions = map[string]string{
+	services.EventCreated:      "Shipment created",
+	services.EventPickedUp:     "Picked up by courier",
+	services.EventArrivedAtHub: "Arrived at sorting hub",
+	services.EventDepartedHub:  "Departed sorting hub",
+	services.EventReleased:     "Waiting for a new courier",
+	services.EventHandedOff:    "Handed over to another courier",
+	services.EventDelivered:    "Delivered",
+	services.EventCanceled:     "Shipment canceled",
+	services.EventLost:         "Shipment lost",
+	services.EventRescheduled:  "Delivery rescheduled",
+}
+
+// trackingEvent is a parcel event stripped of everything that identifies people or places
+type trackingEvent struct {
+	Status      string    `json:"status"`
+	Description string    `json:"description"`
+	Time        time.Time `json:"time"`
+}
+
+// trackingResponse is the privacy-redacted view of a parcel shown on the public tracking page
+type trackingResponse struct {
+	TrackingCode string          `json:"tracking_code"`
+	Status       string          `json:"status"`
+	CreatedAt    time.Time       `json:"created_at"`
+	PickupTime   *time.Time      `json:"pickup_time"`
+	DeliveryTime *time.Time      `json:"delivery_time"`
+	ETA          *time.Time      `json:"estimated_delivery"`
+	Events       []trackingEvent `json:"events"`
+}
+
+// TrackParcel lets anyone holding a tracking code follow the parcel without logging in
+func TrackParcel(w http.ResponseWriter, r *http.Request) {
+	code, err := services.NormalizeTrackingCode(mux.Vars(r)["code"])
+	if err != nil {
+		http.Error(w, "Invalid tracking code", http.StatusBadRequest)
+		return
+	}
+
+	var parcel models.Parcel
+	db.DB.Where("tracking_code = ?", code).First(&parcel)
+	if parcel.ID == 0 {
+		http.Error(w, "Parcel not found", http.StatusNotFound)
+		return
+	}
+
+	var events []models.ParcelEvent
+	db.DB.Where("parcel_id = ?", parcel.ID).Order("created_at, id").Find(&events)
+
+	response := trackingResponse{
+		TrackingCode: code,
+		Status:       parcel.Status,
+		CreatedAt:    parcel.CreatedAt,
+		PickupTime:   parcel.PickupTime,
+		DeliveryTime: parcel.DeliveryTime,
+		Events:       []trackingEvent{},
+	}
+	if eta, err := services.EstimateDelivery(db.DB, &parcel, time.Now()); err == nil && eta != nil {
+		response.ETA = &eta.EstimatedDelivery
+	}
+	for _, event := range events {
+		description, public := trackingEventDescriptions[event.EventType]
+		if !public {
+			continue
+		}
+		response.Events = append(response.Events, trackingEvent{
+			Status:      event.Status,
+			Description: description,
+			Time:        event.CreatedAt,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
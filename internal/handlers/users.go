@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"go-delivery-app/internal/auth"
+	"go-delivery-app/internal/db"
+	"go-delivery-app/internal/models"
+	"go-delivery-app/internal/notifications"
+	"go-delivery-app/internal/services"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// AccountResponse is a user as admin sees it, without the password
+type AccountResponse struct {
+	ID                    uint       `json:"id"`
+	Name                  string     `json:"name"`
+	Email                 string     `json:"email"`
+	Role                  string     `json:"role"`
+	HomeZoneID            *uint      `json:"home_zone_id"`
+	Status                string     `json:"status"`
+	SuspendedAt           *time.Time `json:"suspended_at"`
+	SuspensionReason      *string    `json:"suspension_reason"`
+	PasswordResetRequired bool       `json:"password_reset_required"`
+}
+
+func newAccountResponse(user *models.User) AccountResponse {
+	return AccountResponse{
+		ID:                    user.ID,
+		Name:                  user.Name,
+		Email:                 user.Email,
+		Role:                  user.Role,
+		HomeZoneID:            user.HomeZoneID,
+		Status:                user.Status,
+		SuspendedAt:           user.SuspendedAt,
+		SuspensionReason:      user.SuspensionReason,
+		PasswordResetRequired: user.PasswordResetRequired,
+	}
+}
+
+// SuspendUser allows admin to suspend an account with a reason. The account is refused on every route,
+// even with a valid token, and the parcels a suspended motorbike is carrying are released.
+func SuspendUser(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	updateAccount(w, r, services.AuditUserSuspended, func(tx *gorm.DB, user *models.User, adminID uint, now time.Time) ([]models.Parcel, error) {
+		return services.SuspendUser(tx, user, adminID, input.Reason, now)
+	})
+}
+
+// ReactivateUser allows admin to lift the suspension of an account
+func ReactivateUser(w http.ResponseWriter, r *http.Request) {
+	updateAccount(w, r, services.AuditUserReactivated, func(tx *gorm.DB, user *models.User, adminID uint, now time.Time) ([]models.Parcel, error) {
+		return nil, services.ReactivateUser(tx, user)
+	})
+}
+
+// ChangeUserRole allows admin to give a user another role. A motorbike that stops being one releases
+// the parcels it is carrying and leaves its home zone.
+func ChangeUserRole(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	updateAccount(w, r, services.AuditUserRoleChanged, func(tx *gorm.DB, user *models.User, adminID uint, now time.Time) ([]models.Parcel, error) {
+		return services.ChangeUserRole(tx, user, adminID, input.Role, now)
+	})
+}
+
+// ForcePasswordReset allows admin to make a user choose a new password. The user's tokens stop working
+// and a one-time reset token to use on POST /password/reset is e-mailed to them; admin never sees it.
+func ForcePasswordReset(w http.ResponseWriter, r *http.Request) {
+	var token string
+	var user *models.User
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		user, err = services.LockUser(tx, mux.Vars(r)["id"])
+		if err != nil {
+			return err
+		}
+		event := newAuditEvent(r, services.AuditUserPasswordReset, services.AuditTargetUser, user.ID)
+		event.Before = newAccountResponse(user)
+		now := time.Now()
+		token, err = services.ForcePasswordReset(tx, user, now)
+		if err != nil {
+			return err
+		}
+		event.After = newAccountResponse(user)
+		return services.RecordAudit(tx, event, now)
+	})
+	switch {
+	case errors.Is(err, services.ErrUserNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, "Failed to reset the password", http.StatusInternalServerError)
+		return
+	}
+
+	message := "Your password was reset by an administrator. Choose a new one on POST /password/reset " +
+		"with this token, valid for 24 hours: " + token
+	if err := notifications.PublishAccountNotification("notifications_account_queue", user.ID, user.Email, message); err != nil {
+		log.Printf("Failed to send the password reset token of user %d: %v", user.ID, err)
+		http.Error(w, "The password was reset but the token could not be sent to the user, reset it again", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"user":             newAccountResponse(user),
+		"reset_sent":       true,
+		"reset_expires_at": user.PasswordResetExpiresAt,
+	})
+}
+
+// DeleteUser allows admin to soft-delete an account. Its parcels, ratings and ledger entries are kept,
+// and the parcels a deleted motorbike is carrying are released.
+func DeleteUser(w http.ResponseWriter, r *http.Request) {
+	updateAccount(w, r, services.AuditUserDeleted, services.DeleteUser)
+}
+
+// updateAccount locks the user in the URL, applies an admin change, records it in the audit log and tells
+// the senders of the parcels it released
+func updateAccount(w http.ResponseWriter, r *http.Request, auditAction string,
+	change func(tx *gorm.DB, user *models.User, adminID uint, now time.Time) ([]models.Parcel, error)) {
+	userClaims, ok := auth.GetUserFromContext(r.Context())
+	if !ok || userClaims.UserID == 0 {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+
+	var user *models.User
+	var released []models.Parcel
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		user, err = services.LockUser(tx, mux.Vars(r)["id"])
+		if err != nil {
+			return err
+		}
+		event := newAuditEvent(r, auditAction, services.AuditTargetUser, user.ID)
+		event.Before = newAccountResponse(user)
+		now := time.Now()
+		released, err = change(tx, user, userClaims.UserID, now)
+		if err != nil {
+			return err
+		}
+		if auditAction != services.AuditUserDeleted {
+			event.After = newAccountResponse(user)
+		}
+		return services.RecordAudit(tx, event, now)
+	})
+	switch {
+	case errors.Is(err, services.ErrUserNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	case errors.Is(err, services.ErrInvalidRole):
+		http.Error(w, "Unknown role, use one of: "+strings.Join(services.AccountRoles(), ", "), http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrSuspensionReason):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrCannotModifySelf):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case errors.Is(err, services.ErrAlreadySuspended), errors.Is(err, services.ErrNotSuspended):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Failed to update the user", http.StatusInternalServerError)
+		return
+	}
+
+	for _, parcel := range released {
+		notifications.PublishNotification("notifications_sender_queue", parcel.SenderID,
+			"Your courier had to hand your parcel back, it is waiting for another courier.")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"user":             newAccountResponse(user),
+		"released_parcels": len(released),
+	})
+}
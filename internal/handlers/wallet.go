@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-delivery-app/internal/auth"
+	"go-delivery-app/internal/db"
+	"go-delivery-app/internal/models"
+	"go-delivery-app/internal/services"
+	"log"
+	"net/http"
+
+	"gorm.io/gorm"
+)
+
+// GetWallet allows a sender to see their wallet balance and latest ledger entries
+func GetWallet(w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := auth.GetUserFromContext(r.Context())
+	if !ok || userClaims.UserID == 0 {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+
+	wallet, err := services.GetAccount(db.DB, userClaims.UserID, services.AccountSenderWallet)
+	if err != nil {
+		http.Error(w, "Failed to load the wallet", http.StatusInternalServerError)
+		return
+	}
+	balance, err := services.Balance(db.DB, wallet.ID)
+	if err != nil {
+		http.Error(w, "Failed to load the wallet", http.StatusInternalServerError)
+		return
+	}
+
+	var entries []models.Entry
+	db.DB.Where("account_id = ?", wallet.ID).Order("created_at DESC").Limit(50).Find(&entries)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"balance_cents": balance,
+		"entries":       entries,
+	})
+}
+
+// TopUpWallet charges the sender through the payment provider and credits their wallet. The top-up is
+// recorded before the charge, so a charge whose credit fails is refunded or flagged for review, and a request
+// retried with the same idempotency_key (or Idempotency-Key header) is not charged again.
+func TopUpWallet(w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := auth.GetUserFromContext(r.Context())
+	if !ok || userClaims.UserID == 0 {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+
+	var input struct {
+		AmountCents    int64  `json:"amount_cents"`
+		PaymentToken   string `json:"payment_token"`
+		IdempotencyKey string `json:"idempotency_key"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if input.AmountCents <= 0 {
+		http.Error(w, "amount_cents must be greater than 0", http.StatusBadRequest)
+		return
+	}
+	if input.IdempotencyKey == "" {
+		input.IdempotencyKey = r.Header.Get("Idempotency-Key")
+	}
+
+	topUp, existing, err := services.StartTopUp(db.DB, userClaims.UserID, input.AmountCents, input.IdempotencyKey)
+	switch {
+	case errors.Is(err, services.ErrInvalidTopUpKey):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrTopUpKeyReused):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Failed to record the top-up", http.StatusInternalServerError)
+		return
+	}
+	if existing {
+		switch topUp.Status {
+		case services.TopUpCredited:
+			writeTopUp(w, topUp)
+		case services.TopUpPending, services.TopUpCharged:
+			http.Error(w, services.ErrTopUpInProgress.Error(), http.StatusConflict)
+		default:
+			http.Error(w, "A top-up with this idempotency_key already ended as "+topUp.Status, http.StatusConflict)
+		}
+		return
+	}
+
+	reference, err := services.Payments.Charge(input.AmountCents, input.PaymentToken)
+	if errors.Is(err, services.ErrPaymentDeclined) {
+		recordTopUpStatus(topUp, services.TopUpDeclined, "", err)
+		http.Error(w, "Payment was declined", http.StatusPaymentRequired)
+		return
+	} else if err != nil {
+		recordTopUpStatus(topUp, services.TopUpFailed, "", err)
+		http.Error(w, "Failed to charge the payment method", http.StatusBadGateway)
+		return
+	}
+
+	err = services.SetTopUpStatus(db.DB, topUp, services.TopUpCharged, reference, nil)
+	if err == nil {
+		err = db.DB.Transaction(func(tx *gorm.DB) error {
+			return services.CreditTopUp(tx, topUp)
+		})
+	}
+	if err != nil {
+		refundTopUp(topUp, reference, err)
+		http.Error(w, "Failed to credit the wallet, the payment was not kept", http.StatusInternalServerError)
+		return
+	}
+
+	writeTopUp(w, topUp)
+}
+
+// refundTopUp gives back a charge that could not be credited to the wallet, and flags the top-up for review
+// when the refund fails too
+func refundTopUp(topUp *models.WalletTopUp, reference string, cause error) {
+	log.Printf("Failed to credit top-up %d charged as %s: %v", topUp.ID, reference, cause)
+	status := services.TopUpRefunded
+	if err := services.Payments.Refund(reference, topUp.AmountCents); err != nil {
+		log.Printf("Failed to refund top-up %d, it needs review: %v", topUp.ID, err)
+		status = services.TopUpNeedsReview
+		cause = fmt.Errorf("%v; refund failed: %v", cause, err)
+	}
+	recordTopUpStatus(topUp, status, reference, cause)
+}
+
+// recordTopUpStatus records what happened to a top-up, logging when it cannot
+func recordTopUpStatus(topUp *models.WalletTopUp, status, reference string, cause error) {
+	if err := services.SetTopUpStatus(db.DB, topUp, status, reference, cause); err != nil {
+		log.Printf("Failed to record top-up %d as %s: %v", topUp.ID, status, err)
+	}
+}
+
+func writeTopUp(w http.ResponseWriter, topUp *models.WalletTopUp) {
+	var reference string
+	if topUp.ProviderReference != nil {
+		reference = *topUp.ProviderReference
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":   "Wallet topped up successfully",
+		"reference": reference,
+		"top_up":    topUp,
+	})
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"go-delivery-app/internal/db"
+	"go-delivery-app/internal/models"
+	"go-delivery-app/internal/services"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ListZones allows admin to see every service zone, including inactive ones
+func ListZones(w http.ResponseWriter, r *http.Request) {
+	zones := []models.ServiceZone{}
+	db.DB.Order("name").Find(&zones)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(zones)
+}
+
+// CreateZone allows admin to add a service zone from a GeoJSON polygon
+func CreateZone(w http.ResponseWriter, r *http.Request) {
+	var zone models.ServiceZone
+	if err := json.NewDecoder(r.Body).Decode(&zone); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := services.PrepareZone(&zone); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	zone.ID = 0
+	zone.Active = true
+	zone.CreatedAt = time.Now()
+	zone.UpdatedAt = zone.CreatedAt
+	if result := db.DB.Create(&zone); result.Error != nil {
+		http.Error(w, "Failed to save the zone", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(zone)
+}
+
+// UpdateZone allows admin to redraw or rename a zone, or reactivate it by sending "active": true
+func UpdateZone(w http.ResponseWriter, r *http.Request) {
+	var zone models.ServiceZone
+	db.DB.First(&zone, mux.Vars(r)["id"])
+	if zone.ID == 0 {
+		http.Error(w, "Zone not found", http.StatusNotFound)
+		return
+	}
+
+	var input models.ServiceZone
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := services.PrepareZone(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	input.ID = zone.ID
+	input.CreatedAt = zone.CreatedAt
+	input.UpdatedAt = time.Now()
+	if result := db.DB.Save(&input); result.Error != nil {
+		http.Error(w, "Failed to save the zone", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(input)
+}
+
+// DeleteZone allows admin to deactivate a zone; its motorbikes keep it as home zone until reassigned
+func DeleteZone(w http.ResponseWriter, r *http.Request) {
+	var zone models.ServiceZone
+	db.DB.First(&zone, mux.Vars(r)["id"])
+	if zone.ID == 0 {
+		http.Error(w, "Zone not found", http.StatusNotFound)
+		return
+	}
+
+	zone.Active = false
+	zone.UpdatedAt = time.Now()
+	if result := db.DB.Save(&zone); result.Error != nil {
+		http.Error(w, "Failed to deactivate the zone", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Zone deactivated"})
+}
+
+// SetHomeZone allows admin to assign a motorbike to a zone, or free it from any zone with "zone_id": null
+func SetHomeZone(w http.ResponseWriter, r *http.Request) {
+	var courier models.User
+	db.DB.First(&courier, mux.Vars(r)["id"])
+	if courier.ID == 0 {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	var input struct {
+		ZoneID *uint `json:"zone_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	err := services.SetHomeZone(db.DB, &courier, input.ZoneID)
+	switch {
+	case errors.Is(err, services.ErrHomeZoneNotCourier):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrZoneNotFound):
+		http.Error(w, "Zone not found", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, "Failed to assign the zone", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Home zone updated"})
+}
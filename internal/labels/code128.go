@@ -0,0 +1,56 @@
+package labels
+
+import "errors"
+
+// ErrBarcodeCharacter is returned for characters that Code 128 set B cannot encode
+var ErrBarcodeCharacter = errors.New("barcode can only contain printable ASCII characters")
+
+// code128Patterns holds the bar and space widths of every Code 128 symbol, indexed by value.
+// Values 103 to 105 are the start codes and 106 is the stop code.
+var code128Patterns = [...]string{
+	"212222", "222122", "222221", "121223", "121322", "131222", "122213", "122312", "132212", "221213",
+	"221312", "231212", "112232", "122132", "122231", "113222", "123122", "123221", "223211", "221132",
+	"221231", "213212", "223112", "312131", "311222", "321122", "321221", "312212", "322112", "322211",
+	"212123", "212321", "232121", "111323", "131123", "131321", "112313", "132113", "132311", "211313",
+	"231113", "231311", "112133", "112331", "132131", "113123", "113321", "133121", "313121", "211331",
+	"231131", "213113", "213311", "213131", "311123", "311321", "331121", "312113", "312311", "332111",
+	"314111", "221411", "431111", "111224", "111422", "121124", "121421", "141122", "141221", "112214",
+	"112412", "122114", "122411", "142112", "142211", "241211", "221114", "413111", "241112", "134111",
+	"111242", "121142", "121241", "114212", "124112", "124211", "411212", "421112", "421211", "212141",
+	"214121", "412121", "111143", "111341", "131141", "114113", "114311", "411113", "411311", "113141",
+	"114131", "311141", "411131", "211412", "211214", "211232", "2331112",
+}
+
+const (
+	code128StartB = 104
+	code128Stop   = 106
+)
+
+// EncodeCode128 encodes text with Code 128 set B and returns the modules from left to right, true being a bar.
+// Quiet zones are not included.
+func EncodeCode128(text string) ([]bool, error) {
+	values := []int{code128StartB}
+	checksum := code128StartB
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if c < 32 || c > 126 {
+			return nil, ErrBarcodeCharacter
+		}
+		value := int(c) - 32
+		values = append(values, value)
+		checksum += (i + 1) * value
+	}
+	values = append(values, checksum%103, code128Stop)
+
+	var modules []bool
+	for _, value := range values {
+		bar := true
+		for _, width := range code128Patterns[value] {
+			for w := 0; w < int(width-'0'); w++ {
+				modules = append(modules, bar)
+			}
+			bar = !bar
+		}
+	}
+	return modules, nil
+}
@@ -0,0 +1,102 @@
+package labels
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// decodeCode128 reads the symbol values back from the modules of a barcode
+func decodeCode128(t *testing.T, modules []bool) []int {
+	t.Helper()
+	var widths strings.Builder
+	for i := 0; i < len(modules); {
+		run := 1
+		for i+run < len(modules) && modules[i+run] == modules[i] {
+			run++
+		}
+		widths.WriteByte(byte('0' + run))
+		i += run
+	}
+
+	var values []int
+	rest := widths.String()
+	for len(rest) > 0 {
+		size := 6
+		if len(rest) == 7 {
+			size = 7 // The stop code has a final bar
+		}
+		found := -1
+		for value, pattern := range code128Patterns {
+			if pattern == rest[:size] {
+				found = value
+				break
+			}
+		}
+		if found < 0 {
+			t.Fatalf("unknown symbol %s", rest[:size])
+		}
+		values = append(values, found)
+		rest = rest[size:]
+	}
+	return values
+}
+
+func TestEncodeCode128(t *testing.T) {
+	tests := []struct {
+		text   string
+		values []int
+	}{
+		{"", []int{104, 1, 106}},
+		{"A", []int{104, 33, 34, 106}},
+		{"AB", []int{104, 33, 34, 102, 106}},
+		{"DL1", []int{104, 36, 44, 17, 73, 106}},
+		{" ~", []int{104, 0, 94, 86, 106}},
+	}
+	for _, tt := range tests {
+		modules, err := EncodeCode128(tt.text)
+		if err != nil {
+			t.Fatalf("EncodeCode128(%q) error = %v", tt.text, err)
+		}
+		if want := 11*(len(tt.text)+2) + 13; len(modules) != want {
+			t.Errorf("EncodeCode128(%q) has %d modules, want %d", tt.text, len(modules), want)
+		}
+		if !modules[0] || !modules[len(modules)-1] {
+			t.Errorf("EncodeCode128(%q) does not start and end with a bar", tt.text)
+		}
+		values := decodeCode128(t, modules)
+		if len(values) != len(tt.values) {
+			t.Fatalf("EncodeCode128(%q) decodes to %v, want %v", tt.text, values, tt.values)
+		}
+		for i := range values {
+			if values[i] != tt.values[i] {
+				t.Errorf("EncodeCode128(%q) decodes to %v, want %v", tt.text, values, tt.values)
+				break
+			}
+		}
+	}
+}
+
+func TestEncodeCode128RejectsNonPrintableCharacters(t *testing.T) {
+	for _, text := range []string{"DL\n1", "\x1f", "\x7f", "café"} {
+		if _, err := EncodeCode128(text); !errors.Is(err, ErrBarcodeCharacter) {
+			t.Errorf("EncodeCode128(%q) error = %v, want %v", text, err, ErrBarcodeCharacter)
+		}
+	}
+}
+
+func TestCode128PatternsAreElevenModulesWide(t *testing.T) {
+	for value, pattern := range code128Patterns {
+		want := 11
+		if value == code128Stop {
+			want = 13
+		}
+		total := 0
+		for _, width := range pattern {
+			total += int(width - '0')
+		}
+		if total != want {
+			t.Errorf("pattern %d (%s) is %d modules wide, want %d", value, pattern, total, want)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package labels
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A 4x6 inch shipping label, in points
+const (
+	LabelWidth  = 288.0
+	LabelHeight = 432.0
+
+	margin = 14.0
+)
+
+// Label holds what is printed on the shipping label of one parcel
+type Label struct {
+	TrackingCode   string
+	TrackingURL    string // Encoded in the QR code
+	SenderName     string
+	PickupAddress  string
+	RecipientName  string
+	RecipientPhone string
+	DropoffAddress string
+	ServiceLevel   string
+	WeightKg       float64 // 0 when unknown
+	CODAmount      int64   // Cash to collect in cents, 0 when none
+}
+
+// Render draws one label per page and returns the PDF document
+func Render(labels []Label) ([]byte, error) {
+	var doc Document
+	for _, label := range labels {
+		if err := drawLabel(doc.AddPage(LabelWidth, LabelHeight), label); err != nil {
+			return nil, fmt.Errorf("label %s: %v", label.TrackingCode, err)
+		}
+	}
+	return doc.Bytes(), nil
+}
+
+func drawLabel(page *Page, label Label) error {
+	width := LabelWidth - 2*margin
+	y := LabelHeight - margin
+
+	// Sender
+	y -= 9
+	page.Text(margin, y, 8, HelveticaBold, "FROM")
+	y = drawLines(page, margin, y-12, 10, HelveticaBold, label.SenderName, width, 1)
+	y = drawLines(page, margin, y, 9, Helvetica, label.PickupAddress, width, 2)
+	page.Line(margin, y+2, LabelWidth-margin, y+2, 1)
+
+	// Recipient
+	y -= 12
+	page.Text(margin, y, 8, HelveticaBold, "TO")
+	y = drawLines(page, margin, y-17, 15, HelveticaBold, label.RecipientName, width, 1)
+	if label.RecipientPhone != "" {
+		y = drawLines(page, margin, y, 10, Helvetica, label.RecipientPhone, width, 1)
+	}
+	y = drawLines(page, margin, y, 12, Helvetica, label.DropoffAddress, width, 3)
+	page.Line(margin, y+4, LabelWidth-margin, y+4, 1)
+
+	// Service details
+	y -= 16
+	page.Text(margin, y, 16, HelveticaBold, strings.ToUpper(label.ServiceLevel))
+	details := []string{}
+	if label.WeightKg > 0 {
+		details = append(details, fmt.Sprintf("%.2f kg", label.WeightKg))
+	}
+	if label.CODAmount > 0 {
+		details = append(details, fmt.Sprintf("COD %d.%02d", label.CODAmount/100, label.CODAmount%100))
+	}
+	detailText := strings.Join(details, "   ")
+	page.Text(LabelWidth-margin-TextWidth(detailText, 12, HelveticaBold), y, 12, HelveticaBold, detailText)
+	y -= 10
+	page.Line(margin, y, LabelWidth-margin, y, 1)
+
+	// Barcode of the tracking code, centered with a human readable line below
+	modules, err := EncodeCode128(label.TrackingCode)
+	if err != nil {
+		return err
+	}
+	moduleWidth := (width - 20) / float64(len(modules))
+	if moduleWidth > 2 {
+		moduleWidth = 2
+	}
+	barcodeHeight := 70.0
+	x := (LabelWidth - moduleWidth*float64(len(modules))) / 2
+	y -= 12 + barcodeHeight
+	for start := 0; start < len(modules); {
+		end := start
+		for end < len(modules) && modules[end] == modules[start] {
+			end++
+		}
+		if modules[start] {
+			page.FillRect(x+float64(start)*moduleWidth, y, float64(end-start)*moduleWidth, barcodeHeight)
+		}
+		start = end
+	}
+	y -= 16
+	page.Text((LabelWidth-TextWidth(label.TrackingCode, 13, HelveticaBold))/2, y, 13, HelveticaBold, label.TrackingCode)
+
+	// QR code linking to the public tracking page
+	qr, err := EncodeQR([]byte(label.TrackingURL))
+	if err != nil {
+		return err
+	}
+	qrSize := y - margin - 12
+	if qrSize > 110 {
+		qrSize = 110
+	}
+	cell := qrSize / float64(qr.Size)
+	qrX, qrY := margin, margin
+	for row := 0; row < qr.Size; row++ {
+		for start := 0; start < qr.Size; {
+			end := start
+			for end < qr.Size && qr.Dark(row, end) == qr.Dark(row, start) {
+				end++
+			}
+			if qr.Dark(row, start) {
+				page.FillRect(qrX+float64(start)*cell, qrY+float64(qr.Size-1-row)*cell, float64(end-start)*cell, cell)
+			}
+			start = end
+		}
+	}
+
+	textX := qrX + qrSize + 16
+	textY := qrY + qrSize - 10
+	page.Text(textX, textY, 10, HelveticaBold, "Track your parcel")
+	drawLines(page, textX, textY-13, 8, Helvetica, label.TrackingURL, LabelWidth-margin-textX, 4)
+	return nil
+}
+
+// drawLines draws text wrapped to width, keeping at most maxLines lines, and returns the baseline of the next line
+func drawLines(page *Page, x, y, size float64, font Font, text string, width float64, maxLines int) float64 {
+	lines := WrapText(text, size, font, width)
+	if len(lines) > maxLines {
+		lines = lines[:maxLines]
+	}
+	for _, line := range lines {
+		page.Text(x, y, size, font, line)
+		y -= size * 1.2
+	}
+	return y
+}
@@ -0,0 +1,155 @@
+package labels
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestEscapeText(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"DLD493QMVAG8", "DLD493QMVAG8"},
+		{"Main St (rear)", `Main St \(rear\)`},
+		{`C:\parcels`, `C:\\parcels`},
+		{"Café", `Caf\351`},
+		{"5 €", "5 ?"},
+		{"line\nbreak", "line?break"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := escapeText(tt.text); got != tt.want {
+			t.Errorf("escapeText(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestTextWidth(t *testing.T) {
+	tests := []struct {
+		text string
+		size float64
+		font Font
+		want float64
+	}{
+		{"", 10, Helvetica, 0},
+		{"i", 10, Helvetica, 2.22},
+		{"W", 10, Helvetica, 9.44},
+		{"Hi", 12, Helvetica, 11.328},
+		{"Hi", 12, HelveticaBold, 12.00768},
+		{"é", 10, Helvetica, 5.56},
+	}
+	for _, tt := range tests {
+		if got := TextWidth(tt.text, tt.size, tt.font); fmt.Sprintf("%.5f", got) != fmt.Sprintf("%.5f", tt.want) {
+			t.Errorf("TextWidth(%q, %v, %v) = %v, want %v", tt.text, tt.size, tt.font, got, tt.want)
+		}
+	}
+}
+
+func TestWrapText(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		maxWidth float64
+		want     []string
+	}{
+		{"fits on one line", "12 Main Street", 200, []string{"12 Main Street"}},
+		{"breaks at spaces", "12 Main Street Springfield", 70, []string{"12 Main Street", "Springfield"}},
+		{"collapses spaces", "  12   Main  ", 200, []string{"12 Main"}},
+		{"cuts long words", "AAAAAAAAAA", 20, []string{"AA", "AA", "AA", "AA", "AA"}},
+		{"empty", "", 100, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := WrapText(tt.text, 10, Helvetica, tt.maxWidth)
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") || len(got) != len(tt.want) {
+				t.Errorf("WrapText(%q, %v) = %q, want %q", tt.text, tt.maxWidth, got, tt.want)
+			}
+			for _, line := range got {
+				if len([]rune(line)) > 1 && TextWidth(line, 10, Helvetica) > tt.maxWidth {
+					t.Errorf("line %q is wider than %v", line, tt.maxWidth)
+				}
+			}
+		})
+	}
+}
+
+func TestDocumentBytes(t *testing.T) {
+	tests := []struct {
+		name  string
+		pages int
+	}{
+		{"no pages", 0},
+		{"one page", 1},
+		{"three pages", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var doc Document
+			for i := 0; i < tt.pages; i++ {
+				page := doc.AddPage(LabelWidth, LabelHeight)
+				page.Text(10, 10, 12, Helvetica, fmt.Sprintf("page (%d)", i+1))
+				page.FillRect(10, 20, 30, 40)
+				page.Line(0, 0, 10, 10, 1)
+			}
+			checkPDF(t, doc.Bytes(), 4+2*tt.pages)
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	out, err := Render([]Label{
+		{TrackingCode: "DLD493QMVAG8", TrackingURL: "https://example.com/t/DLD493QMVAG8", SenderName: "Shop",
+			RecipientName: "Ana", DropoffAddress: "1 Side St", ServiceLevel: "express", WeightKg: 1.5, CODAmount: 1250},
+		{TrackingCode: "DLK7M2P9RX4V", TrackingURL: "https://example.com/t/DLK7M2P9RX4V"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPDF(t, out, 8)
+	if !bytes.Contains(out, []byte("(DLD493QMVAG8)")) {
+		t.Error("the tracking code is not printed")
+	}
+}
+
+// checkPDF verifies the header, the cross-reference table and the trailer of a PDF document
+func checkPDF(t *testing.T, out []byte, objects int) {
+	t.Helper()
+	if !bytes.HasPrefix(out, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(out, []byte("%%EOF\n")) {
+		t.Fatalf("missing header or trailer")
+	}
+
+	match := regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`).FindSubmatch(out)
+	if match == nil {
+		t.Fatal("missing startxref")
+	}
+	xref, _ := strconv.Atoi(string(match[1]))
+	if !bytes.HasPrefix(out[xref:], []byte(fmt.Sprintf("xref\n0 %d\n", objects+1))) {
+		t.Fatalf("startxref %d does not point to a table of %d objects", xref, objects+1)
+	}
+
+	entries := regexp.MustCompile(`(\d{10}) 00000 n \n`).FindAllSubmatch(out[xref:], -1)
+	if len(entries) != objects {
+		t.Fatalf("%d xref entries, want %d", len(entries), objects)
+	}
+	for i, entry := range entries {
+		offset, _ := strconv.Atoi(string(entry[1]))
+		if !bytes.HasPrefix(out[offset:], []byte(fmt.Sprintf("%d 0 obj\n", i+1))) {
+			t.Errorf("xref entry %d points to offset %d, which is not the object", i+1, offset)
+		}
+	}
+	if !bytes.Contains(out, []byte(fmt.Sprintf("/Size %d ", objects+1))) {
+		t.Errorf("trailer size is not %d", objects+1)
+	}
+
+	// Every stream length matches its content
+	for _, m := range regexp.MustCompile(`(?s)<< /Length (\d+) >>\nstream\n(.*?)endstream`).FindAllSubmatch(out, -1) {
+		if length, _ := strconv.Atoi(string(m[1])); length != len(m[2]) {
+			t.Errorf("stream length %d, want %d", length, len(m[2]))
+		}
+	}
+}
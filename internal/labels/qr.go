@@ -0,0 +1,467 @@
+package labels
+
+import "errors"
+
+// ErrQRTooLong is returned when the data does not fit in the largest supported QR version
+var ErrQRTooLong = errors.New("data is too long for a QR code")
+
+// qrVersion describes the error correction blocks of a QR version at error correction level M
+type qrVersion struct {
+	ecPerBlock int
+	group1     int // Number of blocks in the first group
+	group1Data int // Data codewords per block in the first group
+	group2     int // Number of blocks in the second group
+	group2Data int // Data codewords per block in the second group
+	alignment  []int
+}
+
+// qrVersions lists versions 1 to 10 at level M, which fits URLs of up to 213 bytes
+var qrVersions = []qrVersion{
+	{10, 1, 16, 0, 0, nil},
+	{16, 1, 28, 0, 0, []int{6, 18}},
+	{26, 1, 44, 0, 0, []int{6, 22}},
+	{18, 2, 32, 0, 0, []int{6, 26}},
+	{24, 2, 43, 0, 0, []int{6, 30}},
+	{16, 4, 27, 0, 0, []int{6, 34}},
+	{18, 4, 31, 0, 0, []int{6, 22, 38}},
+	{22, 2, 38, 2, 39, []int{6, 24, 42}},
+	{22, 3, 36, 2, 37, []int{6, 26, 46}},
+	{26, 4, 43, 1, 44, []int{6, 28, 50}},
+}
+
+func (v qrVersion) dataCodewords() int {
+	return v.group1*v.group1Data + v.group2*v.group2Data
+}
+
+// QRCode is a square matrix of modules; true is dark
+type QRCode struct {
+	Size    int
+	modules [][]bool
+}
+
+// Dark reports whether the module at the given row and column is dark
+func (q *QRCode) Dark(row, col int) bool {
+	return q.modules[row][col]
+}
+
+// EncodeQR encodes data in byte mode with error correction level M, using the smallest version that fits
+func EncodeQR(data []byte) (*QRCode, error) {
+	version := 0
+	for i, v := range qrVersions {
+		countBits := 8
+		if i+1 >= 10 {
+			countBits = 16
+		}
+		if 4+countBits+8*len(data) <= 8*v.dataCodewords() {
+			version = i + 1
+			break
+		}
+	}
+	if version == 0 {
+		return nil, ErrQRTooLong
+	}
+	v := qrVersions[version-1]
+
+	codewords := interleave(v, encodeSegment(data, version, v.dataCodewords()))
+
+	q := newQRMatrix(version)
+	q.placeCodewords(codewords)
+
+	// Keep the mask with the lowest penalty
+	best, bestPenalty := -1, 0
+	for mask := 0; mask < 8; mask++ {
+		q.applyMask(mask)
+		q.drawFormatBits(mask)
+		if penalty := q.penalty(); best < 0 || penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+		q.applyMask(mask) // Masking twice undoes it
+	}
+	q.applyMask(best)
+	q.drawFormatBits(best)
+
+	return &QRCode{Size: q.size, modules: q.modules}, nil
+}
+
+// encodeSegment builds the data codewords: mode, length, data, terminator and padding
+func encodeSegment(data []byte, version, capacity int) []byte {
+	var bits bitBuffer
+	bits.append(0x4, 4) // Byte mode
+	if version < 10 {
+		bits.append(uint(len(data)), 8)
+	} else {
+		bits.append(uint(len(data)), 16)
+	}
+	for _, b := range data {
+		bits.append(uint(b), 8)
+	}
+
+	// Terminator of up to four zero bits, then pad to a byte boundary
+	terminator := capacity*8 - len(bits)
+	if terminator > 4 {
+		terminator = 4
+	}
+	bits.append(0, terminator)
+	if len(bits)%8 != 0 {
+		bits.append(0, 8-len(bits)%8)
+	}
+
+	codewords := bits.bytes()
+	for pad := byte(0xEC); len(codewords) < capacity; pad ^= 0xEC ^ 0x11 {
+		codewords = append(codewords, pad)
+	}
+	return codewords
+}
+
+// interleave splits the data into blocks, adds their error correction codewords and interleaves them
+func interleave(v qrVersion, data []byte) []byte {
+	var blocks, ecBlocks [][]byte
+	divisor := rsDivisor(v.ecPerBlock)
+	offset := 0
+	for i := 0; i < v.group1+v.group2; i++ {
+		length := v.group1Data
+		if i >= v.group1 {
+			length = v.group2Data
+		}
+		block := data[offset : offset+length]
+		offset += length
+		blocks = append(blocks, block)
+		ecBlocks = append(ecBlocks, rsRemainder(block, divisor))
+	}
+
+	var result []byte
+	for i := 0; i < v.group2Data || i < v.group1Data; i++ {
+		for _, block := range blocks {
+			if i < len(block) {
+				result = append(result, block[i])
+			}
+		}
+	}
+	for i := 0; i < v.ecPerBlock; i++ {
+		for _, block := range ecBlocks {
+			result = append(result, block[i])
+		}
+	}
+	return result
+}
+
+// bitBuffer is a sequence of bits, most significant first
+type bitBuffer []bool
+
+func (b *bitBuffer) append(value uint, length int) {
+	for i := length - 1; i >= 0; i-- {
+		*b = append(*b, (value>>uint(i))&1 == 1)
+	}
+}
+
+func (b bitBuffer) bytes() []byte {
+	result := make([]byte, (len(b)+7)/8)
+	for i, bit := range b {
+		if bit {
+			result[i/8] |= 0x80 >> uint(i%8)
+		}
+	}
+	return result
+}
+
+// gfMultiply multiplies two elements of GF(256) with the QR code polynomial 0x11D
+func gfMultiply(x, y byte) byte {
+	var z int
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>uint(i))&1) * int(x)
+	}
+	return byte(z)
+}
+
+// rsDivisor returns the Reed-Solomon generator polynomial of the given degree, leading term omitted
+func rsDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+	return result
+}
+
+// rsRemainder returns the Reed-Solomon error correction codewords of a block
+func rsRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i := range result {
+			result[i] ^= gfMultiply(divisor[i], factor)
+		}
+	}
+	return result
+}
+
+// qrMatrix is a QR code under construction
+type qrMatrix struct {
+	size       int
+	modules    [][]bool
+	isFunction [][]bool
+}
+
+func newQRMatrix(version int) *qrMatrix {
+	size := version*4 + 17
+	q := &qrMatrix{size: size}
+	q.modules = make([][]bool, size)
+	q.isFunction = make([][]bool, size)
+	for i := range q.modules {
+		q.modules[i] = make([]bool, size)
+		q.isFunction[i] = make([]bool, size)
+	}
+
+	// Timing patterns
+	for i := 0; i < size; i++ {
+		q.setFunction(6, i, i%2 == 0)
+		q.setFunction(i, 6, i%2 == 0)
+	}
+
+	// Finder patterns with their separators
+	q.drawFinder(3, 3)
+	q.drawFinder(3, size-4)
+	q.drawFinder(size-4, 3)
+
+	// Alignment patterns, except where they would overlap the finders
+	positions := qrVersions[version-1].alignment
+	for i, row := range positions {
+		for j, col := range positions {
+			if (i == 0 && j == 0) || (i == 0 && j == len(positions)-1) || (i == len(positions)-1 && j == 0) {
+				continue
+			}
+			q.drawAlignment(row, col)
+		}
+	}
+
+	// Reserve the format areas and draw the version information
+	q.drawFormatBits(0)
+	q.drawVersion(version)
+	return q
+}
+
+func (q *qrMatrix) setFunction(row, col int, dark bool) {
+	q.modules[row][col] = dark
+	q.isFunction[row][col] = true
+}
+
+func (q *qrMatrix) drawFinder(centerRow, centerCol int) {
+	for dr := -4; dr <= 4; dr++ {
+		for dc := -4; dc <= 4; dc++ {
+			row, col := centerRow+dr, centerCol+dc
+			if row < 0 || row >= q.size || col < 0 || col >= q.size {
+				continue
+			}
+			dist := maxInt(abs(dr), abs(dc)) // Chebyshev distance from the center
+			q.setFunction(row, col, dist != 2 && dist != 4)
+		}
+	}
+}
+
+func (q *qrMatrix) drawAlignment(centerRow, centerCol int) {
+	for dr := -2; dr <= 2; dr++ {
+		for dc := -2; dc <= 2; dc++ {
+			q.setFunction(centerRow+dr, centerCol+dc, maxInt(abs(dr), abs(dc)) != 1)
+		}
+	}
+}
+
+// drawFormatBits draws both copies of the format information for level M and the mask
+func (q *qrMatrix) drawFormatBits(mask int) {
+	data := 0<<3 | mask // Level M is encoded as 0
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return (bits>>uint(i))&1 == 1 }
+
+	// First copy, around the top-left finder
+	for i := 0; i <= 5; i++ {
+		q.setFunction(i, 8, bit(i))
+	}
+	q.setFunction(7, 8, bit(6))
+	q.setFunction(8, 8, bit(7))
+	q.setFunction(8, 7, bit(8))
+	for i := 9; i < 15; i++ {
+		q.setFunction(8, 14-i, bit(i))
+	}
+
+	// Second copy, split between the other two finders
+	for i := 0; i < 8; i++ {
+		q.setFunction(8, q.size-1-i, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.setFunction(q.size-15+i, 8, bit(i))
+	}
+	q.setFunction(q.size-8, 8, true) // Always dark
+}
+
+// drawVersion draws the version information blocks required from version 7 on
+func (q *qrMatrix) drawVersion(version int) {
+	if version < 7 {
+		return
+	}
+	rem := version
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+	}
+	bits := version<<12 | rem
+	for i := 0; i < 18; i++ {
+		dark := (bits>>uint(i))&1 == 1
+		a, b := q.size-11+i%3, i/3
+		q.setFunction(b, a, dark)
+		q.setFunction(a, b, dark)
+	}
+}
+
+// placeCodewords fills the data area in the zigzag order of the specification
+func (q *qrMatrix) placeCodewords(codewords []byte) {
+	i := 0
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5 // Skip the vertical timing pattern
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < q.size; vert++ {
+			row := vert
+			if upward {
+				row = q.size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				col := right - j
+				if q.isFunction[row][col] || i >= len(codewords)*8 {
+					continue
+				}
+				q.modules[row][col] = (codewords[i/8]>>uint(7-i%8))&1 == 1
+				i++
+			}
+		}
+	}
+}
+
+// applyMask flips the data modules selected by the mask pattern
+func (q *qrMatrix) applyMask(mask int) {
+	for row := 0; row < q.size; row++ {
+		for col := 0; col < q.size; col++ {
+			if q.isFunction[row][col] {
+				continue
+			}
+			var flip bool
+			switch mask {
+			case 0:
+				flip = (row+col)%2 == 0
+			case 1:
+				flip = row%2 == 0
+			case 2:
+				flip = col%3 == 0
+			case 3:
+				flip = (row+col)%3 == 0
+			case 4:
+				flip = (row/2+col/3)%2 == 0
+			case 5:
+				flip = row*col%2+row*col%3 == 0
+			case 6:
+				flip = (row*col%2+row*col%3)%2 == 0
+			case 7:
+				flip = ((row+col)%2+row*col%3)%2 == 0
+			}
+			if flip {
+				q.modules[row][col] = !q.modules[row][col]
+			}
+		}
+	}
+}
+
+// penalty scores how hard the symbol is to read; lower is better
+func (q *qrMatrix) penalty() int {
+	penalty := 0
+	at := func(row, col int, transpose bool) bool {
+		if transpose {
+			return q.modules[col][row]
+		}
+		return q.modules[row][col]
+	}
+
+	for _, transpose := range []bool{false, true} {
+		for row := 0; row < q.size; row++ {
+			// Runs of five or more modules of the same color
+			run := 1
+			for col := 1; col < q.size; col++ {
+				if at(row, col, transpose) == at(row, col-1, transpose) {
+					run++
+					if run == 5 {
+						penalty += 3
+					} else if run > 5 {
+						penalty++
+					}
+				} else {
+					run = 1
+				}
+			}
+
+			// Patterns that look like a finder: dark-light-dark x3-light-dark next to four light modules
+			for col := 0; col+11 <= q.size; col++ {
+				pattern := [11]bool{}
+				for k := range pattern {
+					pattern[k] = at(row, col+k, transpose)
+				}
+				if pattern == finderLike || pattern == finderLikeReversed {
+					penalty += 40
+				}
+			}
+		}
+	}
+
+	// Blocks of 2x2 modules of the same color
+	dark := 0
+	for row := 0; row < q.size; row++ {
+		for col := 0; col < q.size; col++ {
+			if q.modules[row][col] {
+				dark++
+			}
+			if row+1 < q.size && col+1 < q.size {
+				c := q.modules[row][col]
+				if c == q.modules[row][col+1] && c == q.modules[row+1][col] && c == q.modules[row+1][col+1] {
+					penalty += 3
+				}
+			}
+		}
+	}
+
+	// Balance of dark and light modules
+	total := q.size * q.size
+	if k := (abs(dark*20-total*10)+total-1)/total - 1; k > 0 {
+		penalty += k * 10
+	}
+	return penalty
+}
+
+var (
+	finderLike         = [11]bool{true, false, true, true, true, false, true, false, false, false, false}
+	finderLikeReversed = [11]bool{false, false, false, false, true, false, true, true, true, false, true}
+)
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
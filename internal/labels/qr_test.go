@@ -0,0 +1,221 @@
+package labels
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func TestGFMultiply(t *testing.T) {
+	tests := []struct {
+		x, y, want byte
+	}{
+		{0, 0x53, 0},
+		{1, 0x53, 0x53},
+		{2, 0x80, 0x1D},
+		{3, 7, 9},
+		{0x80, 0x80, 0x13},
+		{0xFF, 1, 0xFF},
+	}
+	for _, tt := range tests {
+		if got := gfMultiply(tt.x, tt.y); got != tt.want {
+			t.Errorf("gfMultiply(%#x, %#x) = %#x, want %#x", tt.x, tt.y, got, tt.want)
+		}
+		if got := gfMultiply(tt.y, tt.x); got != tt.want {
+			t.Errorf("gfMultiply(%#x, %#x) = %#x, want %#x", tt.y, tt.x, got, tt.want)
+		}
+	}
+}
+
+func TestRSRemainder(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		ec   []byte
+	}{
+		{
+			// HELLO WORLD at version 1-M, the worked example of the specification
+			"version 1-M",
+			[]byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17},
+			[]byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23},
+		},
+		{
+			"degree 2",
+			[]byte{1},
+			[]byte{3, 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rsRemainder(tt.data, rsDivisor(len(tt.ec))); !bytes.Equal(got, tt.ec) {
+				t.Errorf("rsRemainder() = %v, want %v", got, tt.ec)
+			}
+		})
+	}
+}
+
+func TestEncodeSegment(t *testing.T) {
+	got := encodeSegment([]byte("AB"), 1, qrVersions[0].dataCodewords())
+	want := []byte{0x40, 0x24, 0x14, 0x20, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11}
+	if !bytes.Equal(got, want) {
+		t.Errorf("encodeSegment() = %x, want %x", got, want)
+	}
+}
+
+func TestEncodeQRVersion(t *testing.T) {
+	// Byte mode capacities at level M
+	capacities := []int{14, 26, 42, 62, 84, 106, 122, 152, 180, 213}
+	for i, capacity := range capacities {
+		version := i + 1
+		for _, length := range []int{capacity, capacity + 1} {
+			want := version
+			if length > capacity {
+				want = version + 1
+			}
+			if want > len(capacities) {
+				continue
+			}
+			q, err := EncodeQR(bytes.Repeat([]byte("a"), length))
+			if err != nil {
+				t.Fatalf("EncodeQR(%d bytes) error = %v", length, err)
+			}
+			if q.Size != 4*want+17 {
+				t.Errorf("EncodeQR(%d bytes) size = %d, want %d (version %d)", length, q.Size, 4*want+17, want)
+			}
+		}
+	}
+
+	if _, err := EncodeQR(bytes.Repeat([]byte("a"), 214)); !errors.Is(err, ErrQRTooLong) {
+		t.Errorf("EncodeQR(214 bytes) error = %v, want %v", err, ErrQRTooLong)
+	}
+}
+
+func TestEncodeQRFinders(t *testing.T) {
+	q, err := EncodeQR([]byte("https://example.com/track/DLD493QMVAG8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"1111111",
+		"1000001",
+		"1011101",
+		"1011101",
+		"1011101",
+		"1000001",
+		"1111111",
+	}
+	corners := [][2]int{{0, 0}, {0, q.Size - 7}, {q.Size - 7, 0}}
+	for _, corner := range corners {
+		for dr, line := range want {
+			for dc, c := range line {
+				if q.Dark(corner[0]+dr, corner[1]+dc) != (c == '1') {
+					t.Fatalf("finder at %v is wrong at row %d, column %d", corner, dr, dc)
+				}
+			}
+		}
+	}
+	if !q.Dark(q.Size-8, 8) {
+		t.Error("the module next to the bottom-left finder is not dark")
+	}
+}
+
+func TestDrawFormatBits(t *testing.T) {
+	// Format strings of level M, bit 14 first
+	tests := []string{
+		"101010000010010",
+		"101000100100101",
+		"101111001111100",
+		"101101101001011",
+		"100010111111001",
+		"100000011001110",
+		"100111110010111",
+		"100101010100000",
+	}
+	for mask, format := range tests {
+		q := newQRMatrix(1)
+		q.drawFormatBits(mask)
+
+		want, _ := strconv.ParseUint(format, 2, 16)
+		var first, second uint64
+		for i := 0; i < 15; i++ {
+			// Positions of bit i in both copies
+			var row, col int
+			switch {
+			case i <= 5:
+				row, col = i, 8
+			case i == 6:
+				row, col = 7, 8
+			case i == 7:
+				row, col = 8, 8
+			case i == 8:
+				row, col = 8, 7
+			default:
+				row, col = 8, 14-i
+			}
+			if q.modules[row][col] {
+				first |= 1 << uint(i)
+			}
+			if i < 8 {
+				row, col = 8, q.size-1-i
+			} else {
+				row, col = q.size-15+i, 8
+			}
+			if q.modules[row][col] {
+				second |= 1 << uint(i)
+			}
+		}
+		if first != want || second != want {
+			t.Errorf("mask %d: format bits %015b and %015b, want %s", mask, first, second, format)
+		}
+	}
+}
+
+func TestDrawVersion(t *testing.T) {
+	tests := []struct {
+		version int
+		bits    string
+	}{
+		{7, "000111110010010100"},
+		{8, "001000010110111100"},
+		{9, "001001101010011001"},
+		{10, "001010010011010011"},
+	}
+	for _, tt := range tests {
+		q := newQRMatrix(tt.version)
+		want, _ := strconv.ParseUint(tt.bits, 2, 32)
+		var got uint64
+		for i := 0; i < 18; i++ {
+			a, b := q.size-11+i%3, i/3
+			if q.modules[b][a] != q.modules[a][b] {
+				t.Fatalf("version %d: the two copies differ at bit %d", tt.version, i)
+			}
+			if q.modules[b][a] {
+				got |= 1 << uint(i)
+			}
+		}
+		if got != want {
+			t.Errorf("version %d: version bits %018b, want %s", tt.version, got, tt.bits)
+		}
+	}
+}
+
+func TestApplyMaskTwiceRestoresModules(t *testing.T) {
+	q := newQRMatrix(2)
+	q.placeCodewords(bytes.Repeat([]byte{0xA5}, 44))
+	before := make([][]bool, q.size)
+	for i := range q.modules {
+		before[i] = append([]bool(nil), q.modules[i]...)
+	}
+	for mask := 0; mask < 8; mask++ {
+		q.applyMask(mask)
+		q.applyMask(mask)
+		for row := range before {
+			for col := range before[row] {
+				if q.modules[row][col] != before[row][col] {
+					t.Fatalf("mask %d changed the module at %d, %d", mask, row, col)
+				}
+			}
+		}
+	}
+}
@@ -1,10 +1,15 @@
 package middleware
 
 import (
+	"errors"
 	"go-delivery-app/internal/auth"
+	"go-delivery-app/internal/db"
+	"go-delivery-app/internal/models"
+	"go-delivery-app/internal/services"
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt/v4"
 )
@@ -24,7 +29,7 @@ func JWTMiddleware(next http.Handler) http.Handler {
 
 		// Validate the JWT token
 		jwtSecret := os.Getenv("JWT_SECRET") // Ensure the same JWT_SECRET is used
-		token, err := jwt.ParseWithClaims(tokenStr, &jwt.StandardClaims{}, func(token *jwt.Token) (interface{}, error) {
+		token, err := jwt.ParseWithClaims(tokenStr, &auth.Claims{}, func(token *jwt.Token) (interface{}, error) {
 			return []byte(jwtSecret), nil // Use the same secret used for signing
 		})
 
@@ -34,11 +39,35 @@ func JWTMiddleware(next http.Handler) http.Handler {
 		}
 
 		// Extract claims and add them to the context
-		if claims, ok := token.Claims.(*jwt.StandardClaims); ok && token.Valid {
-			r = r.WithContext(auth.AddUserToContext(r.Context(), claims))
-			next.ServeHTTP(w, r)
-		} else {
+		claims, ok := token.Claims.(*auth.Claims)
+		if !ok {
 			http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+			return
+		}
+
+		// The account may have been suspended, deleted or given another role since the token was issued
+		var user models.User
+		err = db.DB.Select("id", "role", "status", "tokens_valid_after").Where("id = ?", claims.Subject).Limit(1).Find(&user).Error
+		if err != nil {
+			http.Error(w, "Failed to load the account", http.StatusInternalServerError)
+			return
+		}
+		if user.ID == 0 {
+			http.Error(w, "Account not found", http.StatusUnauthorized)
+			return
 		}
+		err = services.CheckAccountAccess(&user, time.Unix(claims.IssuedAt, 0))
+		switch {
+		case errors.Is(err, services.ErrAccountSuspended):
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		claims.Role = user.Role
+
+		r = r.WithContext(auth.AddUserToContext(r.Context(), claims))
+		next.ServeHTTP(w, r)
 	})
 }
@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"go-delivery-app/internal/auth"
+	"net/http"
+)
+
+// RequireRole only lets requests through when the authenticated user has one of the given roles.
+// It must run after JWTMiddleware.
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userClaims, ok := auth.GetUserFromContext(r.Context())
+			if !ok || userClaims.UserID == 0 {
+				http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+				return
+			}
+
+			for _, role := range roles {
+				if userClaims.Role == role {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			http.Error(w, "You are not allowed to access this resource", http.StatusForbidden)
+		})
+	}
+}
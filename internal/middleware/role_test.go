@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"go-delivery-app/internal/auth"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireRole(t *testing.T) {
+	tests := []struct {
+		name    string
+		subject string // Empty for a request without a user
+		role    string
+		allowed []string
+		want    int
+	}{
+		{"admin on an admin route", "1", "admin", []string{"admin"}, http.StatusOK},
+		{"sender on an admin route", "2", "sender", []string{"admin"}, http.StatusForbidden},
+		{"motorbike on an admin route", "3", "motorbike", []string{"admin"}, http.StatusForbidden},
+		{"token without a role", "4", "", []string{"admin"}, http.StatusForbidden},
+		{"one of several roles", "3", "motorbike", []string{"sender", "motorbike"}, http.StatusOK},
+		{"no user", "", "", []string{"admin"}, http.StatusUnauthorized},
+		{"invalid user ID", "abc", "admin", []string{"admin"}, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+			r := httptest.NewRequest(http.MethodGet, "/admin/users", nil)
+			if tt.subject != "" {
+				claims := &auth.Claims{Role: tt.role}
+				claims.Subject = tt.subject
+				r = r.WithContext(auth.AddUserToContext(r.Context(), claims))
+			}
+
+			w := httptest.NewRecorder()
+			RequireRole(tt.allowed...)(next).ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -1,30 +1,76 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+)
 
 // User represents the structure of users (senders, motorbikes, and admins).
 type User struct {
-	ID       uint   `gorm:"primaryKey"`
-	Name     string `gorm:"not null"`
-	Email    string `gorm:"unique;not null"`
-	Password string `gorm:"not null"`
-	Role     string `gorm:"not null"`
+	ID                     uint           `gorm:"primaryKey"`
+	Name                   string         `gorm:"not null"`
+	Email                  string         `gorm:"unique;not null"`
+	Password               string         `gorm:"not null"`
+	Role                   string         `gorm:"not null"`
+	HomeZoneID             *uint          // Service zone a motorbike works in, nullable
+	Status                 string         `gorm:"not null;default:active"` // active or suspended
+	SuspendedAt            *time.Time     // Nullable field
+	SuspensionReason       *string        // Nullable field
+	PasswordResetRequired  bool           // Logging in is refused until the password is reset
+	PasswordResetTokenHash *string        `json:"-"` // SHA-256 of the one-time reset token
+	PasswordResetExpiresAt *time.Time     `json:"-"`
+	TokensValidAfter       *time.Time     `json:"-"` // Tokens issued earlier are refused
+	DeletedAt              gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
 type Parcel struct {
-	ID                   uint       `gorm:"primaryKey"`
-	SenderID             uint       `json:"SenderID"`
-	PickupAddress        string     `json:"PickupAddress"`
-	DropoffAddress       string     `json:"DropoffAddress"`
-	Latitude             float64    `json:"Latitude"`
-	Longitude            float64    `json:"Longitude"`
-	Status               string     `json:"Status"`
-	PickupTime           *time.Time `json:"PickupTime"`
-	DeliveryTime         *time.Time `json:"DeliveryTime"`
-	MotorbikeID          *uint      `json:"MotorbikeID"`
-	SenderDescription    *string    `json:"SenderDescription"`    // Nullable field
-	MotorbikeDescription *string    `json:"MotorbikeDescription"` // Nullable field
-	CanceledAt           *time.Time `json:"canceled_at"`          // Nullable field
+	ID                      uint        `gorm:"primaryKey"`
+	SenderID                uint        `json:"SenderID"`
+	PickupAddress           string      `json:"PickupAddress"`
+	DropoffAddress          string      `json:"DropoffAddress"`
+	Latitude                float64     `json:"Latitude"`
+	Longitude               float64     `json:"Longitude"`
+	Status                  string      `json:"Status"`
+	PickupTime              *time.Time  `json:"PickupTime"`
+	DeliveryTime            *time.Time  `json:"DeliveryTime"`
+	MotorbikeID             *uint       `json:"MotorbikeID"`
+	SenderDescription       *string     `json:"SenderDescription"`    // Nullable field
+	MotorbikeDescription    *string     `json:"MotorbikeDescription"` // Nullable field
+	CanceledAt              *time.Time  `json:"canceled_at"`          // Nullable field
+	DropoffLatitude         *float64    `json:"DropoffLatitude"`      // Nullable field
+	DropoffLongitude        *float64    `json:"DropoffLongitude"`     // Nullable field
+	WeightKg                *float64    `json:"WeightKg"`             // Nullable field
+	ServiceLevel            *string     `json:"ServiceLevel"`         // Nullable field
+	PriceCents              *int64      `json:"PriceCents"`           // Locked from the quote, nullable
+	QuoteID                 *uint       `json:"quote_id"`             // Quote used to price the parcel, nullable
+	CODAmount               *int64      `json:"cod_amount"`           // Cash to collect on delivery in cents, nullable
+	CODCollected            *int64      `json:"cod_collected"`        // Cash the motorbike confirmed collecting in cents, nullable
+	CODCollectedAt          *time.Time  `json:"cod_collected_at"`     // Nullable field
+	TrackingCode            *string     `json:"TrackingCode"`         // Public tracking number with a check digit
+	CreatedAt               time.Time   `json:"CreatedAt"`
+	ContactID               *uint       `json:"contact_id"`                 // Address book entry the recipient came from, nullable
+	RecipientName           *string     `json:"RecipientName"`              // Nullable field
+	RecipientPhone          *string     `json:"RecipientPhone"`             // Nullable field
+	RecipientEmail          *string     `json:"RecipientEmail"`             // Nullable field
+	DeliveryInstructions    *string     `json:"DeliveryInstructions"`       // Set by the recipient, nullable
+	RecipientToken          *string     `json:"-"`                          // Secret of the recipient link, never exposed
+	RecipientNearNotifiedAt *time.Time  `json:"-"`                          // When the recipient was told the parcel is near
+	PickupWindowStart       *time.Time  `json:"pickup_window_start"`        // Nil picks up as soon as possible
+	PickupWindowEnd         *time.Time  `json:"pickup_window_end"`          // Nullable field
+	DeliveryWindowStart     *time.Time  `json:"delivery_window_start"`      // Nil delivers as soon as possible
+	DeliveryWindowEnd       *time.Time  `json:"delivery_window_end"`        // Nullable field
+	TimeZone                *string     `json:"time_zone"`                  // Sender's time zone, used to display the windows
+	DeliveredLate           *bool       `json:"delivered_late"`             // Set on delivery when there is a delivery window
+	ExternalRef             *string     `json:"external_ref"`               // Sender's own reference, unique per sender
+	CancelReason            *string     `json:"cancel_reason"`              // Reason code given on cancellation
+	LostAt                  *time.Time  `json:"lost_at"`                    // Set when admin marks the parcel lost
+	AnnouncedETA            *time.Time  `json:"-"`                          // ETA delays are measured from, last told to the sender
+	WindowAlertedAt         *time.Time  `json:"-"`                          // When the sender was told the delivery window will be missed
+	HubIDs                  []uint      `gorm:"-" json:"hub_ids,omitempty"` // Hubs to route through, only read on creation
+	Legs                    []ParcelLeg `gorm:"foreignKey:ParcelID" json:"legs,omitempty"`
 }
 
 // Notification represents a notification to be sent to a user
@@ -38,10 +84,443 @@ type Notification struct {
 
 // Rating represents the rating given by a sender to a motorbike after parcel delivery
 type Rating struct {
-	ID          uint      `gorm:"primary_key" json:"id"`
-	SenderID    uint      `json:"sender_id"`    // ID of the sender who gave the rating
-	MotorbikeID uint      `json:"motorbike_id"` // ID of the motorbike being rated
-	ParcelID    uint      `json:"parcel_id"`    // ID of the parcel related to the rating
-	Rating      int       `json:"rating"`       // Rating value (1 to 5)
-	CreatedAt   time.Time `json:"created_at"`   // Timestamp when the rating was created
+	ID               uint           `gorm:"primary_key" json:"id"`
+	SenderID         uint           `json:"sender_id"`    // ID of the sender of the parcel
+	MotorbikeID      uint           `json:"motorbike_id"` // ID of the motorbike that carried it
+	ParcelID         uint           `json:"parcel_id"`    // ID of the parcel related to the rating
+	Direction        string         `json:"direction"`    // "courier" when the sender rates the motorbike, "sender" the other way round
+	Rating           int            `json:"rating"`       // Rating value (1 to 5)
+	Comment          *string        `json:"comment"`      // Nullable field
+	Tags             pq.StringArray `gorm:"type:text[]" json:"tags"`
+	ModerationStatus *string        `json:"moderation_status"` // pending, approved or hidden; nil without a comment
+	ModeratedBy      *uint          `json:"moderated_by"`      // Admin who reviewed the comment, nullable
+	ModeratedAt      *time.Time     `json:"moderated_at"`      // Nullable field
+	CreatedAt        time.Time      `json:"created_at"`        // Timestamp when the rating was created
+}
+
+// CourierRatingStat holds the running totals of the ratings senders gave a courier
+type CourierRatingStat struct {
+	CourierID uint      `gorm:"primaryKey;autoIncrement:false" json:"courier_id"`
+	Ratings   int       `json:"ratings"`
+	RatingSum int       `json:"rating_sum"`
+	Stars1    int       `gorm:"column:stars_1" json:"stars_1"`
+	Stars2    int       `gorm:"column:stars_2" json:"stars_2"`
+	Stars3    int       `gorm:"column:stars_3" json:"stars_3"`
+	Stars4    int       `gorm:"column:stars_4" json:"stars_4"`
+	Stars5    int       `gorm:"column:stars_5" json:"stars_5"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// CourierRatingDay holds the ratings senders gave a courier on a day, for the rolling windows
+type CourierRatingDay struct {
+	CourierID uint      `gorm:"primaryKey;autoIncrement:false" json:"courier_id"`
+	Day       time.Time `gorm:"primaryKey;type:date" json:"day"`
+	Ratings   int       `json:"ratings"`
+	RatingSum int       `json:"rating_sum"`
+}
+
+// PriceRule holds the fares of one service level for a version of the price list
+type PriceRule struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	Version       int       `json:"version"`
+	ServiceLevel  string    `json:"service_level"`   // "standard" or "express"
+	BaseFareCents int64     `json:"base_fare_cents"` // Flat amount charged for every parcel
+	PerKmCents    int64     `json:"per_km_cents"`    // Amount charged per kilometer of distance
+	MinFareCents  int64     `json:"min_fare_cents"`  // Lower bound for the final price
+	EffectiveFrom time.Time `json:"effective_from"`  // The version applies from this moment on
+}
+
+// PriceSizeTier maps a weight range to a surcharge for a version of the price list
+type PriceSizeTier struct {
+	ID             uint    `gorm:"primaryKey" json:"id"`
+	Version        int     `json:"version"`
+	Name           string  `json:"name"`
+	MaxWeightKg    float64 `json:"max_weight_kg"`
+	SurchargeCents int64   `json:"surcharge_cents"`
+}
+
+// PriceSurge is a time-of-day multiplier for a version of the price list
+type PriceSurge struct {
+	ID         uint    `gorm:"primaryKey" json:"id"`
+	Version    int     `json:"version"`
+	DayOfWeek  *int    `json:"day_of_week"` // Nil applies to every day, 0 is Sunday
+	StartHour  int     `json:"start_hour"`
+	EndHour    int     `json:"end_hour"` // Exclusive
+	Multiplier float64 `json:"multiplier"`
+}
+
+// Quote is a price computed for a sender, valid until ExpiresAt
+type Quote struct {
+	ID               uint      `gorm:"primaryKey" json:"id"`
+	SenderID         uint      `json:"sender_id"`
+	PickupLatitude   float64   `json:"pickup_latitude"`
+	PickupLongitude  float64   `json:"pickup_longitude"`
+	DropoffLatitude  float64   `json:"dropoff_latitude"`
+	DropoffLongitude float64   `json:"dropoff_longitude"`
+	WeightKg         float64   `json:"weight_kg"`
+	SizeTier         string    `json:"size_tier"`
+	ServiceLevel     string    `json:"service_level"`
+	DistanceKm       float64   `json:"distance_km"`
+	SurgeMultiplier  float64   `json:"surge_multiplier"`
+	PriceCents       int64     `json:"price_cents"`
+	RuleVersion      int       `json:"rule_version"` // Version of the price list used
+	ExpiresAt        time.Time `json:"expires_at"`
+	ParcelID         *uint     `json:"parcel_id"` // Set once the quote has been used, nullable
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// Account is a balance in the double-entry ledger, owned by a user or by the system
+type Account struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	OwnerID   *uint     `json:"owner_id"` // Nil for system accounts
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Entry is one leg of a ledger transaction; the entries of a transaction always sum to zero
+type Entry struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	TransactionID string    `json:"transaction_id"`
+	AccountID     uint      `json:"account_id"`
+	AmountCents   int64     `json:"amount_cents"` // Positive amounts increase the account balance
+	Kind          string    `json:"kind"`
+	ParcelID      *uint     `json:"parcel_id"`
+	PayoutBatchID *uint     `json:"payout_batch_id"`
+	Reference     *string   `json:"reference"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// WalletTopUp is a payment into a sender's wallet, recorded before the payment provider is charged
+type WalletTopUp struct {
+	ID                uint      `gorm:"primaryKey" json:"id"`
+	SenderID          uint      `json:"sender_id"`
+	AmountCents       int64     `json:"amount_cents"`
+	IdempotencyKey    string    `json:"idempotency_key"` // Chosen by the client, or generated, unique per sender
+	Status            string    `json:"status"`
+	ProviderReference *string   `json:"provider_reference"` // Set once the payment provider is charged
+	Error             *string   `json:"error"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// PayoutBatch groups the courier payouts exported together
+type PayoutBatch struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	TotalCents   int64     `json:"total_cents"`
+	CourierCount int       `json:"courier_count"`
+	CreatedBy    uint      `json:"created_by"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// CashHandIn records cash on delivery money a courier handed in at the hub
+type CashHandIn struct {
+	ID               uint      `gorm:"primaryKey" json:"id"`
+	CourierID        uint      `json:"courier_id"`
+	AmountCents      int64     `json:"amount_cents"`      // Cash counted at the hub
+	ExpectedCents    int64     `json:"expected_cents"`    // Cash on hand according to the ledger
+	DiscrepancyCents int64     `json:"discrepancy_cents"` // Positive when cash is missing
+	ShortfallCents   int64     `json:"shortfall_cents"`   // Part of the discrepancy not carried over from the previous hand-in
+	Note             *string   `json:"note"`
+	RecordedBy       uint      `json:"recorded_by"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// ParcelEvent is an entry in the history of a parcel
+type ParcelEvent struct {
+	ID              uint      `gorm:"primaryKey" json:"id"`
+	ParcelID        uint      `json:"parcel_id"`
+	EventType       string    `json:"event_type"`
+	Status          string    `json:"status"`   // Parcel status after the event
+	ActorID         *uint     `json:"actor_id"` // User who triggered the event, nullable
+	Note            *string   `json:"note"`
+	ScanType        *string   `json:"scan_type"`        // Set when the event comes from a barcode scan
+	Latitude        *float64  `json:"latitude"`         // Where the event happened, nullable
+	Longitude       *float64  `json:"longitude"`        // Where the event happened, nullable
+	DistanceMeters  *float64  `json:"distance_meters"`  // How far from the expected place a scan was made, nullable
+	OutsideGeofence bool      `json:"outside_geofence"` // Scan accepted outside the geofence, to be reviewed
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// Contact is an entry in a sender's address book
+type Contact struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	SenderID  uint      `json:"sender_id"`
+	Name      string    `json:"name"`
+	Phone     *string   `json:"phone"`
+	Email     *string   `json:"email"`
+	Address   *string   `json:"address"`
+	Latitude  *float64  `json:"latitude"`
+	Longitude *float64  `json:"longitude"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// CourierLocation is a position reported by a motorbike
+type CourierLocation struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	CourierID  uint      `json:"courier_id"`
+	Latitude   float64   `json:"latitude"`
+	Longitude  float64   `json:"longitude"`
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+// RecipientNotification is a message for a parcel recipient waiting to be sent by e-mail or SMS
+type RecipientNotification struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	ParcelID  uint       `json:"parcel_id"`
+	Email     *string    `json:"email"`
+	Phone     *string    `json:"phone"`
+	Message   string     `json:"message"`
+	CreatedAt time.Time  `json:"created_at"`
+	SentAt    *time.Time `json:"sent_at"`
+}
+
+// AccountNotification is a message e-mailed to a user who cannot log in to read it, such as a password reset token
+type AccountNotification struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `json:"user_id"`
+	Email     string     `json:"email"`
+	Message   string     `json:"message"`
+	CreatedAt time.Time  `json:"created_at"`
+	SentAt    *time.Time `json:"sent_at"`
+}
+
+// Hub is a warehouse where parcels change couriers between legs
+type Hub struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Name      string    `json:"name"`
+	Address   string    `json:"address"`
+	Latitude  float64   `json:"latitude"`
+	Longitude float64   `json:"longitude"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ParcelLeg is the part of a parcel's journey carried by one courier
+type ParcelLeg struct {
+	ID                uint       `gorm:"primaryKey" json:"id"`
+	ParcelID          uint       `json:"parcel_id"`
+	Sequence          int        `json:"sequence"`    // Order of the leg in the journey, starting at 1
+	FromHubID         *uint      `json:"from_hub_id"` // Nil when the leg starts at the pickup address
+	ToHubID           *uint      `json:"to_hub_id"`   // Nil when the leg ends at the drop-off address
+	FromHub           *Hub       `gorm:"foreignKey:FromHubID" json:"from_hub,omitempty"`
+	ToHub             *Hub       `gorm:"foreignKey:ToHubID" json:"to_hub,omitempty"`
+	Status            string     `json:"status"`
+	MotorbikeID       *uint      `json:"motorbike_id"` // Courier carrying the leg, nullable
+	PickupTime        *time.Time `json:"pickup_time"`
+	DeliveryTime      *time.Time `json:"delivery_time"`
+	ReleasedAt        *time.Time `json:"released_at"`        // Set when a courier gave the leg back, nullable
+	ReleasedLatitude  *float64   `json:"released_latitude"`  // Where the parcel waits after a release, nullable
+	ReleasedLongitude *float64   `json:"released_longitude"` // Nullable field
+	CreatedAt         time.Time  `json:"created_at"`
+}
+
+// PickupSchedule creates parcels with the same details on a recurring schedule
+type PickupSchedule struct {
+	ID                  uint            `gorm:"primaryKey" json:"id"`
+	SenderID            uint            `json:"sender_id"`
+	Name                string          `json:"name"`
+	Expression          string          `json:"expression"` // Cron expression or RRULE
+	TimeZone            string          `json:"time_zone"`  // Time zone the expression is evaluated in
+	PickupWindowMinutes int             `json:"pickup_window_minutes"`
+	Template            json.RawMessage `gorm:"type:jsonb" json:"parcel"` // Parcel fields, as sent to POST /sender/parcel
+	Paused              bool            `json:"paused"`
+	GeneratedUntil      time.Time       `json:"generated_until"` // Occurrences up to this moment have been expanded
+	CreatedAt           time.Time       `json:"created_at"`
+	UpdatedAt           time.Time       `json:"updated_at"`
+}
+
+// PickupScheduleSkip is a day on which a schedule does not create a parcel
+type PickupScheduleSkip struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	ScheduleID uint      `json:"schedule_id"`
+	Date       time.Time `json:"date"` // Day in the time zone of the schedule
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// PickupScheduleOccurrence is an expanded occurrence of a schedule and the parcel created for it
+type PickupScheduleOccurrence struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	ScheduleID   uint      `json:"schedule_id"`
+	ScheduledFor time.Time `json:"scheduled_for"`
+	ParcelID     *uint     `json:"parcel_id"` // Nil when the parcel could not be created
+	Error        *string   `json:"error"`     // Why the parcel could not be created, nullable
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// ImportJob is a bulk upload of parcels, processed in the background
+type ImportJob struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	SenderID       uint       `json:"sender_id"`
+	Format         string     `json:"format"` // "csv" or "ndjson"
+	Status         string     `json:"status"`
+	TotalRows      int        `json:"total_rows"`
+	CreatedCount   int        `json:"created_count"`
+	DuplicateCount int        `json:"duplicate_count"` // Rows whose external_ref already had a parcel
+	FailedCount    int        `json:"failed_count"`
+	CreatedAt      time.Time  `json:"created_at"`
+	FinishedAt     *time.Time `json:"finished_at"`
+}
+
+// ImportJobRow is one row of an import job and its outcome
+type ImportJobRow struct {
+	ID          uint            `gorm:"primaryKey" json:"id"`
+	JobID       uint            `json:"job_id"`
+	RowNumber   int             `json:"row_number"` // 1 is the first data row
+	ExternalRef *string         `json:"external_ref"`
+	Payload     json.RawMessage `gorm:"type:jsonb" json:"-"`
+	Status      string          `json:"status"`
+	ParcelID    *uint           `json:"parcel_id"`
+	Error       *string         `json:"error"`
+}
+
+// ParcelHandoff is a courier's offer to hand the leg they carry over to another courier
+type ParcelHandoff struct {
+	ID              uint       `gorm:"primaryKey" json:"id"`
+	ParcelID        uint       `json:"parcel_id"`
+	ParcelLegID     uint       `json:"parcel_leg_id"`
+	FromMotorbikeID uint       `json:"from_motorbike_id"`
+	ToMotorbikeID   uint       `json:"to_motorbike_id"`
+	Status          string     `json:"status"`
+	Note            *string    `json:"note"`
+	ExpiresAt       time.Time  `json:"expires_at"`
+	RespondedAt     *time.Time `json:"responded_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+// ParcelCancellation records who canceled a parcel, why, and what it cost them
+type ParcelCancellation struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	ParcelID   uint      `json:"parcel_id"`
+	ActorID    uint      `json:"actor_id"`
+	ActorRole  string    `json:"actor_role"`
+	Reason     string    `json:"reason"`
+	Note       *string   `json:"note"`
+	FeeCents   int64     `json:"fee_cents"`
+	DistanceKm *float64  `json:"distance_km"` // Travelled by the courier on the current leg, nullable
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// ZoneSpeedStat is the average speed of the legs started in a grid cell at an hour of the day (UTC)
+type ZoneSpeedStat struct {
+	LatCell     int       `gorm:"primaryKey;autoIncrement:false" json:"lat_cell"`
+	LngCell     int       `gorm:"primaryKey;autoIncrement:false" json:"lng_cell"`
+	Hour        int       `gorm:"primaryKey;autoIncrement:false" json:"hour"`
+	Samples     int       `json:"samples"`
+	AvgSpeedKmh float64   `json:"avg_speed_kmh"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// ServiceZone is an area we deliver in, drawn as a GeoJSON polygon or multipolygon
+type ServiceZone struct {
+	ID           uint            `gorm:"primaryKey" json:"id"`
+	Name         string          `json:"name"`
+	Geometry     json.RawMessage `gorm:"type:jsonb" json:"geometry"`
+	MinLatitude  float64         `json:"-"` // Bounding box of the geometry
+	MaxLatitude  float64         `json:"-"`
+	MinLongitude float64         `json:"-"`
+	MaxLongitude float64         `json:"-"`
+	Active       bool            `json:"active"`
+	CreatedAt    time.Time       `json:"created_at"`
+	UpdatedAt    time.Time       `json:"updated_at"`
+}
+
+// Claim is a sender's request for compensation for a parcel delivered damaged, incomplete or not at all
+type Claim struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	ParcelID       uint       `json:"parcel_id"`
+	SenderID       uint       `json:"sender_id"`
+	Type           string     `json:"type"` // damaged, lost, missing_items or other
+	Description    string     `json:"description"`
+	AmountCents    *int64     `json:"amount_cents"` // Compensation asked for, nullable
+	Status         string     `json:"status"`       // open, investigating, approved or rejected
+	PayoutCents    *int64     `json:"payout_cents"` // Compensation paid on approval, nullable
+	ResolutionNote *string    `json:"resolution_note"`
+	ReviewedBy     *uint      `json:"reviewed_by"` // Admin who last changed the status, nullable
+	ResolvedAt     *time.Time `json:"resolved_at"` // Nullable field
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// ClaimAttachment is a photo or document attached to a claim as evidence
+type ClaimAttachment struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	ClaimID     uint      `json:"claim_id"`
+	UploadedBy  uint      `json:"uploaded_by"`
+	FileName    string    `json:"file_name"`
+	ContentType string    `json:"content_type"`
+	SizeBytes   int       `json:"size_bytes"`
+	Data        []byte    `json:"-"` // Only served by the download route
+	Note        *string   `json:"note"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// ClaimUpdate records a status change of a claim
+type ClaimUpdate struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	ClaimID     uint      `json:"claim_id"`
+	ActorID     uint      `json:"actor_id"`
+	Status      string    `json:"status"`
+	PayoutCents *int64    `json:"payout_cents"` // Nullable field
+	Note        *string   `json:"note"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Conversation is the chat between the sender of a parcel and a motorbike carrying it
+type Conversation struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	ParcelID    uint       `json:"parcel_id"`
+	SenderID    uint       `json:"sender_id"`
+	MotorbikeID uint       `json:"motorbike_id"`
+	Status      string     `json:"status"`    // open or closed
+	ClosedAt    *time.Time `json:"closed_at"` // Nullable field
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// ChatMessage is a message of a conversation
+type ChatMessage struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	ConversationID uint       `json:"conversation_id"`
+	AuthorID       uint       `json:"author_id"`
+	Body           string     `json:"body"`
+	ReadAt         *time.Time `json:"read_at"` // When the other participant read it, nullable
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// ParcelAdminAction is an action taken by an admin on a parcel, kept for audit
+type ParcelAdminAction struct {
+	ID              uint      `gorm:"primaryKey" json:"id"`
+	ParcelID        uint      `json:"parcel_id"`
+	AdminID         uint      `json:"admin_id"`
+	Action          string    `json:"action"` // assign, status, note or refund
+	Note            string    `json:"note"`   // Reason for the action, or the text of an internal note
+	StatusBefore    string    `json:"status_before"`
+	StatusAfter     string    `json:"status_after"`
+	MotorbikeBefore *uint     `json:"motorbike_before"` // Nullable field
+	MotorbikeAfter  *uint     `json:"motorbike_after"`  // Nullable field
+	AmountCents     *int64    `json:"amount_cents"`     // Amount refunded, nullable
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// AuditEntry is a security-relevant action in the append-only audit log, chained to the previous entry by its hash
+type AuditEntry struct {
+	ID         uint            `gorm:"primaryKey" json:"id"`
+	ActorID    *uint           `json:"actor_id"`   // Nullable for actions by unknown users, such as failed logins
+	ActorRole  *string         `json:"actor_role"` // Nullable field
+	Action     string          `json:"action"`
+	TargetType *string         `json:"target_type"` // user or parcel, nullable
+	TargetID   *string         `json:"target_id"`   // Nullable field
+	IP         *string         `json:"ip"`
+	UserAgent  *string         `json:"user_agent"`
+	Before     json.RawMessage `gorm:"type:json" json:"before"` // State before the action, nullable
+	After      json.RawMessage `gorm:"type:json" json:"after"`  // State after the action, nullable
+	PrevHash   string          `json:"prev_hash"`
+	Hash       string          `json:"hash"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// TableName keeps the audit log in a single audit_log table
+func (AuditEntry) TableName() string {
+	return "audit_log"
 }
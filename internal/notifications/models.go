@@ -5,3 +5,18 @@ type NotificationMessage struct {
 	UserID  uint   `json:"user_id"`
 	Message string `json:"message"`
 }
+
+// RecipientNotificationMessage defines the structure of a message for a parcel recipient who is not a user
+type RecipientNotificationMessage struct {
+	ParcelID uint    `json:"parcel_id"`
+	Email    *string `json:"email"`
+	Phone    *string `json:"phone"`
+	Message  string  `json:"message"`
+}
+
+// AccountNotificationMessage defines the structure of a message e-mailed to a user
+type AccountNotificationMessage struct {
+	UserID  uint   `json:"user_id"`
+	Email   string `json:"email"`
+	Message string `json:"message"`
+}
@@ -0,0 +1,57 @@
+package notifications
+
+import "sync"
+
+// StreamEvent is pushed to the users connected to the notifications stream
+type StreamEvent struct {
+	Type string      `json:"type"` // "notification", "chat_message" or "chat_read"
+	Data interface{} `json:"data"`
+}
+
+// streamBuffer is how many events a slow connection may fall behind before events are dropped for it
+const streamBuffer = 32
+
+// streams holds the open notification streams of this process, by user
+var streams = struct {
+	sync.Mutex
+	subscribers map[uint]map[chan StreamEvent]struct{}
+}{subscribers: map[uint]map[chan StreamEvent]struct{}{}}
+
+// Subscribe opens a stream of the events pushed to a user. The returned function closes it.
+func Subscribe(userID uint) (<-chan StreamEvent, func()) {
+	ch := make(chan StreamEvent, streamBuffer)
+	streams.Lock()
+	if streams.subscribers[userID] == nil {
+		streams.subscribers[userID] = map[chan StreamEvent]struct{}{}
+	}
+	streams.subscribers[userID][ch] = struct{}{}
+	streams.Unlock()
+
+	return ch, func() {
+		streams.Lock()
+		delete(streams.subscribers[userID], ch)
+		if len(streams.subscribers[userID]) == 0 {
+			delete(streams.subscribers, userID)
+		}
+		streams.Unlock()
+	}
+}
+
+// Connected reports whether a user has a stream open on this process
+func Connected(userID uint) bool {
+	streams.Lock()
+	defer streams.Unlock()
+	return len(streams.subscribers[userID]) > 0
+}
+
+// Push sends an event to the open streams of a user without waiting for slow connections
+func Push(userID uint, event StreamEvent) {
+	streams.Lock()
+	defer streams.Unlock()
+	for ch := range streams.subscribers[userID] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
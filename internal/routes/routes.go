@@ -14,14 +14,53 @@ func InitializeRoutes() *mux.Router {
 	// Public routes
 	router.HandleFunc("/register", handlers.RegisterUser).Methods("POST")
 	router.HandleFunc("/login", handlers.LoginUser).Methods("POST")
+	router.HandleFunc("/password/reset", handlers.ResetPassword).Methods("POST")
+	router.HandleFunc("/track/{code}", handlers.TrackParcel).Methods("GET")
+	router.HandleFunc("/recipient/{token}", handlers.GetRecipientParcel).Methods("GET")
+	router.HandleFunc("/recipient/{token}/instructions", handlers.UpdateDeliveryInstructions).Methods("PUT")
 
 	// Protected routes with JWT middleware
+	scanRoutes := router.PathPrefix("/scan").Subrouter()
+	scanRoutes.Use(middleware.JWTMiddleware)
+	scanRoutes.HandleFunc("", handlers.ScanParcel).Methods("POST")
+
 	senderRoutes := router.PathPrefix("/sender").Subrouter()
 	senderRoutes.Use(middleware.JWTMiddleware)
+	senderRoutes.HandleFunc("/quote", handlers.CreateQuote).Methods("POST")
 	senderRoutes.HandleFunc("/parcel", handlers.CreateParcel).Methods("POST")
 	senderRoutes.HandleFunc("/parcel/{id}", handlers.GetParcelStatus).Methods("GET")
+	senderRoutes.HandleFunc("/parcel/{id}", handlers.EditParcel).Methods("PATCH")
 	senderRoutes.HandleFunc("/parcel/{id}/cancel", handlers.CancelParcel).Methods("POST")
+	senderRoutes.HandleFunc("/parcel/{id}/reschedule", handlers.RescheduleParcel).Methods("POST")
 	senderRoutes.HandleFunc("/parcel/{id}/rate", handlers.RateMotorbike).Methods("POST")
+	senderRoutes.HandleFunc("/parcel/{id}/claim", handlers.OpenClaim).Methods("POST")
+	senderRoutes.HandleFunc("/parcel/{id}/label.pdf", handlers.GetParcelLabel).Methods("GET")
+	senderRoutes.HandleFunc("/parcels/labels", handlers.GetParcelLabels).Methods("POST")
+	senderRoutes.HandleFunc("/parcels/import", handlers.ImportParcels).Methods("POST")
+	senderRoutes.HandleFunc("/imports", handlers.ListImportJobs).Methods("GET")
+	senderRoutes.HandleFunc("/imports/{id}", handlers.GetImportJob).Methods("GET")
+	senderRoutes.HandleFunc("/ratings", handlers.GetSenderRatings).Methods("GET")
+	senderRoutes.HandleFunc("/claims", handlers.ListClaims).Methods("GET")
+	senderRoutes.HandleFunc("/claims/{id}", handlers.GetClaim).Methods("GET")
+	senderRoutes.HandleFunc("/claims/{id}/evidence", handlers.AddClaimEvidence).Methods("POST")
+	senderRoutes.HandleFunc("/claims/{id}/evidence/{evidence_id}", handlers.GetClaimEvidence).Methods("GET")
+	senderRoutes.HandleFunc("/wallet", handlers.GetWallet).Methods("GET")
+	senderRoutes.HandleFunc("/hubs", handlers.ListHubs).Methods("GET")
+	senderRoutes.HandleFunc("/contacts", handlers.ListContacts).Methods("GET")
+	senderRoutes.HandleFunc("/contacts", handlers.CreateContact).Methods("POST")
+	senderRoutes.HandleFunc("/contacts/{id}", handlers.GetContact).Methods("GET")
+	senderRoutes.HandleFunc("/contacts/{id}", handlers.UpdateContact).Methods("PUT")
+	senderRoutes.HandleFunc("/contacts/{id}", handlers.DeleteContact).Methods("DELETE")
+	senderRoutes.HandleFunc("/wallet/topup", handlers.TopUpWallet).Methods("POST")
+	senderRoutes.HandleFunc("/schedules", handlers.ListSchedules).Methods("GET")
+	senderRoutes.HandleFunc("/schedules", handlers.CreateSchedule).Methods("POST")
+	senderRoutes.HandleFunc("/schedules/{id}", handlers.GetSchedule).Methods("GET")
+	senderRoutes.HandleFunc("/schedules/{id}", handlers.DeleteSchedule).Methods("DELETE")
+	senderRoutes.HandleFunc("/schedules/{id}/upcoming", handlers.GetUpcomingOccurrences).Methods("GET")
+	senderRoutes.HandleFunc("/schedules/{id}/pause", handlers.PauseSchedule).Methods("POST")
+	senderRoutes.HandleFunc("/schedules/{id}/resume", handlers.ResumeSchedule).Methods("POST")
+	senderRoutes.HandleFunc("/schedules/{id}/skip", handlers.SkipScheduleDate).Methods("POST")
+	senderRoutes.HandleFunc("/schedules/{id}/skip/{date}", handlers.UnskipScheduleDate).Methods("DELETE")
 
 	motorbikeRoutes := router.PathPrefix("/motorbike").Subrouter()
 	motorbikeRoutes.Use(middleware.JWTMiddleware)
@@ -29,17 +68,75 @@ func InitializeRoutes() *mux.Router {
 	motorbikeRoutes.HandleFunc("/parcel/{id}/pickup", handlers.PickParcel).Methods("POST")
 	motorbikeRoutes.HandleFunc("/parcel/{id}/update", handlers.UpdateParcelStatus).Methods("PUT")
 	motorbikeRoutes.HandleFunc("/parcel/{id}/cancel", handlers.CancelParcel).Methods("POST")
+	motorbikeRoutes.HandleFunc("/parcel/{id}/release", handlers.ReleaseParcel).Methods("POST")
+	motorbikeRoutes.HandleFunc("/parcel/{id}/rate", handlers.RateSender).Methods("POST")
+	motorbikeRoutes.HandleFunc("/parcel/{id}/handoff", handlers.RequestHandoff).Methods("POST")
+	motorbikeRoutes.HandleFunc("/handoffs", handlers.ListHandoffs).Methods("GET")
+	motorbikeRoutes.HandleFunc("/handoffs/{id}/accept", handlers.AcceptHandoff).Methods("POST")
+	motorbikeRoutes.HandleFunc("/handoffs/{id}/decline", handlers.DeclineHandoff).Methods("POST")
 	motorbikeRoutes.HandleFunc("/ratings", handlers.GetMotorbikeRatings).Methods("GET")
+	motorbikeRoutes.HandleFunc("/earnings", handlers.GetEarnings).Methods("GET")
+	motorbikeRoutes.HandleFunc("/location", handlers.UpdateLocation).Methods("POST")
 
 	adminRoutes := router.PathPrefix("/admin").Subrouter()
 	adminRoutes.Use(middleware.JWTMiddleware)
+	adminRoutes.Use(middleware.RequireRole("admin"))
 	adminRoutes.HandleFunc("/parcels", handlers.GetAllParcels).Methods("GET")
+	adminRoutes.HandleFunc("/parcels/{id}/assign", handlers.AssignParcel).Methods("POST")
+	adminRoutes.HandleFunc("/parcels/{id}/status", handlers.OverrideParcelStatus).Methods("POST")
+	adminRoutes.HandleFunc("/parcels/{id}/notes", handlers.AddParcelNote).Methods("POST")
+	adminRoutes.HandleFunc("/parcels/{id}/refund", handlers.RefundParcel).Methods("POST")
+	adminRoutes.HandleFunc("/parcels/{id}/actions", handlers.GetParcelAdminActions).Methods("GET")
 	adminRoutes.HandleFunc("/users", handlers.GetUsers).Methods("GET")
+	adminRoutes.HandleFunc("/audit", handlers.GetAuditLog).Methods("GET")
+	adminRoutes.HandleFunc("/users/{id}/zone", handlers.SetHomeZone).Methods("PUT")
+	adminRoutes.HandleFunc("/users/{id}/suspend", handlers.SuspendUser).Methods("POST")
+	adminRoutes.HandleFunc("/users/{id}/reactivate", handlers.ReactivateUser).Methods("POST")
+	adminRoutes.HandleFunc("/users/{id}/role", handlers.ChangeUserRole).Methods("PUT")
+	adminRoutes.HandleFunc("/users/{id}/reset-password", handlers.ForcePasswordReset).Methods("POST")
+	adminRoutes.HandleFunc("/users/{id}", handlers.DeleteUser).Methods("DELETE")
+	adminRoutes.HandleFunc("/couriers/cancellations", handlers.GetCourierCancellations).Methods("GET")
+	adminRoutes.HandleFunc("/couriers/leaderboard", handlers.GetCourierLeaderboard).Methods("GET")
+	adminRoutes.HandleFunc("/analytics/parcels", handlers.GetParcelVolumeAnalytics).Methods("GET")
+	adminRoutes.HandleFunc("/analytics/pickup-latency", handlers.GetPickupLatencyAnalytics).Methods("GET")
+	adminRoutes.HandleFunc("/analytics/delivery-duration", handlers.GetDeliveryDurationAnalytics).Methods("GET")
+	adminRoutes.HandleFunc("/analytics/cancellations", handlers.GetCancellationAnalytics).Methods("GET")
+	adminRoutes.HandleFunc("/analytics/couriers/utilization", handlers.GetCourierUtilizationAnalytics).Methods("GET")
+	adminRoutes.HandleFunc("/analytics/ratings", handlers.GetRatingAnalytics).Methods("GET")
+	adminRoutes.HandleFunc("/ratings/moderation", handlers.GetRatingModerationQueue).Methods("GET")
+	adminRoutes.HandleFunc("/ratings/{id}/hide", handlers.HideRating).Methods("POST")
+	adminRoutes.HandleFunc("/ratings/{id}/approve", handlers.ApproveRating).Methods("POST")
+	adminRoutes.HandleFunc("/claims", handlers.ListAllClaims).Methods("GET")
+	adminRoutes.HandleFunc("/claims/{id}", handlers.GetClaimForReview).Methods("GET")
+	adminRoutes.HandleFunc("/claims/{id}/review", handlers.ReviewClaim).Methods("POST")
+	adminRoutes.HandleFunc("/claims/{id}/evidence/{evidence_id}", handlers.GetClaimEvidenceForReview).Methods("GET")
+	adminRoutes.HandleFunc("/hubs", handlers.ListAllHubs).Methods("GET")
+	adminRoutes.HandleFunc("/hubs", handlers.CreateHub).Methods("POST")
+	adminRoutes.HandleFunc("/hubs/{id}", handlers.UpdateHub).Methods("PUT")
+	adminRoutes.HandleFunc("/hubs/{id}", handlers.DeleteHub).Methods("DELETE")
+	adminRoutes.HandleFunc("/zones", handlers.ListZones).Methods("GET")
+	adminRoutes.HandleFunc("/zones", handlers.CreateZone).Methods("POST")
+	adminRoutes.HandleFunc("/zones/{id}", handlers.UpdateZone).Methods("PUT")
+	adminRoutes.HandleFunc("/zones/{id}", handlers.DeleteZone).Methods("DELETE")
+	adminRoutes.HandleFunc("/payouts", handlers.CreatePayoutBatch).Methods("POST")
+	adminRoutes.HandleFunc("/payouts/{id}", handlers.ExportPayoutBatch).Methods("GET")
+	adminRoutes.HandleFunc("/cod/cash", handlers.GetCashOnHand).Methods("GET")
+	adminRoutes.HandleFunc("/cod/handins", handlers.RecordCashHandIn).Methods("POST")
+	adminRoutes.HandleFunc("/cod/discrepancies", handlers.GetCODDiscrepancies).Methods("GET")
+	adminRoutes.HandleFunc("/geofence/flags", handlers.GetGeofenceFlags).Methods("GET")
+
+	// Chat between the sender of a parcel and the motorbike carrying it
+	chatRoutes := router.PathPrefix("/chat").Subrouter()
+	chatRoutes.Use(middleware.JWTMiddleware)
+	chatRoutes.HandleFunc("/{id}", handlers.GetChat).Methods("GET")
+	chatRoutes.HandleFunc("/{id}/messages", handlers.SendChatMessage).Methods("POST")
+	chatRoutes.HandleFunc("/{id}/read", handlers.MarkChatRead).Methods("POST")
 
 	// Notification routes
 	notificationRoutes := router.PathPrefix("/notifications").Subrouter()
 	notificationRoutes.Use(middleware.JWTMiddleware)
 	notificationRoutes.HandleFunc("", handlers.GetNotifications).Methods("GET")
+	notificationRoutes.HandleFunc("/stream", handlers.StreamNotifications).Methods("GET")
 	notificationRoutes.HandleFunc("/{id}/read", handlers.MarkNotificationAsRead).Methods("PUT")
 
 	return router
@@ -0,0 +1,248 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"go-delivery-app/internal/models"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Account statuses
+const (
+	UserActive    = "active"
+	UserSuspended = "suspended"
+)
+
+// accountRoles are the roles an admin can give a user
+var accountRoles = []string{"sender", RoleMotorbike, RoleHub, "admin"}
+
+const (
+	passwordResetTTL       = 24 * time.Hour
+	minPasswordLength      = 8
+	maxSuspensionReasonLen = 500
+)
+
+var (
+	ErrUserNotFound          = errors.New("user not found")
+	ErrCannotModifySelf      = errors.New("you cannot change your own account this way")
+	ErrInvalidRole           = errors.New("unknown role")
+	ErrSuspensionReason      = errors.New("a reason of at most 500 characters is required")
+	ErrAlreadySuspended      = errors.New("the account is already suspended")
+	ErrNotSuspended          = errors.New("the account is not suspended")
+	ErrInvalidResetToken     = errors.New("the password reset link is invalid or has expired")
+	ErrPasswordTooShort      = errors.New("password must be at least 8 characters")
+	ErrAccountSuspended      = errors.New("your account is suspended")
+	ErrTokenRevoked          = errors.New("your session has ended, please log in again")
+	ErrPasswordResetRequired = errors.New("you must reset your password before logging in")
+)
+
+// AccountRoles returns the roles an admin can give a user
+func AccountRoles() []string {
+	return accountRoles
+}
+
+// LockUser loads a user by ID and locks it until the transaction ends
+func LockUser(tx *gorm.DB, id interface{}) (*models.User, error) {
+	var user models.User
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUserNotFound
+	}
+	return &user, err
+}
+
+// CheckAccountAccess refuses suspended accounts and tokens issued before the account's tokens were revoked.
+// Tokens only carry their issue time to the second.
+func CheckAccountAccess(user *models.User, issuedAt time.Time) error {
+	if user.Status == UserSuspended {
+		return ErrAccountSuspended
+	}
+	if user.TokensValidAfter != nil && issuedAt.Before(user.TokensValidAfter.Truncate(time.Second)) {
+		return ErrTokenRevoked
+	}
+	return nil
+}
+
+// SuspendUser suspends an account, which is refused by every route from then on. The parcels a suspended
+// motorbike is carrying are released for other motorbikes to pick up. It returns the released parcels.
+func SuspendUser(tx *gorm.DB, user *models.User, adminID uint, reason string, now time.Time) ([]models.Parcel, error) {
+	if user.ID == adminID {
+		return nil, ErrCannotModifySelf
+	}
+	if user.Status == UserSuspended {
+		return nil, ErrAlreadySuspended
+	}
+	reason = strings.TrimSpace(reason)
+	if reason == "" || len(reason) > maxSuspensionReasonLen {
+		return nil, ErrSuspensionReason
+	}
+
+	user.Status = UserSuspended
+	user.SuspendedAt = &now
+	user.SuspensionReason = &reason
+	err := tx.Model(user).Updates(map[string]interface{}{
+		"status":            UserSuspended,
+		"suspended_at":      now,
+		"suspension_reason": reason,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+	return releaseCourierParcels(tx, user, adminID, "courier account suspended", now)
+}
+
+// ReactivateUser lifts the suspension of an account
+func ReactivateUser(tx *gorm.DB, user *models.User) error {
+	if user.Status != UserSuspended {
+		return ErrNotSuspended
+	}
+	user.Status = UserActive
+	user.SuspendedAt = nil
+	user.SuspensionReason = nil
+	return tx.Model(user).Updates(map[string]interface{}{
+		"status":            UserActive,
+		"suspended_at":      nil,
+		"suspension_reason": nil,
+	}).Error
+}
+
+// ChangeUserRole gives a user another role. A motorbike that stops being one releases the parcels it is
+// carrying and leaves its home zone. It returns the released parcels.
+func ChangeUserRole(tx *gorm.DB, user *models.User, adminID uint, role string, now time.Time) ([]models.Parcel, error) {
+	if user.ID == adminID {
+		return nil, ErrCannotModifySelf
+	}
+	if !containsString(accountRoles, role) {
+		return nil, ErrInvalidRole
+	}
+	if role == user.Role {
+		return nil, nil
+	}
+
+	released, err := releaseCourierParcels(tx, user, adminID, "courier role removed", now)
+	if err != nil {
+		return nil, err
+	}
+	user.Role = role
+	user.HomeZoneID = nil
+	err = tx.Model(user).Updates(map[string]interface{}{"role": role, "home_zone_id": nil}).Error
+	return released, err
+}
+
+// ForcePasswordReset makes a user choose a new password: their tokens are revoked, logging in is refused
+// and a one-time reset token valid for 24 hours is returned to be sent to them, never to the admin
+func ForcePasswordReset(tx *gorm.DB, user *models.User, now time.Time) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+	hash := hashResetToken(token)
+	expiresAt := now.Add(passwordResetTTL)
+
+	user.PasswordResetRequired = true
+	user.PasswordResetTokenHash = &hash
+	user.PasswordResetExpiresAt = &expiresAt
+	user.TokensValidAfter = &now
+	err := tx.Model(user).Updates(map[string]interface{}{
+		"password_reset_required":   true,
+		"password_reset_token_hash": hash,
+		"password_reset_expires_at": expiresAt,
+		"tokens_valid_after":        now,
+	}).Error
+	return token, err
+}
+
+// ResetPassword sets a new password with a reset token and lets the user log in again. It returns the user.
+func ResetPassword(tx *gorm.DB, token, password string, now time.Time) (*models.User, error) {
+	if len(password) < minPasswordLength {
+		return nil, ErrPasswordTooShort
+	}
+
+	var user models.User
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("password_reset_token_hash = ?", hashResetToken(token)).Limit(1).Find(&user).Error
+	if err != nil {
+		return nil, err
+	}
+	if user.ID == 0 || user.PasswordResetExpiresAt == nil || now.After(*user.PasswordResetExpiresAt) {
+		return nil, ErrInvalidResetToken
+	}
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	return &user, tx.Model(&user).Updates(map[string]interface{}{
+		"password":                  string(hashed),
+		"password_reset_required":   false,
+		"password_reset_token_hash": nil,
+		"password_reset_expires_at": nil,
+		"tokens_valid_after":        now,
+	}).Error
+}
+
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// DeleteUser soft-deletes an account: it can no longer log in or use its tokens, but its parcels, ratings
+// and ledger entries are kept. The parcels a deleted motorbike is carrying are released.
+func DeleteUser(tx *gorm.DB, user *models.User, adminID uint, now time.Time) ([]models.Parcel, error) {
+	if user.ID == adminID {
+		return nil, ErrCannotModifySelf
+	}
+	released, err := releaseCourierParcels(tx, user, adminID, "courier account deleted", now)
+	if err != nil {
+		return nil, err
+	}
+	return released, tx.Delete(user).Error
+}
+
+// releaseCourierParcels releases, on behalf of an admin, the legs a motorbike is carrying and cancels the handoffs
+// offered to it
+func releaseCourierParcels(tx *gorm.DB, user *models.User, adminID uint, reason string, now time.Time) ([]models.Parcel, error) {
+	if user.Role != RoleMotorbike {
+		return nil, nil
+	}
+
+	err := tx.Model(&models.ParcelHandoff{}).
+		Where("to_motorbike_id = ? AND status = ?", user.ID, HandoffPending).
+		Updates(map[string]interface{}{"status": HandoffCanceled, "responded_at": now}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []uint
+	err = tx.Model(&models.ParcelLeg{}).
+		Where("motorbike_id = ? AND status = ?", user.ID, LegPickedUp).
+		Distinct().Pluck("parcel_id", &ids).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var released []models.Parcel
+	for _, id := range ids {
+		parcel, err := LockParcel(tx, id)
+		if err != nil {
+			return nil, err
+		}
+		err = ReleaseParcel(tx, parcel, user.ID, adminID, reason, nil, nil)
+		if errors.Is(err, ErrNotCarrying) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		released = append(released, *parcel)
+	}
+	return released, nil
+}
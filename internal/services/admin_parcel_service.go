@@ -0,0 +1,348 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"go-delivery-app/internal/models"
+	"go-delivery-app/internal/notifications"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Admin parcel actions
+const (
+	AdminActionAssign = "assign"
+	AdminActionStatus = "status"
+	AdminActionNote   = "note"
+	AdminActionRefund = "refund"
+)
+
+// EventLost is recorded when admin marks a parcel lost
+const EventLost = "lost"
+
+const maxAdminNoteLength = 1000
+
+// overrideStatuses are the statuses admin can move a parcel to
+var overrideStatuses = []string{StatusAtHub, StatusAwaiting, StatusDelivered, StatusCanceled, StatusLost}
+
+var (
+	ErrAdminNoteRequired   = errors.New("a reason of at most 1000 characters is required")
+	ErrAssigneeNotCourier  = errors.New("parcels can only be assigned to an active motorbike")
+	ErrAlreadyAssigned     = errors.New("the parcel is already with this motorbike")
+	ErrParcelClosed        = errors.New("the parcel is delivered, canceled or lost")
+	ErrInvalidStatus       = errors.New("unknown status")
+	ErrStatusTransition    = errors.New("the parcel cannot move to this status")
+	ErrNothingToRefund     = errors.New("nothing is left to refund on this parcel")
+	ErrInvalidRefundAmount = errors.New("amount_cents must be positive and at most what the sender paid and was not refunded")
+)
+
+// OverrideStatuses returns the statuses admin can move a parcel to
+func OverrideStatuses() []string {
+	return overrideStatuses
+}
+
+// AssignParcel hands a parcel to a motorbike chosen by admin: a parcel waiting for a courier is picked up
+// on its behalf, and a parcel already picked up is taken off its courier. The checks a motorbike goes
+// through when picking up (pickup window, parcel in hand, cancellations) are skipped.
+// The parcel should be locked by the caller.
+func AssignParcel(tx *gorm.DB, parcel *models.Parcel, adminID, courierID uint, reason string, now time.Time) (*models.ParcelAdminAction, error) {
+	reason, err := adminNote(reason)
+	if err != nil {
+		return nil, err
+	}
+	if ParcelClosed(parcel) {
+		return nil, ErrParcelClosed
+	}
+
+	var courier models.User
+	if err := tx.Limit(1).Find(&courier, courierID).Error; err != nil {
+		return nil, err
+	}
+	if courier.ID == 0 || courier.Role != RoleMotorbike || courier.Status != UserActive {
+		return nil, ErrAssigneeNotCourier
+	}
+
+	legs, err := ParcelLegs(tx, parcel.ID)
+	if err != nil {
+		return nil, err
+	}
+	leg := CurrentLeg(legs)
+	if leg == nil {
+		return nil, ErrParcelClosed
+	}
+	statusBefore, motorbikeBefore := parcel.Status, parcel.MotorbikeID
+
+	switch leg.Status {
+	case LegReady:
+		scanType := ScanPickup
+		if leg.FromHubID != nil {
+			scanType = ScanHubOut
+		}
+		_, err := ApplyScan(tx, parcel, Scan{
+			Type:          scanType,
+			ActorID:       adminID,
+			ActorRole:     "admin",
+			MotorbikeID:   &courierID,
+			AdminOverride: true,
+			Note:          &reason,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+	case LegPickedUp:
+		if assignedTo(leg.MotorbikeID, courierID) {
+			return nil, ErrAlreadyAssigned
+		}
+		previous := *leg.MotorbikeID
+		if err := closePendingHandoffs(tx, leg.ID, HandoffCanceled, now); err != nil {
+			return nil, err
+		}
+		leg.MotorbikeID = &courierID
+		if err := tx.Save(leg).Error; err != nil {
+			return nil, err
+		}
+		parcel.MotorbikeID = &courierID
+		if err := tx.Omit("Legs").Save(parcel).Error; err != nil {
+			return nil, err
+		}
+		note := fmt.Sprintf("Reassigned by admin from motorbike %d to motorbike %d", previous, courierID)
+		if err := RecordParcelEvent(tx, parcel, EventHandedOff, &adminID, note); err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, fmt.Errorf("%w: parcel is %s", ErrStatusTransition, parcel.Status)
+	}
+
+	return recordAdminAction(tx, parcel, adminID, AdminActionAssign, reason, statusBefore, motorbikeBefore, nil, now)
+}
+
+// OverrideParcelStatus moves a parcel to a status chosen by admin, through the same transition as the
+// scan or action that normally leads there: a parcel only reaches a hub or its drop-off point from the
+// leg carrying it there, and only a parcel being carried can go back to waiting for a courier. Any parcel
+// on its way can be canceled, with a full refund, or marked lost. The parcel should be locked by the caller.
+func OverrideParcelStatus(tx *gorm.DB, parcel *models.Parcel, adminID uint, status, reason string, now time.Time) (*models.ParcelAdminAction, error) {
+	reason, err := adminNote(reason)
+	if err != nil {
+		return nil, err
+	}
+	if !containsString(overrideStatuses, status) {
+		return nil, ErrInvalidStatus
+	}
+	if ParcelClosed(parcel) {
+		return nil, ErrParcelClosed
+	}
+	statusBefore, motorbikeBefore := parcel.Status, parcel.MotorbikeID
+
+	switch status {
+	case StatusAtHub, StatusDelivered:
+		scanType := ScanHubIn
+		if status == StatusDelivered {
+			scanType = ScanDelivery
+		}
+		_, err = ApplyScan(tx, parcel, Scan{
+			Type:          scanType,
+			ActorID:       adminID,
+			ActorRole:     "admin",
+			AdminOverride: true,
+			Note:          &reason,
+		})
+		var transitionErr *TransitionError
+		if errors.As(err, &transitionErr) {
+			return nil, fmt.Errorf("%w: parcel is %s", ErrStatusTransition, parcel.Status)
+		}
+
+	case StatusAwaiting:
+		if parcel.MotorbikeID == nil {
+			return nil, fmt.Errorf("%w: parcel is %s", ErrStatusTransition, parcel.Status)
+		}
+		err = ReleaseParcel(tx, parcel, *parcel.MotorbikeID, adminID, reason, nil, nil)
+		if errors.Is(err, ErrNotCarrying) {
+			return nil, fmt.Errorf("%w: parcel is %s", ErrStatusTransition, parcel.Status)
+		}
+
+	case StatusCanceled:
+		_, err = CancelParcel(tx, parcel, adminID, "admin", CancelAdminOverride, reason, now)
+
+	case StatusLost:
+		err = markLost(tx, parcel, adminID, now)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return recordAdminAction(tx, parcel, adminID, AdminActionStatus, reason, statusBefore, motorbikeBefore, nil, now)
+}
+
+// markLost ends the journey of a parcel that cannot be found. The money held for it stays in escrow
+// until admin refunds it.
+func markLost(tx *gorm.DB, parcel *models.Parcel, adminID uint, now time.Time) error {
+	parcel.Status = StatusLost
+	parcel.LostAt = &now
+	if err := tx.Omit("Legs").Save(parcel).Error; err != nil {
+		return err
+	}
+	if err := CancelLegs(tx, parcel.ID); err != nil {
+		return err
+	}
+	return RecordParcelEvent(tx, parcel, EventLost, &adminID, "")
+}
+
+// AddParcelNote adds an internal note to a parcel, only shown to admins
+func AddParcelNote(tx *gorm.DB, parcel *models.Parcel, adminID uint, note string, now time.Time) (*models.ParcelAdminAction, error) {
+	note, err := adminNote(note)
+	if err != nil {
+		return nil, err
+	}
+	return recordAdminAction(tx, parcel, adminID, AdminActionNote, note, parcel.Status, parcel.MotorbikeID, nil, now)
+}
+
+// RefundParcelByAdmin credits part or all of what the sender paid for a parcel back to their wallet.
+// Without an amount, everything held in escrow is refunded. The refund comes out of escrow first; once the
+// parcel is delivered, canceled or lost, the rest comes out of platform revenue. A parcel on its way can only
+// be refunded what is held for it, and no parcel more than the sender paid and was not refunded yet.
+// The parcel should be locked by the caller.
+func RefundParcelByAdmin(tx *gorm.DB, parcel *models.Parcel, adminID uint, amountCents *int64, reason string, now time.Time) (*models.ParcelAdminAction, error) {
+	reason, err := adminNote(reason)
+	if err != nil {
+		return nil, err
+	}
+
+	held, err := EscrowHeld(tx, parcel.ID)
+	if err != nil {
+		return nil, err
+	}
+	wallet, err := GetAccount(tx, parcel.SenderID, AccountSenderWallet)
+	if err != nil {
+		return nil, err
+	}
+	// What the sender paid for the parcel and was not given back, claim payouts aside
+	var paid int64
+	err = tx.Model(&models.Entry{}).
+		Where("account_id = ? AND parcel_id = ? AND kind <> ?", wallet.ID, parcel.ID, EntryClaimPayout).
+		Select("COALESCE(-SUM(amount_cents), 0)").Scan(&paid).Error
+	if err != nil {
+		return nil, err
+	}
+
+	amount := held
+	if amountCents != nil {
+		amount = *amountCents
+	}
+	limit := paid
+	if !ParcelClosed(parcel) && held < limit {
+		limit = held
+	}
+	if limit <= 0 {
+		return nil, ErrNothingToRefund
+	}
+	if amount <= 0 || amount > limit {
+		return nil, ErrInvalidRefundAmount
+	}
+
+	fromEscrow := amount
+	if fromEscrow > held {
+		fromEscrow = held
+	}
+	postings := []Posting{{AccountID: wallet.ID, AmountCents: amount}}
+	if fromEscrow > 0 {
+		escrow, err := GetAccount(tx, 0, AccountParcelEscrow)
+		if err != nil {
+			return nil, err
+		}
+		postings = append(postings, Posting{AccountID: escrow.ID, AmountCents: -fromEscrow})
+	}
+	if amount > fromEscrow {
+		revenue, err := GetAccount(tx, 0, AccountPlatformRevenue)
+		if err != nil {
+			return nil, err
+		}
+		postings = append(postings, Posting{AccountID: revenue.ID, AmountCents: fromEscrow - amount})
+	}
+	err = Post(tx, LedgerTransaction{
+		Kind:      EntryAdminRefund,
+		ParcelID:  &parcel.ID,
+		Reference: fmt.Sprintf("admin:%d", adminID),
+		Postings:  postings,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return recordAdminAction(tx, parcel, adminID, AdminActionRefund, reason, parcel.Status, parcel.MotorbikeID, &amount, now)
+}
+
+// NotifyAdminAction tells the sender and the couriers of a parcel about an admin action once it has been committed
+func NotifyAdminAction(parcel *models.Parcel, action *models.ParcelAdminAction) {
+	switch action.Action {
+	case AdminActionAssign:
+		notifications.PublishNotification("notifications_motorbike_queue", *action.MotorbikeAfter,
+			fmt.Sprintf("Parcel #%d has been assigned to you.", parcel.ID))
+		if action.MotorbikeBefore != nil {
+			notifications.PublishNotification("notifications_motorbike_queue", *action.MotorbikeBefore,
+				fmt.Sprintf("Parcel #%d has been reassigned to another motorbike.", parcel.ID))
+		}
+		switch {
+		case action.StatusBefore == StatusAtHub:
+			notifications.PublishNotification("notifications_sender_queue", parcel.SenderID, "Your parcel has left the sorting hub.")
+		case action.StatusBefore != parcel.Status:
+			notifications.PublishNotification("notifications_sender_queue", parcel.SenderID, "Your parcel has been picked up!")
+		}
+
+	case AdminActionRefund:
+		notifications.PublishNotification("notifications_sender_queue", parcel.SenderID,
+			fmt.Sprintf("%d cents were refunded to your wallet for parcel #%d.", *action.AmountCents, parcel.ID))
+
+	case AdminActionStatus:
+		message := ""
+		switch parcel.Status {
+		case StatusAtHub:
+			message = "Your parcel has arrived at a sorting hub."
+		case StatusAwaiting:
+			message = "Your courier had to hand your parcel back, it is waiting for another courier."
+		case StatusDelivered:
+			message = "Your parcel has been delivered!"
+		case StatusCanceled:
+			message = "Your parcel has been canceled"
+		case StatusLost:
+			message = "We are sorry, your parcel has been lost. You can open a claim for it."
+		}
+		notifications.PublishNotification("notifications_sender_queue", parcel.SenderID, message)
+		if action.MotorbikeBefore != nil {
+			notifications.PublishNotification("notifications_motorbike_queue", *action.MotorbikeBefore,
+				fmt.Sprintf("Parcel #%d is now %s.", parcel.ID, parcel.Status))
+		}
+	}
+}
+
+// adminNote trims the reason or note given with an admin action and checks it is there
+func adminNote(note string) (string, error) {
+	note = strings.TrimSpace(note)
+	if note == "" || len(note) > maxAdminNoteLength {
+		return "", ErrAdminNoteRequired
+	}
+	return note, nil
+}
+
+// recordAdminAction keeps an admin action on a parcel with what it changed
+func recordAdminAction(tx *gorm.DB, parcel *models.Parcel, adminID uint, action, note, statusBefore string, motorbikeBefore *uint, amountCents *int64, now time.Time) (*models.ParcelAdminAction, error) {
+	entry := models.ParcelAdminAction{
+		ParcelID:        parcel.ID,
+		AdminID:         adminID,
+		Action:          action,
+		Note:            note,
+		StatusBefore:    statusBefore,
+		StatusAfter:     parcel.Status,
+		MotorbikeBefore: motorbikeBefore,
+		MotorbikeAfter:  parcel.MotorbikeID,
+		AmountCents:     amountCents,
+		CreatedAt:       now,
+	}
+	if err := tx.Create(&entry).Error; err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
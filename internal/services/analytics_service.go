@@ -0,0 +1,323 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Analytics periods, the first grouping of every report
+const (
+	PeriodDay   = "day"
+	PeriodWeek  = "week"
+	PeriodMonth = "month"
+)
+
+// Analytics dimensions, the groupings a report can add to the period
+const (
+	GroupByStatus       = "status"
+	GroupByServiceLevel = "service_level"
+	GroupByCourier      = "courier"
+)
+
+// locationSlotSeconds is the length of the slots courier locations are counted in: a courier who reported
+// a location during a slot is counted online for the whole of it
+const locationSlotSeconds = 900
+
+var ErrInvalidGroupBy = errors.New("invalid group_by")
+
+// AnalyticsQuery selects what a report covers and how it is grouped
+type AnalyticsQuery struct {
+	From    time.Time // Inclusive
+	To      time.Time // Exclusive
+	Period  string    // day, week or month
+	GroupBy []string  // Dimensions, in the order of the request
+}
+
+// ParseGroupBy reads a comma-separated list holding at most one period and any of the dimensions allowed
+// for a report, the period being a day when none is given
+func ParseGroupBy(value string, allowed ...string) (string, []string, error) {
+	period, dimensions := PeriodDay, []string{}
+	periodSet := false
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		switch {
+		case part == "":
+		case part == PeriodDay || part == PeriodWeek || part == PeriodMonth:
+			if periodSet {
+				return "", nil, fmt.Errorf("%w: only one of day, week or month can be given", ErrInvalidGroupBy)
+			}
+			period, periodSet = part, true
+		case containsString(allowed, part) && !containsString(dimensions, part):
+			dimensions = append(dimensions, part)
+		default:
+			options := append([]string{PeriodDay, PeriodWeek, PeriodMonth}, allowed...)
+			return "", nil, fmt.Errorf("%w: use %s", ErrInvalidGroupBy, strings.Join(options, ", "))
+		}
+	}
+	return period, dimensions, nil
+}
+
+// groupColumns returns the SELECT expressions of the period of a time column, in UTC, and of the dimensions
+// of a query, each dimension being one of the columns given for the report. Period and dimensions come from
+// fixed lists, so they can be written in the statement.
+func (q AnalyticsQuery) groupColumns(timeColumn string, dimensions map[string]string) ([]string, error) {
+	if q.Period != PeriodDay && q.Period != PeriodWeek && q.Period != PeriodMonth {
+		return nil, ErrInvalidGroupBy
+	}
+	columns := []string{fmt.Sprintf("date_trunc('%s', %s AT TIME ZONE 'UTC') AS period", q.Period, timeColumn)}
+	for _, name := range q.GroupBy {
+		column, ok := dimensions[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidGroupBy, name)
+		}
+		columns = append(columns, column)
+	}
+	return columns, nil
+}
+
+// groupPositions lists the positions of the first n columns of a SELECT, to GROUP BY and ORDER BY them
+func groupPositions(n int) string {
+	positions := make([]string, n)
+	for i := range positions {
+		positions[i] = fmt.Sprint(i + 1)
+	}
+	return strings.Join(positions, ", ")
+}
+
+// ParcelVolume is the number of parcels created during a period that are in a status
+type ParcelVolume struct {
+	Period       time.Time `json:"period"`
+	Status       string    `json:"status"`
+	ServiceLevel *string   `json:"service_level,omitempty"`
+	Parcels      int64     `json:"parcels"`
+}
+
+// ParcelVolumes counts the parcels created per period by their current status
+func ParcelVolumes(tx *gorm.DB, q AnalyticsQuery) ([]ParcelVolume, error) {
+	q.GroupBy = append([]string{GroupByStatus}, q.GroupBy...)
+	columns, err := q.groupColumns("p.created_at", map[string]string{
+		GroupByStatus:       "p.status AS status",
+		GroupByServiceLevel: "p.service_level AS service_level",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	groups := groupPositions(len(columns))
+	rows := []ParcelVolume{}
+	err = tx.Raw(`SELECT `+strings.Join(columns, ", ")+`, COUNT(*) AS parcels
+		FROM parcels p
+		WHERE p.created_at >= ? AND p.created_at < ?
+		GROUP BY `+groups+` ORDER BY `+groups, q.From, q.To).
+		Scan(&rows).Error
+	return rows, err
+}
+
+// DurationStats describe how long a step took for the parcels created during a period
+type DurationStats struct {
+	Period         time.Time `json:"period"`
+	ServiceLevel   *string   `json:"service_level,omitempty"`
+	CourierID      *uint     `json:"courier_id,omitempty"`
+	Parcels        int64     `json:"parcels"`
+	AverageMinutes float64   `json:"average_minutes"`
+	MedianMinutes  float64   `json:"median_minutes"`
+	P90Minutes     float64   `json:"p90_minutes"`
+}
+
+// PickupLatency measures the time from creation to pickup of the parcels created per period that were
+// picked up
+func PickupLatency(tx *gorm.DB, q AnalyticsQuery) ([]DurationStats, error) {
+	return durationStats(tx, q, "p.created_at", "p.pickup_time", map[string]string{
+		GroupByServiceLevel: "p.service_level AS service_level",
+	})
+}
+
+// DeliveryDuration measures the time from pickup to delivery of the parcels created per period that were
+// delivered, grouped by courier on the one who delivered them
+func DeliveryDuration(tx *gorm.DB, q AnalyticsQuery) ([]DurationStats, error) {
+	return durationStats(tx, q, "p.pickup_time", "p.delivery_time", map[string]string{
+		GroupByServiceLevel: "p.service_level AS service_level",
+		GroupByCourier:      "p.motorbike_id AS courier_id",
+	})
+}
+
+func durationStats(tx *gorm.DB, q AnalyticsQuery, start, end string, dimensions map[string]string) ([]DurationStats, error) {
+	columns, err := q.groupColumns("p.created_at", dimensions)
+	if err != nil {
+		return nil, err
+	}
+
+	seconds := fmt.Sprintf("EXTRACT(EPOCH FROM %s - %s)::FLOAT8", end, start)
+	groups := groupPositions(len(columns))
+	rows := []DurationStats{}
+	err = tx.Raw(`SELECT `+strings.Join(columns, ", ")+`, COUNT(*) AS parcels,
+			AVG(`+seconds+`) / 60 AS average_minutes,
+			percentile_cont(0.5) WITHIN GROUP (ORDER BY `+seconds+`) / 60 AS median_minutes,
+			percentile_cont(0.9) WITHIN GROUP (ORDER BY `+seconds+`) / 60 AS p90_minutes
+		FROM parcels p
+		WHERE p.created_at >= ? AND p.created_at < ?
+			AND `+start+` IS NOT NULL AND `+end+` IS NOT NULL AND `+end+` >= `+start+`
+		GROUP BY `+groups+` ORDER BY `+groups, q.From, q.To).
+		Scan(&rows).Error
+	return rows, err
+}
+
+// CancellationStats are the parcels created during a period and how many of them were canceled, by whom
+type CancellationStats struct {
+	Period              time.Time `json:"period"`
+	ServiceLevel        *string   `json:"service_level,omitempty"`
+	Parcels             int64     `json:"parcels"`
+	Canceled            int64     `json:"canceled"`
+	CanceledBySender    int64     `json:"canceled_by_sender"`
+	CanceledByMotorbike int64     `json:"canceled_by_motorbike"`
+	CanceledByAdmin     int64     `json:"canceled_by_admin"`
+	CanceledAfterPickup int64     `json:"canceled_after_pickup"`
+	CancellationRate    float64   `json:"cancellation_rate"` // Canceled over created, from 0 to 1
+}
+
+// CancellationRates computes the share of the parcels created per period that were canceled
+func CancellationRates(tx *gorm.DB, q AnalyticsQuery) ([]CancellationStats, error) {
+	columns, err := q.groupColumns("p.created_at", map[string]string{
+		GroupByServiceLevel: "p.service_level AS service_level",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// A parcel is canceled once, its cancellation row names who did it
+	groups := groupPositions(len(columns))
+	rows := []CancellationStats{}
+	err = tx.Raw(`SELECT `+strings.Join(columns, ", ")+`, COUNT(*) AS parcels,
+			COUNT(*) FILTER (WHERE p.status = @canceled) AS canceled,
+			COUNT(*) FILTER (WHERE p.status = @canceled AND c.actor_role = 'sender') AS canceled_by_sender,
+			COUNT(*) FILTER (WHERE p.status = @canceled AND c.actor_role = @motorbike) AS canceled_by_motorbike,
+			COUNT(*) FILTER (WHERE p.status = @canceled AND c.actor_role = 'admin') AS canceled_by_admin,
+			COUNT(*) FILTER (WHERE p.status = @canceled AND p.pickup_time IS NOT NULL) AS canceled_after_pickup,
+			COUNT(*) FILTER (WHERE p.status = @canceled)::FLOAT8 / COUNT(*) AS cancellation_rate
+		FROM parcels p
+		LEFT JOIN LATERAL (
+			SELECT actor_role FROM parcel_cancellations WHERE parcel_id = p.id ORDER BY created_at DESC LIMIT 1
+		) c ON TRUE
+		WHERE p.created_at >= @from AND p.created_at < @to
+		GROUP BY `+groups+` ORDER BY `+groups,
+		map[string]interface{}{
+			"canceled":  StatusCanceled,
+			"motorbike": RoleMotorbike,
+			"from":      q.From,
+			"to":        q.To,
+		}).
+		Scan(&rows).Error
+	return rows, err
+}
+
+// CourierUtilization is the time couriers spent carrying parcels during a period, against the time they were
+// online
+type CourierUtilization struct {
+	Period         time.Time `json:"period"`
+	CourierID      *uint     `json:"courier_id,omitempty"`
+	ActiveCouriers int64     `json:"active_couriers"` // Couriers who carried at least one leg
+	Legs           int64     `json:"legs"`
+	BusyHours      float64   `json:"busy_hours"`   // From pickup to delivery of the legs delivered
+	OnlineHours    float64   `json:"online_hours"` // Slots of 15 minutes during which the courier reported a location
+	Utilization    *float64  `json:"utilization"`  // Busy over online hours, from 0 to 1, nil without any location
+}
+
+// CourierUtilizations computes, per period, the hours couriers spent carrying the legs they picked up and
+// delivered against the hours they reported their location
+func CourierUtilizations(tx *gorm.DB, q AnalyticsQuery) ([]CourierUtilization, error) {
+	busyColumns, err := q.groupColumns("l.pickup_time", map[string]string{GroupByCourier: "l.motorbike_id AS courier_id"})
+	if err != nil {
+		return nil, err
+	}
+	onlineColumns, err := q.groupColumns("cl.recorded_at", map[string]string{GroupByCourier: "cl.courier_id AS courier_id"})
+	if err != nil {
+		return nil, err
+	}
+
+	join, selectCourier, order := "o.period = b.period", "", "1"
+	if containsString(q.GroupBy, GroupByCourier) {
+		join += " AND o.courier_id = b.courier_id"
+		selectCourier = "COALESCE(b.courier_id, o.courier_id) AS courier_id, "
+		order = "1, 2"
+	}
+
+	groups := groupPositions(len(busyColumns))
+	rows := []CourierUtilization{}
+	err = tx.Raw(`WITH busy AS (
+			SELECT `+strings.Join(busyColumns, ", ")+`, COUNT(*) AS legs,
+				COUNT(DISTINCT l.motorbike_id) AS active_couriers,
+				SUM(EXTRACT(EPOCH FROM l.delivery_time - l.pickup_time))::FLOAT8 / 3600 AS busy_hours
+			FROM parcel_legs l
+			WHERE l.status = @delivered AND l.motorbike_id IS NOT NULL
+				AND l.pickup_time >= @from AND l.pickup_time < @to AND l.delivery_time IS NOT NULL
+			GROUP BY `+groups+`
+		), online AS (
+			SELECT `+strings.Join(onlineColumns, ", ")+`,
+				(COUNT(DISTINCT (cl.courier_id, floor(EXTRACT(EPOCH FROM cl.recorded_at) / @slot)))
+					* @slot / 3600.0)::FLOAT8 AS online_hours
+			FROM courier_locations cl
+			WHERE cl.recorded_at >= @from AND cl.recorded_at < @to
+			GROUP BY `+groups+`
+		)
+		SELECT COALESCE(b.period, o.period) AS period, `+selectCourier+`
+			COALESCE(b.active_couriers, 0) AS active_couriers,
+			COALESCE(b.legs, 0) AS legs,
+			COALESCE(b.busy_hours, 0) AS busy_hours,
+			COALESCE(o.online_hours, 0) AS online_hours,
+			CASE WHEN o.online_hours > 0 THEN LEAST(COALESCE(b.busy_hours, 0) / o.online_hours, 1) END AS utilization
+		FROM busy b
+		FULL JOIN online o ON `+join+`
+		ORDER BY `+order,
+		map[string]interface{}{
+			"delivered": LegDelivered,
+			"slot":      locationSlotSeconds,
+			"from":      q.From,
+			"to":        q.To,
+		}).
+		Scan(&rows).Error
+	return rows, err
+}
+
+// RatingTrend are the ratings given during a period
+type RatingTrend struct {
+	Period        time.Time `json:"period"`
+	CourierID     *uint     `json:"courier_id,omitempty"`
+	Ratings       int64     `json:"ratings"`
+	AverageRating float64   `json:"average_rating"`
+	OneStar       int64     `json:"one_star"`
+	TwoStars      int64     `json:"two_stars"`
+	ThreeStars    int64     `json:"three_stars"`
+	FourStars     int64     `json:"four_stars"`
+	FiveStars     int64     `json:"five_stars"`
+}
+
+// RatingTrends computes the average and the distribution of the ratings given per period in a direction,
+// grouped by courier on the courier of the parcel
+func RatingTrends(tx *gorm.DB, q AnalyticsQuery, direction string) ([]RatingTrend, error) {
+	// Ratings keep their creation time without a time zone, in UTC
+	columns, err := q.groupColumns("r.created_at AT TIME ZONE 'UTC'", map[string]string{
+		GroupByCourier: "r.motorbike_id AS courier_id",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	groups := groupPositions(len(columns))
+	rows := []RatingTrend{}
+	err = tx.Raw(`SELECT `+strings.Join(columns, ", ")+`, COUNT(*) AS ratings,
+			AVG(r.rating)::FLOAT8 AS average_rating,
+			COUNT(*) FILTER (WHERE r.rating = 1) AS one_star,
+			COUNT(*) FILTER (WHERE r.rating = 2) AS two_stars,
+			COUNT(*) FILTER (WHERE r.rating = 3) AS three_stars,
+			COUNT(*) FILTER (WHERE r.rating = 4) AS four_stars,
+			COUNT(*) FILTER (WHERE r.rating = 5) AS five_stars
+		FROM ratings r
+		WHERE r.direction = ? AND r.created_at >= ? AND r.created_at < ?
+		GROUP BY `+groups+` ORDER BY `+groups, direction, q.From, q.To).
+		Scan(&rows).Error
+	return rows, err
+}
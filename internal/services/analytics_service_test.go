@@ -0,0 +1,74 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseGroupBy(t *testing.T) {
+	allowed := []string{GroupByServiceLevel, GroupByCourier}
+	tests := []struct {
+		value      string
+		period     string
+		dimensions []string
+		err        error
+	}{
+		{"", PeriodDay, []string{}, nil},
+		{" , ", PeriodDay, []string{}, nil},
+		{"day", PeriodDay, []string{}, nil},
+		{"week", PeriodWeek, []string{}, nil},
+		{"month", PeriodMonth, []string{}, nil},
+		{"service_level", PeriodDay, []string{GroupByServiceLevel}, nil},
+		{"week,courier", PeriodWeek, []string{GroupByCourier}, nil},
+		{"courier, month, service_level", PeriodMonth, []string{GroupByCourier, GroupByServiceLevel}, nil},
+		{"day,week", "", nil, ErrInvalidGroupBy},
+		{"status", "", nil, ErrInvalidGroupBy},
+		{"year", "", nil, ErrInvalidGroupBy},
+		{"Day", "", nil, ErrInvalidGroupBy},
+		{"courier,courier", "", nil, ErrInvalidGroupBy},
+	}
+	for _, tt := range tests {
+		period, dimensions, err := ParseGroupBy(tt.value, allowed...)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("ParseGroupBy(%q) error = %v, want %v", tt.value, err, tt.err)
+			continue
+		}
+		if period != tt.period || !reflect.DeepEqual(dimensions, tt.dimensions) {
+			t.Errorf("ParseGroupBy(%q) = %q, %v, want %q, %v", tt.value, period, dimensions, tt.period, tt.dimensions)
+		}
+	}
+}
+
+func TestGroupColumns(t *testing.T) {
+	dimensions := map[string]string{
+		GroupByStatus:       "p.status AS status",
+		GroupByServiceLevel: "p.service_level AS service_level",
+	}
+	tests := []struct {
+		name  string
+		query AnalyticsQuery
+		want  []string
+		err   error
+	}{
+		{"period only", AnalyticsQuery{Period: PeriodDay}, []string{"date_trunc('day', p.created_at AT TIME ZONE 'UTC') AS period"}, nil},
+		{"dimensions in the order asked", AnalyticsQuery{Period: PeriodMonth, GroupBy: []string{GroupByServiceLevel, GroupByStatus}}, []string{
+			"date_trunc('month', p.created_at AT TIME ZONE 'UTC') AS period",
+			"p.service_level AS service_level",
+			"p.status AS status",
+		}, nil},
+		{"no period", AnalyticsQuery{}, nil, ErrInvalidGroupBy},
+		{"unknown period", AnalyticsQuery{Period: "year"}, nil, ErrInvalidGroupBy},
+		{"dimension of another report", AnalyticsQuery{Period: PeriodWeek, GroupBy: []string{GroupByCourier}}, nil, ErrInvalidGroupBy},
+	}
+	for _, tt := range tests {
+		got, err := tt.query.groupColumns("p.created_at", dimensions)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: groupColumns() error = %v, want %v", tt.name, err, tt.err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: groupColumns() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
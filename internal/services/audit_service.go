@@ -0,0 +1,223 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"go-delivery-app/internal/models"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Audited actions
+const (
+	AuditLogin               = "auth.login"
+	AuditLoginFailed         = "auth.login_failed"
+	AuditPasswordReset       = "auth.password_reset"
+	AuditUserSuspended       = "user.suspend"
+	AuditUserReactivated     = "user.reactivate"
+	AuditUserRoleChanged     = "user.role_change"
+	AuditUserPasswordReset   = "user.password_reset_forced"
+	AuditUserDeleted         = "user.delete"
+	AuditParcelCanceled      = "parcel.cancel"
+	AuditParcelAdminOverride = "parcel.admin_" // Followed by the admin action: assign, status, note or refund
+)
+
+// Audit targets
+const (
+	AuditTargetUser   = "user"
+	AuditTargetParcel = "parcel"
+)
+
+// auditLockKey serializes appends to the audit log through a transaction-scoped advisory lock,
+// so that every entry is chained to the one committed before it
+const auditLockKey = 72710049
+
+const maxAuditPageSize = 500
+
+// genesisHash is the previous hash of the first entry of the audit log
+var genesisHash = strings.Repeat("0", 64)
+
+// errAuditChainBroken stops the verification at the first broken entry
+var errAuditChainBroken = errors.New("audit chain broken")
+
+// AuditEvent is an action to record in the audit log
+type AuditEvent struct {
+	ActorID    *uint
+	ActorRole  string
+	Action     string
+	TargetType string
+	TargetID   string
+	IP         string
+	UserAgent  string
+	Before     interface{} // Encoded as JSON, nil leaves it empty
+	After      interface{}
+}
+
+// RecordAudit appends an event to the audit log, chained to the last entry. Called with the transaction
+// of the action, the entry is only kept if the action is.
+func RecordAudit(db *gorm.DB, event AuditEvent, now time.Time) error {
+	before, err := auditJSON(event.Before)
+	if err != nil {
+		return err
+	}
+	after, err := auditJSON(event.After)
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", auditLockKey).Error; err != nil {
+			return err
+		}
+		var last models.AuditEntry
+		if err := tx.Select("hash").Order("id DESC").Limit(1).Find(&last).Error; err != nil {
+			return err
+		}
+		if last.Hash == "" {
+			last.Hash = genesisHash
+		}
+
+		entry := models.AuditEntry{
+			ActorID:    event.ActorID,
+			ActorRole:  optionalString(event.ActorRole),
+			Action:     event.Action,
+			TargetType: optionalString(event.TargetType),
+			TargetID:   optionalString(event.TargetID),
+			IP:         optionalString(event.IP),
+			UserAgent:  optionalString(event.UserAgent),
+			Before:     before,
+			After:      after,
+			PrevHash:   last.Hash,
+			// The database keeps microseconds, the hash must be computed on what it keeps
+			CreatedAt: now.UTC().Truncate(time.Microsecond),
+		}
+		entry.Hash = AuditHash(&entry)
+		return tx.Create(&entry).Error
+	})
+}
+
+// AuditHash computes the hash of an audit entry from its content and the hash of the previous entry
+func AuditHash(entry *models.AuditEntry) string {
+	// Fields are encoded in a fixed order; the JSON states are hashed as the text stored
+	payload, _ := json.Marshal([]interface{}{
+		entry.PrevHash,
+		entry.CreatedAt.UTC().Format(time.RFC3339Nano),
+		entry.ActorID,
+		entry.ActorRole,
+		entry.Action,
+		entry.TargetType,
+		entry.TargetID,
+		entry.IP,
+		entry.UserAgent,
+		string(entry.Before),
+		string(entry.After),
+	})
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// auditJSON encodes the state of a target for the audit log
+func auditJSON(value interface{}) (json.RawMessage, error) {
+	if value == nil {
+		return nil, nil
+	}
+	return json.Marshal(value)
+}
+
+// optionalString returns nil for an empty string
+func optionalString(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
+
+// AuditFilter selects audit log entries; zero values match everything
+type AuditFilter struct {
+	ActorID    *uint
+	Action     string // Exact action, or a prefix ending with a dot such as "user."
+	TargetType string
+	TargetID   string
+	From       *time.Time
+	To         *time.Time
+	BeforeID   uint // Only entries older than this one, to page through the log
+	Limit      int
+}
+
+// AuditEntries returns the entries matching a filter, most recent first
+func AuditEntries(tx *gorm.DB, filter AuditFilter) ([]models.AuditEntry, error) {
+	if filter.Limit <= 0 || filter.Limit > maxAuditPageSize {
+		filter.Limit = maxAuditPageSize
+	}
+	query := tx.Order("id DESC").Limit(filter.Limit)
+	if filter.ActorID != nil {
+		query = query.Where("actor_id = ?", *filter.ActorID)
+	}
+	if strings.HasSuffix(filter.Action, ".") {
+		query = query.Where("action LIKE ?", strings.ReplaceAll(filter.Action, "_", `\_`)+"%")
+	} else if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.TargetType != "" {
+		query = query.Where("target_type = ?", filter.TargetType)
+	}
+	if filter.TargetID != "" {
+		query = query.Where("target_id = ?", filter.TargetID)
+	}
+	if filter.From != nil {
+		query = query.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("created_at < ?", *filter.To)
+	}
+	if filter.BeforeID != 0 {
+		query = query.Where("id < ?", filter.BeforeID)
+	}
+
+	entries := []models.AuditEntry{}
+	err := query.Find(&entries).Error
+	return entries, err
+}
+
+// AuditVerification is the result of checking the hash chain of the audit log
+type AuditVerification struct {
+	Entries  int64  `json:"entries"`   // Entries checked
+	LastHash string `json:"last_hash"` // Hash of the last entry, to keep outside the database
+	BrokenAt *uint  `json:"broken_at"` // First entry that does not match the chain, nil when it is intact
+	Problem  string `json:"problem,omitempty"`
+}
+
+// VerifyAuditLog walks the audit log in order and checks that every entry is chained to the previous one
+// and still matches its hash. Removing the most recent entries cannot be detected from the chain alone:
+// compare LastHash with a value kept from an earlier run.
+func VerifyAuditLog(tx *gorm.DB) (*AuditVerification, error) {
+	result := &AuditVerification{LastHash: genesisHash}
+	var entries []models.AuditEntry
+	err := tx.FindInBatches(&entries, 1000, func(_ *gorm.DB, _ int) error {
+		for i := range entries {
+			entry := &entries[i]
+			switch {
+			case entry.PrevHash != result.LastHash:
+				result.Problem = "the entry is not chained to the previous one, an entry was removed or inserted"
+			case AuditHash(entry) != entry.Hash:
+				result.Problem = "the entry does not match its hash, it was modified"
+			}
+			if result.Problem != "" {
+				id := entry.ID
+				result.BrokenAt = &id
+				return errAuditChainBroken
+			}
+			result.Entries++
+			result.LastHash = entry.Hash
+		}
+		return nil
+	}).Error
+	if err != nil && !errors.Is(err, errAuditChainBroken) {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"encoding/json"
+	"go-delivery-app/internal/models"
+	"testing"
+	"time"
+)
+
+func auditEntry() models.AuditEntry {
+	actorID := uint(7)
+	return models.AuditEntry{
+		ActorID:    &actorID,
+		ActorRole:  optionalString("admin"),
+		Action:     AuditUserSuspended,
+		TargetType: optionalString(AuditTargetUser),
+		TargetID:   optionalString("42"),
+		IP:         optionalString("203.0.113.9"),
+		UserAgent:  optionalString("curl/8.0"),
+		Before:     json.RawMessage(`{"suspended":false}`),
+		After:      json.RawMessage(`{"suspended":true}`),
+		PrevHash:   genesisHash,
+		CreatedAt:  time.Date(2026, 10, 19, 8, 30, 15, 123456000, time.UTC),
+	}
+}
+
+func TestAuditHashIsStable(t *testing.T) {
+	entry := auditEntry()
+	hash := AuditHash(&entry)
+	if len(hash) != 64 {
+		t.Fatalf("AuditHash() = %q, want 64 hex characters", hash)
+	}
+
+	// Stored hashes must keep verifying, so the encoding cannot change
+	const want = "ac4b5d9149cdad39f984edb17fbddcb6408bf058de9a5375005349f76d3cb2b8"
+	if hash != want {
+		t.Errorf("AuditHash() = %s, want %s", hash, want)
+	}
+	if again := AuditHash(&entry); again != hash {
+		t.Errorf("AuditHash() is not deterministic: %s then %s", hash, again)
+	}
+
+	// The same instant read back in another time zone hashes the same
+	paris := time.FixedZone("CEST", 2*60*60)
+	for _, createdAt := range []time.Time{entry.CreatedAt.In(paris), entry.CreatedAt.In(time.Local)} {
+		moved := entry
+		moved.CreatedAt = createdAt
+		if got := AuditHash(&moved); got != hash {
+			t.Errorf("AuditHash() with created_at %v = %s, want %s", createdAt, got, hash)
+		}
+	}
+
+	// The stored hash is not part of what is hashed
+	entry.ID, entry.Hash = 99, "ignored"
+	if got := AuditHash(&entry); got != hash {
+		t.Errorf("AuditHash() depends on the ID or the stored hash")
+	}
+}
+
+func TestAuditHashDetectsChanges(t *testing.T) {
+	otherActor := uint(8)
+	tests := []struct {
+		name   string
+		change func(entry *models.AuditEntry)
+	}{
+		{"previous hash", func(e *models.AuditEntry) { e.PrevHash = "1" + genesisHash[1:] }},
+		{"created at", func(e *models.AuditEntry) { e.CreatedAt = e.CreatedAt.Add(time.Microsecond) }},
+		{"actor", func(e *models.AuditEntry) { e.ActorID = &otherActor }},
+		{"no actor", func(e *models.AuditEntry) { e.ActorID = nil }},
+		{"actor role", func(e *models.AuditEntry) { e.ActorRole = optionalString("sender") }},
+		{"action", func(e *models.AuditEntry) { e.Action = AuditUserReactivated }},
+		{"target type", func(e *models.AuditEntry) { e.TargetType = optionalString(AuditTargetParcel) }},
+		{"target", func(e *models.AuditEntry) { e.TargetID = optionalString("43") }},
+		{"no target", func(e *models.AuditEntry) { e.TargetID = nil }},
+		{"ip", func(e *models.AuditEntry) { e.IP = optionalString("203.0.113.10") }},
+		{"user agent", func(e *models.AuditEntry) { e.UserAgent = optionalString("curl/8.1") }},
+		{"before", func(e *models.AuditEntry) { e.Before = json.RawMessage(`{"suspended":true}`) }},
+		{"after", func(e *models.AuditEntry) { e.After = json.RawMessage(`{"suspended":false}`) }},
+		{"after whitespace", func(e *models.AuditEntry) { e.After = json.RawMessage(`{"suspended": true}`) }},
+		{"before and after swapped", func(e *models.AuditEntry) { e.Before, e.After = e.After, e.Before }},
+		{"fields shifted", func(e *models.AuditEntry) { e.IP, e.UserAgent = optionalString("203.0.113.9curl/8.0"), nil }},
+	}
+	original := auditEntry()
+	hash := AuditHash(&original)
+	for _, tt := range tests {
+		entry := auditEntry()
+		tt.change(&entry)
+		if AuditHash(&entry) == hash {
+			t.Errorf("changing the %s keeps the hash", tt.name)
+		}
+	}
+}
+
+func TestAuditHashChain(t *testing.T) {
+	// Chain three entries the way RecordAudit does
+	entries := make([]models.AuditEntry, 3)
+	prev := genesisHash
+	for i := range entries {
+		entries[i] = auditEntry()
+		entries[i].ID = uint(i + 1)
+		entries[i].CreatedAt = entries[i].CreatedAt.Add(time.Duration(i) * time.Minute)
+		entries[i].PrevHash = prev
+		entries[i].Hash = AuditHash(&entries[i])
+		prev = entries[i].Hash
+	}
+	if entries[0].Hash == entries[1].Hash || entries[1].Hash == entries[2].Hash {
+		t.Fatal("entries with different content share a hash")
+	}
+
+	// brokenAt mirrors the checks of VerifyAuditLog and returns the ID of the first broken entry, or 0
+	brokenAt := func(entries []models.AuditEntry) uint {
+		prev := genesisHash
+		for i := range entries {
+			if entries[i].PrevHash != prev || AuditHash(&entries[i]) != entries[i].Hash {
+				return entries[i].ID
+			}
+			prev = entries[i].Hash
+		}
+		return 0
+	}
+
+	tests := []struct {
+		name   string
+		tamper func(entries []models.AuditEntry) []models.AuditEntry
+		want   uint
+	}{
+		{"intact", func(e []models.AuditEntry) []models.AuditEntry { return e }, 0},
+		{"modified entry", func(e []models.AuditEntry) []models.AuditEntry {
+			e[1].Action = AuditUserDeleted
+			return e
+		}, 2},
+		{"modified and rehashed entry", func(e []models.AuditEntry) []models.AuditEntry {
+			e[1].Action = AuditUserDeleted
+			e[1].Hash = AuditHash(&e[1])
+			return e
+		}, 3},
+		{"removed entry", func(e []models.AuditEntry) []models.AuditEntry {
+			return append(e[:1], e[2:]...)
+		}, 3},
+		{"reordered entries", func(e []models.AuditEntry) []models.AuditEntry {
+			e[1], e[2] = e[2], e[1]
+			return e
+		}, 3},
+	}
+	for _, tt := range tests {
+		copied := append([]models.AuditEntry(nil), entries...)
+		if got := brokenAt(tt.tamper(copied)); got != tt.want {
+			t.Errorf("%s: chain broken at %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"go-delivery-app/internal/models"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Cancellation reason codes
+const (
+	CancelChangedMind      = "changed_mind"
+	CancelWrongDetails     = "wrong_details"
+	CancelTooSlow          = "too_slow"
+	CancelVehicleProblem   = "vehicle_problem"
+	CancelUnsafe           = "unsafe"
+	CancelParcelDamaged    = "parcel_damaged"
+	CancelSenderNotThere   = "sender_unreachable"
+	CancelParcelProhibited = "prohibited_item"
+	CancelOther            = "other"
+	CancelAdminOverride    = "admin_override" // Canceled by admin, the note holds their reason
+)
+
+// cancelReasons lists the reason codes each role may give
+var cancelReasons = map[string][]string{
+	"sender":      {CancelChangedMind, CancelWrongDetails, CancelTooSlow, CancelOther},
+	RoleMotorbike: {CancelVehicleProblem, CancelUnsafe, CancelParcelDamaged, CancelSenderNotThere, CancelParcelProhibited, CancelOther},
+	"admin":       {CancelAdminOverride},
+}
+
+const maxCancelNoteLength = 500
+
+var (
+	ErrParcelNotCancelable = errors.New("parcel can no longer be canceled")
+	ErrInvalidCancelReason = errors.New("unknown cancellation reason")
+	ErrCancelNoteRequired  = errors.New("a note of at most 500 characters is required with the reason other")
+	ErrCancelDistanceLimit = errors.New("you have travelled too far with this parcel to cancel it, release it instead")
+	ErrCourierBlocked      = errors.New("you are blocked from picking up parcels because of too many cancellations")
+)
+
+// CancelFeeAfterPickupCents is what a sender pays for canceling a parcel that was already picked up,
+// configured with CANCEL_FEE_AFTER_PICKUP_CENTS. Canceling before pickup is free.
+func CancelFeeAfterPickupCents() int64 {
+	return int64(envInt("CANCEL_FEE_AFTER_PICKUP_CENTS", 300))
+}
+
+// CourierCancelMaxDistanceKm is how far a courier may travel on a leg and still cancel the parcel,
+// configured with COURIER_CANCEL_MAX_DISTANCE_KM. Zero lets couriers cancel at any distance.
+func CourierCancelMaxDistanceKm() float64 {
+	return envFloat("COURIER_CANCEL_MAX_DISTANCE_KM", 2)
+}
+
+// CancelReasons returns the reason codes a role may give when canceling
+func CancelReasons(role string) []string {
+	return cancelReasons[role]
+}
+
+// CancelParcel cancels a parcel on behalf of its sender, its courier or admin, applying the cancellation policy:
+// senders cancel for free before pickup and pay a fee after it, couriers cannot cancel once they have
+// travelled too far with the parcel, and admin cancellations are refunded in full. The parcel should be
+// locked by the caller.
+func CancelParcel(tx *gorm.DB, parcel *models.Parcel, actorID uint, role, reason, note string, now time.Time) (*models.ParcelCancellation, error) {
+	if ParcelClosed(parcel) {
+		return nil, fmt.Errorf("%w: parcel is %s", ErrParcelNotCancelable, parcel.Status)
+	}
+	if !containsString(cancelReasons[role], reason) {
+		return nil, ErrInvalidCancelReason
+	}
+	note = strings.TrimSpace(note)
+	if len(note) > maxCancelNoteLength || (reason == CancelOther && note == "") {
+		return nil, ErrCancelNoteRequired
+	}
+
+	legs, err := ParcelLegs(tx, parcel.ID)
+	if err != nil {
+		return nil, err
+	}
+	cancellation := models.ParcelCancellation{
+		ParcelID:  parcel.ID,
+		ActorID:   actorID,
+		ActorRole: role,
+		Reason:    reason,
+		CreatedAt: now,
+	}
+	if note != "" {
+		cancellation.Note = &note
+	}
+
+	if role == RoleMotorbike {
+		if leg := CurrentLeg(legs); leg != nil && leg.Status == LegPickedUp && leg.PickupTime != nil {
+			distance, err := travelledKm(tx, actorID, *leg.PickupTime, now)
+			if err != nil {
+				return nil, err
+			}
+			cancellation.DistanceKm = &distance
+			if limit := CourierCancelMaxDistanceKm(); limit > 0 && distance > limit {
+				return nil, ErrCancelDistanceLimit
+			}
+		}
+	}
+
+	// Cancellations by the courier or by admin and anything before pickup are refunded in full
+	pickedUp := parcel.Status != StatusCreated
+	if role == RoleMotorbike || role == "admin" || !pickedUp {
+		err = RefundParcel(tx, parcel)
+	} else {
+		cancellation.FeeCents, err = SettleCancellationFee(tx, parcel, CancelFeeAfterPickupCents(), carryingCouriers(legs))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	parcel.Status = StatusCanceled
+	parcel.CanceledAt = &now
+	parcel.CancelReason = &reason
+	if err := tx.Omit("Legs").Save(parcel).Error; err != nil {
+		return nil, err
+	}
+	if err := CancelLegs(tx, parcel.ID); err != nil {
+		return nil, err
+	}
+	if err := tx.Create(&cancellation).Error; err != nil {
+		return nil, err
+	}
+	if err := RecordParcelEvent(tx, parcel, EventCanceled, &actorID, reason); err != nil {
+		return nil, err
+	}
+	return &cancellation, nil
+}
+
+// carryingCouriers returns the couriers who carried a parcel on its delivered legs and the leg in progress
+func carryingCouriers(legs []models.ParcelLeg) []uint {
+	couriers := LegCouriers(legs)
+	if leg := CurrentLeg(legs); leg != nil && leg.Status == LegPickedUp && leg.MotorbikeID != nil {
+		couriers = append(couriers, *leg.MotorbikeID)
+	}
+	return couriers
+}
+
+// travelledKm adds up the distance between the locations a courier reported in a period
+func travelledKm(tx *gorm.DB, courierID uint, from, to time.Time) (float64, error) {
+	var locations []models.CourierLocation
+	err := tx.Where("courier_id = ? AND recorded_at BETWEEN ? AND ?", courierID, from, to).
+		Order("recorded_at").Find(&locations).Error
+	if err != nil {
+		return 0, err
+	}
+	var distance float64
+	for i := 1; i < len(locations); i++ {
+		prev, cur := locations[i-1], locations[i]
+		distance += Haversine(prev.Latitude, prev.Longitude, cur.Latitude, cur.Longitude)
+	}
+	return distance, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// CourierCancellationStats is how often a courier cancels the parcels they pick up
+type CourierCancellationStats struct {
+	CourierID     uint    `json:"courier_id"`
+	Name          string  `json:"name"`
+	Email         string  `json:"email"`
+	Pickups       int64   `json:"pickups"`
+	Cancellations int64   `json:"cancellations"`
+	Rate          float64 `json:"rate"`
+	Blocked       bool    `json:"blocked"`
+}
+
+// CourierCancelWindow is the period cancellation rates are computed over, configured with COURIER_CANCEL_WINDOW_DAYS
+func CourierCancelWindow() time.Duration {
+	return time.Duration(envInt("COURIER_CANCEL_WINDOW_DAYS", 30)) * 24 * time.Hour
+}
+
+// CourierCancelMaxRate is the share of pickups a courier may cancel before being blocked from picking up parcels,
+// configured with COURIER_CANCEL_MAX_RATE. Couriers with fewer than COURIER_CANCEL_MIN_PICKUPS pickups are never blocked.
+func CourierCancelMaxRate() float64 {
+	return envFloat("COURIER_CANCEL_MAX_RATE", 0.2)
+}
+
+func courierCancelMinPickups() int64 {
+	return int64(envInt("COURIER_CANCEL_MIN_PICKUPS", 10))
+}
+
+// CourierCancellationRates returns the cancellation stats of the motorbikes over the configured window,
+// worst first. A courierID other than 0 selects a single courier.
+func CourierCancellationRates(tx *gorm.DB, courierID uint, now time.Time) ([]CourierCancellationStats, error) {
+	since := now.Add(-CourierCancelWindow())
+	query := tx.Table("users u").
+		Select(`u.id AS courier_id, u.name, u.email,
+			(SELECT COUNT(*) FROM parcel_legs l WHERE l.motorbike_id = u.id AND l.pickup_time >= ?) AS pickups,
+			(SELECT COUNT(*) FROM parcel_cancellations c WHERE c.actor_id = u.id AND c.actor_role = ? AND c.created_at >= ?) AS cancellations`,
+			since, RoleMotorbike, since).
+		Where("u.role = ?", RoleMotorbike)
+	if courierID != 0 {
+		query = query.Where("u.id = ?", courierID)
+	}
+
+	var stats []CourierCancellationStats
+	if err := query.Scan(&stats).Error; err != nil {
+		return nil, err
+	}
+	for i := range stats {
+		if stats[i].Pickups > 0 {
+			stats[i].Rate = float64(stats[i].Cancellations) / float64(stats[i].Pickups)
+		}
+		stats[i].Blocked = stats[i].Pickups >= courierCancelMinPickups() && stats[i].Rate > CourierCancelMaxRate()
+	}
+	sort.SliceStable(stats, func(i, j int) bool {
+		if stats[i].Rate != stats[j].Rate {
+			return stats[i].Rate > stats[j].Rate
+		}
+		return stats[i].Cancellations > stats[j].Cancellations
+	})
+	return stats, nil
+}
+
+// ensureCourierNotBlocked refuses new pickups to couriers whose cancellation rate is too high
+func ensureCourierNotBlocked(tx *gorm.DB, courierID uint) error {
+	stats, err := CourierCancellationRates(tx, courierID, time.Now())
+	if err != nil {
+		return err
+	}
+	if len(stats) == 1 && stats[0].Blocked {
+		return ErrCourierBlocked
+	}
+	return nil
+}
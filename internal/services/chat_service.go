@@ -0,0 +1,220 @@
+package services
+
+import (
+	"errors"
+	"go-delivery-app/internal/models"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Conversation statuses
+const (
+	ConversationOpen   = "open"
+	ConversationClosed = "closed"
+)
+
+const (
+	maxChatMessageLength = 1000
+	maxChatPageSize      = 100
+	chatJanitorInterval  = time.Hour
+)
+
+var (
+	ErrChatNotAllowed   = errors.New("you can only chat about parcels you sent or are carrying")
+	ErrChatNotFound     = errors.New("there is no conversation about this parcel yet")
+	ErrChatClosed       = errors.New("the conversation is closed")
+	ErrChatMessageEmpty = errors.New("a message of at most 1000 characters is required")
+)
+
+var (
+	// phonePattern finds runs of digits that look like phone numbers; digits are counted afterwards
+	// so that short codes such as "gate code is 1234" are kept
+	phonePattern = regexp.MustCompile(`\+?\d[\d\s().-]{4,}\d`)
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+)
+
+// ChatRetention is how long the messages of a closed conversation are kept, configured with CHAT_RETENTION_DAYS
+func ChatRetention() time.Duration {
+	return time.Duration(envInt("CHAT_RETENTION_DAYS", 30)) * 24 * time.Hour
+}
+
+// chatOpen reports whether the sender of a parcel can chat with the motorbike: while the parcel is on its way
+func chatOpen(parcel *models.Parcel) bool {
+	return parcel.MotorbikeID != nil && !ParcelClosed(parcel)
+}
+
+// GetConversation returns the conversation a user can see on a parcel. While the parcel is on its way the
+// conversation with the motorbike carrying it is opened on first use; afterwards the last conversation is
+// returned closed, until its messages are deleted.
+func GetConversation(tx *gorm.DB, parcel *models.Parcel, userID uint, role string) (*models.Conversation, error) {
+	var motorbikeID uint
+	switch {
+	case role == RoleMotorbike:
+		motorbikeID = userID
+	case parcel.SenderID == userID:
+		if parcel.MotorbikeID != nil {
+			motorbikeID = *parcel.MotorbikeID
+		}
+	default:
+		return nil, ErrChatNotAllowed
+	}
+
+	active := chatOpen(parcel) && *parcel.MotorbikeID == motorbikeID
+	var conversation models.Conversation
+	query := tx.Where("parcel_id = ?", parcel.ID)
+	if motorbikeID != 0 {
+		query = query.Where("motorbike_id = ?", motorbikeID)
+	}
+	if err := query.Order("created_at DESC").Limit(1).Find(&conversation).Error; err != nil {
+		return nil, err
+	}
+
+	if conversation.ID == 0 {
+		if !active {
+			if role == RoleMotorbike {
+				return nil, ErrChatNotAllowed
+			}
+			return nil, ErrChatNotFound
+		}
+		conversation = models.Conversation{
+			ParcelID:    parcel.ID,
+			SenderID:    parcel.SenderID,
+			MotorbikeID: motorbikeID,
+			Status:      ConversationOpen,
+			CreatedAt:   time.Now(),
+		}
+		// Both participants may open it at the same time; the unique index keeps one of them
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&conversation).Error; err != nil {
+			return nil, err
+		}
+		if conversation.ID == 0 {
+			if err := tx.Where("parcel_id = ? AND motorbike_id = ?", parcel.ID, motorbikeID).First(&conversation).Error; err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// The parcel was delivered or handed to another motorbike since the last message
+	if !active && conversation.Status == ConversationOpen {
+		if err := closeConversation(tx, &conversation, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+	return &conversation, nil
+}
+
+// closeConversation closes a conversation, starting its retention period
+func closeConversation(tx *gorm.DB, conversation *models.Conversation, now time.Time) error {
+	conversation.Status = ConversationClosed
+	conversation.ClosedAt = &now
+	return tx.Model(conversation).Updates(map[string]interface{}{"status": ConversationClosed, "closed_at": now}).Error
+}
+
+// SendChatMessage adds a message to an open conversation. Phone numbers and e-mail addresses written by the
+// sender are masked, so the motorbike never sees the sender's contact details.
+func SendChatMessage(tx *gorm.DB, conversation *models.Conversation, authorID uint, body string, now time.Time) (*models.ChatMessage, error) {
+	if conversation.Status != ConversationOpen {
+		return nil, ErrChatClosed
+	}
+	body = strings.TrimSpace(body)
+	if body == "" || len(body) > maxChatMessageLength {
+		return nil, ErrChatMessageEmpty
+	}
+	if authorID == conversation.SenderID {
+		body = MaskContactDetails(body)
+	}
+
+	message := models.ChatMessage{
+		ConversationID: conversation.ID,
+		AuthorID:       authorID,
+		Body:           body,
+		CreatedAt:      now,
+	}
+	if err := tx.Create(&message).Error; err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// MaskContactDetails hides the phone numbers and e-mail addresses in a text
+func MaskContactDetails(text string) string {
+	text = emailPattern.ReplaceAllString(text, "[email hidden]")
+	return phonePattern.ReplaceAllStringFunc(text, func(match string) string {
+		digits := 0
+		for _, c := range match {
+			if c >= '0' && c <= '9' {
+				digits++
+			}
+		}
+		if digits < 7 {
+			return match
+		}
+		return "[phone hidden]"
+	})
+}
+
+// ChatMessages returns up to limit messages of a conversation following the message afterID, oldest first
+func ChatMessages(tx *gorm.DB, conversation *models.Conversation, afterID uint, limit int) ([]models.ChatMessage, error) {
+	if limit <= 0 || limit > maxChatPageSize {
+		limit = maxChatPageSize
+	}
+	messages := []models.ChatMessage{}
+	err := tx.Where("conversation_id = ? AND id > ?", conversation.ID, afterID).
+		Order("id").Limit(limit).Find(&messages).Error
+	return messages, err
+}
+
+// MarkChatRead marks the messages the other participant sent as read and returns the last one marked,
+// or 0 when there was nothing new
+func MarkChatRead(tx *gorm.DB, conversation *models.Conversation, readerID uint, now time.Time) (uint, error) {
+	var lastID uint
+	err := tx.Model(&models.ChatMessage{}).
+		Where("conversation_id = ? AND author_id <> ? AND read_at IS NULL", conversation.ID, readerID).
+		Select("COALESCE(MAX(id), 0)").Scan(&lastID).Error
+	if err != nil || lastID == 0 {
+		return 0, err
+	}
+	err = tx.Model(&models.ChatMessage{}).
+		Where("conversation_id = ? AND author_id <> ? AND read_at IS NULL AND id <= ?", conversation.ID, readerID, lastID).
+		Update("read_at", now).Error
+	return lastID, err
+}
+
+// ChatPeer returns the other participant of a conversation
+func ChatPeer(conversation *models.Conversation, userID uint) uint {
+	if userID == conversation.SenderID {
+		return conversation.MotorbikeID
+	}
+	return conversation.SenderID
+}
+
+// RunChatJanitor closes finished conversations and deletes old ones in the background
+func RunChatJanitor(db *gorm.DB) {
+	for {
+		if err := CleanUpConversations(db, time.Now()); err != nil {
+			log.Printf("Failed to clean up conversations: %v", err)
+		}
+		time.Sleep(chatJanitorInterval)
+	}
+}
+
+// CleanUpConversations closes the conversations whose parcel was delivered, canceled, lost or handed to another
+// motorbike, and deletes the conversations closed for longer than the retention period with their messages
+func CleanUpConversations(db *gorm.DB, now time.Time) error {
+	err := db.Model(&models.Conversation{}).
+		Where("status = ?", ConversationOpen).
+		Where(`EXISTS (SELECT 1 FROM parcels p WHERE p.id = conversations.parcel_id
+			AND (p.status IN ? OR p.motorbike_id IS DISTINCT FROM conversations.motorbike_id))`,
+			[]string{StatusDelivered, StatusCanceled, StatusLost}).
+		Updates(map[string]interface{}{"status": ConversationClosed, "closed_at": now}).Error
+	if err != nil {
+		return err
+	}
+	return db.Where("status = ? AND closed_at < ?", ConversationClosed, now.Add(-ChatRetention())).
+		Delete(&models.Conversation{}).Error
+}
@@ -0,0 +1,364 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"go-delivery-app/internal/models"
+	"go-delivery-app/internal/notifications"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Claim types
+const (
+	ClaimDamaged      = "damaged"
+	ClaimLost         = "lost"
+	ClaimMissingItems = "missing_items"
+	ClaimOther        = "other"
+)
+
+// Claim statuses
+const (
+	ClaimOpen          = "open"
+	ClaimInvestigating = "investigating"
+	ClaimApproved      = "approved"
+	ClaimRejected      = "rejected"
+)
+
+// Parcel events recorded on the history of a parcel with a claim
+const (
+	EventClaimOpened   = "claim_opened"
+	EventClaimResolved = "claim_resolved"
+)
+
+var claimTypes = []string{ClaimDamaged, ClaimLost, ClaimMissingItems, ClaimOther}
+
+// claimTransitions lists the statuses a claim can move to from each status
+var claimTransitions = map[string][]string{
+	ClaimOpen:          {ClaimInvestigating, ClaimApproved, ClaimRejected},
+	ClaimInvestigating: {ClaimApproved, ClaimRejected},
+}
+
+// Limits on the evidence attached to a claim
+const (
+	MaxClaimAttachmentBytes = 5 << 20
+	maxClaimAttachments     = 10
+	maxClaimTextLength      = 2000
+)
+
+// claimAttachmentTypes are the kinds of files accepted as evidence
+var claimAttachmentTypes = []string{"image/jpeg", "image/png", "image/webp", "image/heic", "application/pdf"}
+
+var (
+	ErrClaimNotAllowed       = errors.New("claims can only be opened on parcels that were delivered, lost or canceled after pickup")
+	ErrClaimWindowClosed     = errors.New("the period to open a claim on this parcel is over")
+	ErrClaimExists           = errors.New("a claim was already opened on this parcel")
+	ErrInvalidClaimType      = errors.New("unknown claim type")
+	ErrClaimDescription      = errors.New("a description of at most 2000 characters is required")
+	ErrInvalidClaimAmount    = errors.New("amounts cannot be negative")
+	ErrClaimNotFound         = errors.New("claim not found")
+	ErrClaimClosed           = errors.New("the claim is already resolved")
+	ErrInvalidClaimStatus    = errors.New("the claim cannot move to this status")
+	ErrClaimPayoutRequired   = errors.New("payout_cents is required to approve a claim")
+	ErrClaimAttachmentType   = errors.New("evidence must be a JPEG, PNG, WebP or HEIC photo or a PDF")
+	ErrClaimAttachmentsLimit = errors.New("a claim can have at most 10 attachments")
+	ErrClaimAttachmentEmpty  = errors.New("the attachment is empty")
+)
+
+// ClaimInput is what a sender sends when opening a claim
+type ClaimInput struct {
+	Type        string `json:"type"`
+	Description string `json:"description"`
+	AmountCents *int64 `json:"amount_cents"`
+}
+
+// ClaimReview is what an admin sends when changing the status of a claim
+type ClaimReview struct {
+	Status      string `json:"status"`
+	PayoutCents *int64 `json:"payout_cents"` // Required on approval, 0 approves without compensation
+	Note        string `json:"note"`
+}
+
+// ClaimWindow is how long after delivery or cancellation a claim can be opened, configured with CLAIM_WINDOW_DAYS
+func ClaimWindow() time.Duration {
+	return time.Duration(envInt("CLAIM_WINDOW_DAYS", 30)) * 24 * time.Hour
+}
+
+// ClaimTypes returns the types of claims senders can open
+func ClaimTypes() []string {
+	return claimTypes
+}
+
+// OpenClaim opens a claim on a parcel the sender sent. The parcel must have been delivered, picked up
+// and then canceled, or lost, within the claim window. The parcel should be locked by the caller.
+func OpenClaim(tx *gorm.DB, parcel *models.Parcel, senderID uint, input ClaimInput, now time.Time) (*models.Claim, error) {
+	if parcel.SenderID != senderID {
+		return nil, ErrParcelNotFound
+	}
+
+	var closedAt *time.Time
+	switch {
+	case parcel.Status == StatusDelivered:
+		closedAt = parcel.DeliveryTime
+	case parcel.Status == StatusCanceled && parcel.PickupTime != nil:
+		closedAt = parcel.CanceledAt
+	case parcel.Status == StatusLost:
+		closedAt = parcel.LostAt
+	}
+	if closedAt == nil {
+		return nil, ErrClaimNotAllowed
+	}
+	if now.Sub(*closedAt) > ClaimWindow() {
+		return nil, ErrClaimWindowClosed
+	}
+
+	if !containsString(claimTypes, input.Type) {
+		return nil, ErrInvalidClaimType
+	}
+	description := strings.TrimSpace(input.Description)
+	if description == "" || len(description) > maxClaimTextLength {
+		return nil, ErrClaimDescription
+	}
+	if input.AmountCents != nil && *input.AmountCents < 0 {
+		return nil, ErrInvalidClaimAmount
+	}
+
+	var count int64
+	if err := tx.Model(&models.Claim{}).Where("parcel_id = ?", parcel.ID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, ErrClaimExists
+	}
+
+	claim := models.Claim{
+		ParcelID:    parcel.ID,
+		SenderID:    senderID,
+		Type:        input.Type,
+		Description: description,
+		AmountCents: input.AmountCents,
+		Status:      ClaimOpen,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := tx.Create(&claim).Error; err != nil {
+		return nil, err
+	}
+	if err := recordClaimUpdate(tx, &claim, senderID, nil, "", now); err != nil {
+		return nil, err
+	}
+	if err := RecordParcelEvent(tx, parcel, EventClaimOpened, &senderID, fmt.Sprintf("claim %d: %s", claim.ID, claim.Type)); err != nil {
+		return nil, err
+	}
+	return &claim, nil
+}
+
+// LockClaim loads a claim by ID and locks it until the transaction ends
+func LockClaim(tx *gorm.DB, id interface{}) (*models.Claim, error) {
+	var claim models.Claim
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&claim, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrClaimNotFound
+	}
+	return &claim, err
+}
+
+// AddClaimAttachment attaches a photo or document to a claim that is still under review
+func AddClaimAttachment(tx *gorm.DB, claim *models.Claim, uploaderID uint, fileName, contentType string, data []byte, note string, now time.Time) (*models.ClaimAttachment, error) {
+	if claim.Status == ClaimApproved || claim.Status == ClaimRejected {
+		return nil, ErrClaimClosed
+	}
+	if !containsString(claimAttachmentTypes, contentType) {
+		return nil, ErrClaimAttachmentType
+	}
+	if len(data) == 0 {
+		return nil, ErrClaimAttachmentEmpty
+	}
+	note = strings.TrimSpace(note)
+	if len(note) > maxClaimTextLength {
+		return nil, ErrClaimDescription
+	}
+
+	var count int64
+	if err := tx.Model(&models.ClaimAttachment{}).Where("claim_id = ?", claim.ID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count >= maxClaimAttachments {
+		return nil, ErrClaimAttachmentsLimit
+	}
+
+	attachment := models.ClaimAttachment{
+		ClaimID:     claim.ID,
+		UploadedBy:  uploaderID,
+		FileName:    fileName,
+		ContentType: contentType,
+		SizeBytes:   len(data),
+		Data:        data,
+		CreatedAt:   now,
+	}
+	if note != "" {
+		attachment.Note = &note
+	}
+	if err := tx.Create(&attachment).Error; err != nil {
+		return nil, err
+	}
+	return &attachment, tx.Model(claim).Update("updated_at", now).Error
+}
+
+// ReviewClaim moves a claim to a new status. Approving it pays the compensation into the sender's wallet.
+// The claim should be locked by the caller.
+func ReviewClaim(tx *gorm.DB, claim *models.Claim, adminID uint, review ClaimReview, now time.Time) error {
+	allowed, open := claimTransitions[claim.Status]
+	if !open {
+		return ErrClaimClosed
+	}
+	if !containsString(allowed, review.Status) {
+		return ErrInvalidClaimStatus
+	}
+	note := strings.TrimSpace(review.Note)
+	if len(note) > maxClaimTextLength {
+		return ErrClaimDescription
+	}
+
+	var payout *int64
+	if review.Status == ClaimApproved {
+		if review.PayoutCents == nil {
+			return ErrClaimPayoutRequired
+		}
+		if *review.PayoutCents < 0 {
+			return ErrInvalidClaimAmount
+		}
+		payout = review.PayoutCents
+	}
+
+	claim.Status = review.Status
+	claim.ReviewedBy = &adminID
+	claim.UpdatedAt = now
+	if note != "" {
+		claim.ResolutionNote = &note
+	}
+	if review.Status == ClaimApproved || review.Status == ClaimRejected {
+		claim.PayoutCents = payout
+		claim.ResolvedAt = &now
+	}
+	if err := tx.Save(claim).Error; err != nil {
+		return err
+	}
+	if err := recordClaimUpdate(tx, claim, adminID, payout, note, now); err != nil {
+		return err
+	}
+	if claim.ResolvedAt == nil {
+		return nil
+	}
+
+	if payout != nil {
+		if err := PayClaim(tx, claim, *payout); err != nil {
+			return err
+		}
+	}
+	var parcel models.Parcel
+	if err := tx.First(&parcel, claim.ParcelID).Error; err != nil {
+		return err
+	}
+	return RecordParcelEvent(tx, &parcel, EventClaimResolved, &adminID, fmt.Sprintf("claim %d: %s", claim.ID, claim.Status))
+}
+
+// recordClaimUpdate adds the current status of a claim to its history
+func recordClaimUpdate(tx *gorm.DB, claim *models.Claim, actorID uint, payoutCents *int64, note string, now time.Time) error {
+	update := models.ClaimUpdate{
+		ClaimID:     claim.ID,
+		ActorID:     actorID,
+		Status:      claim.Status,
+		PayoutCents: payoutCents,
+		CreatedAt:   now,
+	}
+	if note != "" {
+		update.Note = &note
+	}
+	return tx.Create(&update).Error
+}
+
+// ProofOfDelivery is what was recorded when the courier marked the parcel delivered
+type ProofOfDelivery struct {
+	DeliveredAt     time.Time `json:"delivered_at"`
+	CourierID       *uint     `json:"courier_id"`
+	ScanType        *string   `json:"scan_type"` // Set when the delivery was confirmed by scanning the label
+	Latitude        *float64  `json:"latitude"`
+	Longitude       *float64  `json:"longitude"`
+	DistanceMeters  *float64  `json:"distance_meters"` // From the drop-off point
+	OutsideGeofence bool      `json:"outside_geofence"`
+	CODCollected    *int64    `json:"cod_collected"`
+}
+
+// ClaimDetail is a claim with its evidence, its history and the history of its parcel
+type ClaimDetail struct {
+	models.Claim
+	Attachments     []models.ClaimAttachment `json:"attachments"`
+	Updates         []models.ClaimUpdate     `json:"updates"`
+	ParcelEvents    []models.ParcelEvent     `json:"parcel_events"`
+	ProofOfDelivery *ProofOfDelivery         `json:"proof_of_delivery"` // Nil when the parcel was never delivered
+}
+
+// GetClaimDetail loads the evidence and history of a claim, along with the events of its parcel and
+// the proof of delivery
+func GetClaimDetail(tx *gorm.DB, claim *models.Claim) (*ClaimDetail, error) {
+	detail := ClaimDetail{
+		Claim:        *claim,
+		Attachments:  []models.ClaimAttachment{},
+		Updates:      []models.ClaimUpdate{},
+		ParcelEvents: []models.ParcelEvent{},
+	}
+	if err := tx.Omit("data").Where("claim_id = ?", claim.ID).Order("created_at, id").Find(&detail.Attachments).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Where("claim_id = ?", claim.ID).Order("created_at, id").Find(&detail.Updates).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Where("parcel_id = ?", claim.ParcelID).Order("created_at, id").Find(&detail.ParcelEvents).Error; err != nil {
+		return nil, err
+	}
+
+	var parcel models.Parcel
+	if err := tx.First(&parcel, claim.ParcelID).Error; err != nil {
+		return nil, err
+	}
+	for i := len(detail.ParcelEvents) - 1; i >= 0; i-- {
+		event := detail.ParcelEvents[i]
+		if event.EventType != EventDelivered {
+			continue
+		}
+		detail.ProofOfDelivery = &ProofOfDelivery{
+			DeliveredAt:     event.CreatedAt,
+			CourierID:       event.ActorID,
+			ScanType:        event.ScanType,
+			Latitude:        event.Latitude,
+			Longitude:       event.Longitude,
+			DistanceMeters:  event.DistanceMeters,
+			OutsideGeofence: event.OutsideGeofence,
+			CODCollected:    parcel.CODCollected,
+		}
+		break
+	}
+	return &detail, nil
+}
+
+// NotifyClaim tells the sender and the couriers who carried the parcel about a change to a claim
+func NotifyClaim(tx *gorm.DB, claim *models.Claim, senderMessage, courierMessage string) {
+	notifications.PublishNotification("notifications_sender_queue", claim.SenderID, senderMessage)
+
+	legs, err := ParcelLegs(tx, claim.ParcelID)
+	if err != nil {
+		return
+	}
+	var notified []uint
+	for _, courierID := range carryingCouriers(legs) {
+		if containsUint(notified, courierID) {
+			continue
+		}
+		notified = append(notified, courierID)
+		notifications.PublishNotification("notifications_motorbike_queue", courierID, courierMessage)
+	}
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"go-delivery-app/internal/models"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// CourierCash is the cash on delivery money a courier currently holds
+type CourierCash struct {
+	CourierID   uint   `json:"courier_id"`
+	Name        string `json:"name"`
+	Email       string `json:"email"`
+	AmountCents int64  `json:"amount_cents"`
+}
+
+// CODMismatch is a delivered parcel whose collected cash differs from the amount to collect
+type CODMismatch struct {
+	ParcelID     uint      `json:"parcel_id"`
+	CourierID    uint      `json:"courier_id"`
+	CODAmount    int64     `json:"cod_amount"`
+	CODCollected int64     `json:"cod_collected"`
+	Difference   int64     `json:"difference"` // Positive when less cash was collected than expected
+	CollectedAt  time.Time `json:"collected_at"`
+}
+
+// RecordCODCollection stores the cash a courier confirmed collecting for a parcel
+// and adds it to the courier's cash on hand
+func RecordCODCollection(tx *gorm.DB, parcel *models.Parcel, courierID uint, collected int64) error {
+	now := time.Now()
+	parcel.CODCollected = &collected
+	parcel.CODCollectedAt = &now
+	if collected == 0 {
+		return nil
+	}
+
+	cash, err := GetAccount(tx, courierID, AccountCourierCash)
+	if err != nil {
+		return err
+	}
+	clearing, err := GetAccount(tx, 0, AccountCODClearing)
+	if err != nil {
+		return err
+	}
+	return Post(tx, LedgerTransaction{
+		Kind:     EntryCODCollected,
+		ParcelID: &parcel.ID,
+		Postings: []Posting{
+			{AccountID: clearing.ID, AmountCents: -collected},
+			{AccountID: cash.ID, AmountCents: collected},
+		},
+	})
+}
+
+// CashOnHand lists the couriers holding cash on delivery money that has not been handed in
+func CashOnHand(tx *gorm.DB) ([]CourierCash, error) {
+	cash := []CourierCash{}
+	err := tx.Raw(`SELECT u.id AS courier_id, u.name, u.email, SUM(e.amount_cents) AS amount_cents
+		FROM accounts a
+		JOIN entries e ON e.account_id = a.id
+		JOIN users u ON u.id = a.owner_id
+		WHERE a.type = ?
+		GROUP BY u.id, u.name, u.email
+		HAVING SUM(e.amount_cents) <> 0
+		ORDER BY amount_cents DESC`, AccountCourierCash).Scan(&cash).Error
+	return cash, err
+}
+
+// RecordCashHandIn books the cash a courier handed in at the hub against their cash on hand
+// and records any discrepancy with what the ledger expected
+func RecordCashHandIn(tx *gorm.DB, courierID uint, amountCents int64, note string, adminID uint) (*models.CashHandIn, error) {
+	cash, err := GetAccount(tx, courierID, AccountCourierCash)
+	if err != nil {
+		return nil, err
+	}
+	if err := LockAccount(tx, cash); err != nil {
+		return nil, err
+	}
+	expected, err := Balance(tx, cash.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// What was missing at the previous hand-in is still on the courier's cash on hand, only count it once
+	var previous models.CashHandIn
+	if err := tx.Where("courier_id = ?", courierID).Order("id DESC").Limit(1).Find(&previous).Error; err != nil {
+		return nil, err
+	}
+
+	handIn := models.CashHandIn{
+		CourierID:        courierID,
+		AmountCents:      amountCents,
+		ExpectedCents:    expected,
+		DiscrepancyCents: expected - amountCents,
+		ShortfallCents:   expected - amountCents - previous.DiscrepancyCents,
+		RecordedBy:       adminID,
+		CreatedAt:        time.Now(),
+	}
+	if note != "" {
+		handIn.Note = &note
+	}
+	if err := tx.Create(&handIn).Error; err != nil {
+		return nil, err
+	}
+
+	hub, err := GetAccount(tx, 0, AccountHubCash)
+	if err != nil {
+		return nil, err
+	}
+	// A shortfall stays on the courier's cash on hand until it is settled
+	err = Post(tx, LedgerTransaction{
+		Kind:      EntryCashHandIn,
+		Reference: "cash_hand_in:" + strconv.FormatUint(uint64(handIn.ID), 10),
+		Postings: []Posting{
+			{AccountID: cash.ID, AmountCents: -amountCents},
+			{AccountID: hub.ID, AmountCents: amountCents},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &handIn, nil
+}
+
+// CODMismatches lists the parcels collected in the range whose collected cash differs from the amount to collect
+func CODMismatches(tx *gorm.DB, from, to time.Time) ([]CODMismatch, error) {
+	mismatches := []CODMismatch{}
+	err := tx.Raw(`SELECT id AS parcel_id, motorbike_id AS courier_id, COALESCE(cod_amount, 0) AS cod_amount, cod_collected,
+			COALESCE(cod_amount, 0) - cod_collected AS difference, cod_collected_at AS collected_at
+		FROM parcels
+		WHERE cod_collected IS NOT NULL AND COALESCE(cod_amount, 0) <> cod_collected
+			AND cod_collected_at >= ? AND cod_collected_at < ?
+		ORDER BY cod_collected_at`, from, to).Scan(&mismatches).Error
+	return mismatches, err
+}
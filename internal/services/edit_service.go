@@ -0,0 +1,239 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"go-delivery-app/internal/models"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// EventEdited is recorded for every field a sender changes before pickup
+const EventEdited = "edited"
+
+var (
+	ErrParcelNotEditable = errors.New("parcel can only be edited before pickup")
+	ErrInvalidParcelEdit = errors.New("addresses cannot be empty and coordinates must be valid")
+)
+
+// ParcelChanges are the fields a sender can change before pickup; nil fields are left as they are.
+// Field names are the ones of the parcel JSON.
+type ParcelChanges struct {
+	PickupAddress       *string    `json:"PickupAddress"`
+	DropoffAddress      *string    `json:"DropoffAddress"`
+	Latitude            *float64   `json:"Latitude"`
+	Longitude           *float64   `json:"Longitude"`
+	DropoffLatitude     *float64   `json:"DropoffLatitude"`
+	DropoffLongitude    *float64   `json:"DropoffLongitude"`
+	SenderDescription   *string    `json:"SenderDescription"`
+	PickupWindowStart   *time.Time `json:"pickup_window_start"`
+	PickupWindowEnd     *time.Time `json:"pickup_window_end"`
+	DeliveryWindowStart *time.Time `json:"delivery_window_start"`
+	DeliveryWindowEnd   *time.Time `json:"delivery_window_end"`
+	TimeZone            *string    `json:"time_zone"`
+}
+
+// EditParcel applies a sender's changes to a parcel that has not been picked up, records one history
+// event per changed field and, when the price came from a quote, reprices the parcel and settles the
+// difference with the sender's wallet. It returns the names of the changed fields.
+// The parcel should be locked by the caller.
+func EditParcel(tx *gorm.DB, parcel *models.Parcel, changes ParcelChanges, actorID uint, now time.Time) ([]string, error) {
+	if parcel.Status != StatusCreated {
+		return nil, fmt.Errorf("%w, it is %s", ErrParcelNotEditable, parcel.Status)
+	}
+
+	before := *parcel
+	var notes []string
+	changeString := func(name string, field *string, value *string) {
+		if value == nil || strings.TrimSpace(*value) == *field {
+			return
+		}
+		notes = append(notes, fmt.Sprintf("%s changed from %q to %q", name, *field, strings.TrimSpace(*value)))
+		*field = strings.TrimSpace(*value)
+	}
+	changeFloat := func(name string, field *float64, value *float64) {
+		if value == nil || *value == *field {
+			return
+		}
+		notes = append(notes, fmt.Sprintf("%s changed from %v to %v", name, *field, *value))
+		*field = *value
+	}
+	changeOptionalFloat := func(name string, field **float64, value *float64) {
+		if value == nil || sameFloat(*field, value) {
+			return
+		}
+		old := "none"
+		if *field != nil {
+			old = fmt.Sprint(**field)
+		}
+		notes = append(notes, fmt.Sprintf("%s changed from %s to %v", name, old, *value))
+		v := *value
+		*field = &v
+	}
+
+	changeString("PickupAddress", &parcel.PickupAddress, changes.PickupAddress)
+	changeString("DropoffAddress", &parcel.DropoffAddress, changes.DropoffAddress)
+	changeFloat("Latitude", &parcel.Latitude, changes.Latitude)
+	changeFloat("Longitude", &parcel.Longitude, changes.Longitude)
+	changeOptionalFloat("DropoffLatitude", &parcel.DropoffLatitude, changes.DropoffLatitude)
+	changeOptionalFloat("DropoffLongitude", &parcel.DropoffLongitude, changes.DropoffLongitude)
+	if changes.SenderDescription != nil && (parcel.SenderDescription == nil || *parcel.SenderDescription != *changes.SenderDescription) {
+		notes = append(notes, fmt.Sprintf("SenderDescription changed from %s to %q", quoteOrNone(parcel.SenderDescription), *changes.SenderDescription))
+		description := *changes.SenderDescription
+		parcel.SenderDescription = &description
+	}
+
+	if !HasRequiredFields(parcel) || !ValidCoordinates(parcel.Latitude, parcel.Longitude) ||
+		(parcel.DropoffLatitude == nil) != (parcel.DropoffLongitude == nil) ||
+		(parcel.DropoffLatitude != nil && !ValidCoordinates(*parcel.DropoffLatitude, *parcel.DropoffLongitude)) {
+		return nil, ErrInvalidParcelEdit
+	}
+	if routeChanged(&before, parcel) {
+		if err := CheckServiceArea(tx, parcel); err != nil {
+			return nil, err
+		}
+	}
+
+	// Windows are validated together, so one end of a window can be moved on its own
+	windows := ParcelWindows(parcel)
+	if changes.PickupWindowStart != nil || changes.PickupWindowEnd != nil ||
+		changes.DeliveryWindowStart != nil || changes.DeliveryWindowEnd != nil || changes.TimeZone != nil {
+		windows.PickupStart = timeOr(changes.PickupWindowStart, windows.PickupStart)
+		windows.PickupEnd = timeOr(changes.PickupWindowEnd, windows.PickupEnd)
+		windows.DeliveryStart = timeOr(changes.DeliveryWindowStart, windows.DeliveryStart)
+		windows.DeliveryEnd = timeOr(changes.DeliveryWindowEnd, windows.DeliveryEnd)
+		if changes.TimeZone != nil {
+			windows.TimeZone = changes.TimeZone
+		}
+		if err := SetWindows(parcel, windows, now); err != nil {
+			return nil, err
+		}
+		notes = append(notes, windowNotes(&before, parcel)...)
+	}
+
+	if len(notes) == 0 {
+		return nil, nil
+	}
+
+	// A quoted price follows the new route
+	if parcel.QuoteID != nil && routeChanged(&before, parcel) {
+		if err := repriceParcel(tx, parcel, now); err != nil {
+			return nil, err
+		}
+		notes = append(notes, fmt.Sprintf("PriceCents changed from %s to %d", intOrNone(before.PriceCents), *parcel.PriceCents))
+	}
+
+	if err := tx.Omit("Legs").Save(parcel).Error; err != nil {
+		return nil, err
+	}
+	if err := AdjustParcelCharge(tx, parcel); err != nil {
+		return nil, err
+	}
+
+	changed := make([]string, 0, len(notes))
+	for _, note := range notes {
+		if err := RecordParcelEvent(tx, parcel, EventEdited, &actorID, note); err != nil {
+			return nil, err
+		}
+		changed = append(changed, strings.SplitN(note, " ", 2)[0])
+	}
+	return changed, nil
+}
+
+// repriceParcel quotes the parcel again for its new route and attaches the new quote
+func repriceParcel(tx *gorm.DB, parcel *models.Parcel, now time.Time) error {
+	var previous models.Quote
+	if err := tx.First(&previous, *parcel.QuoteID).Error; err != nil {
+		return err
+	}
+	if parcel.DropoffLatitude == nil || parcel.DropoffLongitude == nil {
+		return ErrInvalidParcelEdit
+	}
+
+	quote, err := CalculateQuote(tx, parcel.SenderID, QuoteRequest{
+		PickupLatitude:   parcel.Latitude,
+		PickupLongitude:  parcel.Longitude,
+		DropoffLatitude:  *parcel.DropoffLatitude,
+		DropoffLongitude: *parcel.DropoffLongitude,
+		WeightKg:         previous.WeightKg,
+		SizeTier:         previous.SizeTier,
+		ServiceLevel:     previous.ServiceLevel,
+	}, now)
+	if err != nil {
+		return err
+	}
+	quote.ParcelID = &parcel.ID
+	if err := tx.Create(quote).Error; err != nil {
+		return err
+	}
+	parcel.QuoteID = &quote.ID
+	parcel.PriceCents = &quote.PriceCents
+	return nil
+}
+
+// routeChanged reports whether the pickup or drop-off coordinates of a parcel changed
+func routeChanged(before, after *models.Parcel) bool {
+	return before.Latitude != after.Latitude || before.Longitude != after.Longitude ||
+		!sameFloat(before.DropoffLatitude, after.DropoffLatitude) || !sameFloat(before.DropoffLongitude, after.DropoffLongitude)
+}
+
+// windowNotes describes the window fields that changed
+func windowNotes(before, after *models.Parcel) []string {
+	var notes []string
+	for _, w := range []struct {
+		name          string
+		before, after *time.Time
+	}{
+		{"pickup_window_start", before.PickupWindowStart, after.PickupWindowStart},
+		{"pickup_window_end", before.PickupWindowEnd, after.PickupWindowEnd},
+		{"delivery_window_start", before.DeliveryWindowStart, after.DeliveryWindowStart},
+		{"delivery_window_end", before.DeliveryWindowEnd, after.DeliveryWindowEnd},
+	} {
+		if sameTime(w.before, w.after) {
+			continue
+		}
+		notes = append(notes, fmt.Sprintf("%s changed from %s to %s", w.name, timeOrNone(w.before), timeOrNone(w.after)))
+	}
+	if before.TimeZone == nil || after.TimeZone == nil || *before.TimeZone != *after.TimeZone {
+		notes = append(notes, fmt.Sprintf("time_zone changed from %s to %s", quoteOrNone(before.TimeZone), quoteOrNone(after.TimeZone)))
+	}
+	return notes
+}
+
+func timeOr(value, def *time.Time) *time.Time {
+	if value != nil {
+		return value
+	}
+	return def
+}
+
+func sameFloat(a, b *float64) bool {
+	return (a == nil && b == nil) || (a != nil && b != nil && *a == *b)
+}
+
+func sameTime(a, b *time.Time) bool {
+	return (a == nil && b == nil) || (a != nil && b != nil && a.Equal(*b))
+}
+
+func quoteOrNone(s *string) string {
+	if s == nil {
+		return "none"
+	}
+	return fmt.Sprintf("%q", *s)
+}
+
+func intOrNone(n *int64) string {
+	if n == nil {
+		return "none"
+	}
+	return fmt.Sprint(*n)
+}
+
+func timeOrNone(t *time.Time) string {
+	if t == nil {
+		return "none"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
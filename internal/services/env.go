@@ -0,0 +1,39 @@
+package services
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// envInt reads an integer setting from the environment, falling back to def when unset or invalid
+func envInt(key string, def int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return value
+	}
+	return def
+}
+
+// envFloat reads a floating point setting from the environment, falling back to def when unset or invalid
+func envFloat(key string, def float64) float64 {
+	if value, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil {
+		return value
+	}
+	return def
+}
+
+// envLocation reads a time zone name from the environment, falling back to UTC when unset or unknown
+func envLocation(key string) *time.Location {
+	if loc, err := time.LoadLocation(os.Getenv(key)); err == nil && os.Getenv(key) != "" {
+		return loc
+	}
+	return time.UTC
+}
+
+// envString reads a setting from the environment, falling back to def when unset
+func envString(key, def string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return def
+}
@@ -0,0 +1,336 @@
+package services
+
+import (
+	"fmt"
+	"go-delivery-app/internal/models"
+	"go-delivery-app/internal/notifications"
+	"log"
+	"math"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// etaZoneSize is the side of the grid cells average speeds are learned for, in degrees (about 5 km)
+const etaZoneSize = 0.05
+
+// Learned speeds outside this range come from bad data, such as a scan made far from the parcel
+const (
+	minLearnedSpeedKmh = 1
+	maxLearnedSpeedKmh = 120
+)
+
+// etaMonitorInterval is how often the delay monitor checks the parcels on their way
+const etaMonitorInterval = 5 * time.Minute
+
+// ETA is the estimated delivery time of a parcel
+type ETA struct {
+	EstimatedDelivery time.Time `json:"estimated_delivery"`
+	RemainingKm       float64   `json:"remaining_km"`
+	RemainingStops    int       `json:"remaining_stops"` // Hubs still to go through, plus the drop-off
+	MissesWindow      bool      `json:"misses_window"`   // The estimate is after the end of the delivery window
+}
+
+// defaultSpeedKmh is the speed used where too few legs were delivered to learn one, configured with ETA_DEFAULT_SPEED_KMH
+func defaultSpeedKmh() float64 {
+	return envFloat("ETA_DEFAULT_SPEED_KMH", 20)
+}
+
+// minSpeedSamples is how many legs a zone needs at an hour before its average is trusted, configured with ETA_MIN_SAMPLES
+func minSpeedSamples() int {
+	return envInt("ETA_MIN_SAMPLES", 5)
+}
+
+// pickupWait is the expected time before a courier picks up a waiting leg, configured with ETA_PICKUP_WAIT_MINUTES
+func pickupWait() time.Duration {
+	return time.Duration(envInt("ETA_PICKUP_WAIT_MINUTES", 15)) * time.Minute
+}
+
+// hubDwell is the expected time a parcel spends at a hub, configured with ETA_HUB_DWELL_MINUTES
+func hubDwell() time.Duration {
+	return time.Duration(envInt("ETA_HUB_DWELL_MINUTES", 30)) * time.Minute
+}
+
+// ETADelayThreshold is how much later than last announced an ETA may get before the sender is told,
+// configured with ETA_DELAY_THRESHOLD_MINUTES
+func ETADelayThreshold() time.Duration {
+	return time.Duration(envInt("ETA_DELAY_THRESHOLD_MINUTES", 15)) * time.Minute
+}
+
+// zoneCell returns the grid cell of a point
+func zoneCell(lat, lng float64) (int, int) {
+	return int(math.Floor(lat / etaZoneSize)), int(math.Floor(lng / etaZoneSize))
+}
+
+// point is a place on a parcel's journey
+type point struct {
+	lat, lng float64
+}
+
+// legStart returns where a leg starts: the hub it leaves from, the place it was released, or the pickup address
+func legStart(parcel *models.Parcel, leg *models.ParcelLeg) point {
+	switch {
+	case leg.ReleasedLatitude != nil && leg.ReleasedLongitude != nil:
+		return point{*leg.ReleasedLatitude, *leg.ReleasedLongitude}
+	case leg.FromHub != nil:
+		return point{leg.FromHub.Latitude, leg.FromHub.Longitude}
+	default:
+		return point{parcel.Latitude, parcel.Longitude}
+	}
+}
+
+// legEnd returns where a leg ends, or false when the drop-off has no coordinates
+func legEnd(parcel *models.Parcel, leg *models.ParcelLeg) (point, bool) {
+	if leg.ToHub != nil {
+		return point{leg.ToHub.Latitude, leg.ToHub.Longitude}, true
+	}
+	if parcel.DropoffLatitude == nil || parcel.DropoffLongitude == nil {
+		return point{}, false
+	}
+	return point{*parcel.DropoffLatitude, *parcel.DropoffLongitude}, true
+}
+
+// loadLegHubs fills in the hubs of a leg
+func loadLegHubs(tx *gorm.DB, leg *models.ParcelLeg) error {
+	if leg.FromHubID != nil && leg.FromHub == nil {
+		leg.FromHub = &models.Hub{}
+		if err := tx.First(leg.FromHub, *leg.FromHubID).Error; err != nil {
+			return err
+		}
+	}
+	if leg.ToHubID != nil && leg.ToHub == nil {
+		leg.ToHub = &models.Hub{}
+		if err := tx.First(leg.ToHub, *leg.ToHubID).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// learnLegSpeed adds the average speed of a finished leg to the speed of the zone and hour it started in
+func learnLegSpeed(tx *gorm.DB, parcel *models.Parcel, leg *models.ParcelLeg) error {
+	if leg.PickupTime == nil || leg.DeliveryTime == nil {
+		return nil
+	}
+	finished := *leg
+	if err := loadLegHubs(tx, &finished); err != nil {
+		return err
+	}
+	start := legStart(parcel, &finished)
+	end, ok := legEnd(parcel, &finished)
+	hours := leg.DeliveryTime.Sub(*leg.PickupTime).Hours()
+	if !ok || hours <= 0 {
+		return nil
+	}
+	speed := Haversine(start.lat, start.lng, end.lat, end.lng) / hours
+	if speed < minLearnedSpeedKmh || speed > maxLearnedSpeedKmh {
+		return nil
+	}
+
+	latCell, lngCell := zoneCell(start.lat, start.lng)
+	stat := models.ZoneSpeedStat{
+		LatCell:     latCell,
+		LngCell:     lngCell,
+		Hour:        leg.PickupTime.UTC().Hour(),
+		Samples:     1,
+		AvgSpeedKmh: speed,
+		UpdatedAt:   time.Now(),
+	}
+	// Keep a running mean so the table stays one row per zone and hour
+	return tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "lat_cell"}, {Name: "lng_cell"}, {Name: "hour"}},
+		DoUpdates: clause.Set{
+			{Column: clause.Column{Name: "avg_speed_kmh"}, Value: gorm.Expr("zone_speed_stats.avg_speed_kmh + (EXCLUDED.avg_speed_kmh - zone_speed_stats.avg_speed_kmh) / (zone_speed_stats.samples + 1)")},
+			{Column: clause.Column{Name: "samples"}, Value: gorm.Expr("zone_speed_stats.samples + 1")},
+			{Column: clause.Column{Name: "updated_at"}, Value: stat.UpdatedAt},
+		},
+	}).Create(&stat).Error
+}
+
+// zoneSpeedKmh returns the average speed learned around a point at the hour of the given time.
+// Without enough samples at that hour the zone's average over the day is used, then the default speed.
+func zoneSpeedKmh(tx *gorm.DB, p point, at time.Time) (float64, error) {
+	latCell, lngCell := zoneCell(p.lat, p.lng)
+	var stats []models.ZoneSpeedStat
+	if err := tx.Where("lat_cell = ? AND lng_cell = ?", latCell, lngCell).Find(&stats).Error; err != nil {
+		return 0, err
+	}
+
+	var samples int
+	var total float64
+	for _, stat := range stats {
+		if stat.Hour == at.UTC().Hour() && stat.Samples >= minSpeedSamples() {
+			return stat.AvgSpeedKmh, nil
+		}
+		samples += stat.Samples
+		total += stat.AvgSpeedKmh * float64(stat.Samples)
+	}
+	if samples >= minSpeedSamples() {
+		return total / float64(samples), nil
+	}
+	return defaultSpeedKmh(), nil
+}
+
+// travel returns when a courier leaving one point at the given time reaches the other, and the distance covered
+func travel(tx *gorm.DB, from, to point, at time.Time) (time.Time, float64, error) {
+	km := Haversine(from.lat, from.lng, to.lat, to.lng)
+	speed, err := zoneSpeedKmh(tx, from, at)
+	if err != nil || speed <= 0 {
+		return at, km, err
+	}
+	return at.Add(time.Duration(km / speed * float64(time.Hour))), km, nil
+}
+
+// EstimateDelivery estimates when a parcel will be delivered from the courier's last reported location,
+// the stops left on its journey and the speeds learned for each zone and time of day.
+// It returns nil for parcels that are delivered, canceled, lost or have no drop-off coordinates.
+func EstimateDelivery(tx *gorm.DB, parcel *models.Parcel, now time.Time) (*ETA, error) {
+	if ParcelClosed(parcel) || parcel.DropoffLatitude == nil || parcel.DropoffLongitude == nil {
+		return nil, nil
+	}
+	var legs []models.ParcelLeg
+	err := tx.Preload("FromHub").Preload("ToHub").Where("parcel_id = ?", parcel.ID).Order("sequence").Find(&legs).Error
+	if err != nil {
+		return nil, err
+	}
+	current := CurrentLeg(legs)
+	if current == nil {
+		return nil, nil
+	}
+
+	eta := ETA{}
+	at := now
+	for i := range legs {
+		leg := &legs[i]
+		if leg.Sequence < current.Sequence || leg.Status == LegCanceled {
+			continue
+		}
+		end, _ := legEnd(parcel, leg)
+		from := legStart(parcel, leg)
+
+		switch {
+		case leg.Status == LegPickedUp:
+			// Start from the courier when they reported a location during the leg
+			var location models.CourierLocation
+			err := tx.Where("courier_id = ? AND recorded_at >= ?", leg.MotorbikeID, leg.PickupTime).
+				Order("recorded_at DESC").Limit(1).Find(&location).Error
+			if err != nil {
+				return nil, err
+			}
+			if location.ID != 0 {
+				from = point{location.Latitude, location.Longitude}
+			}
+		case leg.Sequence == 1 && leg.ReleasedAt == nil:
+			if parcel.PickupWindowStart != nil && parcel.PickupWindowStart.After(at) {
+				at = *parcel.PickupWindowStart
+			}
+			at = at.Add(pickupWait())
+		case leg.ReleasedAt != nil:
+			// Released on the way, waiting for another courier
+			at = at.Add(pickupWait())
+		default:
+			at = at.Add(hubDwell())
+		}
+
+		arrival, km, err := travel(tx, from, end, at)
+		if err != nil {
+			return nil, err
+		}
+		at = arrival
+		eta.RemainingKm += km
+		eta.RemainingStops++
+	}
+
+	eta.EstimatedDelivery = at.UTC().Truncate(time.Minute)
+	eta.RemainingKm = math.Round(eta.RemainingKm*10) / 10
+	eta.MissesWindow = parcel.DeliveryWindowEnd != nil && eta.EstimatedDelivery.After(*parcel.DeliveryWindowEnd)
+	return &eta, nil
+}
+
+// RunDelayMonitor watches the ETA of the parcels on their way in the background
+func RunDelayMonitor(db *gorm.DB) {
+	for {
+		if err := MonitorDelays(db, time.Now()); err != nil {
+			log.Printf("Failed to monitor delivery delays: %v", err)
+		}
+		time.Sleep(etaMonitorInterval)
+	}
+}
+
+// MonitorDelays recomputes the ETA of every picked up parcel and tells the sender and the recipient
+// when it slipped by more than the threshold since they were last told, or past the delivery window.
+func MonitorDelays(db *gorm.DB, now time.Time) error {
+	var ids []uint
+	err := db.Model(&models.Parcel{}).
+		Where("status IN ? AND dropoff_latitude IS NOT NULL AND dropoff_longitude IS NOT NULL",
+			[]string{StatusPickedUp, StatusAtHub, StatusInTransit, StatusAwaiting}).
+		Pluck("id", &ids).Error
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		var parcel models.Parcel
+		var message string
+		err := db.Transaction(func(tx *gorm.DB) error {
+			// Skip parcels another instance or a scan is working on, they are checked next time
+			result := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).Limit(1).Find(&parcel, id)
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+			var err error
+			message, err = checkDelay(tx, &parcel, now)
+			return err
+		})
+		if err != nil {
+			log.Printf("Failed to check the ETA of parcel %d: %v", id, err)
+			continue
+		}
+		if message != "" {
+			notifyDelay(&parcel, message)
+		}
+	}
+	return nil
+}
+
+// checkDelay updates the ETA kept on a parcel and returns the message to send about it, if any
+func checkDelay(tx *gorm.DB, parcel *models.Parcel, now time.Time) (string, error) {
+	eta, err := EstimateDelivery(tx, parcel, now)
+	if err != nil || eta == nil {
+		return "", err
+	}
+	estimate := eta.EstimatedDelivery
+	local := estimate.In(parcelLocation(parcel)).Format("15:04 on Jan 2")
+
+	message := ""
+	switch {
+	case parcel.AnnouncedETA == nil:
+		// The first estimate is the reference later ones are compared to
+		parcel.AnnouncedETA = &estimate
+	case estimate.Sub(*parcel.AnnouncedETA) >= ETADelayThreshold():
+		message = fmt.Sprintf("Your parcel is running late and is now expected around %s.", local)
+		parcel.AnnouncedETA = &estimate
+	case parcel.AnnouncedETA.Sub(estimate) >= ETADelayThreshold():
+		// Running ahead, later delays are measured from the better estimate
+		parcel.AnnouncedETA = &estimate
+	}
+	if eta.MissesWindow && parcel.WindowAlertedAt == nil {
+		message = fmt.Sprintf("Your parcel will miss its delivery window and is now expected around %s.", local)
+		parcel.WindowAlertedAt = &now
+	}
+
+	err = tx.Model(parcel).Updates(map[string]interface{}{
+		"announced_eta":     parcel.AnnouncedETA,
+		"window_alerted_at": parcel.WindowAlertedAt,
+	}).Error
+	return message, err
+}
+
+// notifyDelay tells the sender and the recipient of a parcel about a delay
+func notifyDelay(parcel *models.Parcel, message string) {
+	if err := notifications.PublishNotification("notifications_sender_queue", parcel.SenderID, message); err != nil {
+		log.Printf("Failed to notify the sender of parcel %d of a delay: %v", parcel.ID, err)
+	}
+	NotifyRecipient(parcel, message)
+}
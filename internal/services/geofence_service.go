@@ -0,0 +1,124 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"go-delivery-app/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Geofence modes, configured with GEOFENCE_MODE
+const (
+	GeofenceReject = "reject" // Refuse scans made too far from where the parcel should be
+	GeofenceFlag   = "flag"   // Accept them but flag the event for review
+	GeofenceOff    = "off"    // Only record the distance
+)
+
+var ErrLocationRequired = errors.New("latitude and longitude are required to confirm a pickup or a delivery")
+
+// GeofenceError is returned when a motorbike confirms a scan too far from where the parcel should be
+type GeofenceError struct {
+	Place          string
+	DistanceMeters float64
+	RadiusMeters   float64
+}
+
+func (e *GeofenceError) Error() string {
+	return fmt.Sprintf("you are %.0f m from the %s, move within %.0f m to confirm", e.DistanceMeters, e.Place, e.RadiusMeters)
+}
+
+// GeofenceMode tells what happens to scans made outside the geofence
+func GeofenceMode() string {
+	switch mode := envString("GEOFENCE_MODE", GeofenceReject); mode {
+	case GeofenceFlag, GeofenceOff:
+		return mode
+	default:
+		return GeofenceReject
+	}
+}
+
+// GeofenceRadiusMeters is how far from the pickup, hub or drop-off point a motorbike may confirm a scan,
+// configured with GEOFENCE_RADIUS_METERS
+func GeofenceRadiusMeters() float64 {
+	return envFloat("GEOFENCE_RADIUS_METERS", 200)
+}
+
+// scanPlace returns where a scan of the current leg should happen, or false when the place has no coordinates
+func scanPlace(tx *gorm.DB, parcel *models.Parcel, leg *models.ParcelLeg, scanType string) (point, string, bool, error) {
+	located := *leg
+	if err := loadLegHubs(tx, &located); err != nil {
+		return point{}, "", false, err
+	}
+	switch scanType {
+	case ScanPickup, ScanHubOut:
+		place := "pickup point"
+		if located.FromHub != nil && located.ReleasedLatitude == nil {
+			place = "hub"
+		}
+		return legStart(parcel, &located), place, true, nil
+	default:
+		end, ok := legEnd(parcel, &located)
+		place := "drop-off point"
+		if located.ToHub != nil {
+			place = "hub"
+		}
+		return end, place, ok, nil
+	}
+}
+
+// checkGeofence measures how far a scan was made from where it should happen. Motorbikes must send
+// their location, and depending on the mode a scan outside the radius is refused or flagged.
+// Hub staff scans are measured when they carry a location but never refused.
+func checkGeofence(tx *gorm.DB, parcel *models.Parcel, leg *models.ParcelLeg, scan Scan) (*float64, bool, error) {
+	mode := GeofenceMode()
+	enforced := scan.ActorRole == RoleMotorbike && mode != GeofenceOff
+	if scan.Latitude == nil || scan.Longitude == nil {
+		if enforced {
+			return nil, false, ErrLocationRequired
+		}
+		return nil, false, nil
+	}
+
+	target, place, ok, err := scanPlace(tx, parcel, leg, scan.Type)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	distance := Haversine(*scan.Latitude, *scan.Longitude, target.lat, target.lng) * 1000
+	radius := GeofenceRadiusMeters()
+	if !enforced || distance <= radius {
+		return &distance, false, nil
+	}
+	if mode == GeofenceReject {
+		return nil, false, &GeofenceError{Place: place, DistanceMeters: distance, RadiusMeters: radius}
+	}
+	return &distance, true, nil
+}
+
+// GeofenceFlagReport is a scan accepted outside the geofence, shown to admins for review
+type GeofenceFlagReport struct {
+	EventID        uint      `json:"event_id"`
+	ParcelID       uint      `json:"parcel_id"`
+	TrackingCode   *string   `json:"tracking_code"`
+	CourierID      uint      `json:"courier_id"`
+	CourierName    string    `json:"courier_name"`
+	ScanType       *string   `json:"scan_type"`
+	DistanceMeters float64   `json:"distance_meters"`
+	Latitude       *float64  `json:"latitude"`
+	Longitude      *float64  `json:"longitude"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// GeofenceFlags lists the scans accepted outside the geofence in the range, farthest first
+func GeofenceFlags(tx *gorm.DB, from, to time.Time) ([]GeofenceFlagReport, error) {
+	flags := []GeofenceFlagReport{}
+	err := tx.Raw(`SELECT e.id AS event_id, e.parcel_id, p.tracking_code, e.actor_id AS courier_id, u.name AS courier_name,
+			e.scan_type, e.distance_meters, e.latitude, e.longitude, e.created_at
+		FROM parcel_events e
+		JOIN parcels p ON p.id = e.parcel_id
+		JOIN users u ON u.id = e.actor_id
+		WHERE e.outside_geofence AND e.created_at >= ? AND e.created_at < ?
+		ORDER BY e.distance_meters DESC`, from, to).Scan(&flags).Error
+	return flags, err
+}
@@ -0,0 +1,278 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"go-delivery-app/internal/models"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Parcel event types recorded when couriers change
+const (
+	EventReleased  = "released"
+	EventHandedOff = "handed_off"
+)
+
+// Handoff statuses
+const (
+	HandoffPending  = "pending"
+	HandoffAccepted = "accepted"
+	HandoffDeclined = "declined"
+	HandoffCanceled = "canceled"
+	HandoffExpired  = "expired"
+)
+
+// handoffTTL is how long the receiving courier has to accept a handoff
+const handoffTTL = 30 * time.Minute
+
+// maxReleaseReasonLength caps the reason a courier gives for releasing a parcel
+const maxReleaseReasonLength = 500
+
+var (
+	ErrNotCarrying         = errors.New("you are not carrying this parcel")
+	ErrReleaseReason       = errors.New("reason is required and must be at most 500 characters")
+	ErrHandoffNotFound     = errors.New("handoff not found")
+	ErrHandoffNotPending   = errors.New("handoff is no longer pending")
+	ErrHandoffToSelf       = errors.New("you cannot hand a parcel over to yourself")
+	ErrHandoffPending      = errors.New("this parcel already has a pending handoff")
+	ErrReceiverNotCourier  = errors.New("parcels can only be handed over to a motorbike")
+	ErrHandoffLegCompleted = errors.New("the parcel has moved on since the handoff was offered")
+)
+
+// carriedLeg returns the current leg of a parcel when the courier is carrying it
+func carriedLeg(tx *gorm.DB, parcel *models.Parcel, courierID uint) ([]models.ParcelLeg, *models.ParcelLeg, error) {
+	legs, err := ParcelLegs(tx, parcel.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	leg := CurrentLeg(legs)
+	if leg == nil || leg.Status != LegPickedUp || !assignedTo(leg.MotorbikeID, courierID) {
+		return nil, nil, ErrNotCarrying
+	}
+	return legs, leg, nil
+}
+
+// ReleaseParcel gives the leg a courier is carrying back to the pool, to be picked up by another courier
+// where it was left. Pending handoffs of the leg are canceled. The event is recorded for the actor, the courier
+// or the admin releasing the parcel for them. The parcel should be locked by the caller.
+func ReleaseParcel(tx *gorm.DB, parcel *models.Parcel, courierID, actorID uint, reason string, lat, lng *float64) error {
+	reason = strings.TrimSpace(reason)
+	if reason == "" || len(reason) > maxReleaseReasonLength {
+		return ErrReleaseReason
+	}
+	legs, leg, err := carriedLeg(tx, parcel, courierID)
+	if err != nil {
+		return err
+	}
+
+	// Without coordinates, the parcel is where the courier last reported being
+	if lat == nil || lng == nil {
+		var location models.CourierLocation
+		err := tx.Where("courier_id = ?", courierID).Order("recorded_at DESC").Limit(1).Find(&location).Error
+		if err != nil {
+			return err
+		}
+		if location.ID != 0 {
+			lat, lng = &location.Latitude, &location.Longitude
+		}
+	}
+
+	now := time.Now()
+	leg.Status = LegReady
+	leg.MotorbikeID = nil
+	leg.ReleasedAt = &now
+	leg.ReleasedLatitude = lat
+	leg.ReleasedLongitude = lng
+	if err := tx.Save(leg).Error; err != nil {
+		return err
+	}
+	if err := closePendingHandoffs(tx, leg.ID, HandoffCanceled, now); err != nil {
+		return err
+	}
+
+	parcel.MotorbikeID = nil
+	parcel.Status = DeriveParcelStatus(legs)
+	if err := tx.Omit("Legs").Save(parcel).Error; err != nil {
+		return err
+	}
+
+	event := models.ParcelEvent{
+		ParcelID:  parcel.ID,
+		EventType: EventReleased,
+		Status:    parcel.Status,
+		ActorID:   &actorID,
+		Note:      &reason,
+		Latitude:  lat,
+		Longitude: lng,
+		CreatedAt: now,
+	}
+	return tx.Create(&event).Error
+}
+
+// RequestHandoff offers the leg a courier is carrying to another courier, who has to accept it.
+// The parcel should be locked by the caller.
+func RequestHandoff(tx *gorm.DB, parcel *models.Parcel, fromID, toID uint, note string) (*models.ParcelHandoff, error) {
+	if fromID == toID {
+		return nil, ErrHandoffToSelf
+	}
+	_, leg, err := carriedLeg(tx, parcel, fromID)
+	if err != nil {
+		return nil, err
+	}
+
+	var receiver models.User
+	if err := tx.First(&receiver, toID).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if receiver.ID == 0 || receiver.Role != RoleMotorbike {
+		return nil, ErrReceiverNotCourier
+	}
+
+	now := time.Now()
+	if err := expireHandoffs(tx, leg.ID, now); err != nil {
+		return nil, err
+	}
+	var pending int64
+	if err := tx.Model(&models.ParcelHandoff{}).Where("parcel_leg_id = ? AND status = ?", leg.ID, HandoffPending).
+		Count(&pending).Error; err != nil {
+		return nil, err
+	}
+	if pending > 0 {
+		return nil, ErrHandoffPending
+	}
+
+	handoff := models.ParcelHandoff{
+		ParcelID:        parcel.ID,
+		ParcelLegID:     leg.ID,
+		FromMotorbikeID: fromID,
+		ToMotorbikeID:   toID,
+		Status:          HandoffPending,
+		ExpiresAt:       now.Add(handoffTTL),
+		CreatedAt:       now,
+	}
+	if note = strings.TrimSpace(note); note != "" {
+		handoff.Note = &note
+	}
+	if err := tx.Create(&handoff).Error; err != nil {
+		return nil, err
+	}
+	return &handoff, nil
+}
+
+// LockHandoff loads a handoff and locks it and its parcel until the transaction ends
+func LockHandoff(tx *gorm.DB, id interface{}) (*models.ParcelHandoff, error) {
+	var handoff models.ParcelHandoff
+	err := tx.Select("id", "parcel_id").First(&handoff, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrHandoffNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	// The parcel is locked before its handoff, in the same order as releasing or scanning the parcel,
+	// which update its handoffs, so that they cannot deadlock
+	if _, err := LockParcel(tx, handoff.ParcelID); errors.Is(err, ErrParcelNotFound) {
+		return nil, ErrHandoffNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&handoff, handoff.ID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrHandoffNotFound
+	}
+	return &handoff, err
+}
+
+// AcceptHandoff moves the leg to the receiving courier once they confirm the handoff. The parcel keeps its
+// status and history; a handoff event records the change of courier.
+func AcceptHandoff(tx *gorm.DB, handoff *models.ParcelHandoff, courierID uint) (*models.Parcel, error) {
+	if handoff.ToMotorbikeID != courierID {
+		return nil, ErrHandoffNotFound
+	}
+	now := time.Now()
+	if err := checkPending(tx, handoff, now); err != nil {
+		return nil, err
+	}
+
+	parcel, err := LockParcel(tx, handoff.ParcelID)
+	if err != nil {
+		return nil, err
+	}
+	_, leg, err := carriedLeg(tx, parcel, handoff.FromMotorbikeID)
+	if err != nil || leg.ID != handoff.ParcelLegID {
+		return nil, ErrHandoffLegCompleted
+	}
+	if err := ensureCourierAvailable(tx, courierID); err != nil {
+		return nil, err
+	}
+
+	leg.MotorbikeID = &courierID
+	if err := tx.Save(leg).Error; err != nil {
+		return nil, err
+	}
+	parcel.MotorbikeID = &courierID
+	if err := tx.Omit("Legs").Save(parcel).Error; err != nil {
+		return nil, err
+	}
+
+	handoff.Status = HandoffAccepted
+	handoff.RespondedAt = &now
+	if err := tx.Save(handoff).Error; err != nil {
+		return nil, err
+	}
+
+	note := fmt.Sprintf("Handed over from motorbike %d to motorbike %d", handoff.FromMotorbikeID, courierID)
+	return parcel, RecordParcelEvent(tx, parcel, EventHandedOff, &courierID, note)
+}
+
+// CloseHandoff ends a pending handoff: the receiving courier declines it or the offering courier cancels it
+func CloseHandoff(tx *gorm.DB, handoff *models.ParcelHandoff, courierID uint) error {
+	status := ""
+	switch courierID {
+	case handoff.ToMotorbikeID:
+		status = HandoffDeclined
+	case handoff.FromMotorbikeID:
+		status = HandoffCanceled
+	default:
+		return ErrHandoffNotFound
+	}
+	now := time.Now()
+	if err := checkPending(tx, handoff, now); err != nil {
+		return err
+	}
+	handoff.Status = status
+	handoff.RespondedAt = &now
+	return tx.Save(handoff).Error
+}
+
+// checkPending fails unless the handoff is still pending, expiring it when its time is up
+func checkPending(tx *gorm.DB, handoff *models.ParcelHandoff, now time.Time) error {
+	if handoff.Status == HandoffPending && now.After(handoff.ExpiresAt) {
+		handoff.Status = HandoffExpired
+		if err := tx.Save(handoff).Error; err != nil {
+			return err
+		}
+	}
+	if handoff.Status != HandoffPending {
+		return fmt.Errorf("%w, it is %s", ErrHandoffNotPending, handoff.Status)
+	}
+	return nil
+}
+
+// expireHandoffs marks the pending handoffs of a leg whose time is up as expired
+func expireHandoffs(tx *gorm.DB, legID uint, now time.Time) error {
+	return tx.Model(&models.ParcelHandoff{}).
+		Where("parcel_leg_id = ? AND status = ? AND expires_at < ?", legID, HandoffPending, now).
+		Updates(map[string]interface{}{"status": HandoffExpired, "responded_at": now}).Error
+}
+
+// closePendingHandoffs ends the pending handoffs of a leg
+func closePendingHandoffs(tx *gorm.DB, legID uint, status string, now time.Time) error {
+	return tx.Model(&models.ParcelHandoff{}).
+		Where("parcel_leg_id = ? AND status = ?", legID, HandoffPending).
+		Updates(map[string]interface{}{"status": status, "responded_at": now}).Error
+}
@@ -0,0 +1,414 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-delivery-app/internal/models"
+	"go-delivery-app/internal/notifications"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Import formats
+const (
+	ImportCSV    = "csv"
+	ImportNDJSON = "ndjson"
+)
+
+// Import job statuses
+const (
+	ImportPending   = "pending"
+	ImportCompleted = "completed"
+)
+
+// Import row statuses
+const (
+	RowPending   = "pending"
+	RowCreated   = "created"
+	RowDuplicate = "duplicate" // A parcel with the same external_ref already exists
+	RowFailed    = "failed"
+)
+
+const (
+	MaxImportRows  = 5000
+	MaxImportBytes = 10 << 20
+)
+
+var (
+	ErrUnknownImportFormat = errors.New("format must be csv or ndjson")
+	ErrEmptyImport         = errors.New("the upload has no rows")
+	ErrTooManyImportRows   = fmt.Errorf("an upload can have at most %d rows", MaxImportRows)
+	ErrExternalRefRequired = errors.New("external_ref is required for imported parcels")
+)
+
+// csvColumnKinds are the types of the parcel fields that can appear as CSV columns. Columns are named
+// like the JSON fields of POST /sender/parcel, case-insensitively.
+var csvColumnKinds = map[string]string{
+	"PickupAddress":         "string",
+	"DropoffAddress":        "string",
+	"Latitude":              "float",
+	"Longitude":             "float",
+	"DropoffLatitude":       "float",
+	"DropoffLongitude":      "float",
+	"WeightKg":              "float",
+	"ServiceLevel":          "string",
+	"SenderDescription":     "string",
+	"RecipientName":         "string",
+	"RecipientPhone":        "string",
+	"RecipientEmail":        "string",
+	"quote_id":              "int",
+	"cod_amount":            "int",
+	"contact_id":            "int",
+	"external_ref":          "string",
+	"hub_ids":               "ints", // Separated by "|"
+	"pickup_window_start":   "string",
+	"pickup_window_end":     "string",
+	"delivery_window_start": "string",
+	"delivery_window_end":   "string",
+	"time_zone":             "string",
+}
+
+// ImportRow is a row read from an upload, with the parcel fields as JSON or the reason it could not be read
+type ImportRow struct {
+	Number  int
+	Payload json.RawMessage
+	Error   string
+}
+
+// ParseImport reads the rows of a CSV or NDJSON upload. Rows that cannot be read are returned with an
+// error; an error is only returned when the upload as a whole cannot be read.
+func ParseImport(r io.Reader, format string) ([]ImportRow, error) {
+	var rows []ImportRow
+	var err error
+	switch format {
+	case ImportCSV:
+		rows, err = parseCSVImport(r)
+	case ImportNDJSON:
+		rows, err = parseNDJSONImport(r)
+	default:
+		return nil, ErrUnknownImportFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, ErrEmptyImport
+	}
+	return rows, nil
+}
+
+func parseNDJSONImport(r io.Reader) ([]ImportRow, error) {
+	var rows []ImportRow
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if len(rows) == MaxImportRows {
+			return nil, ErrTooManyImportRows
+		}
+		row := ImportRow{Number: len(rows) + 1}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(line, &fields); err != nil {
+			row.Error = "invalid JSON object"
+		} else {
+			row.Payload = append(json.RawMessage(nil), line...)
+		}
+		rows = append(rows, row)
+	}
+	return rows, scanner.Err()
+}
+
+func parseCSVImport(r io.Reader) ([]ImportRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, ErrEmptyImport
+	} else if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %w", err)
+	}
+
+	names := make(map[string]string, len(csvColumnKinds))
+	for name := range csvColumnKinds {
+		names[strings.ToLower(name)] = name
+	}
+	columns := make([]string, len(header))
+	for i, h := range header {
+		name, ok := names[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\uFEFF")))]
+		if !ok {
+			return nil, fmt.Errorf("unknown CSV column %q", h)
+		}
+		columns[i] = name
+	}
+
+	var rows []ImportRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		if err != nil && !errors.As(err, &parseErr) {
+			return nil, err
+		}
+		if len(rows) == MaxImportRows {
+			return nil, ErrTooManyImportRows
+		}
+		row := ImportRow{Number: len(rows) + 1}
+		if err != nil {
+			row.Error = "invalid CSV row"
+		} else if len(record) != len(columns) {
+			row.Error = fmt.Sprintf("expected %d columns, got %d", len(columns), len(record))
+		} else {
+			row.Payload, err = csvRecordToJSON(columns, record)
+			if err != nil {
+				row.Error = err.Error()
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// csvRecordToJSON converts a CSV record to the JSON body POST /sender/parcel would receive; empty cells are left out
+func csvRecordToJSON(columns, record []string) (json.RawMessage, error) {
+	fields := make(map[string]interface{}, len(columns))
+	for i, name := range columns {
+		value := strings.TrimSpace(record[i])
+		if value == "" {
+			continue
+		}
+		switch csvColumnKinds[name] {
+		case "string":
+			fields[name] = value
+		case "float":
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s must be a number", name)
+			}
+			fields[name] = f
+		case "int":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s must be an integer", name)
+			}
+			fields[name] = n
+		case "ints":
+			var ids []uint64
+			for _, s := range strings.Split(value, "|") {
+				n, err := strconv.ParseUint(strings.TrimSpace(s), 10, 32)
+				if err != nil {
+					return nil, fmt.Errorf("%s must be IDs separated by |", name)
+				}
+				ids = append(ids, n)
+			}
+			fields[name] = ids
+		}
+	}
+	return json.Marshal(fields)
+}
+
+// CreateImportJob saves an import job with its rows, ready to be processed
+func CreateImportJob(tx *gorm.DB, senderID uint, format string, rows []ImportRow) (*models.ImportJob, error) {
+	job := models.ImportJob{
+		SenderID:  senderID,
+		Format:    format,
+		Status:    ImportPending,
+		TotalRows: len(rows),
+		CreatedAt: time.Now(),
+	}
+	// The job and its rows are saved together, a job is never left without its rows
+	err := tx.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&job).Error; err != nil {
+			return err
+		}
+
+		jobRows := make([]models.ImportJobRow, 0, len(rows))
+		for _, row := range rows {
+			jobRow := models.ImportJobRow{JobID: job.ID, RowNumber: row.Number, Payload: row.Payload, Status: RowPending}
+			if row.Error != "" {
+				msg := row.Error
+				jobRow.Status = RowFailed
+				jobRow.Error = &msg
+			}
+			jobRows = append(jobRows, jobRow)
+		}
+		return tx.CreateInBatches(&jobRows, 500).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// ResumeImportJobs processes the jobs left unfinished by a restart
+func ResumeImportJobs(db *gorm.DB) {
+	var ids []uint
+	if err := db.Model(&models.ImportJob{}).Where("status = ?", ImportPending).Pluck("id", &ids).Error; err != nil {
+		log.Printf("Failed to load pending import jobs: %v", err)
+		return
+	}
+	for _, id := range ids {
+		go ProcessImportJob(db, id)
+	}
+}
+
+// ProcessImportJob creates the parcels of the pending rows of a job, one row per transaction, then
+// records the totals and notifies the sender. Rows are locked while they are processed, so several
+// processes can work on the same job without creating a parcel twice.
+func ProcessImportJob(db *gorm.DB, jobID uint) {
+	for {
+		done, err := processNextImportRow(db, jobID)
+		if err != nil {
+			log.Printf("Failed to process import job %d: %v", jobID, err)
+			return
+		}
+		if done {
+			break
+		}
+	}
+
+	if err := finishImportJob(db, jobID); err != nil {
+		log.Printf("Failed to finish import job %d: %v", jobID, err)
+	}
+}
+
+// processNextImportRow processes one pending row of a job and reports whether none was left
+func processNextImportRow(db *gorm.DB, jobID uint) (bool, error) {
+	done := false
+	var rowID uint
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var row models.ImportJobRow
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("job_id = ? AND status = ?", jobID, RowPending).
+			Order("row_number").First(&row).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			done = true
+			return nil
+		} else if err != nil {
+			return err
+		}
+		rowID = row.ID
+
+		var job models.ImportJob
+		if err := tx.First(&job, jobID).Error; err != nil {
+			return err
+		}
+
+		status, parcelID, rowErr := importRow(tx, &job, &row)
+		row.Status = status
+		row.ParcelID = parcelID
+		if rowErr != nil {
+			msg := rowErr.Error()
+			row.Error = &msg
+		}
+		return tx.Save(&row).Error
+	})
+	if err != nil && rowID != 0 {
+		// Fail the row instead of stopping the job on it, it would be tried again and again
+		log.Printf("Failed to import row %d of job %d: %v", rowID, jobID, err)
+		err = db.Model(&models.ImportJobRow{}).Where("id = ? AND status = ?", rowID, RowPending).
+			Updates(map[string]interface{}{"status": RowFailed, "error": "failed to save parcel"}).Error
+	}
+	return done, err
+}
+
+// importRow creates the parcel of one row inside a savepoint and returns the outcome of the row
+func importRow(tx *gorm.DB, job *models.ImportJob, row *models.ImportJobRow) (string, *uint, error) {
+	var parcel models.Parcel
+	if err := json.Unmarshal(row.Payload, &parcel); err != nil {
+		return RowFailed, nil, errors.New("invalid parcel fields")
+	}
+	if parcel.ExternalRef == nil || strings.TrimSpace(*parcel.ExternalRef) == "" {
+		return RowFailed, nil, ErrExternalRefRequired
+	}
+	ref := strings.TrimSpace(*parcel.ExternalRef)
+	row.ExternalRef = &ref
+
+	err := tx.Transaction(func(tx *gorm.DB) error {
+		return CreateParcel(tx, &parcel, job.SenderID, time.Now())
+	})
+	if err == nil {
+		return RowCreated, &parcel.ID, nil
+	}
+
+	// The same reference may have been created in the meantime
+	if existing, findErr := FindByExternalRef(tx, job.SenderID, ref); findErr == nil && existing != nil {
+		return RowDuplicate, &existing.ID, nil
+	}
+	if isValidationError(err) {
+		return RowFailed, nil, err
+	}
+	log.Printf("Failed to import row %d of job %d: %v", row.RowNumber, job.ID, err)
+	return RowFailed, nil, errors.New("failed to save parcel")
+}
+
+// isValidationError reports whether a parcel creation error can be shown to the sender as is
+func isValidationError(err error) bool {
+	for _, target := range []error{
+		ErrMissingParcelFields, ErrNegativeCODAmount, ErrContactNotFound, ErrDuplicateExternalRef,
+		ErrHubNotFound, ErrTooManyHubs, ErrRepeatedHubs, ErrOutsideServiceArea,
+		ErrIncompleteWindow, ErrInvalidWindow, ErrWindowInPast, ErrDeliveryBeforePickup, ErrUnknownTimeZone,
+		ErrInsufficientFunds, ErrQuoteNotFound, ErrQuoteNotOwned, ErrQuoteExpired, ErrQuoteUsed,
+		ErrPriceFieldsRequired, ErrInvalidQuoteCoordinates, ErrInvalidWeight, ErrInvalidServiceLevel,
+		ErrTooHeavy, ErrUnknownTier, ErrNoPriceRules,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// finishImportJob records the totals of a job once all its rows are processed and notifies the sender once
+func finishImportJob(db *gorm.DB, jobID uint) error {
+	var counts []struct {
+		Status string
+		Count  int
+	}
+	err := db.Model(&models.ImportJobRow{}).Select("status, COUNT(*) AS count").
+		Where("job_id = ?", jobID).Group("status").Scan(&counts).Error
+	if err != nil {
+		return err
+	}
+
+	updates := map[string]interface{}{"status": ImportCompleted, "finished_at": time.Now()}
+	totals := map[string]int{}
+	for _, c := range counts {
+		totals[c.Status] = c.Count
+	}
+	if totals[RowPending] > 0 {
+		return nil // Another process is still working on the job
+	}
+	updates["created_count"] = totals[RowCreated]
+	updates["duplicate_count"] = totals[RowDuplicate]
+	updates["failed_count"] = totals[RowFailed]
+
+	// Only the process that completes the job notifies the sender
+	var job models.ImportJob
+	result := db.Model(&job).Clauses(clause.Returning{}).
+		Where("id = ? AND status = ?", jobID, ImportPending).Updates(updates)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return result.Error
+	}
+
+	message := fmt.Sprintf("Your parcel import #%d has finished: %d created, %d already existed, %d failed",
+		job.ID, totals[RowCreated], totals[RowDuplicate], totals[RowFailed])
+	notifications.PublishNotification("notifications_sender_queue", job.SenderID, message)
+	return nil
+}
@@ -0,0 +1,608 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go-delivery-app/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Ledger account types
+const (
+	AccountSenderWallet    = "sender_wallet"
+	AccountCourierEarnings = "courier_earnings"
+	AccountParcelEscrow    = "parcel_escrow"
+	AccountPlatformRevenue = "platform_revenue"
+	AccountPaymentProvider = "payment_provider"
+	AccountPayouts         = "payouts"
+	AccountCourierCash     = "courier_cash"
+	AccountCODClearing     = "cod_clearing"
+	AccountHubCash         = "hub_cash"
+	AccountClaims          = "claims" // Compensation paid to senders for damaged or lost parcels
+)
+
+// Ledger entry kinds
+const (
+	EntryTopUp         = "top_up"
+	EntryParcelCharge  = "parcel_charge"
+	EntryParcelRefund  = "parcel_refund"
+	EntryDelivery      = "delivery"
+	EntryCourierPayout = "courier_payout"
+	EntryCODCollected  = "cod_collected"
+	EntryCashHandIn    = "cash_hand_in"
+	EntryParcelReprice = "parcel_reprice"
+	EntryCancelFee     = "cancel_fee"
+	EntryClaimPayout   = "claim_payout"
+	EntryAdminRefund   = "admin_refund"
+)
+
+// Wallet top-up statuses
+const (
+	TopUpPending     = "pending"      // Recorded, the payment provider is being charged
+	TopUpDeclined    = "declined"     // The payment provider declined the charge
+	TopUpFailed      = "failed"       // The charge failed, it may need checking with the payment provider
+	TopUpCharged     = "charged"      // Charged, the wallet is being credited
+	TopUpCredited    = "credited"     // Charged and credited to the wallet
+	TopUpRefunded    = "refunded"     // Crediting the wallet failed and the charge was refunded
+	TopUpNeedsReview = "needs_review" // Charged, but neither credited nor refunded
+)
+
+const maxIdempotencyKeyLength = 100
+
+var (
+	ErrTopUpKeyReused    = errors.New("this idempotency_key was already used for another amount")
+	ErrTopUpInProgress   = errors.New("a top-up with this idempotency_key is in progress")
+	ErrInvalidTopUpKey   = errors.New("idempotency_key must be at most 100 characters")
+	ErrInsufficientFunds = errors.New("insufficient wallet balance")
+	ErrUnbalanced        = errors.New("ledger transaction does not balance")
+	ErrParcelNotPriced   = errors.New("parcel has no price")
+)
+
+// Posting is a single amount booked on an account as part of a transaction
+type Posting struct {
+	AccountID   uint
+	AmountCents int64
+}
+
+// LedgerTransaction describes a set of postings booked together
+type LedgerTransaction struct {
+	Kind          string
+	ParcelID      *uint
+	PayoutBatchID *uint
+	Reference     string
+	Postings      []Posting
+}
+
+// CourierSharePercent is the share of a parcel price credited to the courier, configured with COURIER_SHARE_PERCENT
+func CourierSharePercent() int64 {
+	share := envInt("COURIER_SHARE_PERCENT", 70)
+	if share < 0 || share > 100 {
+		return 70
+	}
+	return int64(share)
+}
+
+// GetAccount returns the account of the given type for a user, creating it on first use.
+// An ownerID of 0 selects the system account of that type.
+func GetAccount(tx *gorm.DB, ownerID uint, accountType string) (*models.Account, error) {
+	find := func(account *models.Account) error {
+		if ownerID == 0 {
+			return tx.Where("type = ? AND owner_id IS NULL", accountType).Limit(1).Find(account).Error
+		}
+		return tx.Where("type = ? AND owner_id = ?", accountType, ownerID).Limit(1).Find(account).Error
+	}
+
+	var account models.Account
+	if err := find(&account); err != nil || account.ID != 0 {
+		return &account, err
+	}
+
+	// Another request may create the same account concurrently; the unique index keeps one of them
+	account = models.Account{Type: accountType, CreatedAt: time.Now()}
+	if ownerID != 0 {
+		account.OwnerID = &ownerID
+	}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&account).Error; err != nil {
+		return nil, err
+	}
+	if account.ID == 0 {
+		if err := find(&account); err != nil {
+			return nil, err
+		}
+	}
+	return &account, nil
+}
+
+// LockAccount takes a row lock on an account so its balance cannot change until the transaction ends
+func LockAccount(tx *gorm.DB, account *models.Account) error {
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(account, account.ID).Error
+}
+
+// Balance returns the current balance of an account
+func Balance(tx *gorm.DB, accountID uint) (int64, error) {
+	var balance int64
+	err := tx.Model(&models.Entry{}).Where("account_id = ?", accountID).
+		Select("COALESCE(SUM(amount_cents), 0)").Scan(&balance).Error
+	return balance, err
+}
+
+// Post books a transaction, refusing it unless its postings sum to zero
+func Post(tx *gorm.DB, txn LedgerTransaction) error {
+	var sum int64
+	for _, posting := range txn.Postings {
+		sum += posting.AmountCents
+	}
+	if sum != 0 || len(txn.Postings) < 2 {
+		return ErrUnbalanced
+	}
+
+	id, err := newTransactionID()
+	if err != nil {
+		return err
+	}
+
+	var reference *string
+	if txn.Reference != "" {
+		reference = &txn.Reference
+	}
+
+	now := time.Now()
+	entries := make([]models.Entry, 0, len(txn.Postings))
+	for _, posting := range txn.Postings {
+		if posting.AmountCents == 0 {
+			continue
+		}
+		entries = append(entries, models.Entry{
+			TransactionID: id,
+			AccountID:     posting.AccountID,
+			AmountCents:   posting.AmountCents,
+			Kind:          txn.Kind,
+			ParcelID:      txn.ParcelID,
+			PayoutBatchID: txn.PayoutBatchID,
+			Reference:     reference,
+			CreatedAt:     now,
+		})
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	return tx.Create(&entries).Error
+}
+
+// newTransactionID returns a random identifier for a ledger transaction
+func newTransactionID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate transaction id: %v", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// TopUpWallet credits a sender's wallet with money received from the payment provider
+func TopUpWallet(tx *gorm.DB, senderID uint, amountCents int64, providerReference string) error {
+	wallet, err := GetAccount(tx, senderID, AccountSenderWallet)
+	if err != nil {
+		return err
+	}
+	provider, err := GetAccount(tx, 0, AccountPaymentProvider)
+	if err != nil {
+		return err
+	}
+	return Post(tx, LedgerTransaction{
+		Kind:      EntryTopUp,
+		Reference: providerReference,
+		Postings: []Posting{
+			{AccountID: provider.ID, AmountCents: -amountCents},
+			{AccountID: wallet.ID, AmountCents: amountCents},
+		},
+	})
+}
+
+// StartTopUp records a wallet top-up before the payment provider is charged. A top-up already made with the
+// same idempotency key is returned instead, with true, so that a retried request is never charged twice.
+// Without a key, one is generated.
+func StartTopUp(tx *gorm.DB, senderID uint, amountCents int64, idempotencyKey string) (*models.WalletTopUp, bool, error) {
+	if len(idempotencyKey) > maxIdempotencyKeyLength {
+		return nil, false, ErrInvalidTopUpKey
+	}
+	if idempotencyKey == "" {
+		key, err := newTransactionID()
+		if err != nil {
+			return nil, false, err
+		}
+		idempotencyKey = key
+	}
+
+	topUp := models.WalletTopUp{
+		SenderID:       senderID,
+		AmountCents:    amountCents,
+		IdempotencyKey: idempotencyKey,
+		Status:         TopUpPending,
+	}
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&topUp)
+	if result.Error != nil {
+		return nil, false, result.Error
+	}
+	if result.RowsAffected == 1 {
+		return &topUp, false, nil
+	}
+
+	var existing models.WalletTopUp
+	if err := tx.Where("sender_id = ? AND idempotency_key = ?", senderID, idempotencyKey).First(&existing).Error; err != nil {
+		return nil, false, err
+	}
+	if existing.AmountCents != amountCents {
+		return nil, false, ErrTopUpKeyReused
+	}
+	return &existing, true, nil
+}
+
+// SetTopUpStatus records what happened to a top-up with the payment provider
+func SetTopUpStatus(tx *gorm.DB, topUp *models.WalletTopUp, status, providerReference string, cause error) error {
+	topUp.Status = status
+	if providerReference != "" {
+		topUp.ProviderReference = &providerReference
+	}
+	if cause != nil {
+		message := cause.Error()
+		topUp.Error = &message
+	}
+	return tx.Model(topUp).Select("status", "provider_reference", "error", "updated_at").Updates(topUp).Error
+}
+
+// CreditTopUp credits a charged top-up to the sender's wallet. The top-up moves from charged to credited in the
+// same transaction as the ledger entries, so it is credited once however many times this is called.
+func CreditTopUp(tx *gorm.DB, topUp *models.WalletTopUp) error {
+	result := tx.Model(&models.WalletTopUp{}).
+		Where("id = ? AND status = ?", topUp.ID, TopUpCharged).
+		Updates(map[string]interface{}{"status": TopUpCredited, "updated_at": time.Now()})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil
+	}
+	topUp.Status = TopUpCredited
+	var reference string
+	if topUp.ProviderReference != nil {
+		reference = *topUp.ProviderReference
+	}
+	return TopUpWallet(tx, topUp.SenderID, topUp.AmountCents, reference)
+}
+
+// ChargeParcel moves the price of a parcel from the sender's wallet into escrow
+func ChargeParcel(tx *gorm.DB, parcel *models.Parcel) error {
+	if parcel.PriceCents == nil {
+		return ErrParcelNotPriced
+	}
+	if *parcel.PriceCents <= 0 {
+		return nil
+	}
+
+	wallet, err := GetAccount(tx, parcel.SenderID, AccountSenderWallet)
+	if err != nil {
+		return err
+	}
+	if err := LockAccount(tx, wallet); err != nil {
+		return err
+	}
+	balance, err := Balance(tx, wallet.ID)
+	if err != nil {
+		return err
+	}
+	if balance < *parcel.PriceCents {
+		return ErrInsufficientFunds
+	}
+
+	escrow, err := GetAccount(tx, 0, AccountParcelEscrow)
+	if err != nil {
+		return err
+	}
+	return Post(tx, LedgerTransaction{
+		Kind:     EntryParcelCharge,
+		ParcelID: &parcel.ID,
+		Postings: []Posting{
+			{AccountID: wallet.ID, AmountCents: -*parcel.PriceCents},
+			{AccountID: escrow.ID, AmountCents: *parcel.PriceCents},
+		},
+	})
+}
+
+// AdjustParcelCharge brings the escrow of a parcel in line with its price after it was repriced,
+// debiting the sender's wallet for an increase and refunding a decrease
+func AdjustParcelCharge(tx *gorm.DB, parcel *models.Parcel) error {
+	if parcel.PriceCents == nil {
+		return nil
+	}
+	held, err := EscrowHeld(tx, parcel.ID)
+	if err != nil {
+		return err
+	}
+	diff := *parcel.PriceCents - held
+	if diff == 0 {
+		return nil
+	}
+
+	wallet, err := GetAccount(tx, parcel.SenderID, AccountSenderWallet)
+	if err != nil {
+		return err
+	}
+	if diff > 0 {
+		if err := LockAccount(tx, wallet); err != nil {
+			return err
+		}
+		balance, err := Balance(tx, wallet.ID)
+		if err != nil {
+			return err
+		}
+		if balance < diff {
+			return ErrInsufficientFunds
+		}
+	}
+
+	escrow, err := GetAccount(tx, 0, AccountParcelEscrow)
+	if err != nil {
+		return err
+	}
+	return Post(tx, LedgerTransaction{
+		Kind:     EntryParcelReprice,
+		ParcelID: &parcel.ID,
+		Postings: []Posting{
+			{AccountID: wallet.ID, AmountCents: -diff},
+			{AccountID: escrow.ID, AmountCents: diff},
+		},
+	})
+}
+
+// EscrowHeld returns how much money is still held in escrow for a parcel.
+// The parcel row stays locked until the transaction ends so the escrow is released only once.
+func EscrowHeld(tx *gorm.DB, parcelID uint) (int64, error) {
+	if err := tx.Exec("SELECT id FROM parcels WHERE id = ? FOR UPDATE", parcelID).Error; err != nil {
+		return 0, err
+	}
+	escrow, err := GetAccount(tx, 0, AccountParcelEscrow)
+	if err != nil {
+		return 0, err
+	}
+	var held int64
+	err = tx.Model(&models.Entry{}).Where("account_id = ? AND parcel_id = ?", escrow.ID, parcelID).
+		Select("COALESCE(SUM(amount_cents), 0)").Scan(&held).Error
+	return held, err
+}
+
+// RefundParcel returns whatever is held in escrow for a parcel to the sender's wallet
+func RefundParcel(tx *gorm.DB, parcel *models.Parcel) error {
+	held, err := EscrowHeld(tx, parcel.ID)
+	if err != nil || held <= 0 {
+		return err
+	}
+
+	wallet, err := GetAccount(tx, parcel.SenderID, AccountSenderWallet)
+	if err != nil {
+		return err
+	}
+	escrow, err := GetAccount(tx, 0, AccountParcelEscrow)
+	if err != nil {
+		return err
+	}
+	return Post(tx, LedgerTransaction{
+		Kind:     EntryParcelRefund,
+		ParcelID: &parcel.ID,
+		Postings: []Posting{
+			{AccountID: escrow.ID, AmountCents: -held},
+			{AccountID: wallet.ID, AmountCents: held},
+		},
+	})
+}
+
+// SettleDelivery releases the escrow of a delivered parcel, splitting the courier share evenly
+// between the couriers of its legs and booking the remainder as platform revenue.
+// Cents left over by the split go to the courier of the last leg.
+func SettleDelivery(tx *gorm.DB, parcel *models.Parcel, courierIDs []uint) error {
+	held, err := EscrowHeld(tx, parcel.ID)
+	if err != nil || held <= 0 || len(courierIDs) == 0 {
+		return err
+	}
+
+	escrow, err := GetAccount(tx, 0, AccountParcelEscrow)
+	if err != nil {
+		return err
+	}
+	revenue, err := GetAccount(tx, 0, AccountPlatformRevenue)
+	if err != nil {
+		return err
+	}
+
+	share := held * CourierSharePercent() / 100
+	postings := []Posting{
+		{AccountID: escrow.ID, AmountCents: -held},
+		{AccountID: revenue.ID, AmountCents: held - share},
+	}
+	courierPostings, err := splitCourierShare(tx, share, courierIDs)
+	if err != nil {
+		return err
+	}
+
+	return Post(tx, LedgerTransaction{
+		Kind:     EntryDelivery,
+		ParcelID: &parcel.ID,
+		Postings: append(postings, courierPostings...),
+	})
+}
+
+// SettleCancellationFee releases the escrow of a parcel canceled after pickup: the fee is kept and shared like
+// a delivery between the couriers who carried the parcel and the platform, and the rest is refunded to the sender.
+// It returns the fee actually charged, which cannot exceed what is held.
+func SettleCancellationFee(tx *gorm.DB, parcel *models.Parcel, feeCents int64, courierIDs []uint) (int64, error) {
+	held, err := EscrowHeld(tx, parcel.ID)
+	if err != nil || held <= 0 {
+		return 0, err
+	}
+	if feeCents > held {
+		feeCents = held
+	}
+	if feeCents <= 0 {
+		return 0, RefundParcel(tx, parcel)
+	}
+
+	escrow, err := GetAccount(tx, 0, AccountParcelEscrow)
+	if err != nil {
+		return 0, err
+	}
+	wallet, err := GetAccount(tx, parcel.SenderID, AccountSenderWallet)
+	if err != nil {
+		return 0, err
+	}
+	revenue, err := GetAccount(tx, 0, AccountPlatformRevenue)
+	if err != nil {
+		return 0, err
+	}
+
+	share := int64(0)
+	if len(courierIDs) > 0 {
+		share = feeCents * CourierSharePercent() / 100
+	}
+	postings := []Posting{
+		{AccountID: escrow.ID, AmountCents: -held},
+		{AccountID: wallet.ID, AmountCents: held - feeCents},
+		{AccountID: revenue.ID, AmountCents: feeCents - share},
+	}
+	courierPostings, err := splitCourierShare(tx, share, courierIDs)
+	if err != nil {
+		return 0, err
+	}
+
+	err = Post(tx, LedgerTransaction{
+		Kind:     EntryCancelFee,
+		ParcelID: &parcel.ID,
+		Postings: append(postings, courierPostings...),
+	})
+	return feeCents, err
+}
+
+// PayClaim credits the compensation of an approved claim to the sender's wallet
+func PayClaim(tx *gorm.DB, claim *models.Claim, amountCents int64) error {
+	if amountCents <= 0 {
+		return nil
+	}
+	wallet, err := GetAccount(tx, claim.SenderID, AccountSenderWallet)
+	if err != nil {
+		return err
+	}
+	claims, err := GetAccount(tx, 0, AccountClaims)
+	if err != nil {
+		return err
+	}
+	return Post(tx, LedgerTransaction{
+		Kind:      EntryClaimPayout,
+		ParcelID:  &claim.ParcelID,
+		Reference: fmt.Sprintf("claim:%d", claim.ID),
+		Postings: []Posting{
+			{AccountID: claims.ID, AmountCents: -amountCents},
+			{AccountID: wallet.ID, AmountCents: amountCents},
+		},
+	})
+}
+
+// splitCourierShare credits a share evenly to the earnings of the couriers.
+// Cents left over by the split go to the last courier.
+func splitCourierShare(tx *gorm.DB, share int64, courierIDs []uint) ([]Posting, error) {
+	if len(courierIDs) == 0 {
+		return nil, nil
+	}
+	legShare := share / int64(len(courierIDs))
+	var postings []Posting
+	for i, courierID := range courierIDs {
+		earnings, err := GetAccount(tx, courierID, AccountCourierEarnings)
+		if err != nil {
+			return nil, err
+		}
+		amount := legShare
+		if i == len(courierIDs)-1 {
+			amount = share - legShare*int64(len(courierIDs)-1)
+		}
+		postings = append(postings, Posting{AccountID: earnings.ID, AmountCents: amount})
+	}
+	return postings, nil
+}
+
+// payoutLockKey serializes payout batch creation through a transaction-scoped advisory lock
+const payoutLockKey = 72710027
+
+// PayoutLine is the amount paid out to one courier in a batch
+type PayoutLine struct {
+	CourierID   uint   `json:"courier_id"`
+	Name        string `json:"name"`
+	Email       string `json:"email"`
+	AmountCents int64  `json:"amount_cents"`
+}
+
+// CreatePayoutBatch moves every positive courier earnings balance to the payouts account
+// and records the transfers under a new batch
+func CreatePayoutBatch(tx *gorm.DB, adminID uint) (*models.PayoutBatch, error) {
+	if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", payoutLockKey).Error; err != nil {
+		return nil, err
+	}
+
+	var balances []struct {
+		AccountID   uint
+		AmountCents int64
+	}
+	err := tx.Raw(`SELECT a.id AS account_id, SUM(e.amount_cents) AS amount_cents
+		FROM accounts a JOIN entries e ON e.account_id = a.id
+		WHERE a.type = ?
+		GROUP BY a.id
+		HAVING SUM(e.amount_cents) > 0
+		ORDER BY a.id`, AccountCourierEarnings).Scan(&balances).Error
+	if err != nil {
+		return nil, err
+	}
+
+	batch := models.PayoutBatch{CreatedBy: adminID, CreatedAt: time.Now()}
+	if err := tx.Create(&batch).Error; err != nil {
+		return nil, err
+	}
+
+	payouts, err := GetAccount(tx, 0, AccountPayouts)
+	if err != nil {
+		return nil, err
+	}
+	for _, balance := range balances {
+		err := Post(tx, LedgerTransaction{
+			Kind:          EntryCourierPayout,
+			PayoutBatchID: &batch.ID,
+			Postings: []Posting{
+				{AccountID: balance.AccountID, AmountCents: -balance.AmountCents},
+				{AccountID: payouts.ID, AmountCents: balance.AmountCents},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		batch.TotalCents += balance.AmountCents
+		batch.CourierCount++
+	}
+
+	if err := tx.Save(&batch).Error; err != nil {
+		return nil, err
+	}
+	return &batch, nil
+}
+
+// PayoutLines lists the couriers paid in a batch and how much each of them receives
+func PayoutLines(tx *gorm.DB, batchID uint) ([]PayoutLine, error) {
+	var lines []PayoutLine
+	err := tx.Raw(`SELECT u.id AS courier_id, u.name, u.email, -SUM(e.amount_cents) AS amount_cents
+		FROM entries e
+		JOIN accounts a ON a.id = e.account_id
+		JOIN users u ON u.id = a.owner_id
+		WHERE e.payout_batch_id = ? AND a.type = ?
+		GROUP BY u.id, u.name, u.email
+		ORDER BY u.id`, batchID, AccountCourierEarnings).Scan(&lines).Error
+	return lines, err
+}
@@ -0,0 +1,161 @@
+package services
+
+import (
+	"errors"
+	"go-delivery-app/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Parcel leg statuses
+const (
+	LegPending   = "Pending" // Waiting for the previous leg to reach its hub
+	LegReady     = "Ready"   // Waiting for a courier
+	LegPickedUp  = "Picked up"
+	LegDelivered = "Delivered"
+	LegCanceled  = "Canceled"
+)
+
+// MaxHubsPerParcel caps the number of hubs a parcel can be routed through
+const MaxHubsPerParcel = 5
+
+var (
+	ErrHubNotFound  = errors.New("hub not found or inactive")
+	ErrTooManyHubs  = errors.New("a parcel can be routed through at most 5 hubs")
+	ErrRepeatedHubs = errors.New("a parcel cannot go through the same hub twice in a row")
+)
+
+// CreateLegs splits the journey of a new parcel into legs going through the given hubs in order.
+// Without hubs the parcel travels door to door on a single leg.
+func CreateLegs(tx *gorm.DB, parcel *models.Parcel, hubIDs []uint) error {
+	if len(hubIDs) > MaxHubsPerParcel {
+		return ErrTooManyHubs
+	}
+	for i, id := range hubIDs {
+		if i > 0 && hubIDs[i-1] == id {
+			return ErrRepeatedHubs
+		}
+		var count int64
+		if err := tx.Model(&models.Hub{}).Where("id = ? AND active", id).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrHubNotFound
+		}
+	}
+
+	legs := make([]models.ParcelLeg, 0, len(hubIDs)+1)
+	var from *uint
+	for i := 0; i <= len(hubIDs); i++ {
+		leg := models.ParcelLeg{
+			ParcelID:  parcel.ID,
+			Sequence:  i + 1,
+			FromHubID: from,
+			Status:    LegPending,
+			CreatedAt: parcel.CreatedAt,
+		}
+		if i < len(hubIDs) {
+			to := hubIDs[i]
+			leg.ToHubID = &to
+			from = &to
+		}
+		legs = append(legs, leg)
+	}
+	legs[0].Status = LegReady
+	return tx.Create(&legs).Error
+}
+
+// ParcelLegs returns the legs of a parcel in travel order
+func ParcelLegs(tx *gorm.DB, parcelID uint) ([]models.ParcelLeg, error) {
+	var legs []models.ParcelLeg
+	err := tx.Where("parcel_id = ?", parcelID).Order("sequence").Find(&legs).Error
+	return legs, err
+}
+
+// CurrentLeg returns the first leg that has not been delivered or canceled, or nil once the journey is over
+func CurrentLeg(legs []models.ParcelLeg) *models.ParcelLeg {
+	for i := range legs {
+		if legs[i].Status != LegDelivered && legs[i].Status != LegCanceled {
+			return &legs[i]
+		}
+	}
+	return nil
+}
+
+// DeriveParcelStatus computes the overall status of a parcel from its legs
+func DeriveParcelStatus(legs []models.ParcelLeg) string {
+	leg := CurrentLeg(legs)
+	switch {
+	case len(legs) == 0:
+		return StatusCreated
+	case leg == nil && legs[len(legs)-1].Status == LegCanceled:
+		return StatusCanceled
+	case leg == nil:
+		return StatusDelivered
+	case leg.Status == LegPickedUp && leg.Sequence == 1:
+		return StatusPickedUp
+	case leg.Status == LegPickedUp:
+		return StatusInTransit
+	case leg.ReleasedAt != nil:
+		return StatusAwaiting
+	case leg.Sequence == 1:
+		return StatusCreated
+	default:
+		return StatusAtHub
+	}
+}
+
+// CancelLegs cancels the legs of a parcel that have not been completed, with their pending handoffs
+func CancelLegs(tx *gorm.DB, parcelID uint) error {
+	err := tx.Model(&models.ParcelHandoff{}).
+		Where("parcel_id = ? AND status = ?", parcelID, HandoffPending).
+		Updates(map[string]interface{}{"status": HandoffCanceled, "responded_at": time.Now()}).Error
+	if err != nil {
+		return err
+	}
+	return tx.Model(&models.ParcelLeg{}).
+		Where("parcel_id = ? AND status <> ?", parcelID, LegDelivered).
+		Update("status", LegCanceled).Error
+}
+
+// LegCouriers returns the couriers of the delivered legs of a parcel, one entry per leg
+func LegCouriers(legs []models.ParcelLeg) []uint {
+	var couriers []uint
+	for _, leg := range legs {
+		if leg.Status == LegDelivered && leg.MotorbikeID != nil {
+			couriers = append(couriers, *leg.MotorbikeID)
+		}
+	}
+	return couriers
+}
+
+// startLeg hands a ready leg to a courier
+func startLeg(tx *gorm.DB, leg *models.ParcelLeg, courierID uint, now time.Time) error {
+	leg.Status = LegPickedUp
+	leg.MotorbikeID = &courierID
+	leg.PickupTime = &now
+	return tx.Save(leg).Error
+}
+
+// finishLeg completes a leg, learns the speed it was carried at and makes the next one ready for pickup
+func finishLeg(tx *gorm.DB, parcel *models.Parcel, legs []models.ParcelLeg, leg *models.ParcelLeg, now time.Time) error {
+	leg.Status = LegDelivered
+	leg.DeliveryTime = &now
+	if err := tx.Save(leg).Error; err != nil {
+		return err
+	}
+	if err := learnLegSpeed(tx, parcel, leg); err != nil {
+		return err
+	}
+	if err := closePendingHandoffs(tx, leg.ID, HandoffCanceled, now); err != nil {
+		return err
+	}
+	for i := range legs {
+		if legs[i].Sequence == leg.Sequence+1 {
+			legs[i].Status = LegReady
+			return tx.Save(&legs[i]).Error
+		}
+	}
+	return nil
+}
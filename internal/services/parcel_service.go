@@ -0,0 +1,228 @@
+package services
+
+import (
+	"errors"
+	"go-delivery-app/internal/models"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrMissingParcelFields  = errors.New("PickupAddress, DropoffAddress, Latitude, and Longitude are required")
+	ErrNegativeCODAmount    = errors.New("cod_amount cannot be negative")
+	ErrContactNotFound      = errors.New("contact not found")
+	ErrDuplicateExternalRef = errors.New("a parcel with this external_ref already exists")
+	ErrPriceFieldsRequired  = errors.New("quote_id, or DropoffLatitude, DropoffLongitude and WeightKg to price the parcel, are required")
+)
+
+// PrepareParcel validates a parcel sent by a sender and resets the fields senders cannot set.
+// It fills in the recipient from the address book and normalizes the windows, without writing anything.
+func PrepareParcel(tx *gorm.DB, parcel *models.Parcel, senderID uint, now time.Time) error {
+	parcel.ID = 0
+	parcel.SenderID = senderID
+	parcel.Status = StatusCreated
+	parcel.CreatedAt = time.Time{}
+	parcel.TrackingCode = nil
+
+	// Set by the couriers, admins and cancellations as the parcel moves
+	parcel.MotorbikeID = nil
+	parcel.MotorbikeDescription = nil
+	parcel.PickupTime = nil
+	parcel.DeliveryTime = nil
+	parcel.CanceledAt = nil
+	parcel.CancelReason = nil
+	parcel.LostAt = nil
+
+	if parcel.ExternalRef != nil {
+		ref := strings.TrimSpace(*parcel.ExternalRef)
+		parcel.ExternalRef = &ref
+		if ref == "" {
+			parcel.ExternalRef = nil
+		}
+	}
+
+	// The price can only come from a quote
+	parcel.PriceCents = nil
+
+	// Cash on delivery is confirmed by the motorbike at delivery time
+	parcel.CODCollected = nil
+	parcel.CODCollectedAt = nil
+	if parcel.CODAmount != nil && *parcel.CODAmount < 0 {
+		return ErrNegativeCODAmount
+	}
+
+	// Fill in the recipient from the sender's address book
+	if parcel.ContactID != nil {
+		var contact models.Contact
+		if err := tx.First(&contact, *parcel.ContactID).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if contact.ID == 0 || contact.SenderID != senderID {
+			return ErrContactNotFound
+		}
+		applyContact(parcel, &contact)
+	}
+
+	// Set by the recipient and the couriers later on
+	parcel.DeliveryInstructions = nil
+	parcel.DeliveredLate = nil
+
+	// Legs are planned from hub_ids, never taken from the request
+	parcel.Legs = nil
+
+	// Pickup and drop-off must be in an area we serve
+	if err := CheckServiceArea(tx, parcel); err != nil {
+		return err
+	}
+
+	// Store the pickup and delivery windows in UTC, keeping the sender's time zone for display
+	return SetWindows(parcel, ParcelWindows(parcel), now)
+}
+
+// CreateParcel validates and saves a new parcel: it locks the quoted price onto it, plans its legs,
+// records its creation and debits the sender's wallet
+func CreateParcel(tx *gorm.DB, parcel *models.Parcel, senderID uint, now time.Time) error {
+	if err := PrepareParcel(tx, parcel, senderID, now); err != nil {
+		return err
+	}
+
+	// The external reference makes creation idempotent
+	if parcel.ExternalRef != nil {
+		existing, err := FindByExternalRef(tx, senderID, *parcel.ExternalRef)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			return ErrDuplicateExternalRef
+		}
+	}
+
+	// The recipient gets a private link to follow the parcel and leave delivery instructions
+	token, err := NewRecipientToken()
+	if err != nil {
+		return err
+	}
+	parcel.RecipientToken = &token
+
+	// A parcel sent without a quote is priced now, so that every parcel is paid for
+	if parcel.QuoteID == nil {
+		if err := quoteParcel(tx, parcel, senderID, now); err != nil {
+			return err
+		}
+	}
+	quote, err := LockQuote(tx, *parcel.QuoteID, senderID, now)
+	if err != nil {
+		return err
+	}
+	ApplyQuote(parcel, quote)
+
+	// The quote replaces the coordinates of the request, and the zones may have changed since it was made
+	if err := CheckServiceArea(tx, parcel); err != nil {
+		return err
+	}
+
+	// Ensure that required fields are provided
+	if !HasRequiredFields(parcel) {
+		return ErrMissingParcelFields
+	}
+
+	if err := AssignTrackingCode(tx, parcel); err != nil {
+		return err
+	}
+	if err := tx.Create(parcel).Error; err != nil {
+		return err
+	}
+	if err := CreateLegs(tx, parcel, parcel.HubIDs); err != nil {
+		return err
+	}
+	if err := RecordParcelEvent(tx, parcel, EventCreated, &senderID, ""); err != nil {
+		return err
+	}
+	if err := tx.Model(&models.Quote{}).Where("id = ?", *parcel.QuoteID).Update("parcel_id", parcel.ID).Error; err != nil {
+		return err
+	}
+
+	// Debit the sender's wallet with the locked price
+	return ChargeParcel(tx, parcel)
+}
+
+// quoteParcel saves a quote for a parcel from its coordinates, weight and service level, priced at the given
+// time, and sets it on the parcel
+func quoteParcel(tx *gorm.DB, parcel *models.Parcel, senderID uint, at time.Time) error {
+	req, err := parcelQuoteRequest(parcel)
+	if err != nil {
+		return err
+	}
+	quote, err := CalculateQuote(tx, senderID, req, at)
+	if err != nil {
+		return err
+	}
+	if err := tx.Create(quote).Error; err != nil {
+		return err
+	}
+	parcel.QuoteID = &quote.ID
+	return nil
+}
+
+// parcelQuoteRequest builds the request a parcel sent without a quote is priced from, validated like a quote
+func parcelQuoteRequest(parcel *models.Parcel) (QuoteRequest, error) {
+	if !HasPriceFields(parcel) {
+		return QuoteRequest{}, ErrPriceFieldsRequired
+	}
+	req := QuoteRequest{
+		PickupLatitude:   parcel.Latitude,
+		PickupLongitude:  parcel.Longitude,
+		DropoffLatitude:  *parcel.DropoffLatitude,
+		DropoffLongitude: *parcel.DropoffLongitude,
+		WeightKg:         *parcel.WeightKg,
+	}
+	if parcel.ServiceLevel != nil {
+		req.ServiceLevel = *parcel.ServiceLevel
+	}
+	return req, ValidateQuoteRequest(req)
+}
+
+// HasPriceFields reports whether a parcel has what it takes to price it without a quote
+func HasPriceFields(parcel *models.Parcel) bool {
+	return parcel.DropoffLatitude != nil && parcel.DropoffLongitude != nil && parcel.WeightKg != nil
+}
+
+// FindByExternalRef returns the parcel of a sender with the given external reference, or nil when there is none
+func FindByExternalRef(tx *gorm.DB, senderID uint, ref string) (*models.Parcel, error) {
+	var parcel models.Parcel
+	err := tx.Where("sender_id = ? AND external_ref = ?", senderID, ref).First(&parcel).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &parcel, nil
+}
+
+// HasRequiredFields reports whether the addresses and pickup coordinates of a parcel are set
+func HasRequiredFields(parcel *models.Parcel) bool {
+	return parcel.PickupAddress != "" && parcel.DropoffAddress != "" && parcel.Latitude != 0 && parcel.Longitude != 0
+}
+
+// applyContact copies an address book entry onto the recipient of a parcel, keeping what the sender set explicitly
+func applyContact(parcel *models.Parcel, contact *models.Contact) {
+	if parcel.RecipientName == nil {
+		parcel.RecipientName = &contact.Name
+	}
+	if parcel.RecipientPhone == nil {
+		parcel.RecipientPhone = contact.Phone
+	}
+	if parcel.RecipientEmail == nil {
+		parcel.RecipientEmail = contact.Email
+	}
+	if parcel.DropoffAddress == "" && contact.Address != nil {
+		parcel.DropoffAddress = *contact.Address
+	}
+	if parcel.DropoffLatitude == nil && parcel.DropoffLongitude == nil {
+		parcel.DropoffLatitude = contact.Latitude
+		parcel.DropoffLongitude = contact.Longitude
+	}
+}
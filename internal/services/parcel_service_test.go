@@ -0,0 +1,87 @@
+package services
+
+import (
+	"encoding/json"
+	"go-delivery-app/internal/models"
+	"testing"
+	"time"
+)
+
+func TestPrepareParcelIgnoresServerOwnedFields(t *testing.T) {
+	// A sender posting every field the server owns, as well as the ones it may set
+	body := `{
+		"ID": 99,
+		"SenderID": 7,
+		"Status": "Delivered",
+		"PickupAddress": "1 Main St",
+		"DropoffAddress": "2 Side St",
+		"Latitude": 48.85,
+		"Longitude": 2.35,
+		"PickupTime": "2026-10-01T10:00:00Z",
+		"DeliveryTime": "2026-10-01T11:00:00Z",
+		"MotorbikeID": 5,
+		"MotorbikeDescription": "Picked up by me",
+		"canceled_at": "2026-10-01T12:00:00Z",
+		"cancel_reason": "sender_request",
+		"lost_at": "2026-10-01T13:00:00Z",
+		"PriceCents": 1,
+		"cod_amount": 2500,
+		"cod_collected": 2500,
+		"cod_collected_at": "2026-10-01T11:00:00Z",
+		"TrackingCode": "DLD493QMVAG8",
+		"CreatedAt": "2020-01-01T00:00:00Z",
+		"DeliveryInstructions": "Leave it outside",
+		"delivered_late": false,
+		"legs": [{"ID": 1, "Sequence": 1}]
+	}`
+	now := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	db := newFakeDB(t, map[string]fakeTable{
+		"service_zones": {columns: []string{"id"}}, // No zone: every location is served
+	})
+
+	var p models.Parcel
+	if err := json.Unmarshal([]byte(body), &p); err != nil {
+		t.Fatal(err)
+	}
+	if err := PrepareParcel(db, &p, 3, now); err != nil {
+		t.Fatalf("PrepareParcel() error = %v", err)
+	}
+
+	ignored := []struct {
+		field string
+		set   bool
+	}{
+		{"ID", p.ID != 0},
+		{"CreatedAt", !p.CreatedAt.IsZero()},
+		{"TrackingCode", p.TrackingCode != nil},
+		{"PickupTime", p.PickupTime != nil},
+		{"DeliveryTime", p.DeliveryTime != nil},
+		{"MotorbikeID", p.MotorbikeID != nil},
+		{"MotorbikeDescription", p.MotorbikeDescription != nil},
+		{"canceled_at", p.CanceledAt != nil},
+		{"cancel_reason", p.CancelReason != nil},
+		{"lost_at", p.LostAt != nil},
+		{"PriceCents", p.PriceCents != nil},
+		{"cod_collected", p.CODCollected != nil},
+		{"cod_collected_at", p.CODCollectedAt != nil},
+		{"DeliveryInstructions", p.DeliveryInstructions != nil},
+		{"delivered_late", p.DeliveredLate != nil},
+		{"legs", p.Legs != nil},
+	}
+	for _, f := range ignored {
+		if f.set {
+			t.Errorf("%s was taken from the request", f.field)
+		}
+	}
+	if p.SenderID != 3 || p.Status != StatusCreated {
+		t.Errorf("sender and status = %d, %q, want 3, %q", p.SenderID, p.Status, StatusCreated)
+	}
+
+	// What the sender may set is kept
+	if p.PickupAddress != "1 Main St" || p.DropoffAddress != "2 Side St" || p.Latitude != 48.85 || p.Longitude != 2.35 {
+		t.Errorf("addresses and coordinates were not kept: %+v", p)
+	}
+	if p.CODAmount == nil || *p.CODAmount != 2500 {
+		t.Errorf("cod_amount = %v, want 2500", p.CODAmount)
+	}
+}
@@ -0,0 +1,39 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+)
+
+var ErrPaymentDeclined = errors.New("payment was declined")
+
+// PaymentProvider charges a payment method and returns the provider's reference for the charge,
+// and refunds a charge by its reference
+type PaymentProvider interface {
+	Charge(amountCents int64, paymentToken string) (string, error)
+	Refund(reference string, amountCents int64) error
+}
+
+// FakePaymentProvider accepts every payment token except "tok_declined"
+type FakePaymentProvider struct{}
+
+// Charge pretends to charge the payment method
+func (FakePaymentProvider) Charge(amountCents int64, paymentToken string) (string, error) {
+	if paymentToken == "" || paymentToken == "tok_declined" || amountCents <= 0 {
+		return "", ErrPaymentDeclined
+	}
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "fake_" + hex.EncodeToString(buf), nil
+}
+
+// Refund pretends to refund a charge
+func (FakePaymentProvider) Refund(reference string, amountCents int64) error {
+	return nil
+}
+
+// Payments is the payment provider used for wallet top-ups
+var Payments PaymentProvider = FakePaymentProvider{}
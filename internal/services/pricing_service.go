@@ -0,0 +1,223 @@
+package services
+
+import (
+	"errors"
+	"go-delivery-app/internal/models"
+	"math"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	ServiceLevelStandard = "standard"
+	ServiceLevelExpress  = "express"
+
+	earthRadiusKm = 6371.0
+)
+
+var (
+	ErrNoPriceRules  = errors.New("no price rules are configured for this service level")
+	ErrTooHeavy      = errors.New("parcel exceeds the maximum weight")
+	ErrUnknownTier   = errors.New("unknown size tier")
+	ErrQuoteNotFound = errors.New("quote not found")
+	ErrQuoteExpired  = errors.New("quote has expired")
+	ErrQuoteUsed     = errors.New("quote has already been used")
+	ErrQuoteNotOwned = errors.New("quote belongs to another sender")
+
+	ErrInvalidQuoteCoordinates = errors.New("Valid pickup and drop-off coordinates are required")
+	ErrInvalidWeight           = errors.New("weight_kg must be greater than 0")
+	ErrInvalidServiceLevel     = errors.New("service_level must be either standard or express")
+)
+
+// QuoteRequest holds the inputs a price is computed from
+type QuoteRequest struct {
+	PickupLatitude   float64 `json:"pickup_latitude"`
+	PickupLongitude  float64 `json:"pickup_longitude"`
+	DropoffLatitude  float64 `json:"dropoff_latitude"`
+	DropoffLongitude float64 `json:"dropoff_longitude"`
+	WeightKg         float64 `json:"weight_kg"`
+	SizeTier         string  `json:"size_tier"`     // Optional, forces at least this tier
+	ServiceLevel     string  `json:"service_level"` // "standard" (default) or "express"
+}
+
+// ValidateQuoteRequest checks the coordinates, weight and service level a price is computed from
+func ValidateQuoteRequest(req QuoteRequest) error {
+	if !ValidCoordinates(req.PickupLatitude, req.PickupLongitude) || !ValidCoordinates(req.DropoffLatitude, req.DropoffLongitude) {
+		return ErrInvalidQuoteCoordinates
+	}
+	if req.WeightKg <= 0 {
+		return ErrInvalidWeight
+	}
+	if req.ServiceLevel != "" && req.ServiceLevel != ServiceLevelStandard && req.ServiceLevel != ServiceLevelExpress {
+		return ErrInvalidServiceLevel
+	}
+	return nil
+}
+
+// ValidCoordinates reports whether a latitude/longitude pair is set and within range
+func ValidCoordinates(lat, lng float64) bool {
+	if lat == 0 && lng == 0 {
+		return false
+	}
+	return lat >= -90 && lat <= 90 && lng >= -180 && lng <= 180
+}
+
+// Haversine returns the great-circle distance in kilometers between two coordinates
+func Haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := toRad(lat2 - lat1)
+	dLon := toRad(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// QuoteTTL is how long a quote stays valid, configured with QUOTE_TTL_MINUTES
+func QuoteTTL() time.Duration {
+	return time.Duration(envInt("QUOTE_TTL_MINUTES", 15)) * time.Minute
+}
+
+// CalculateQuote prices a request with the price list in effect at the given time.
+// The returned quote is not saved.
+func CalculateQuote(tx *gorm.DB, senderID uint, req QuoteRequest, now time.Time) (*models.Quote, error) {
+	if req.ServiceLevel == "" {
+		req.ServiceLevel = ServiceLevelStandard
+	}
+
+	// Only places we serve can be quoted
+	if err := CheckServiceArea(tx, &models.Parcel{
+		Latitude:         req.PickupLatitude,
+		Longitude:        req.PickupLongitude,
+		DropoffLatitude:  &req.DropoffLatitude,
+		DropoffLongitude: &req.DropoffLongitude,
+	}); err != nil {
+		return nil, err
+	}
+
+	// The latest version that is already effective wins
+	var rule models.PriceRule
+	err := tx.Where("service_level = ? AND effective_from <= ?", req.ServiceLevel, now).
+		Order("version DESC").First(&rule).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNoPriceRules
+	} else if err != nil {
+		return nil, err
+	}
+
+	var tiers []models.PriceSizeTier
+	if err := tx.Where("version = ?", rule.Version).Order("max_weight_kg ASC").Find(&tiers).Error; err != nil {
+		return nil, err
+	}
+	tier, err := selectSizeTier(tiers, req.WeightKg, req.SizeTier)
+	if err != nil {
+		return nil, err
+	}
+
+	var surges []models.PriceSurge
+	if err := tx.Where("version = ?", rule.Version).Find(&surges).Error; err != nil {
+		return nil, err
+	}
+	multiplier := surgeMultiplier(surges, now.In(envLocation("PRICING_TIME_ZONE")))
+
+	distance := Haversine(req.PickupLatitude, req.PickupLongitude, req.DropoffLatitude, req.DropoffLongitude)
+	price := float64(rule.BaseFareCents) + float64(rule.PerKmCents)*distance + float64(tier.SurchargeCents)
+	priceCents := int64(math.Round(price * multiplier))
+	if priceCents < rule.MinFareCents {
+		priceCents = rule.MinFareCents
+	}
+
+	return &models.Quote{
+		SenderID:         senderID,
+		PickupLatitude:   req.PickupLatitude,
+		PickupLongitude:  req.PickupLongitude,
+		DropoffLatitude:  req.DropoffLatitude,
+		DropoffLongitude: req.DropoffLongitude,
+		WeightKg:         req.WeightKg,
+		SizeTier:         tier.Name,
+		ServiceLevel:     req.ServiceLevel,
+		DistanceKm:       math.Round(distance*1000) / 1000,
+		SurgeMultiplier:  multiplier,
+		PriceCents:       priceCents,
+		RuleVersion:      rule.Version,
+		ExpiresAt:        now.Add(QuoteTTL()),
+		CreatedAt:        now,
+	}, nil
+}
+
+// selectSizeTier picks the lightest tier that carries the weight, or the requested tier if it is larger
+func selectSizeTier(tiers []models.PriceSizeTier, weightKg float64, requested string) (*models.PriceSizeTier, error) {
+	var selected *models.PriceSizeTier
+	for i := range tiers {
+		if tiers[i].MaxWeightKg >= weightKg {
+			selected = &tiers[i]
+			break
+		}
+	}
+	if selected == nil {
+		return nil, ErrTooHeavy
+	}
+
+	if requested != "" && requested != selected.Name {
+		for i := range tiers {
+			if tiers[i].Name == requested {
+				if tiers[i].MaxWeightKg > selected.MaxWeightKg {
+					selected = &tiers[i]
+				}
+				return selected, nil
+			}
+		}
+		return nil, ErrUnknownTier
+	}
+	return selected, nil
+}
+
+// surgeMultiplier returns the highest multiplier active at the given local time, or 1 if none is
+func surgeMultiplier(surges []models.PriceSurge, local time.Time) float64 {
+	multiplier := 1.0
+	hour := local.Hour()
+	for _, surge := range surges {
+		if surge.DayOfWeek != nil && *surge.DayOfWeek != int(local.Weekday()) {
+			continue
+		}
+		if hour >= surge.StartHour && hour < surge.EndHour && surge.Multiplier > multiplier {
+			multiplier = surge.Multiplier
+		}
+	}
+	return multiplier
+}
+
+// LockQuote loads a quote for update and checks that the sender can still use it
+func LockQuote(tx *gorm.DB, quoteID, senderID uint, now time.Time) (*models.Quote, error) {
+	var quote models.Quote
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&quote, quoteID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrQuoteNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	if quote.SenderID != senderID {
+		return nil, ErrQuoteNotOwned
+	}
+	if quote.ParcelID != nil {
+		return nil, ErrQuoteUsed
+	}
+	if now.After(quote.ExpiresAt) {
+		return nil, ErrQuoteExpired
+	}
+	return &quote, nil
+}
+
+// ApplyQuote copies the priced inputs and the price of a quote onto a parcel
+func ApplyQuote(parcel *models.Parcel, quote *models.Quote) {
+	parcel.Latitude = quote.PickupLatitude
+	parcel.Longitude = quote.PickupLongitude
+	parcel.DropoffLatitude = &quote.DropoffLatitude
+	parcel.DropoffLongitude = &quote.DropoffLongitude
+	parcel.WeightKg = &quote.WeightKg
+	parcel.ServiceLevel = &quote.ServiceLevel
+	parcel.PriceCents = &quote.PriceCents
+	parcel.QuoteID = &quote.ID
+}
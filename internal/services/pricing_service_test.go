@@ -0,0 +1,65 @@
+package services
+
+import (
+	"errors"
+	"go-delivery-app/internal/models"
+	"testing"
+	"time"
+)
+
+func TestValidateQuoteRequest(t *testing.T) {
+	valid := QuoteRequest{PickupLatitude: 48.85, PickupLongitude: 2.35, DropoffLatitude: 48.86, DropoffLongitude: 2.29, WeightKg: 1.5}
+	tests := []struct {
+		name   string
+		change func(req *QuoteRequest)
+		err    error
+	}{
+		{"valid", func(req *QuoteRequest) {}, nil},
+		{"standard", func(req *QuoteRequest) { req.ServiceLevel = ServiceLevelStandard }, nil},
+		{"express", func(req *QuoteRequest) { req.ServiceLevel = ServiceLevelExpress }, nil},
+		{"no pickup coordinates", func(req *QuoteRequest) { req.PickupLatitude, req.PickupLongitude = 0, 0 }, ErrInvalidQuoteCoordinates},
+		{"no drop-off coordinates", func(req *QuoteRequest) { req.DropoffLatitude, req.DropoffLongitude = 0, 0 }, ErrInvalidQuoteCoordinates},
+		{"latitude out of range", func(req *QuoteRequest) { req.PickupLatitude = 91 }, ErrInvalidQuoteCoordinates},
+		{"longitude out of range", func(req *QuoteRequest) { req.DropoffLongitude = -181 }, ErrInvalidQuoteCoordinates},
+		{"zero weight", func(req *QuoteRequest) { req.WeightKg = 0 }, ErrInvalidWeight},
+		{"negative weight", func(req *QuoteRequest) { req.WeightKg = -2 }, ErrInvalidWeight},
+		{"unknown service level", func(req *QuoteRequest) { req.ServiceLevel = "overnight" }, ErrInvalidServiceLevel},
+	}
+	for _, tt := range tests {
+		req := valid
+		tt.change(&req)
+		if err := ValidateQuoteRequest(req); !errors.Is(err, tt.err) {
+			t.Errorf("%s: ValidateQuoteRequest() error = %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestQuoteParcelValidatesLikeAQuote(t *testing.T) {
+	float := func(v float64) *float64 { return &v }
+	level := func(v string) *string { return &v }
+	tests := []struct {
+		name   string
+		parcel models.Parcel
+		err    error
+	}{
+		{"addresses and pickup coordinates only", models.Parcel{Latitude: 48.85, Longitude: 2.35}, ErrPriceFieldsRequired},
+		{"no weight", models.Parcel{Latitude: 48.85, Longitude: 2.35, DropoffLatitude: float(48.86), DropoffLongitude: float(2.29)}, ErrPriceFieldsRequired},
+		{"zero weight", models.Parcel{Latitude: 48.85, Longitude: 2.35, DropoffLatitude: float(48.86), DropoffLongitude: float(2.29), WeightKg: float(0)}, ErrInvalidWeight},
+		{"negative weight", models.Parcel{Latitude: 48.85, Longitude: 2.35, DropoffLatitude: float(48.86), DropoffLongitude: float(2.29), WeightKg: float(-1)}, ErrInvalidWeight},
+		{"drop-off off the map", models.Parcel{Latitude: 48.85, Longitude: 2.35, DropoffLatitude: float(95), DropoffLongitude: float(2.29), WeightKg: float(1)}, ErrInvalidQuoteCoordinates},
+		{"pickup off the map", models.Parcel{Latitude: 48.85, Longitude: 200, DropoffLatitude: float(48.86), DropoffLongitude: float(2.29), WeightKg: float(1)}, ErrInvalidQuoteCoordinates},
+		{"unknown service level", models.Parcel{Latitude: 48.85, Longitude: 2.35, DropoffLatitude: float(48.86), DropoffLongitude: float(2.29), WeightKg: float(1), ServiceLevel: level("overnight")}, ErrInvalidServiceLevel},
+	}
+
+	// Invalid parcels are refused before anything is read or saved: the database fails every query
+	db := newFakeDB(t, nil)
+	for _, tt := range tests {
+		parcel := tt.parcel
+		if err := quoteParcel(db, &parcel, 3, time.Now()); !errors.Is(err, tt.err) {
+			t.Errorf("%s: quoteParcel() error = %v, want %v", tt.name, err, tt.err)
+		}
+		if parcel.QuoteID != nil {
+			t.Errorf("%s: a quote was set on the parcel", tt.name)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package services
+
+import (
+	"errors"
+	"go-delivery-app/internal/models"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Who a rating is about
+const (
+	RateCourier = "courier" // The sender rates the motorbike that delivered the parcel
+	RateSender  = "sender"  // A motorbike that carried the parcel rates its sender
+)
+
+// Moderation statuses of rating comments
+const (
+	ModerationPending  = "pending"
+	ModerationApproved = "approved"
+	ModerationHidden   = "hidden"
+)
+
+// ratingTags are the tags that can be attached to a rating, depending on who is rated
+var ratingTags = map[string][]string{
+	RateCourier: {"late", "on_time", "polite", "rude", "damaged", "careful"},
+	RateSender:  {"late", "ready_on_time", "polite", "rude", "well_packed", "badly_packed"},
+}
+
+const maxRatingCommentLength = 1000
+
+var (
+	ErrRatingNotAllowed   = errors.New("you did not take part in this delivery")
+	ErrRatingNotDelivered = errors.New("you can only rate after the parcel is delivered")
+	ErrRatingClosed       = errors.New("the rating period for this delivery is over")
+	ErrAlreadyRated       = errors.New("you have already rated this delivery")
+	ErrInvalidRating      = errors.New("rating must be between 1 and 5")
+	ErrInvalidRatingTag   = errors.New("unknown rating tag")
+	ErrRatingComment      = errors.New("comment must be at most 1000 characters")
+	ErrRatingNotFound     = errors.New("rating not found")
+)
+
+// RatingInput is what a user sends when rating a delivery
+type RatingInput struct {
+	Rating  int      `json:"rating"`
+	Comment string   `json:"comment"`
+	Tags    []string `json:"tags"`
+}
+
+// RatingWindow is how long after delivery a parcel can be rated, configured with RATING_WINDOW_DAYS
+func RatingWindow() time.Duration {
+	return time.Duration(envInt("RATING_WINDOW_DAYS", 7)) * 24 * time.Hour
+}
+
+// RatingTags returns the tags that can be attached to a rating in a direction
+func RatingTags(direction string) []string {
+	return ratingTags[direction]
+}
+
+// CreateRating records a rating of a delivered parcel. Only the parcel's sender can rate its courier and only
+// the couriers who carried it can rate its sender, within the rating window. Comments are queued for moderation.
+func CreateRating(tx *gorm.DB, parcel *models.Parcel, raterID uint, direction string, input RatingInput, now time.Time) (*models.Rating, error) {
+	rating := models.Rating{
+		SenderID:  parcel.SenderID,
+		ParcelID:  parcel.ID,
+		Direction: direction,
+		Rating:    input.Rating,
+		CreatedAt: now,
+	}
+
+	switch direction {
+	case RateCourier:
+		if parcel.SenderID != raterID {
+			return nil, ErrRatingNotAllowed
+		}
+		if parcel.MotorbikeID == nil {
+			return nil, ErrRatingNotDelivered
+		}
+		rating.MotorbikeID = *parcel.MotorbikeID
+	case RateSender:
+		legs, err := ParcelLegs(tx, parcel.ID)
+		if err != nil {
+			return nil, err
+		}
+		if !containsUint(LegCouriers(legs), raterID) {
+			return nil, ErrRatingNotAllowed
+		}
+		rating.MotorbikeID = raterID
+	default:
+		return nil, ErrRatingNotAllowed
+	}
+
+	if parcel.Status != StatusDelivered || parcel.DeliveryTime == nil {
+		return nil, ErrRatingNotDelivered
+	}
+	if now.Sub(*parcel.DeliveryTime) > RatingWindow() {
+		return nil, ErrRatingClosed
+	}
+	if input.Rating < 1 || input.Rating > 5 {
+		return nil, ErrInvalidRating
+	}
+
+	rating.Tags = []string{}
+	for _, tag := range input.Tags {
+		tag = strings.TrimSpace(strings.ToLower(tag))
+		if !containsString(ratingTags[direction], tag) {
+			return nil, ErrInvalidRatingTag
+		}
+		if !containsString(rating.Tags, tag) {
+			rating.Tags = append(rating.Tags, tag)
+		}
+	}
+
+	comment := strings.TrimSpace(input.Comment)
+	if len(comment) > maxRatingCommentLength {
+		return nil, ErrRatingComment
+	}
+	if comment != "" {
+		status := ModerationPending
+		rating.Comment = &comment
+		rating.ModerationStatus = &status
+	}
+
+	var count int64
+	err := tx.Model(&models.Rating{}).
+		Where("parcel_id = ? AND direction = ? AND motorbike_id = ?", parcel.ID, direction, rating.MotorbikeID).
+		Count(&count).Error
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, ErrAlreadyRated
+	}
+
+	if err := tx.Create(&rating).Error; err != nil {
+		return nil, err
+	}
+	if direction == RateCourier {
+		if err := recordCourierRating(tx, &rating); err != nil {
+			return nil, err
+		}
+	}
+	return &rating, nil
+}
+
+// ModerateRating approves or hides the comment of a rating. Comments are shown until an admin hides them;
+// a hidden comment is only shown to admins, and the score itself still counts.
+func ModerateRating(tx *gorm.DB, ratingID interface{}, adminID uint, status string, now time.Time) (*models.Rating, error) {
+	var rating models.Rating
+	if err := tx.First(&rating, ratingID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrRatingNotFound
+		}
+		return nil, err
+	}
+	if rating.Comment == nil {
+		return nil, ErrRatingNotFound
+	}
+
+	rating.ModerationStatus = &status
+	rating.ModeratedBy = &adminID
+	rating.ModeratedAt = &now
+	err := tx.Model(&rating).Updates(map[string]interface{}{
+		"moderation_status": status,
+		"moderated_by":      adminID,
+		"moderated_at":      now,
+	}).Error
+	return &rating, err
+}
+
+// PublicRating removes the comment of a rating when an admin hid it
+func PublicRating(rating models.Rating) models.Rating {
+	if rating.ModerationStatus != nil && *rating.ModerationStatus == ModerationHidden {
+		rating.Comment = nil
+	}
+	return rating
+}
+
+func containsUint(values []uint, value uint) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
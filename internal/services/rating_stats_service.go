@@ -0,0 +1,248 @@
+package services
+
+import (
+	"fmt"
+	"go-delivery-app/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// defaultRatingMean is the prior used for scores before anyone was rated
+const defaultRatingMean = 3.0
+
+// RatingWindowStats are the ratings a courier received over a period
+type RatingWindowStats struct {
+	Ratings       int     `json:"ratings"`
+	AverageRating float64 `json:"average_rating"`
+	Score         float64 `json:"score"` // Average pulled towards the mean of every courier, see RatingPriorWeight
+}
+
+// CourierRatingSummary are the ratings senders gave a courier, over all time and the last 30 and 90 days
+type CourierRatingSummary struct {
+	CourierID       uint              `json:"courier_id"`
+	TotalRatings    int               `json:"total_ratings"`
+	AverageRating   float64           `json:"average_rating"`
+	Score           float64           `json:"score"`
+	RatingBreakdown map[int]int       `json:"rating_breakdown"` // E.g., 5-star: X, 4-star: Y
+	Last30Days      RatingWindowStats `json:"last_30_days"`
+	Last90Days      RatingWindowStats `json:"last_90_days"`
+}
+
+// LeaderboardEntry is a courier ranked by score over a period
+type LeaderboardEntry struct {
+	Rank          int     `json:"rank"`
+	CourierID     uint    `json:"courier_id"`
+	Name          string  `json:"name"`
+	Ratings       int     `json:"ratings"`
+	AverageRating float64 `json:"average_rating"`
+	Score         float64 `json:"score"`
+}
+
+// RatingPriorWeight is how many ratings at the mean of every courier each courier starts with when scored,
+// configured with RATING_PRIOR_WEIGHT, so that a new courier is not ranked by a single 5-star review
+func RatingPriorWeight() float64 {
+	return envFloat("RATING_PRIOR_WEIGHT", 10)
+}
+
+// bayesianScore is the average of the ratings after adding weight ratings at the given mean
+func bayesianScore(ratings, ratingSum int, mean, weight float64) float64 {
+	if weight+float64(ratings) == 0 {
+		return mean
+	}
+	return (weight*mean + float64(ratingSum)) / (weight + float64(ratings))
+}
+
+// recordCourierRating adds a rating a sender gave to the totals of its courier. It runs in the transaction
+// creating the rating so the totals never drift from the ratings table.
+func recordCourierRating(tx *gorm.DB, rating *models.Rating) error {
+	if rating.Rating < 1 || rating.Rating > 5 {
+		return ErrInvalidRating
+	}
+	stat := models.CourierRatingStat{
+		CourierID: rating.MotorbikeID,
+		Ratings:   1,
+		RatingSum: rating.Rating,
+		UpdatedAt: rating.CreatedAt,
+	}
+	switch rating.Rating {
+	case 1:
+		stat.Stars1 = 1
+	case 2:
+		stat.Stars2 = 1
+	case 3:
+		stat.Stars3 = 1
+	case 4:
+		stat.Stars4 = 1
+	case 5:
+		stat.Stars5 = 1
+	}
+	stars := fmt.Sprintf("stars_%d", rating.Rating)
+	err := tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "courier_id"}},
+		DoUpdates: clause.Set{
+			{Column: clause.Column{Name: "ratings"}, Value: gorm.Expr("courier_rating_stats.ratings + 1")},
+			{Column: clause.Column{Name: "rating_sum"}, Value: gorm.Expr("courier_rating_stats.rating_sum + EXCLUDED.rating_sum")},
+			{Column: clause.Column{Name: stars}, Value: gorm.Expr("courier_rating_stats." + stars + " + 1")},
+			{Column: clause.Column{Name: "updated_at"}, Value: stat.UpdatedAt},
+		},
+	}).Create(&stat).Error
+	if err != nil {
+		return err
+	}
+
+	day := models.CourierRatingDay{
+		CourierID: rating.MotorbikeID,
+		Day:       ratingDay(rating.CreatedAt),
+		Ratings:   1,
+		RatingSum: rating.Rating,
+	}
+	return tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "courier_id"}, {Name: "day"}},
+		DoUpdates: clause.Set{
+			{Column: clause.Column{Name: "ratings"}, Value: gorm.Expr("courier_rating_days.ratings + 1")},
+			{Column: clause.Column{Name: "rating_sum"}, Value: gorm.Expr("courier_rating_days.rating_sum + EXCLUDED.rating_sum")},
+		},
+	}).Create(&day).Error
+}
+
+// ratingDay is the day (UTC) a rating is counted on
+func ratingDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// windowStart is the first day of a rolling window of the given number of days ending today
+func windowStart(days int, now time.Time) time.Time {
+	return ratingDay(now).AddDate(0, 0, 1-days)
+}
+
+// ratingTotals are the number and sum of ratings over a period
+type ratingTotals struct {
+	Ratings   int
+	RatingSum int
+}
+
+func (t ratingTotals) mean() float64 {
+	if t.Ratings == 0 {
+		return defaultRatingMean
+	}
+	return float64(t.RatingSum) / float64(t.Ratings)
+}
+
+// ratingWindowTotals adds up the daily totals since a day, for a courier or for every courier when courierID is 0
+func ratingWindowTotals(tx *gorm.DB, courierID uint, since time.Time) (ratingTotals, error) {
+	var totals ratingTotals
+	query := tx.Model(&models.CourierRatingDay{}).
+		Select("COALESCE(SUM(ratings), 0) AS ratings, COALESCE(SUM(rating_sum), 0) AS rating_sum").
+		Where("day >= ?", since)
+	if courierID != 0 {
+		query = query.Where("courier_id = ?", courierID)
+	}
+	err := query.Scan(&totals).Error
+	return totals, err
+}
+
+// allTimeRatingTotals adds up the totals of every courier
+func allTimeRatingTotals(tx *gorm.DB) (ratingTotals, error) {
+	var totals ratingTotals
+	err := tx.Model(&models.CourierRatingStat{}).
+		Select("COALESCE(SUM(ratings), 0) AS ratings, COALESCE(SUM(rating_sum), 0) AS rating_sum").
+		Scan(&totals).Error
+	return totals, err
+}
+
+// ratingWindow scores a courier's ratings over the last days against the mean of every courier over them
+func ratingWindow(tx *gorm.DB, courierID uint, days int, now time.Time) (RatingWindowStats, error) {
+	since := windowStart(days, now)
+	own, err := ratingWindowTotals(tx, courierID, since)
+	if err != nil {
+		return RatingWindowStats{}, err
+	}
+	all, err := ratingWindowTotals(tx, 0, since)
+	if err != nil {
+		return RatingWindowStats{}, err
+	}
+	stats := RatingWindowStats{
+		Ratings: own.Ratings,
+		Score:   bayesianScore(own.Ratings, own.RatingSum, all.mean(), RatingPriorWeight()),
+	}
+	if own.Ratings > 0 {
+		stats.AverageRating = own.mean()
+	}
+	return stats, nil
+}
+
+// CourierRatings returns the rating summary of a courier from the maintained totals
+func CourierRatings(tx *gorm.DB, courierID uint, now time.Time) (*CourierRatingSummary, error) {
+	var stat models.CourierRatingStat
+	if err := tx.Where("courier_id = ?", courierID).Limit(1).Find(&stat).Error; err != nil {
+		return nil, err
+	}
+	all, err := allTimeRatingTotals(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := CourierRatingSummary{
+		CourierID:    courierID,
+		TotalRatings: stat.Ratings,
+		Score:        bayesianScore(stat.Ratings, stat.RatingSum, all.mean(), RatingPriorWeight()),
+		RatingBreakdown: map[int]int{
+			1: stat.Stars1, 2: stat.Stars2, 3: stat.Stars3, 4: stat.Stars4, 5: stat.Stars5,
+		},
+	}
+	if stat.Ratings > 0 {
+		summary.AverageRating = float64(stat.RatingSum) / float64(stat.Ratings)
+	}
+	if summary.Last30Days, err = ratingWindow(tx, courierID, 30, now); err != nil {
+		return nil, err
+	}
+	if summary.Last90Days, err = ratingWindow(tx, courierID, 90, now); err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
+
+// CourierLeaderboard ranks the motorbikes with at least minRatings ratings by score, over the last days
+// or over all time when days is 0
+func CourierLeaderboard(tx *gorm.DB, days, minRatings, limit int, now time.Time) ([]LeaderboardEntry, error) {
+	var (
+		totals ratingTotals
+		source *gorm.DB
+		err    error
+	)
+	if days == 0 {
+		totals, err = allTimeRatingTotals(tx)
+		source = tx.Model(&models.CourierRatingStat{}).Select("courier_id, ratings, rating_sum")
+	} else {
+		since := windowStart(days, now)
+		totals, err = ratingWindowTotals(tx, 0, since)
+		source = tx.Model(&models.CourierRatingDay{}).
+			Select("courier_id, SUM(ratings) AS ratings, SUM(rating_sum) AS rating_sum").
+			Where("day >= ?", since).
+			Group("courier_id")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	weight, mean := RatingPriorWeight(), totals.mean()
+	entries := []LeaderboardEntry{}
+	err = tx.Table("(?) AS t", source).
+		Select(`t.courier_id, u.name, t.ratings, t.rating_sum::FLOAT8 / t.ratings AS average_rating,
+			(?::FLOAT8 * ?::FLOAT8 + t.rating_sum) / (?::FLOAT8 + t.ratings) AS score`, weight, mean, weight).
+		Joins("JOIN users u ON u.id = t.courier_id").
+		Where("u.role = ? AND t.ratings >= ? AND t.ratings > 0", RoleMotorbike, minRatings).
+		Order("score DESC, t.ratings DESC, t.courier_id").
+		Limit(limit).
+		Scan(&entries).Error
+	if err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		entries[i].Rank = i + 1
+	}
+	return entries, nil
+}
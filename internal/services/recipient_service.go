@@ -0,0 +1,101 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"go-delivery-app/internal/models"
+	"go-delivery-app/internal/notifications"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PublicBaseURL is the address the public pages are served from, configured with PUBLIC_BASE_URL
+func PublicBaseURL() string {
+	return strings.TrimSuffix(envString("PUBLIC_BASE_URL", "http://localhost:8080"), "/")
+}
+
+// RecipientNearRadiusKm is how close the motorbike must be to the drop-off before the recipient
+// is told the parcel is near, configured with RECIPIENT_NEAR_RADIUS_METERS
+func RecipientNearRadiusKm() float64 {
+	return envFloat("RECIPIENT_NEAR_RADIUS_METERS", 1000) / 1000
+}
+
+// NewRecipientToken returns the secret used in the link sent to a parcel recipient
+func NewRecipientToken() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// RecipientLink is the page where a recipient can follow the parcel and leave delivery instructions
+func RecipientLink(parcel *models.Parcel) string {
+	if parcel.RecipientToken == nil {
+		return ""
+	}
+	return PublicBaseURL() + "/recipient/" + *parcel.RecipientToken
+}
+
+// NotifyRecipient sends a message to the recipient of a parcel, if they left any contact details
+func NotifyRecipient(parcel *models.Parcel, message string) {
+	if parcel.RecipientEmail == nil && parcel.RecipientPhone == nil {
+		return
+	}
+	if link := RecipientLink(parcel); link != "" {
+		message += " Follow your delivery and leave instructions at " + link
+	}
+	if err := notifications.PublishRecipientNotification("notifications_recipient_queue", parcel.ID, parcel.RecipientEmail, parcel.RecipientPhone, message); err != nil {
+		log.Printf("Failed to notify the recipient of parcel %d: %v", parcel.ID, err)
+	}
+}
+
+// RecordCourierLocation stores the position reported by a motorbike
+func RecordCourierLocation(tx *gorm.DB, courierID uint, lat, lng float64) (*models.CourierLocation, error) {
+	location := models.CourierLocation{
+		CourierID:  courierID,
+		Latitude:   lat,
+		Longitude:  lng,
+		RecordedAt: time.Now(),
+	}
+	if err := tx.Create(&location).Error; err != nil {
+		return nil, err
+	}
+	return &location, nil
+}
+
+// NotifyNearbyRecipients tells the recipients of the parcels a motorbike is carrying that their parcel
+// is near once the motorbike gets within the configured radius of the drop-off. Each recipient is told once.
+func NotifyNearbyRecipients(tx *gorm.DB, courierID uint, lat, lng float64) error {
+	var parcels []models.Parcel
+	err := tx.Where("motorbike_id = ? AND status IN ? AND recipient_near_notified_at IS NULL", courierID, []string{StatusPickedUp, StatusInTransit}).
+		Where("dropoff_latitude IS NOT NULL AND dropoff_longitude IS NOT NULL").
+		Where("NOT EXISTS (SELECT 1 FROM parcel_legs l WHERE l.parcel_id = parcels.id AND l.status = ? AND l.to_hub_id IS NOT NULL)", LegPickedUp).
+		Where("recipient_email IS NOT NULL OR recipient_phone IS NOT NULL").
+		Find(&parcels).Error
+	if err != nil {
+		return err
+	}
+
+	for i := range parcels {
+		parcel := &parcels[i]
+		if Haversine(lat, lng, *parcel.DropoffLatitude, *parcel.DropoffLongitude) > RecipientNearRadiusKm() {
+			continue
+		}
+
+		// Only the request that flips the flag sends the message
+		result := tx.Model(&models.Parcel{}).
+			Where("id = ? AND recipient_near_notified_at IS NULL", parcel.ID).
+			Update("recipient_near_notified_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 1 {
+			NotifyRecipient(parcel, "Your parcel is nearby and will be delivered soon.")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,372 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidRecurrence = errors.New("expression must be a 5-field cron expression or an RRULE")
+
+// maxRecurrenceSearchDays bounds the search for the next occurrence of an expression that rarely or never matches
+const maxRecurrenceSearchDays = 5 * 366
+
+// Recurrence is a parsed cron or RRULE expression, evaluated in a time zone
+type Recurrence struct {
+	minutes     []int // Sorted
+	hours       []int // Sorted
+	monthDays   map[int]bool
+	months      map[int]bool
+	weekDays    map[time.Weekday]bool
+	anyMonthDay bool
+	anyWeekDay  bool
+
+	// RRULE only
+	freq     string
+	interval int
+	start    time.Time // Anchor of INTERVAL, in the recurrence time zone
+	until    *time.Time
+	loc      *time.Location
+}
+
+// ParseRecurrence parses either a cron expression ("0 17 * * 1-5") or an RRULE
+// ("RRULE:FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR;BYHOUR=17;BYMINUTE=0"). Supported RRULE parts are
+// FREQ (DAILY, WEEKLY or MONTHLY), INTERVAL, BYDAY, BYMONTHDAY, BYMONTH, BYHOUR, BYMINUTE and UNTIL.
+// start anchors the RRULE INTERVAL and the defaults taken from DTSTART.
+func ParseRecurrence(expr string, loc *time.Location, start time.Time) (*Recurrence, error) {
+	expr = strings.TrimSpace(expr)
+	upper := strings.ToUpper(expr)
+	if strings.HasPrefix(upper, "RRULE:") || strings.HasPrefix(upper, "FREQ=") {
+		return parseRRule(strings.TrimPrefix(upper, "RRULE:"), loc, start.In(loc))
+	}
+	return parseCron(expr, loc)
+}
+
+// Next returns the first occurrence strictly after t, or the zero time when there is none
+func (r *Recurrence) Next(t time.Time) time.Time {
+	t = t.In(r.loc)
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, r.loc)
+	for i := 0; i < maxRecurrenceSearchDays; i++ {
+		if r.until != nil && day.After(*r.until) {
+			return time.Time{}
+		}
+		if r.matchesDay(day) {
+			for _, hour := range r.hours {
+				for _, minute := range r.minutes {
+					candidate := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, r.loc)
+					if candidate.Hour() != hour {
+						continue // Skipped by a daylight saving time change
+					}
+					if r.until != nil && candidate.After(*r.until) {
+						return time.Time{}
+					}
+					if candidate.After(t) {
+						return candidate
+					}
+				}
+			}
+		}
+		day = day.AddDate(0, 0, 1)
+	}
+	return time.Time{}
+}
+
+// Between returns the occurrences in (from, to], at most limit of them
+func (r *Recurrence) Between(from, to time.Time, limit int) []time.Time {
+	var occurrences []time.Time
+	for t := r.Next(from); !t.IsZero() && !t.After(to) && len(occurrences) < limit; t = r.Next(t) {
+		occurrences = append(occurrences, t)
+	}
+	return occurrences
+}
+
+// matchesDay reports whether occurrences can fall on the day
+func (r *Recurrence) matchesDay(day time.Time) bool {
+	if !r.months[int(day.Month())] {
+		return false
+	}
+
+	// Like cron, when both the day of month and the day of week are restricted, either may match
+	monthDay, weekDay := r.monthDays[day.Day()], r.weekDays[day.Weekday()]
+	var matches bool
+	switch {
+	case r.anyMonthDay && r.anyWeekDay:
+		matches = true
+	case r.anyMonthDay:
+		matches = weekDay
+	case r.anyWeekDay:
+		matches = monthDay
+	case r.freq != "":
+		matches = monthDay && weekDay
+	default:
+		matches = monthDay || weekDay
+	}
+	if !matches || r.interval <= 1 {
+		return matches
+	}
+
+	if day.Before(time.Date(r.start.Year(), r.start.Month(), r.start.Day(), 0, 0, 0, 0, r.loc)) {
+		return false
+	}
+	switch r.freq {
+	case "DAILY":
+		return daysBetween(r.start, day)%r.interval == 0
+	case "WEEKLY":
+		return daysBetween(startOfWeek(r.start), startOfWeek(day))/7%r.interval == 0
+	case "MONTHLY":
+		months := (day.Year()-r.start.Year())*12 + int(day.Month()) - int(r.start.Month())
+		return months%r.interval == 0
+	}
+	return true
+}
+
+func parseCron(expr string, loc *time.Location) (*Recurrence, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, ErrInvalidRecurrence
+	}
+	minutes, err := parseCronField(fields[0], 0, 59)
+	if err != nil {
+		return nil, err
+	}
+	hours, err := parseCronField(fields[1], 0, 23)
+	if err != nil {
+		return nil, err
+	}
+	monthDays, err := parseCronField(fields[2], 1, 31)
+	if err != nil {
+		return nil, err
+	}
+	months, err := parseCronField(fields[3], 1, 12)
+	if err != nil {
+		return nil, err
+	}
+	weekDays, err := parseCronField(fields[4], 0, 7)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Recurrence{
+		minutes:     minutes,
+		hours:       hours,
+		monthDays:   intSet(monthDays),
+		months:      intSet(months),
+		weekDays:    map[time.Weekday]bool{},
+		anyMonthDay: fields[2] == "*",
+		anyWeekDay:  fields[4] == "*",
+		loc:         loc,
+	}
+	for _, d := range weekDays {
+		r.weekDays[time.Weekday(d%7)] = true // 0 and 7 are both Sunday
+	}
+	return r, nil
+}
+
+// parseCronField parses a cron field made of "*", numbers, ranges ("1-5") and steps ("*/15", "0-30/10")
+func parseCronField(field string, min, max int) ([]int, error) {
+	seen := map[int]bool{}
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			s, err := strconv.Atoi(part[i+1:])
+			if err != nil || s <= 0 {
+				return nil, ErrInvalidRecurrence
+			}
+			step = s
+			part = part[:i]
+		}
+
+		lo, hi := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			a, err1 := strconv.Atoi(bounds[0])
+			b, err2 := strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil {
+				return nil, ErrInvalidRecurrence
+			}
+			lo, hi = a, b
+		default:
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return nil, ErrInvalidRecurrence
+			}
+			lo, hi = n, n
+			if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return nil, ErrInvalidRecurrence
+		}
+		for v := lo; v <= hi; v += step {
+			seen[v] = true
+		}
+	}
+	return sortedKeys(seen), nil
+}
+
+func parseRRule(rule string, loc *time.Location, start time.Time) (*Recurrence, error) {
+	r := &Recurrence{
+		minutes:     []int{start.Minute()},
+		hours:       []int{start.Hour()},
+		months:      intSet(rangeInts(1, 12)),
+		anyMonthDay: true,
+		anyWeekDay:  true,
+		interval:    1,
+		start:       start,
+		loc:         loc,
+	}
+
+	for _, part := range strings.Split(rule, ";") {
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, ErrInvalidRecurrence
+		}
+		key, value := kv[0], kv[1]
+		var err error
+		switch key {
+		case "FREQ":
+			r.freq = value
+		case "INTERVAL":
+			r.interval, err = strconv.Atoi(value)
+			if err == nil && r.interval < 1 {
+				err = ErrInvalidRecurrence
+			}
+		case "BYMINUTE":
+			r.minutes, err = parseIntList(value, 0, 59)
+		case "BYHOUR":
+			r.hours, err = parseIntList(value, 0, 23)
+		case "BYMONTHDAY":
+			var days []int
+			days, err = parseIntList(value, 1, 31)
+			r.monthDays, r.anyMonthDay = intSet(days), false
+		case "BYMONTH":
+			var months []int
+			months, err = parseIntList(value, 1, 12)
+			r.months = intSet(months)
+		case "BYDAY":
+			r.weekDays, err = parseWeekDays(value)
+			r.anyWeekDay = false
+		case "UNTIL":
+			var until time.Time
+			until, err = parseRRuleTime(value, loc)
+			r.until = &until
+		default:
+			err = fmt.Errorf("%w: unsupported RRULE part %s", ErrInvalidRecurrence, key)
+		}
+		if err != nil {
+			if errors.Is(err, ErrInvalidRecurrence) {
+				return nil, err
+			}
+			return nil, ErrInvalidRecurrence
+		}
+	}
+
+	// Like RFC 5545, the rule repeats on the day of DTSTART unless told otherwise
+	switch r.freq {
+	case "DAILY":
+	case "WEEKLY":
+		if r.anyWeekDay {
+			r.weekDays, r.anyWeekDay = map[time.Weekday]bool{start.Weekday(): true}, false
+		}
+	case "MONTHLY":
+		if r.anyMonthDay && r.anyWeekDay {
+			r.monthDays, r.anyMonthDay = map[int]bool{start.Day(): true}, false
+		}
+	default:
+		return nil, fmt.Errorf("%w: FREQ must be DAILY, WEEKLY or MONTHLY", ErrInvalidRecurrence)
+	}
+	return r, nil
+}
+
+func parseIntList(value string, min, max int) ([]int, error) {
+	seen := map[int]bool{}
+	for _, s := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < min || n > max {
+			return nil, ErrInvalidRecurrence
+		}
+		seen[n] = true
+	}
+	return sortedKeys(seen), nil
+}
+
+var rruleWeekDays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+func parseWeekDays(value string) (map[time.Weekday]bool, error) {
+	days := map[time.Weekday]bool{}
+	for _, s := range strings.Split(value, ",") {
+		day, ok := rruleWeekDays[s]
+		if !ok {
+			return nil, ErrInvalidRecurrence
+		}
+		days[day] = true
+	}
+	return days, nil
+}
+
+// parseRRuleTime parses an UNTIL value, either a date or a date-time in UTC or local time
+func parseRRuleTime(value string, loc *time.Location) (time.Time, error) {
+	for _, layout := range []string{"20060102T150405Z", "20060102T150405", "20060102"} {
+		l := loc
+		if strings.HasSuffix(layout, "Z") {
+			l = time.UTC
+		}
+		if t, err := time.ParseInLocation(layout, value, l); err == nil {
+			if layout == "20060102" {
+				t = t.AddDate(0, 0, 1).Add(-time.Second) // The whole day is included
+			}
+			return t, nil
+		}
+	}
+	return time.Time{}, ErrInvalidRecurrence
+}
+
+func intSet(values []int) map[int]bool {
+	set := make(map[int]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
+
+func sortedKeys(set map[int]bool) []int {
+	keys := make([]int, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+func rangeInts(lo, hi int) []int {
+	values := make([]int, 0, hi-lo+1)
+	for v := lo; v <= hi; v++ {
+		values = append(values, v)
+	}
+	return values
+}
+
+// daysBetween counts calendar days from a to b, ignoring daylight saving time changes
+func daysBetween(a, b time.Time) int {
+	da := time.Date(a.Year(), a.Month(), a.Day(), 0, 0, 0, 0, time.UTC)
+	db := time.Date(b.Year(), b.Month(), b.Day(), 0, 0, 0, 0, time.UTC)
+	return int(db.Sub(da).Hours() / 24)
+}
+
+// startOfWeek returns the Monday of the week of t
+func startOfWeek(t time.Time) time.Time {
+	offset := (int(t.Weekday()) + 6) % 7
+	return t.AddDate(0, 0, -offset)
+}
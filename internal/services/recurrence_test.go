@@ -0,0 +1,172 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func loadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("time zone %s is not available: %v", name, err)
+	}
+	return loc
+}
+
+func TestParseCronField(t *testing.T) {
+	tests := []struct {
+		field    string
+		min, max int
+		want     []int
+		err      error
+	}{
+		{"*", 0, 5, []int{0, 1, 2, 3, 4, 5}, nil},
+		{"7", 0, 59, []int{7}, nil},
+		{"1-3", 0, 59, []int{1, 2, 3}, nil},
+		{"*/15", 0, 59, []int{0, 15, 30, 45}, nil},
+		{"0-30/10", 0, 59, []int{0, 10, 20, 30}, nil},
+		{"5/20", 0, 59, []int{5, 25, 45}, nil},
+		{"1,3,1", 0, 59, []int{1, 3}, nil},
+		{"1-2,10-11", 1, 12, []int{1, 2, 10, 11}, nil},
+		{"60", 0, 59, nil, ErrInvalidRecurrence},
+		{"0", 1, 31, nil, ErrInvalidRecurrence},
+		{"5-1", 0, 59, nil, ErrInvalidRecurrence},
+		{"*/0", 0, 59, nil, ErrInvalidRecurrence},
+		{"*/x", 0, 59, nil, ErrInvalidRecurrence},
+		{"1-", 0, 59, nil, ErrInvalidRecurrence},
+		{"a", 0, 59, nil, ErrInvalidRecurrence},
+		{"", 0, 59, nil, ErrInvalidRecurrence},
+	}
+	for _, tt := range tests {
+		got, err := parseCronField(tt.field, tt.min, tt.max)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("parseCronField(%q, %d, %d) error = %v, want %v", tt.field, tt.min, tt.max, err, tt.err)
+			continue
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("parseCronField(%q, %d, %d) = %v, want %v", tt.field, tt.min, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestParseRecurrenceRejectsInvalidExpressions(t *testing.T) {
+	loc := loadLocation(t, "Europe/Paris")
+	start := time.Date(2026, 10, 19, 8, 0, 0, 0, loc)
+	for _, expr := range []string{
+		"",
+		"0 17 * *",
+		"0 17 * * 1-5 2026",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"RRULE:BYHOUR=9",
+		"RRULE:FREQ=YEARLY",
+		"RRULE:FREQ=DAILY;INTERVAL=0",
+		"RRULE:FREQ=DAILY;INTERVAL=x",
+		"RRULE:FREQ=WEEKLY;BYDAY=XX",
+		"RRULE:FREQ=DAILY;BYHOUR=24",
+		"RRULE:FREQ=DAILY;COUNT=3",
+		"RRULE:FREQ=DAILY;UNTIL=tomorrow",
+		"RRULE:FREQ",
+	} {
+		if _, err := ParseRecurrence(expr, loc, start); !errors.Is(err, ErrInvalidRecurrence) {
+			t.Errorf("ParseRecurrence(%q) error = %v, want %v", expr, err, ErrInvalidRecurrence)
+		}
+	}
+}
+
+func TestRecurrenceNext(t *testing.T) {
+	loc := loadLocation(t, "Europe/Paris")
+	at := func(year int, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, loc)
+	}
+	monday := at(2026, 10, 19, 10, 0)
+
+	tests := []struct {
+		name  string
+		expr  string
+		start time.Time
+		after time.Time
+		want  time.Time
+	}{
+		{"weekdays later today", "0 17 * * 1-5", monday, monday, at(2026, 10, 19, 17, 0)},
+		{"every quarter hour", "*/15 * * * *", monday, monday, at(2026, 10, 19, 10, 15)},
+		{"strictly after", "0 10 * * *", monday, monday, at(2026, 10, 20, 10, 0)},
+		{"weekdays tomorrow", "30 9 * * 1-5", monday, monday, at(2026, 10, 20, 9, 30)},
+		{"first of the month", "0 9 1 * *", monday, monday, at(2026, 11, 1, 9, 0)},
+		{"day of month or day of week", "0 9 13 * 5", monday, monday, at(2026, 10, 23, 9, 0)},
+		{"sunday as 0", "0 0 * * 0", monday, monday, at(2026, 10, 25, 0, 0)},
+		{"sunday as 7", "0 0 * * 7", monday, monday, at(2026, 10, 25, 0, 0)},
+		{"leap day", "0 12 29 2 *", monday, monday, at(2028, 2, 29, 12, 0)},
+		{"never", "0 0 31 2 *", monday, monday, time.Time{}},
+		{"skipped by daylight saving time", "30 2 * * *", monday, at(2027, 3, 27, 3, 0), at(2027, 3, 29, 2, 30)},
+		{"across the end of daylight saving time", "0 9 * * 1", monday, monday, at(2026, 10, 26, 9, 0)},
+		{"rrule weekly", "RRULE:FREQ=WEEKLY;BYDAY=MO,WE,FR;BYHOUR=17;BYMINUTE=0", monday, monday, at(2026, 10, 19, 17, 0)},
+		{"rrule lower case", "rrule:freq=weekly;byday=tu;byhour=8;byminute=5", monday, monday, at(2026, 10, 20, 8, 5)},
+		{"rrule without prefix", "FREQ=DAILY;BYHOUR=6", at(2026, 10, 19, 8, 30), monday, at(2026, 10, 20, 6, 30)},
+		{"rrule time from start", "RRULE:FREQ=DAILY", at(2026, 10, 19, 8, 0), monday, at(2026, 10, 20, 8, 0)},
+		{"rrule every other day", "RRULE:FREQ=DAILY;INTERVAL=2", at(2026, 10, 18, 8, 0), monday, at(2026, 10, 20, 8, 0)},
+		{"rrule every other week", "RRULE:FREQ=WEEKLY;INTERVAL=2", at(2026, 10, 12, 9, 0), monday, at(2026, 10, 26, 9, 0)},
+		{"rrule not before start", "RRULE:FREQ=DAILY;INTERVAL=2", at(2026, 11, 1, 8, 0), monday, at(2026, 11, 1, 8, 0)},
+		{"rrule monthly on the start day", "RRULE:FREQ=MONTHLY", at(2026, 1, 31, 8, 0), monday, at(2026, 10, 31, 8, 0)},
+		{"rrule every third month", "RRULE:FREQ=MONTHLY;INTERVAL=3;BYMONTHDAY=15", at(2026, 1, 15, 8, 0), monday, at(2027, 1, 15, 8, 0)},
+		{"rrule day of month and day of week", "RRULE:FREQ=MONTHLY;BYDAY=MO;BYMONTHDAY=1", at(2026, 10, 19, 8, 0), monday, at(2027, 2, 1, 8, 0)},
+		{"rrule by month", "RRULE:FREQ=DAILY;BYMONTH=12;BYHOUR=7;BYMINUTE=0", monday, monday, at(2026, 12, 1, 7, 0)},
+		{"rrule until", "RRULE:FREQ=DAILY;UNTIL=20261019T120000Z", at(2026, 10, 18, 8, 0), monday, time.Time{}},
+		{"rrule until a date", "RRULE:FREQ=DAILY;UNTIL=20261020", at(2026, 10, 18, 8, 0), monday, at(2026, 10, 20, 8, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := ParseRecurrence(tt.expr, loc, tt.start)
+			if err != nil {
+				t.Fatalf("ParseRecurrence(%q) error = %v", tt.expr, err)
+			}
+			if got := r.Next(tt.after); !got.Equal(tt.want) {
+				t.Errorf("Next(%v) = %v, want %v", tt.after, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecurrenceBetween(t *testing.T) {
+	loc := loadLocation(t, "Europe/Paris")
+	at := func(month time.Month, day, hour int) time.Time {
+		return time.Date(2026, month, day, hour, 0, 0, 0, loc)
+	}
+
+	tests := []struct {
+		name     string
+		expr     string
+		from, to time.Time
+		limit    int
+		want     []time.Time
+	}{
+		{"weekdays", "0 9 * * 1-5", at(10, 23, 0), at(10, 27, 23), 10, []time.Time{at(10, 23, 9), at(10, 26, 9), at(10, 27, 9)}},
+		{"limited", "0 9 * * 1-5", at(10, 23, 0), at(10, 27, 23), 2, []time.Time{at(10, 23, 9), at(10, 26, 9)}},
+		{"from is excluded and to is included", "0 9 * * *", at(10, 23, 9), at(10, 25, 9), 10, []time.Time{at(10, 24, 9), at(10, 25, 9)}},
+		{"until", "RRULE:FREQ=DAILY;BYHOUR=9;BYMINUTE=0;UNTIL=20261024", at(10, 22, 0), at(10, 31, 0), 10, []time.Time{at(10, 22, 9), at(10, 23, 9), at(10, 24, 9)}},
+		{"none", "0 9 1 1 *", at(10, 22, 0), at(10, 31, 0), 10, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := ParseRecurrence(tt.expr, loc, tt.from)
+			if err != nil {
+				t.Fatalf("ParseRecurrence(%q) error = %v", tt.expr, err)
+			}
+			got := r.Between(tt.from, tt.to, tt.limit)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Between() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if !got[i].Equal(tt.want[i]) {
+					t.Errorf("Between()[%d] = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
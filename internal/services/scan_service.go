@@ -0,0 +1,298 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"go-delivery-app/internal/models"
+	"go-delivery-app/internal/notifications"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Parcel statuses
+const (
+	StatusCreated   = "Created"
+	StatusPickedUp  = "Picked up"
+	StatusAtHub     = "At hub"
+	StatusInTransit = "In transit"
+	StatusAwaiting  = "Awaiting courier" // Released by its courier, waiting for another one
+	StatusDelivered = "Delivered"
+	StatusCanceled  = "Canceled"
+	StatusLost      = "Lost" // Marked lost by admin
+)
+
+// Scan types
+const (
+	ScanPickup   = "pickup"
+	ScanHubIn    = "hub-in"
+	ScanHubOut   = "hub-out"
+	ScanDelivery = "delivery"
+)
+
+// Parcel event types recorded by scans
+const (
+	EventArrivedAtHub = "arrived_at_hub"
+	EventDepartedHub  = "departed_hub"
+)
+
+// User roles allowed to scan parcels
+const (
+	RoleMotorbike = "motorbike"
+	RoleHub       = "hub"
+)
+
+var (
+	ErrParcelNotFound          = errors.New("parcel not found")
+	ErrUnknownScanType         = errors.New("scan_type must be one of pickup, hub-in, hub-out or delivery")
+	ErrScanNotAllowed          = errors.New("your role cannot perform this scan")
+	ErrNotAssignedCourier      = errors.New("parcel is assigned to another motorbike")
+	ErrCourierBusy             = errors.New("you have already picked up a parcel, deliver it before picking up another")
+	ErrCourierRequired         = errors.New("motorbike_id is required when hub staff hand a parcel over")
+	ErrCODConfirmationRequired = errors.New("cod_collected is required for cash on delivery parcels")
+	ErrInvalidCODAmount        = errors.New("cod_collected cannot be negative")
+	ErrNotCODParcel            = errors.New("cod_collected can only be given for cash on delivery parcels")
+)
+
+// TransitionError is returned when a scan does not apply to the current status of a parcel
+type TransitionError struct {
+	ScanType string
+	Status   string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("a %s scan is not allowed while the parcel is %s", e.ScanType, e.Status)
+}
+
+// Scan is a physical handling of a parcel, made by a motorbike or by hub staff
+type Scan struct {
+	Type                 string
+	ActorID              uint
+	ActorRole            string
+	Latitude             *float64
+	Longitude            *float64
+	MotorbikeID          *uint   // Motorbike receiving the parcel when hub staff scan it out
+	MotorbikeDescription *string // Note left by the motorbike at pickup
+	CODCollected         *int64  // Cash collected at delivery
+	AdminOverride        bool    // Admin forcing the transition: role, courier, pickup window and cash checks are skipped
+	Note                 *string // Reason recorded on the event, nullable
+}
+
+// ParcelClosed reports whether a parcel has reached a final status and can no longer move
+func ParcelClosed(parcel *models.Parcel) bool {
+	return parcel.Status == StatusDelivered || parcel.Status == StatusCanceled || parcel.Status == StatusLost
+}
+
+// LockParcel loads a parcel by ID and locks it until the transaction ends
+func LockParcel(tx *gorm.DB, id interface{}) (*models.Parcel, error) {
+	var parcel models.Parcel
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&parcel, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrParcelNotFound
+	}
+	return &parcel, err
+}
+
+// LockParcelByTrackingCode loads a parcel by tracking code and locks it until the transaction ends
+func LockParcelByTrackingCode(tx *gorm.DB, code string) (*models.Parcel, error) {
+	var parcel models.Parcel
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("tracking_code = ?", code).First(&parcel).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrParcelNotFound
+	}
+	return &parcel, err
+}
+
+// ApplyScan validates a scan against the current leg of the parcel, applies the transition with its
+// side effects (assignment, cash on delivery, payment settlement) and records the scan event.
+// Pickup and hub-out scans start the current leg, hub-in and delivery scans finish it.
+// Admin overrides go through the same transitions on behalf of the courier of the leg.
+// The parcel should be locked by the caller.
+func ApplyScan(tx *gorm.DB, parcel *models.Parcel, scan Scan) (*models.ParcelEvent, error) {
+	switch scan.Type {
+	case ScanPickup, ScanHubIn, ScanHubOut, ScanDelivery:
+	default:
+		return nil, ErrUnknownScanType
+	}
+	if !scan.AdminOverride && !canScan(scan) {
+		return nil, ErrScanNotAllowed
+	}
+
+	legs, err := ParcelLegs(tx, parcel.ID)
+	if err != nil {
+		return nil, err
+	}
+	leg := CurrentLeg(legs)
+	if leg == nil || !scanFitsLeg(scan.Type, leg) {
+		return nil, &TransitionError{ScanType: scan.Type, Status: parcel.Status}
+	}
+
+	distance, outside, err := checkGeofence(tx, parcel, leg, scan)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	eventType := ""
+	switch scan.Type {
+	case ScanPickup, ScanHubOut:
+		courierID := scan.ActorID
+		if scan.ActorRole == RoleHub || scan.AdminOverride {
+			if scan.MotorbikeID == nil {
+				return nil, ErrCourierRequired
+			}
+			courierID = *scan.MotorbikeID
+		}
+		if !scan.AdminOverride {
+			if opens := PickupOpensAt(parcel); leg.Sequence == 1 && opens != nil && now.Before(*opens) {
+				return nil, ErrBeforePickupWindow
+			}
+			if err := ensureCourierAvailable(tx, courierID); err != nil {
+				return nil, err
+			}
+		}
+		if err := startLeg(tx, leg, courierID, now); err != nil {
+			return nil, err
+		}
+		if parcel.PickupTime == nil {
+			parcel.PickupTime = &now
+		}
+		parcel.MotorbikeID = &courierID
+		if scan.MotorbikeDescription != nil {
+			parcel.MotorbikeDescription = scan.MotorbikeDescription
+		}
+		eventType = EventPickedUp
+		if leg.FromHubID != nil {
+			eventType = EventDepartedHub
+		}
+
+	case ScanHubIn:
+		if scan.ActorRole == RoleMotorbike && !scan.AdminOverride && !assignedTo(leg.MotorbikeID, scan.ActorID) {
+			return nil, ErrNotAssignedCourier
+		}
+		if err := finishLeg(tx, parcel, legs, leg, now); err != nil {
+			return nil, err
+		}
+		// The parcel waits at the hub for the courier of the next leg
+		parcel.MotorbikeID = nil
+		eventType = EventArrivedAtHub
+
+	case ScanDelivery:
+		if !scan.AdminOverride && !assignedTo(leg.MotorbikeID, scan.ActorID) {
+			return nil, ErrNotAssignedCourier
+		}
+		if !scan.AdminOverride && parcel.CODAmount != nil && *parcel.CODAmount > 0 && scan.CODCollected == nil {
+			return nil, ErrCODConfirmationRequired
+		}
+		if scan.CODCollected != nil {
+			if *scan.CODCollected < 0 {
+				return nil, ErrInvalidCODAmount
+			}
+			if parcel.CODAmount == nil || *parcel.CODAmount <= 0 {
+				return nil, ErrNotCODParcel
+			}
+			if err := RecordCODCollection(tx, parcel, *leg.MotorbikeID, *scan.CODCollected); err != nil {
+				return nil, err
+			}
+		}
+		if err := finishLeg(tx, parcel, legs, leg, now); err != nil {
+			return nil, err
+		}
+		parcel.DeliveryTime = &now
+		MarkLateness(parcel, now)
+		eventType = EventDelivered
+	}
+
+	parcel.Status = DeriveParcelStatus(legs)
+	if err := tx.Omit("Legs").Save(parcel).Error; err != nil {
+		return nil, err
+	}
+
+	event := models.ParcelEvent{
+		ParcelID:        parcel.ID,
+		EventType:       eventType,
+		Status:          parcel.Status,
+		ActorID:         &scan.ActorID,
+		ScanType:        &scan.Type,
+		Note:            scan.Note,
+		Latitude:        scan.Latitude,
+		Longitude:       scan.Longitude,
+		DistanceMeters:  distance,
+		OutsideGeofence: outside,
+		CreatedAt:       now,
+	}
+	if err := tx.Create(&event).Error; err != nil {
+		return nil, err
+	}
+
+	if parcel.Status == StatusDelivered {
+		if err := SettleDelivery(tx, parcel, LegCouriers(legs)); err != nil {
+			return nil, err
+		}
+	}
+	return &event, nil
+}
+
+// NotifyScan tells the people involved about a scan event once it has been committed
+func NotifyScan(parcel *models.Parcel, event *models.ParcelEvent) {
+	switch event.EventType {
+	case EventPickedUp:
+		notifications.PublishNotification("notifications_sender_queue", parcel.SenderID, "Your parcel has been picked up!")
+		notifications.PublishNotification("notifications_motorbike_queue", *parcel.MotorbikeID, "You have picked up a parcel!")
+		NotifyRecipient(parcel, "Your parcel has been picked up and is on its way.")
+	case EventArrivedAtHub:
+		notifications.PublishNotification("notifications_sender_queue", parcel.SenderID, "Your parcel has arrived at a sorting hub.")
+	case EventDepartedHub:
+		notifications.PublishNotification("notifications_sender_queue", parcel.SenderID, "Your parcel has left the sorting hub.")
+		notifications.PublishNotification("notifications_motorbike_queue", *parcel.MotorbikeID, "You have picked up a parcel!")
+	case EventDelivered:
+		notifications.PublishNotification("notifications_sender_queue", parcel.SenderID, "Your parcel has been delivered!")
+		notifications.PublishNotification("notifications_motorbike_queue", *parcel.MotorbikeID, "You have delivered a parcel!")
+	}
+}
+
+// scanFitsLeg reports whether a scan applies to the current leg of a parcel
+func scanFitsLeg(scanType string, leg *models.ParcelLeg) bool {
+	switch scanType {
+	case ScanPickup:
+		return leg.Status == LegReady
+	case ScanHubOut:
+		return leg.Status == LegReady && leg.FromHubID != nil
+	case ScanHubIn:
+		return leg.Status == LegPickedUp && leg.ToHubID != nil
+	case ScanDelivery:
+		return leg.Status == LegPickedUp && leg.ToHubID == nil
+	}
+	return false
+}
+
+// canScan reports whether the role of the actor may perform the scan
+func canScan(scan Scan) bool {
+	switch scan.Type {
+	case ScanPickup, ScanDelivery:
+		return scan.ActorRole == RoleMotorbike
+	default:
+		return scan.ActorRole == RoleMotorbike || scan.ActorRole == RoleHub
+	}
+}
+
+// ensureCourierAvailable checks that a motorbike is not already carrying a parcel nor blocked from picking up
+func ensureCourierAvailable(tx *gorm.DB, courierID uint) error {
+	var count int64
+	err := tx.Model(&models.ParcelLeg{}).
+		Where("motorbike_id = ? AND status = ?", courierID, LegPickedUp).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrCourierBusy
+	}
+	return ensureCourierNotBlocked(tx, courierID)
+}
+
+// assignedTo reports whether a leg or parcel is assigned to the motorbike
+func assignedTo(motorbikeID *uint, courierID uint) bool {
+	return motorbikeID != nil && *motorbikeID == courierID
+}
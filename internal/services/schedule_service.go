@@ -0,0 +1,321 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-delivery-app/internal/models"
+	"go-delivery-app/internal/notifications"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// scheduleExpandInterval is how often the background expander looks for schedules to expand
+	scheduleExpandInterval = 5 * time.Minute
+
+	// maxOccurrencesPerExpansion bounds the parcels created for one schedule in a single run
+	maxOccurrencesPerExpansion = 500
+
+	// MaxUpcomingOccurrences bounds the upcoming occurrences shown for a schedule
+	MaxUpcomingOccurrences = 50
+
+	minPickupWindowMinutes     = 15
+	maxPickupWindowMinutes     = 12 * 60
+	defaultPickupWindowMinutes = 60
+)
+
+var (
+	ErrScheduleNameRequired   = errors.New("name is required")
+	ErrNoUpcomingOccurrence   = errors.New("the expression has no upcoming occurrence")
+	ErrInvalidPickupWindow    = errors.New("pickup_window_minutes must be between 15 and 720")
+	ErrInvalidParcelTemplate  = errors.New("parcel must be a JSON object with the fields of a parcel")
+	ErrQuoteInTemplate        = errors.New("a schedule cannot use a quote, scheduled parcels are priced when they are created")
+	ErrOccurrenceAlreadyTaken = errors.New("a parcel was already created for this date, cancel it instead")
+)
+
+// ScheduleHorizon is how far ahead schedules are expanded into parcels
+func ScheduleHorizon() time.Duration {
+	return time.Duration(envInt("SCHEDULE_HORIZON_HOURS", 24)) * time.Hour
+}
+
+// ScheduleRecurrence parses the expression of a schedule in its time zone
+func ScheduleRecurrence(schedule *models.PickupSchedule) (*Recurrence, error) {
+	loc, err := time.LoadLocation(schedule.TimeZone)
+	if err != nil {
+		return nil, ErrUnknownTimeZone
+	}
+	return ParseRecurrence(schedule.Expression, loc, schedule.CreatedAt)
+}
+
+// ValidateSchedule checks a new or changed schedule, filling in the defaults. The parcel template goes
+// through the same validation as parcels created with POST /sender/parcel.
+func ValidateSchedule(tx *gorm.DB, schedule *models.PickupSchedule, now time.Time) error {
+	schedule.Name = strings.TrimSpace(schedule.Name)
+	if schedule.Name == "" {
+		return ErrScheduleNameRequired
+	}
+	if schedule.TimeZone == "" {
+		schedule.TimeZone = envLocation("PRICING_TIME_ZONE").String()
+	}
+	if schedule.PickupWindowMinutes == 0 {
+		schedule.PickupWindowMinutes = defaultPickupWindowMinutes
+	}
+	if schedule.PickupWindowMinutes < minPickupWindowMinutes || schedule.PickupWindowMinutes > maxPickupWindowMinutes {
+		return ErrInvalidPickupWindow
+	}
+
+	recurrence, err := ScheduleRecurrence(schedule)
+	if err != nil {
+		return err
+	}
+	if recurrence.Next(now).IsZero() {
+		return ErrNoUpcomingOccurrence
+	}
+
+	parcel, err := decodeTemplate(schedule.Template)
+	if err != nil {
+		return err
+	}
+	if parcel.QuoteID != nil {
+		return ErrQuoteInTemplate
+	}
+	if err := PrepareParcel(tx, parcel, schedule.SenderID, now); err != nil {
+		return err
+	}
+	if !HasRequiredFields(parcel) {
+		return ErrMissingParcelFields
+	}
+	// Every occurrence is priced from the template, so it must hold a valid quote request
+	_, err = parcelQuoteRequest(parcel)
+	return err
+}
+
+// UpcomingOccurrence is a future occurrence of a schedule
+type UpcomingOccurrence struct {
+	ScheduledFor time.Time `json:"scheduled_for"`
+	Local        string    `json:"local"` // In the time zone of the schedule
+	Skipped      bool      `json:"skipped"`
+	ParcelID     *uint     `json:"parcel_id"` // Set once the parcel has been created
+}
+
+// UpcomingOccurrences lists the next count occurrences of a schedule, with skipped dates and created parcels
+func UpcomingOccurrences(tx *gorm.DB, schedule *models.PickupSchedule, now time.Time, count int) ([]UpcomingOccurrence, error) {
+	recurrence, err := ScheduleRecurrence(schedule)
+	if err != nil {
+		return nil, err
+	}
+	skips, err := skippedDates(tx, schedule.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var expanded []models.PickupScheduleOccurrence
+	if err := tx.Where("schedule_id = ? AND scheduled_for > ?", schedule.ID, now).Find(&expanded).Error; err != nil {
+		return nil, err
+	}
+	parcels := make(map[int64]*uint, len(expanded))
+	for _, occurrence := range expanded {
+		parcels[occurrence.ScheduledFor.Unix()] = occurrence.ParcelID
+	}
+
+	loc, _ := time.LoadLocation(schedule.TimeZone)
+	upcoming := []UpcomingOccurrence{}
+	for t := recurrence.Next(now); !t.IsZero() && len(upcoming) < count; t = recurrence.Next(t) {
+		upcoming = append(upcoming, UpcomingOccurrence{
+			ScheduledFor: t.UTC(),
+			Local:        t.In(loc).Format(time.RFC3339),
+			Skipped:      skips[t.Format("2006-01-02")],
+			ParcelID:     parcels[t.Unix()],
+		})
+	}
+	return upcoming, nil
+}
+
+// SkipScheduleDate stops a schedule from creating a parcel on a day, given in its time zone
+func SkipScheduleDate(tx *gorm.DB, schedule *models.PickupSchedule, date time.Time) error {
+	var taken int64
+	err := tx.Model(&models.PickupScheduleOccurrence{}).
+		Where("schedule_id = ? AND parcel_id IS NOT NULL AND (scheduled_for AT TIME ZONE ?)::date = ?",
+			schedule.ID, schedule.TimeZone, date.Format("2006-01-02")).
+		Count(&taken).Error
+	if err != nil {
+		return err
+	}
+	if taken > 0 {
+		return ErrOccurrenceAlreadyTaken
+	}
+
+	skip := models.PickupScheduleSkip{ScheduleID: schedule.ID, Date: date, CreatedAt: time.Now()}
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&skip).Error
+}
+
+// RunScheduleExpander expands the active schedules into parcels in the background
+func RunScheduleExpander(db *gorm.DB) {
+	for {
+		if err := ExpandSchedules(db, time.Now()); err != nil {
+			log.Printf("Failed to expand pickup schedules: %v", err)
+		}
+		time.Sleep(scheduleExpandInterval)
+	}
+}
+
+// ExpandSchedules creates the parcels of every active schedule up to the horizon. Each schedule is
+// expanded in its own transaction and skipped when another instance is already expanding it.
+func ExpandSchedules(db *gorm.DB, now time.Time) error {
+	horizon := now.Add(ScheduleHorizon())
+
+	var ids []uint
+	err := db.Model(&models.PickupSchedule{}).
+		Where("NOT paused AND generated_until < ?", horizon).
+		Pluck("id", &ids).Error
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		var sent []scheduleNotification
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			sent, err = expandSchedule(tx, id, now, horizon)
+			return err
+		})
+		if err != nil {
+			log.Printf("Failed to expand pickup schedule %d: %v", id, err)
+			continue
+		}
+		for _, n := range sent {
+			notifications.PublishNotification("notifications_sender_queue", n.senderID, n.message)
+		}
+	}
+	return nil
+}
+
+type scheduleNotification struct {
+	senderID uint
+	message  string
+}
+
+// expandSchedule creates the parcels of one schedule between its last expansion and the horizon
+func expandSchedule(tx *gorm.DB, id uint, now, horizon time.Time) ([]scheduleNotification, error) {
+	var schedule models.PickupSchedule
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("id = ? AND NOT paused", id).First(&schedule).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	recurrence, err := ScheduleRecurrence(&schedule)
+	if err != nil {
+		return nil, err
+	}
+	skips, err := skippedDates(tx, schedule.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	from := schedule.GeneratedUntil
+	if from.Before(now) {
+		from = now
+	}
+	occurrences := recurrence.Between(from, horizon, maxOccurrencesPerExpansion)
+
+	var sent []scheduleNotification
+	for _, at := range occurrences {
+		local := at.Format("Mon 2 Jan 15:04")
+		if skips[at.Format("2006-01-02")] {
+			continue
+		}
+
+		occurrence := models.PickupScheduleOccurrence{ScheduleID: schedule.ID, ScheduledFor: at, CreatedAt: now}
+		var parcel *models.Parcel
+		err := tx.Transaction(func(tx *gorm.DB) error {
+			var err error
+			parcel, err = createScheduledParcel(tx, &schedule, at, now)
+			return err
+		})
+		if err != nil {
+			msg := err.Error()
+			occurrence.Error = &msg
+			sent = append(sent, scheduleNotification{schedule.SenderID,
+				fmt.Sprintf("Your scheduled pickup \"%s\" for %s could not be created: %s", schedule.Name, local, msg)})
+		} else {
+			occurrence.ParcelID = &parcel.ID
+			sent = append(sent, scheduleNotification{schedule.SenderID,
+				fmt.Sprintf("Your scheduled pickup \"%s\" for %s has been created", schedule.Name, local)})
+		}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&occurrence).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	// When the occurrences were capped, the next run carries on from the last one
+	schedule.GeneratedUntil = horizon
+	if len(occurrences) == maxOccurrencesPerExpansion {
+		schedule.GeneratedUntil = occurrences[len(occurrences)-1]
+	}
+	schedule.UpdatedAt = now
+	if err := tx.Save(&schedule).Error; err != nil {
+		return nil, err
+	}
+	return sent, nil
+}
+
+// createScheduledParcel creates the parcel of one occurrence, priced at the time of the pickup when the
+// template has what a quote needs
+func createScheduledParcel(tx *gorm.DB, schedule *models.PickupSchedule, at, now time.Time) (*models.Parcel, error) {
+	parcel, err := decodeTemplate(schedule.Template)
+	if err != nil {
+		return nil, err
+	}
+
+	end := at.Add(time.Duration(schedule.PickupWindowMinutes) * time.Minute)
+	parcel.PickupWindowStart = &at
+	parcel.PickupWindowEnd = &end
+	parcel.DeliveryWindowStart = nil
+	parcel.DeliveryWindowEnd = nil
+	parcel.TimeZone = &schedule.TimeZone
+	parcel.ExternalRef = nil // Would clash between occurrences
+
+	// Resolve the drop-off from the address book first, so the quote sees its coordinates
+	if err := PrepareParcel(tx, parcel, schedule.SenderID, now); err != nil {
+		return nil, err
+	}
+	if err := quoteParcel(tx, parcel, schedule.SenderID, at); err != nil {
+		return nil, err
+	}
+
+	if err := CreateParcel(tx, parcel, schedule.SenderID, now); err != nil {
+		return nil, err
+	}
+	return parcel, nil
+}
+
+// decodeTemplate reads the parcel template of a schedule
+func decodeTemplate(template json.RawMessage) (*models.Parcel, error) {
+	var parcel models.Parcel
+	if len(bytes.TrimSpace(template)) == 0 || json.Unmarshal(template, &parcel) != nil {
+		return nil, ErrInvalidParcelTemplate
+	}
+	return &parcel, nil
+}
+
+// skippedDates returns the skipped days of a schedule, keyed by YYYY-MM-DD
+func skippedDates(tx *gorm.DB, scheduleID uint) (map[string]bool, error) {
+	var skips []models.PickupScheduleSkip
+	if err := tx.Where("schedule_id = ?", scheduleID).Find(&skips).Error; err != nil {
+		return nil, err
+	}
+	dates := make(map[string]bool, len(skips))
+	for _, skip := range skips {
+		dates[skip.Date.Format("2006-01-02")] = true
+	}
+	return dates, nil
+}
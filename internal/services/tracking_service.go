@@ -0,0 +1,143 @@
+package services
+
+import (
+	"crypto/rand"
+	"errors"
+	"go-delivery-app/internal/models"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Parcel event types
+const (
+	EventCreated     = "created"
+	EventPickedUp    = "picked_up"
+	EventDelivered   = "delivered"
+	EventCanceled    = "canceled"
+	EventRescheduled = "rescheduled"
+)
+
+const (
+	trackingPrefix = "DL"
+	trackingLength = 9 // Random characters, followed by one check character
+
+	// Crockford's base32 alphabet leaves out I, L, O and U so codes are easy to read aloud
+	trackingAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+)
+
+var ErrInvalidTrackingCode = errors.New("invalid tracking code")
+
+// GenerateTrackingCode returns a random tracking code such as DLD493QMVAG8,
+// made of a prefix, nine random characters and a Luhn mod 32 check character
+func GenerateTrackingCode() (string, error) {
+	body := make([]byte, trackingLength)
+	max := big.NewInt(int64(len(trackingAlphabet)))
+	for i := range body {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		body[i] = trackingAlphabet[n.Int64()]
+	}
+	return trackingPrefix + string(body) + string(trackingCheckChar(string(body))), nil
+}
+
+// NormalizeTrackingCode cleans up a tracking code typed by a person and verifies its check character.
+// Dashes, spaces and case are ignored, and letters that look like digits are read as those digits.
+func NormalizeTrackingCode(code string) (string, error) {
+	code = strings.ToUpper(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	if !strings.HasPrefix(code, trackingPrefix) {
+		return "", ErrInvalidTrackingCode
+	}
+
+	code = strings.NewReplacer("O", "0", "I", "1", "L", "1").Replace(strings.TrimPrefix(code, trackingPrefix))
+	if len(code) != trackingLength+1 || strings.Trim(code, trackingAlphabet) != "" {
+		return "", ErrInvalidTrackingCode
+	}
+
+	body, check := code[:trackingLength], code[trackingLength]
+	if trackingCheckChar(body) != check {
+		return "", ErrInvalidTrackingCode
+	}
+	return trackingPrefix + code, nil
+}
+
+// trackingCheckChar computes the Luhn mod N check character of a code body
+func trackingCheckChar(body string) byte {
+	n := len(trackingAlphabet)
+	sum := 0
+	factor := 2
+	for i := len(body) - 1; i >= 0; i-- {
+		addend := factor * strings.IndexByte(trackingAlphabet, body[i])
+		sum += addend/n + addend%n
+		if factor == 2 {
+			factor = 1
+		} else {
+			factor = 2
+		}
+	}
+	return trackingAlphabet[(n-sum%n)%n]
+}
+
+// TrackingURL is the public tracking page of a parcel
+func TrackingURL(code string) string {
+	return PublicBaseURL() + "/track/" + code
+}
+
+// AssignTrackingCode gives a parcel a tracking code that is not used by any other parcel
+func AssignTrackingCode(tx *gorm.DB, parcel *models.Parcel) error {
+	for attempt := 0; attempt < 5; attempt++ {
+		code, err := GenerateTrackingCode()
+		if err != nil {
+			return err
+		}
+
+		var count int64
+		if err := tx.Model(&models.Parcel{}).Where("tracking_code = ?", code).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			parcel.TrackingCode = &code
+			return nil
+		}
+	}
+	return errors.New("could not generate a unique tracking code")
+}
+
+// BackfillTrackingCodes assigns tracking codes to parcels created before tracking codes existed
+func BackfillTrackingCodes(tx *gorm.DB) {
+	var parcels []models.Parcel
+	tx.Where("tracking_code IS NULL").Find(&parcels)
+
+	for i := range parcels {
+		if err := AssignTrackingCode(tx, &parcels[i]); err != nil {
+			log.Printf("Failed to generate a tracking code for parcel %d: %v", parcels[i].ID, err)
+			continue
+		}
+		tx.Model(&parcels[i]).Update("tracking_code", parcels[i].TrackingCode)
+	}
+
+	if len(parcels) > 0 {
+		log.Printf("Assigned tracking codes to %d parcels", len(parcels))
+	}
+}
+
+// RecordParcelEvent appends an event to the history of a parcel, using the parcel's current status
+func RecordParcelEvent(tx *gorm.DB, parcel *models.Parcel, eventType string, actorID *uint, note string) error {
+	event := models.ParcelEvent{
+		ParcelID:  parcel.ID,
+		EventType: eventType,
+		Status:    parcel.Status,
+		ActorID:   actorID,
+		CreatedAt: time.Now(),
+	}
+	if note != "" {
+		event.Note = &note
+	}
+	return tx.Create(&event).Error
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTrackingCheckChar(t *testing.T) {
+	tests := []struct {
+		body string
+		want byte
+	}{
+		{"000000000", '0'},
+		{"000000001", 'Y'},
+		{"D493QMVAG", '8'},
+		{"ZZZZZZZZZ", '9'},
+		{"123456789", 'T'},
+		{"ABCDEFGHJ", 'T'},
+		{"K7M2P9RX4", 'V'},
+	}
+	for _, tt := range tests {
+		if got := trackingCheckChar(tt.body); got != tt.want {
+			t.Errorf("trackingCheckChar(%q) = %q, want %q", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestTrackingCheckCharDetectsSingleSubstitutions(t *testing.T) {
+	body := "D493QMVAG"
+	check := trackingCheckChar(body)
+	for i := range body {
+		for _, c := range trackingAlphabet {
+			if byte(c) == body[i] {
+				continue
+			}
+			changed := body[:i] + string(c) + body[i+1:]
+			if trackingCheckChar(changed) == check {
+				t.Errorf("changing position %d of %s to %c keeps the check character %c", i, body, c, check)
+			}
+		}
+	}
+}
+
+func TestTrackingCheckCharDetectsAdjacentTranspositions(t *testing.T) {
+	for _, body := range []string{"D493QMVAG", "123456789", "K7M2P9RX4"} {
+		check := trackingCheckChar(body)
+		for i := 0; i+1 < len(body); i++ {
+			if body[i] == body[i+1] {
+				continue
+			}
+			swapped := body[:i] + string(body[i+1]) + string(body[i]) + body[i+2:]
+			if trackingCheckChar(swapped) == check {
+				t.Errorf("swapping positions %d and %d of %s keeps the check character %c", i, i+1, body, check)
+			}
+		}
+	}
+}
+
+func TestNormalizeTrackingCode(t *testing.T) {
+	tests := []struct {
+		name string
+		code string
+		want string
+		err  error
+	}{
+		{"valid", "DLD493QMVAG8", "DLD493QMVAG8", nil},
+		{"lower case with dashes and spaces", "dl-d493 qmva-g8", "DLD493QMVAG8", nil},
+		{"letters read as digits", "DLOOOOOOOOOO", "DL0000000000", nil},
+		{"I read as one", "DL00000000IY", "DL000000001Y", nil},
+		{"L read as one", "DL00000000LY", "DL000000001Y", nil},
+		{"wrong check character", "DLD493QMVAG9", "", ErrInvalidTrackingCode},
+		{"wrong prefix", "XXD493QMVAG8", "", ErrInvalidTrackingCode},
+		{"too short", "DLD493QMVA8", "", ErrInvalidTrackingCode},
+		{"too long", "DLD493QMVAGG8", "", ErrInvalidTrackingCode},
+		{"character outside the alphabet", "DLD493QMVUG8", "", ErrInvalidTrackingCode},
+		{"empty", "", "", ErrInvalidTrackingCode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizeTrackingCode(tt.code)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("NormalizeTrackingCode(%q) error = %v, want %v", tt.code, err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("NormalizeTrackingCode(%q) = %q, want %q", tt.code, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateTrackingCodeIsValid(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		code, err := GenerateTrackingCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(code) != len(trackingPrefix)+trackingLength+1 || !strings.HasPrefix(code, trackingPrefix) {
+			t.Fatalf("GenerateTrackingCode() = %q, malformed", code)
+		}
+		if normalized, err := NormalizeTrackingCode(code); err != nil || normalized != code {
+			t.Fatalf("NormalizeTrackingCode(%q) = %q, %v", code, normalized, err)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"errors"
+	"go-delivery-app/internal/models"
+	"time"
+)
+
+var (
+	ErrIncompleteWindow     = errors.New("a window needs both a start and an end")
+	ErrInvalidWindow        = errors.New("a window must end after it starts")
+	ErrWindowInPast         = errors.New("a window cannot end in the past")
+	ErrDeliveryBeforePickup = errors.New("the delivery window must end after the pickup window starts")
+	ErrUnknownTimeZone      = errors.New("unknown time_zone")
+	ErrBeforePickupWindow   = errors.New("the pickup window has not opened yet")
+)
+
+// Windows are the pickup and delivery windows chosen by a sender. Nil windows mean "as soon as possible".
+type Windows struct {
+	PickupStart   *time.Time `json:"pickup_window_start"`
+	PickupEnd     *time.Time `json:"pickup_window_end"`
+	DeliveryStart *time.Time `json:"delivery_window_start"`
+	DeliveryEnd   *time.Time `json:"delivery_window_end"`
+	TimeZone      *string    `json:"time_zone"`
+}
+
+// ParcelWindows returns the windows currently set on a parcel
+func ParcelWindows(parcel *models.Parcel) Windows {
+	return Windows{
+		PickupStart:   parcel.PickupWindowStart,
+		PickupEnd:     parcel.PickupWindowEnd,
+		DeliveryStart: parcel.DeliveryWindowStart,
+		DeliveryEnd:   parcel.DeliveryWindowEnd,
+		TimeZone:      parcel.TimeZone,
+	}
+}
+
+// PickupWindowLead is how long before the pickup window opens a parcel is shown to motorbikes
+func PickupWindowLead() time.Duration {
+	return time.Duration(envInt("PICKUP_WINDOW_LEAD_MINUTES", 30)) * time.Minute
+}
+
+// SetWindows validates the windows and stores them on the parcel in UTC.
+// Without a time zone, the operating time zone (PRICING_TIME_ZONE) is kept for display.
+func SetWindows(parcel *models.Parcel, windows Windows, now time.Time) error {
+	if err := validateWindow(windows.PickupStart, windows.PickupEnd, now); err != nil {
+		return err
+	}
+	if err := validateWindow(windows.DeliveryStart, windows.DeliveryEnd, now); err != nil {
+		return err
+	}
+	if windows.PickupStart != nil && windows.DeliveryEnd != nil && !windows.DeliveryEnd.After(*windows.PickupStart) {
+		return ErrDeliveryBeforePickup
+	}
+
+	zone := envLocation("PRICING_TIME_ZONE").String()
+	if windows.TimeZone != nil && *windows.TimeZone != "" {
+		loc, err := time.LoadLocation(*windows.TimeZone)
+		if err != nil {
+			return ErrUnknownTimeZone
+		}
+		zone = loc.String()
+	}
+
+	parcel.PickupWindowStart = utc(windows.PickupStart)
+	parcel.PickupWindowEnd = utc(windows.PickupEnd)
+	parcel.DeliveryWindowStart = utc(windows.DeliveryStart)
+	parcel.DeliveryWindowEnd = utc(windows.DeliveryEnd)
+	parcel.TimeZone = &zone
+	return nil
+}
+
+// PickupOpensAt returns when a parcel becomes visible to motorbikes, or nil when it can be picked up right away
+func PickupOpensAt(parcel *models.Parcel) *time.Time {
+	if parcel.PickupWindowStart == nil {
+		return nil
+	}
+	opens := parcel.PickupWindowStart.Add(-PickupWindowLead())
+	return &opens
+}
+
+// MarkLateness flags a parcel delivered after the end of its delivery window
+func MarkLateness(parcel *models.Parcel, deliveredAt time.Time) {
+	if parcel.DeliveryWindowEnd == nil {
+		return
+	}
+	late := deliveredAt.After(*parcel.DeliveryWindowEnd)
+	parcel.DeliveredLate = &late
+}
+
+// LocalTimes are the times of a parcel shown in the sender's time zone
+type LocalTimes struct {
+	TimeZone            string  `json:"time_zone"`
+	PickupWindowStart   *string `json:"pickup_window_start"`
+	PickupWindowEnd     *string `json:"pickup_window_end"`
+	DeliveryWindowStart *string `json:"delivery_window_start"`
+	DeliveryWindowEnd   *string `json:"delivery_window_end"`
+	PickupTime          *string `json:"pickup_time"`
+	DeliveryTime        *string `json:"delivery_time"`
+}
+
+// ParcelLocalTimes converts the times of a parcel to its time zone, UTC when it has none
+func ParcelLocalTimes(parcel *models.Parcel) LocalTimes {
+	loc := parcelLocation(parcel)
+	local := func(t *time.Time) *string {
+		if t == nil {
+			return nil
+		}
+		s := t.In(loc).Format(time.RFC3339)
+		return &s
+	}
+	return LocalTimes{
+		TimeZone:            loc.String(),
+		PickupWindowStart:   local(parcel.PickupWindowStart),
+		PickupWindowEnd:     local(parcel.PickupWindowEnd),
+		DeliveryWindowStart: local(parcel.DeliveryWindowStart),
+		DeliveryWindowEnd:   local(parcel.DeliveryWindowEnd),
+		PickupTime:          local(parcel.PickupTime),
+		DeliveryTime:        local(parcel.DeliveryTime),
+	}
+}
+
+// parcelLocation returns the time zone of a parcel, UTC when it has none
+func parcelLocation(parcel *models.Parcel) *time.Location {
+	if parcel.TimeZone != nil {
+		if loc, err := time.LoadLocation(*parcel.TimeZone); err == nil {
+			return loc
+		}
+	}
+	return time.UTC
+}
+
+// validateWindow checks a single window; both ends must be given together
+func validateWindow(start, end *time.Time, now time.Time) error {
+	switch {
+	case start == nil && end == nil:
+		return nil
+	case start == nil || end == nil:
+		return ErrIncompleteWindow
+	case !end.After(*start):
+		return ErrInvalidWindow
+	case !end.After(now):
+		return ErrWindowInPast
+	}
+	return nil
+}
+
+// utc returns a copy of t in UTC
+func utc(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	u := t.UTC()
+	return &u
+}
@@ -0,0 +1,227 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"go-delivery-app/internal/models"
+	"math"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidZoneGeometry = errors.New("geometry must be a GeoJSON Polygon or MultiPolygon, or a Feature holding one")
+	ErrOutsideServiceArea  = errors.New("we do not serve this area yet")
+	ErrZoneNotFound        = errors.New("service zone not found")
+	ErrZoneNameRequired    = errors.New("name is required")
+	ErrHomeZoneNotCourier  = errors.New("only motorbikes can have a home zone")
+)
+
+// polygon is a GeoJSON polygon: an outer ring followed by its holes, each a closed list of [longitude, latitude]
+type polygon [][][2]float64
+
+// geoJSON holds the parts of a GeoJSON object needed to read a zone
+type geoJSON struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+	Geometry    *geoJSON        `json:"geometry"`
+}
+
+// parseZoneGeometry reads the polygons of a zone from GeoJSON and returns the geometry to store,
+// with Features unwrapped
+func parseZoneGeometry(raw json.RawMessage) (json.RawMessage, []polygon, error) {
+	var geo geoJSON
+	if err := json.Unmarshal(raw, &geo); err != nil {
+		return nil, nil, ErrInvalidZoneGeometry
+	}
+	if geo.Type == "Feature" {
+		if geo.Geometry == nil {
+			return nil, nil, ErrInvalidZoneGeometry
+		}
+		geo = *geo.Geometry
+	}
+
+	var polygons []polygon
+	switch geo.Type {
+	case "Polygon":
+		var p polygon
+		if err := json.Unmarshal(geo.Coordinates, &p); err != nil {
+			return nil, nil, ErrInvalidZoneGeometry
+		}
+		polygons = []polygon{p}
+	case "MultiPolygon":
+		if err := json.Unmarshal(geo.Coordinates, &polygons); err != nil {
+			return nil, nil, ErrInvalidZoneGeometry
+		}
+	default:
+		return nil, nil, ErrInvalidZoneGeometry
+	}
+	if len(polygons) == 0 {
+		return nil, nil, ErrInvalidZoneGeometry
+	}
+	for _, p := range polygons {
+		if !validPolygon(p) {
+			return nil, nil, ErrInvalidZoneGeometry
+		}
+	}
+
+	geometry, err := json.Marshal(map[string]interface{}{"type": geo.Type, "coordinates": geo.Coordinates})
+	if err != nil {
+		return nil, nil, err
+	}
+	return geometry, polygons, nil
+}
+
+// validPolygon checks that every ring of a polygon is closed, has at least three corners and is on the map
+func validPolygon(p polygon) bool {
+	if len(p) == 0 {
+		return false
+	}
+	for _, ring := range p {
+		if len(ring) < 4 || ring[0] != ring[len(ring)-1] {
+			return false
+		}
+		for _, c := range ring {
+			if c[0] < -180 || c[0] > 180 || c[1] < -90 || c[1] > 90 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// zonePolygons reads the polygons of a stored zone
+func zonePolygons(zone *models.ServiceZone) []polygon {
+	_, polygons, err := parseZoneGeometry(zone.Geometry)
+	if err != nil {
+		return nil
+	}
+	return polygons
+}
+
+// PrepareZone validates the name and geometry of a zone and sets its bounding box
+func PrepareZone(zone *models.ServiceZone) error {
+	zone.Name = strings.TrimSpace(zone.Name)
+	if zone.Name == "" {
+		return ErrZoneNameRequired
+	}
+	geometry, polygons, err := parseZoneGeometry(zone.Geometry)
+	if err != nil {
+		return err
+	}
+	zone.Geometry = geometry
+
+	zone.MinLatitude, zone.MinLongitude = math.Inf(1), math.Inf(1)
+	zone.MaxLatitude, zone.MaxLongitude = math.Inf(-1), math.Inf(-1)
+	for _, p := range polygons {
+		for _, c := range p[0] {
+			zone.MinLongitude = math.Min(zone.MinLongitude, c[0])
+			zone.MaxLongitude = math.Max(zone.MaxLongitude, c[0])
+			zone.MinLatitude = math.Min(zone.MinLatitude, c[1])
+			zone.MaxLatitude = math.Max(zone.MaxLatitude, c[1])
+		}
+	}
+	return nil
+}
+
+// ZoneContains reports whether a point lies inside a zone. Points on the edge may fall either way.
+func ZoneContains(zone *models.ServiceZone, lat, lng float64) bool {
+	if lat < zone.MinLatitude || lat > zone.MaxLatitude || lng < zone.MinLongitude || lng > zone.MaxLongitude {
+		return false
+	}
+	for _, p := range zonePolygons(zone) {
+		if !ringContains(p[0], lat, lng) {
+			continue
+		}
+		inHole := false
+		for _, hole := range p[1:] {
+			if ringContains(hole, lat, lng) {
+				inHole = true
+				break
+			}
+		}
+		if !inHole {
+			return true
+		}
+	}
+	return false
+}
+
+// ringContains casts a ray from the point and counts how many edges of the ring it crosses
+func ringContains(ring [][2]float64, lat, lng float64) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		xi, yi := ring[i][0], ring[i][1]
+		xj, yj := ring[j][0], ring[j][1]
+		if (yi > lat) != (yj > lat) && lng < (xj-xi)*(lat-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// ActiveZones returns the zones currently served
+func ActiveZones(tx *gorm.DB) ([]models.ServiceZone, error) {
+	var zones []models.ServiceZone
+	err := tx.Where("active").Order("id").Find(&zones).Error
+	return zones, err
+}
+
+// ZoneAt returns the first active zone containing a point, or nil when the point is outside every zone
+func ZoneAt(zones []models.ServiceZone, lat, lng float64) *models.ServiceZone {
+	for i := range zones {
+		if ZoneContains(&zones[i], lat, lng) {
+			return &zones[i]
+		}
+	}
+	return nil
+}
+
+// CheckServiceArea refuses parcels picked up or dropped off outside every active zone.
+// Until admins define a zone, every location is served.
+func CheckServiceArea(tx *gorm.DB, parcel *models.Parcel) error {
+	zones, err := ActiveZones(tx)
+	if err != nil || len(zones) == 0 {
+		return err
+	}
+	if ZoneAt(zones, parcel.Latitude, parcel.Longitude) == nil {
+		return ErrOutsideServiceArea
+	}
+	if parcel.DropoffLatitude != nil && parcel.DropoffLongitude != nil &&
+		ZoneAt(zones, *parcel.DropoffLatitude, *parcel.DropoffLongitude) == nil {
+		return ErrOutsideServiceArea
+	}
+	return nil
+}
+
+// SetHomeZone assigns a motorbike to a zone, or frees it from any zone when zoneID is nil
+func SetHomeZone(tx *gorm.DB, courier *models.User, zoneID *uint) error {
+	if courier.Role != RoleMotorbike {
+		return ErrHomeZoneNotCourier
+	}
+	if zoneID != nil {
+		var zone models.ServiceZone
+		if err := tx.Where("id = ? AND active", *zoneID).Limit(1).Find(&zone).Error; err != nil {
+			return err
+		}
+		if zone.ID == 0 {
+			return ErrZoneNotFound
+		}
+	}
+	courier.HomeZoneID = zoneID
+	return tx.Model(courier).Update("home_zone_id", zoneID).Error
+}
+
+// LegsInZone keeps the legs that start inside a zone: at the pickup address, at a hub, or where a courier left them
+func LegsInZone(zone *models.ServiceZone, legs []models.ParcelLeg, parcels map[uint]models.Parcel) []models.ParcelLeg {
+	var kept []models.ParcelLeg
+	for _, leg := range legs {
+		parcel := parcels[leg.ParcelID]
+		start := legStart(&parcel, &leg)
+		if ZoneContains(zone, start.lat, start.lng) {
+			kept = append(kept, leg)
+		}
+	}
+	return kept
+}
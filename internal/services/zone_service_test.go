@@ -0,0 +1,211 @@
+package services
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"go-delivery-app/internal/models"
+	"testing"
+	"time"
+)
+
+// square is a closed ring of [longitude, latitude] with its corners at (lng0, lat0) and (lng1, lat1)
+func square(lng0, lat0, lng1, lat1 float64) [][2]float64 {
+	return [][2]float64{{lng0, lat0}, {lng1, lat0}, {lng1, lat1}, {lng0, lat1}, {lng0, lat0}}
+}
+
+func TestRingContains(t *testing.T) {
+	// An L shape, concave at (1, 1)
+	lShape := [][2]float64{{0, 0}, {2, 0}, {2, 1}, {1, 1}, {1, 2}, {0, 2}, {0, 0}}
+	triangle := [][2]float64{{0, 0}, {4, 0}, {2, 3}, {0, 0}}
+
+	tests := []struct {
+		name     string
+		ring     [][2]float64
+		lat, lng float64
+		want     bool
+	}{
+		{"inside a square", square(0, 0, 2, 2), 1, 1, true},
+		{"west of a square", square(0, 0, 2, 2), 1, -1, false},
+		{"east of a square", square(0, 0, 2, 2), 1, 3, false},
+		{"north of a square", square(0, 0, 2, 2), 3, 1, false},
+		{"level with a corner", square(0, 0, 2, 2), 2, -1, false},
+		{"inside an L", lShape, 0.5, 1.5, true},
+		{"in the notch of an L", lShape, 1.5, 1.5, false},
+		{"in the other arm of an L", lShape, 1.5, 0.5, true},
+		{"inside a triangle", triangle, 1, 2, true},
+		{"beside a triangle", triangle, 2.5, 0.5, false},
+		{"negative coordinates", square(-10, -20, -5, -15), -17, -7, true},
+		{"counter-clockwise ring", [][2]float64{{0, 0}, {0, 2}, {2, 2}, {2, 0}, {0, 0}}, 1, 1, true},
+	}
+	for _, tt := range tests {
+		if got := ringContains(tt.ring, tt.lat, tt.lng); got != tt.want {
+			t.Errorf("%s: ringContains(%v, %v) = %v, want %v", tt.name, tt.lat, tt.lng, got, tt.want)
+		}
+	}
+}
+
+func TestValidPolygon(t *testing.T) {
+	tests := []struct {
+		name string
+		p    polygon
+		want bool
+	}{
+		{"square", polygon{square(0, 0, 1, 1)}, true},
+		{"square with a hole", polygon{square(0, 0, 4, 4), square(1, 1, 2, 2)}, true},
+		{"no rings", polygon{}, false},
+		{"open ring", polygon{{{0, 0}, {1, 0}, {1, 1}, {0, 1}}}, false},
+		{"too few corners", polygon{{{0, 0}, {1, 0}, {0, 0}}}, false},
+		{"longitude off the map", polygon{square(179, 0, 181, 1)}, false},
+		{"latitude off the map", polygon{square(0, 89, 1, 91)}, false},
+		{"invalid hole", polygon{square(0, 0, 4, 4), {{1, 1}, {2, 1}, {2, 2}}}, false},
+	}
+	for _, tt := range tests {
+		if got := validPolygon(tt.p); got != tt.want {
+			t.Errorf("%s: validPolygon() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseZoneGeometry(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		polygons int
+		geometry string
+		err      error
+	}{
+		{
+			"polygon",
+			`{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,0]]]}`,
+			1, `{"coordinates":[[[0,0],[1,0],[1,1],[0,0]]],"type":"Polygon"}`, nil,
+		},
+		{
+			"multipolygon",
+			`{"type":"MultiPolygon","coordinates":[[[[0,0],[1,0],[1,1],[0,0]]],[[[5,5],[6,5],[6,6],[5,5]]]]}`,
+			2, `{"coordinates":[[[[0,0],[1,0],[1,1],[0,0]]],[[[5,5],[6,5],[6,6],[5,5]]]],"type":"MultiPolygon"}`, nil,
+		},
+		{
+			"feature is unwrapped",
+			`{"type":"Feature","properties":{"name":"Centre"},"geometry":{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,0]]]}}`,
+			1, `{"coordinates":[[[0,0],[1,0],[1,1],[0,0]]],"type":"Polygon"}`, nil,
+		},
+		{"feature without geometry", `{"type":"Feature"}`, 0, "", ErrInvalidZoneGeometry},
+		{"point", `{"type":"Point","coordinates":[0,0]}`, 0, "", ErrInvalidZoneGeometry},
+		{"empty multipolygon", `{"type":"MultiPolygon","coordinates":[]}`, 0, "", ErrInvalidZoneGeometry},
+		{"open ring", `{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,1]]]}`, 0, "", ErrInvalidZoneGeometry},
+		{"malformed coordinates", `{"type":"Polygon","coordinates":[0,0]}`, 0, "", ErrInvalidZoneGeometry},
+		{"not json", `polygon`, 0, "", ErrInvalidZoneGeometry},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			geometry, polygons, err := parseZoneGeometry(json.RawMessage(tt.raw))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("parseZoneGeometry() error = %v, want %v", err, tt.err)
+			}
+			if len(polygons) != tt.polygons {
+				t.Errorf("parseZoneGeometry() returned %d polygons, want %d", len(polygons), tt.polygons)
+			}
+			if string(geometry) != tt.geometry {
+				t.Errorf("parseZoneGeometry() geometry = %s, want %s", geometry, tt.geometry)
+			}
+		})
+	}
+}
+
+func TestZoneContains(t *testing.T) {
+	// Two squares, the first one with a hole in the middle
+	zone := models.ServiceZone{
+		Name: "Centre",
+		Geometry: json.RawMessage(`{"type":"MultiPolygon","coordinates":[
+			[[[0,0],[4,0],[4,4],[0,4],[0,0]],[[1,1],[3,1],[3,3],[1,3],[1,1]]],
+			[[[10,10],[12,10],[12,12],[10,12],[10,10]]]
+		]}`),
+	}
+	if err := PrepareZone(&zone); err != nil {
+		t.Fatal(err)
+	}
+	if zone.MinLongitude != 0 || zone.MaxLongitude != 12 || zone.MinLatitude != 0 || zone.MaxLatitude != 12 {
+		t.Fatalf("bounding box = %v, %v, %v, %v", zone.MinLongitude, zone.MinLatitude, zone.MaxLongitude, zone.MaxLatitude)
+	}
+
+	tests := []struct {
+		name     string
+		lat, lng float64
+		want     bool
+	}{
+		{"in the first polygon", 0.5, 0.5, true},
+		{"in the hole", 2, 2, false},
+		{"in the second polygon", 11, 11, true},
+		{"between the polygons", 7, 7, false},
+		{"outside the bounding box", 20, 20, false},
+		{"beside the hole", 2, 3.5, true},
+	}
+	for _, tt := range tests {
+		if got := ZoneContains(&zone, tt.lat, tt.lng); got != tt.want {
+			t.Errorf("%s: ZoneContains(%v, %v) = %v, want %v", tt.name, tt.lat, tt.lng, got, tt.want)
+		}
+	}
+
+	zones := []models.ServiceZone{{Name: "Empty", MinLatitude: 1, MaxLatitude: 0}, zone}
+	if got := ZoneAt(zones, 11, 11); got == nil || got.Name != "Centre" {
+		t.Errorf("ZoneAt(11, 11) = %v, want the Centre zone", got)
+	}
+	if got := ZoneAt(zones, 7, 7); got != nil {
+		t.Errorf("ZoneAt(7, 7) = %v, want nil", got)
+	}
+}
+
+func TestQuotesAndParcelsOutsideTheServiceArea(t *testing.T) {
+	now := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	zones := fakeTable{
+		columns: []string{"id", "name", "geometry", "min_latitude", "max_latitude", "min_longitude", "max_longitude", "active"},
+		rows: [][]driver.Value{
+			{int64(1), "Paris", []byte(`{"type":"Polygon","coordinates":[[[2,48],[3,48],[3,49],[2,49],[2,48]]]}`), 48.0, 49.0, 2.0, 3.0, true},
+		},
+	}
+	// A quote picked up in Paris and dropped off in Lyon, outside the zone
+	quotes := fakeTable{
+		columns: []string{"id", "sender_id", "pickup_latitude", "pickup_longitude", "dropoff_latitude", "dropoff_longitude", "weight_kg", "service_level", "price_cents", "expires_at", "parcel_id"},
+		rows: [][]driver.Value{
+			{int64(1), int64(3), 48.85, 2.35, 45.76, 4.83, 1.5, ServiceLevelStandard, int64(1200), now.Add(time.Hour), nil},
+		},
+	}
+	db := newFakeDB(t, map[string]fakeTable{
+		"service_zones": zones,
+		"quotes":        quotes,
+		"price_rules":   {columns: []string{"id"}},
+	})
+
+	// The coordinates of the request are in the zone, but the parcel takes those of its quote
+	quoteID := uint(1)
+	dropoffLat, dropoffLng := 48.86, 2.29
+	parcel := models.Parcel{
+		PickupAddress:    "1 Main St",
+		DropoffAddress:   "2 Side St",
+		Latitude:         48.85,
+		Longitude:        2.35,
+		DropoffLatitude:  &dropoffLat,
+		DropoffLongitude: &dropoffLng,
+		QuoteID:          &quoteID,
+	}
+	if err := CreateParcel(db, &parcel, 3, now); !errors.Is(err, ErrOutsideServiceArea) {
+		t.Errorf("CreateParcel() error = %v, want %v", err, ErrOutsideServiceArea)
+	}
+
+	tests := []struct {
+		name string
+		req  QuoteRequest
+		err  error
+	}{
+		{"pickup outside", QuoteRequest{PickupLatitude: 45.76, PickupLongitude: 4.83, DropoffLatitude: 48.86, DropoffLongitude: 2.29, WeightKg: 1}, ErrOutsideServiceArea},
+		{"drop-off outside", QuoteRequest{PickupLatitude: 48.85, PickupLongitude: 2.35, DropoffLatitude: 45.76, DropoffLongitude: 4.83, WeightKg: 1}, ErrOutsideServiceArea},
+		// Served, so the quote goes on to the price rules, of which there are none
+		{"both inside", QuoteRequest{PickupLatitude: 48.85, PickupLongitude: 2.35, DropoffLatitude: 48.86, DropoffLongitude: 2.29, WeightKg: 1}, ErrNoPriceRules},
+	}
+	for _, tt := range tests {
+		if _, err := CalculateQuote(db, 3, tt.req, now); !errors.Is(err, tt.err) {
+			t.Errorf("%s: CalculateQuote() error = %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS quotes;
+DROP TABLE IF EXISTS price_surges;
+DROP TABLE IF EXISTS price_size_tiers;
+DROP TABLE IF EXISTS price_rules;
@@ -0,0 +1,66 @@
+CREATE TABLE price_rules (
+    id SERIAL PRIMARY KEY,
+    version INT NOT NULL,
+    service_level VARCHAR(50) NOT NULL,
+    base_fare_cents BIGINT NOT NULL,
+    per_km_cents BIGINT NOT NULL,
+    min_fare_cents BIGINT NOT NULL DEFAULT 0,
+    effective_from TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (version, service_level)
+);
+
+CREATE TABLE price_size_tiers (
+    id SERIAL PRIMARY KEY,
+    version INT NOT NULL,
+    name VARCHAR(50) NOT NULL,
+    max_weight_kg DOUBLE PRECISION NOT NULL,
+    surcharge_cents BIGINT NOT NULL DEFAULT 0,
+    UNIQUE (version, name)
+);
+
+CREATE TABLE price_surges (
+    id SERIAL PRIMARY KEY,
+    version INT NOT NULL,
+    day_of_week INT NULL CHECK (day_of_week >= 0 AND day_of_week <= 6), -- NULL applies to every day, 0 is Sunday
+    start_hour INT NOT NULL CHECK (start_hour >= 0 AND start_hour <= 23),
+    end_hour INT NOT NULL CHECK (end_hour >= 1 AND end_hour <= 24), -- Exclusive
+    multiplier DOUBLE PRECISION NOT NULL CHECK (multiplier > 0)
+);
+
+CREATE TABLE quotes (
+    id SERIAL PRIMARY KEY,
+    sender_id INT NOT NULL,
+    pickup_latitude DOUBLE PRECISION NOT NULL,
+    pickup_longitude DOUBLE PRECISION NOT NULL,
+    dropoff_latitude DOUBLE PRECISION NOT NULL,
+    dropoff_longitude DOUBLE PRECISION NOT NULL,
+    weight_kg DOUBLE PRECISION NOT NULL,
+    size_tier VARCHAR(50) NOT NULL,
+    service_level VARCHAR(50) NOT NULL,
+    distance_km DOUBLE PRECISION NOT NULL,
+    surge_multiplier DOUBLE PRECISION NOT NULL DEFAULT 1,
+    price_cents BIGINT NOT NULL,
+    rule_version INT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    parcel_id INT NULL UNIQUE, -- Set once the quote has been used to create a parcel
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (sender_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (parcel_id) REFERENCES parcels(id) ON DELETE SET NULL
+);
+
+-- Initial price list
+INSERT INTO price_rules (version, service_level, base_fare_cents, per_km_cents, min_fare_cents) VALUES
+    (1, 'standard', 300, 80, 500),
+    (1, 'express', 600, 120, 900);
+
+INSERT INTO price_size_tiers (version, name, max_weight_kg, surcharge_cents) VALUES
+    (1, 'small', 2, 0),
+    (1, 'medium', 5, 150),
+    (1, 'large', 15, 400),
+    (1, 'xl', 30, 900);
+
+INSERT INTO price_surges (version, day_of_week, start_hour, end_hour, multiplier) VALUES
+    (1, NULL, 7, 9, 1.2),
+    (1, NULL, 17, 20, 1.3),
+    (1, NULL, 22, 24, 1.5),
+    (1, NULL, 0, 6, 1.5);
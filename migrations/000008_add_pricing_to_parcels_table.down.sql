@@ -0,0 +1,7 @@
+ALTER TABLE parcels
+DROP COLUMN quote_id,
+DROP COLUMN price_cents,
+DROP COLUMN service_level,
+DROP COLUMN weight_kg,
+DROP COLUMN dropoff_longitude,
+DROP COLUMN dropoff_latitude;
@@ -0,0 +1,7 @@
+ALTER TABLE parcels
+ADD COLUMN dropoff_latitude DOUBLE PRECISION NULL,
+ADD COLUMN dropoff_longitude DOUBLE PRECISION NULL,
+ADD COLUMN weight_kg DOUBLE PRECISION NULL,
+ADD COLUMN service_level VARCHAR(50) NULL,
+ADD COLUMN price_cents BIGINT NULL,
+ADD COLUMN quote_id INT NULL REFERENCES quotes(id) ON DELETE SET NULL;
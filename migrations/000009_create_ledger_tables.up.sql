@@ -0,0 +1,39 @@
+CREATE TABLE accounts (
+    id SERIAL PRIMARY KEY,
+    owner_id INT NULL, -- NULL for system accounts
+    type VARCHAR(50) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (owner_id) REFERENCES users(id) ON DELETE RESTRICT
+);
+
+CREATE UNIQUE INDEX accounts_owner_type_idx ON accounts (owner_id, type) WHERE owner_id IS NOT NULL;
+CREATE UNIQUE INDEX accounts_system_type_idx ON accounts (type) WHERE owner_id IS NULL;
+
+CREATE TABLE payout_batches (
+    id SERIAL PRIMARY KEY,
+    total_cents BIGINT NOT NULL DEFAULT 0,
+    courier_count INT NOT NULL DEFAULT 0,
+    created_by INT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (created_by) REFERENCES users(id)
+);
+
+-- Every transaction is a group of entries sharing a transaction_id whose amounts sum to zero
+CREATE TABLE entries (
+    id SERIAL PRIMARY KEY,
+    transaction_id VARCHAR(64) NOT NULL,
+    account_id INT NOT NULL,
+    amount_cents BIGINT NOT NULL, -- Positive amounts increase the account balance
+    kind VARCHAR(50) NOT NULL,
+    parcel_id INT NULL,
+    payout_batch_id INT NULL,
+    reference TEXT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (account_id) REFERENCES accounts(id) ON DELETE RESTRICT,
+    FOREIGN KEY (parcel_id) REFERENCES parcels(id) ON DELETE SET NULL,
+    FOREIGN KEY (payout_batch_id) REFERENCES payout_batches(id) ON DELETE RESTRICT
+);
+
+CREATE INDEX entries_account_created_idx ON entries (account_id, created_at);
+CREATE INDEX entries_transaction_idx ON entries (transaction_id);
+CREATE INDEX entries_parcel_idx ON entries (parcel_id);
@@ -0,0 +1,4 @@
+ALTER TABLE parcels
+DROP COLUMN cod_collected_at,
+DROP COLUMN cod_collected,
+DROP COLUMN cod_amount;
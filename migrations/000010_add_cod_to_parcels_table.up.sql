@@ -0,0 +1,4 @@
+ALTER TABLE parcels
+ADD COLUMN cod_amount BIGINT NULL CHECK (cod_amount >= 0), -- Cash to collect from the recipient, in cents
+ADD COLUMN cod_collected BIGINT NULL CHECK (cod_collected >= 0), -- Cash the courier confirmed collecting, in cents
+ADD COLUMN cod_collected_at TIMESTAMPTZ NULL;
@@ -0,0 +1,14 @@
+CREATE TABLE cash_hand_ins (
+    id SERIAL PRIMARY KEY,
+    courier_id INT NOT NULL,
+    amount_cents BIGINT NOT NULL CHECK (amount_cents >= 0), -- Cash counted at the hub
+    expected_cents BIGINT NOT NULL, -- Cash on hand according to the ledger
+    discrepancy_cents BIGINT NOT NULL, -- expected_cents - amount_cents, positive when cash is missing
+    note TEXT NULL,
+    recorded_by INT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (courier_id) REFERENCES users(id) ON DELETE RESTRICT,
+    FOREIGN KEY (recorded_by) REFERENCES users(id)
+);
+
+CREATE INDEX cash_hand_ins_courier_idx ON cash_hand_ins (courier_id, created_at);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS parcel_events;
+
+ALTER TABLE parcels
+DROP COLUMN created_at,
+DROP COLUMN tracking_code;
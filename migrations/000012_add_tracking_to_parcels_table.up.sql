@@ -0,0 +1,32 @@
+ALTER TABLE parcels
+ADD COLUMN tracking_code VARCHAR(20) NULL UNIQUE, -- Filled for existing parcels at startup
+ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+CREATE TABLE parcel_events (
+    id SERIAL PRIMARY KEY,
+    parcel_id INT NOT NULL,
+    event_type VARCHAR(50) NOT NULL,
+    status VARCHAR(255) NOT NULL, -- Parcel status after the event
+    actor_id INT NULL,
+    note TEXT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (parcel_id) REFERENCES parcels(id) ON DELETE CASCADE,
+    FOREIGN KEY (actor_id) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX parcel_events_parcel_idx ON parcel_events (parcel_id, created_at);
+
+-- Rebuild the history of existing parcels from their timestamps
+UPDATE parcels SET created_at = COALESCE(LEAST(pickup_time, delivery_time, canceled_at), created_at);
+
+INSERT INTO parcel_events (parcel_id, event_type, status, created_at)
+SELECT id, 'created', 'Created', created_at FROM parcels;
+
+INSERT INTO parcel_events (parcel_id, event_type, status, actor_id, created_at)
+SELECT id, 'picked_up', 'Picked up', motorbike_id, pickup_time FROM parcels WHERE pickup_time IS NOT NULL;
+
+INSERT INTO parcel_events (parcel_id, event_type, status, actor_id, created_at)
+SELECT id, 'delivered', 'Delivered', motorbike_id, delivery_time FROM parcels WHERE delivery_time IS NOT NULL;
+
+INSERT INTO parcel_events (parcel_id, event_type, status, created_at)
+SELECT id, 'canceled', 'Canceled', canceled_at FROM parcels WHERE canceled_at IS NOT NULL;
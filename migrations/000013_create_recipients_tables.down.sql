@@ -0,0 +1,13 @@
+DROP TABLE IF EXISTS recipient_notifications;
+DROP TABLE IF EXISTS courier_locations;
+
+ALTER TABLE parcels
+DROP COLUMN recipient_near_notified_at,
+DROP COLUMN recipient_token,
+DROP COLUMN delivery_instructions,
+DROP COLUMN recipient_email,
+DROP COLUMN recipient_phone,
+DROP COLUMN recipient_name,
+DROP COLUMN contact_id;
+
+DROP TABLE IF EXISTS contacts;
@@ -0,0 +1,47 @@
+CREATE TABLE contacts (
+    id SERIAL PRIMARY KEY,
+    sender_id INT NOT NULL,
+    name TEXT NOT NULL,
+    phone VARCHAR(50) NULL,
+    email TEXT NULL,
+    address TEXT NULL,
+    latitude DOUBLE PRECISION NULL,
+    longitude DOUBLE PRECISION NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (sender_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX contacts_sender_idx ON contacts (sender_id);
+
+ALTER TABLE parcels
+ADD COLUMN contact_id INT NULL REFERENCES contacts(id) ON DELETE SET NULL,
+ADD COLUMN recipient_name TEXT NULL,
+ADD COLUMN recipient_phone VARCHAR(50) NULL,
+ADD COLUMN recipient_email TEXT NULL,
+ADD COLUMN delivery_instructions TEXT NULL,
+ADD COLUMN recipient_token VARCHAR(64) NULL UNIQUE, -- Secret in the link sent to the recipient
+ADD COLUMN recipient_near_notified_at TIMESTAMPTZ NULL;
+
+CREATE TABLE courier_locations (
+    id SERIAL PRIMARY KEY,
+    courier_id INT NOT NULL,
+    latitude DOUBLE PRECISION NOT NULL,
+    longitude DOUBLE PRECISION NOT NULL,
+    recorded_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (courier_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX courier_locations_courier_idx ON courier_locations (courier_id, recorded_at);
+
+-- Outbox of messages for people who are not users, picked up by the e-mail/SMS gateway
+CREATE TABLE recipient_notifications (
+    id SERIAL PRIMARY KEY,
+    parcel_id INT NOT NULL,
+    email TEXT NULL,
+    phone VARCHAR(50) NULL,
+    message TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    sent_at TIMESTAMPTZ NULL,
+    FOREIGN KEY (parcel_id) REFERENCES parcels(id) ON DELETE CASCADE
+);
@@ -0,0 +1,4 @@
+ALTER TABLE parcel_events
+DROP COLUMN longitude,
+DROP COLUMN latitude,
+DROP COLUMN scan_type;
@@ -0,0 +1,4 @@
+ALTER TABLE parcel_events
+ADD COLUMN scan_type VARCHAR(50) NULL,
+ADD COLUMN latitude DOUBLE PRECISION NULL,
+ADD COLUMN longitude DOUBLE PRECISION NULL;
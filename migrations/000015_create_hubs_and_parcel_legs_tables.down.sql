@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS parcel_legs;
+DROP TABLE IF EXISTS hubs;
@@ -0,0 +1,42 @@
+CREATE TABLE hubs (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    address TEXT NOT NULL,
+    latitude DOUBLE PRECISION NOT NULL,
+    longitude DOUBLE PRECISION NOT NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- A parcel travels pickup -> hub -> ... -> drop-off, one leg per courier.
+-- A leg without from_hub_id starts at the pickup address, one without to_hub_id ends at the drop-off.
+CREATE TABLE parcel_legs (
+    id SERIAL PRIMARY KEY,
+    parcel_id INT NOT NULL,
+    sequence INT NOT NULL,
+    from_hub_id INT NULL REFERENCES hubs(id),
+    to_hub_id INT NULL REFERENCES hubs(id),
+    status VARCHAR(50) NOT NULL,
+    motorbike_id INT NULL,
+    pickup_time TIMESTAMPTZ NULL,
+    delivery_time TIMESTAMPTZ NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (parcel_id) REFERENCES parcels(id) ON DELETE CASCADE,
+    UNIQUE (parcel_id, sequence)
+);
+
+CREATE INDEX parcel_legs_status_idx ON parcel_legs (status);
+CREATE INDEX parcel_legs_motorbike_idx ON parcel_legs (motorbike_id, status);
+
+-- Existing parcels travel door to door on a single leg
+INSERT INTO parcel_legs (parcel_id, sequence, status, motorbike_id, pickup_time, delivery_time, created_at)
+SELECT id, 1,
+    CASE status
+        WHEN 'Created' THEN 'Ready'
+        WHEN 'At hub' THEN 'Ready'
+        WHEN 'In transit' THEN 'Picked up'
+        ELSE status
+    END,
+    motorbike_id, pickup_time, delivery_time, created_at
+FROM parcels;
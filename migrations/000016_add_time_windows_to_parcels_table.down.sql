@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS parcels_pickup_window_start_idx;
+
+ALTER TABLE parcels
+DROP COLUMN delivered_late,
+DROP COLUMN time_zone,
+DROP COLUMN delivery_window_end,
+DROP COLUMN delivery_window_start,
+DROP COLUMN pickup_window_end,
+DROP COLUMN pickup_window_start;
@@ -0,0 +1,10 @@
+-- Windows are stored in UTC; time_zone is the sender's zone, used to display them
+ALTER TABLE parcels
+ADD COLUMN pickup_window_start TIMESTAMPTZ NULL,
+ADD COLUMN pickup_window_end TIMESTAMPTZ NULL,
+ADD COLUMN delivery_window_start TIMESTAMPTZ NULL,
+ADD COLUMN delivery_window_end TIMESTAMPTZ NULL,
+ADD COLUMN time_zone VARCHAR(64) NULL,
+ADD COLUMN delivered_late BOOLEAN NULL;
+
+CREATE INDEX parcels_pickup_window_start_idx ON parcels (pickup_window_start);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS pickup_schedule_occurrences;
+DROP TABLE IF EXISTS pickup_schedule_skips;
+DROP TABLE IF EXISTS pickup_schedules;
@@ -0,0 +1,36 @@
+CREATE TABLE pickup_schedules (
+    id SERIAL PRIMARY KEY,
+    sender_id INT NOT NULL,
+    name TEXT NOT NULL,
+    expression TEXT NOT NULL, -- Cron expression or RRULE
+    time_zone VARCHAR(64) NOT NULL,
+    pickup_window_minutes INT NOT NULL DEFAULT 60,
+    template JSONB NOT NULL, -- Fields of the parcels to create, as sent to POST /sender/parcel
+    paused BOOLEAN NOT NULL DEFAULT FALSE,
+    generated_until TIMESTAMPTZ NOT NULL, -- Occurrences up to this moment have been expanded
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (sender_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX pickup_schedules_sender_idx ON pickup_schedules (sender_id);
+
+CREATE TABLE pickup_schedule_skips (
+    id SERIAL PRIMARY KEY,
+    schedule_id INT NOT NULL,
+    date DATE NOT NULL, -- Day in the time zone of the schedule
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (schedule_id) REFERENCES pickup_schedules(id) ON DELETE CASCADE,
+    UNIQUE (schedule_id, date)
+);
+
+CREATE TABLE pickup_schedule_occurrences (
+    id SERIAL PRIMARY KEY,
+    schedule_id INT NOT NULL,
+    scheduled_for TIMESTAMPTZ NOT NULL,
+    parcel_id INT NULL REFERENCES parcels(id) ON DELETE SET NULL,
+    error TEXT NULL, -- Why no parcel could be created
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (schedule_id) REFERENCES pickup_schedules(id) ON DELETE CASCADE,
+    UNIQUE (schedule_id, scheduled_for)
+);
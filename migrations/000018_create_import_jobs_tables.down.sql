@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS import_job_rows;
+DROP TABLE IF EXISTS import_jobs;
+
+DROP INDEX IF EXISTS parcels_sender_external_ref_idx;
+
+ALTER TABLE parcels
+DROP COLUMN external_ref;
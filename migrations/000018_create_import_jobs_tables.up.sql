@@ -0,0 +1,36 @@
+-- Client-supplied reference that makes parcel creation idempotent per sender
+ALTER TABLE parcels
+ADD COLUMN external_ref TEXT NULL;
+
+CREATE UNIQUE INDEX parcels_sender_external_ref_idx ON parcels (sender_id, external_ref) WHERE external_ref IS NOT NULL;
+
+CREATE TABLE import_jobs (
+    id SERIAL PRIMARY KEY,
+    sender_id INT NOT NULL,
+    format VARCHAR(10) NOT NULL, -- csv or ndjson
+    status VARCHAR(20) NOT NULL,
+    total_rows INT NOT NULL DEFAULT 0,
+    created_count INT NOT NULL DEFAULT 0,
+    duplicate_count INT NOT NULL DEFAULT 0,
+    failed_count INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    finished_at TIMESTAMPTZ NULL,
+    FOREIGN KEY (sender_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX import_jobs_sender_idx ON import_jobs (sender_id, created_at);
+
+CREATE TABLE import_job_rows (
+    id SERIAL PRIMARY KEY,
+    job_id INT NOT NULL,
+    row_number INT NOT NULL,
+    external_ref TEXT NULL,
+    payload JSONB NULL, -- Parcel fields of the row, null when the row could not be read
+    status VARCHAR(20) NOT NULL,
+    parcel_id INT NULL REFERENCES parcels(id) ON DELETE SET NULL,
+    error TEXT NULL,
+    FOREIGN KEY (job_id) REFERENCES import_jobs(id) ON DELETE CASCADE,
+    UNIQUE (job_id, row_number)
+);
+
+CREATE INDEX import_job_rows_pending_idx ON import_job_rows (job_id, row_number) WHERE status = 'pending';
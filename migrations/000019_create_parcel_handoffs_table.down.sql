@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS parcel_handoffs;
+
+ALTER TABLE parcel_legs
+DROP COLUMN released_longitude,
+DROP COLUMN released_latitude,
+DROP COLUMN released_at;
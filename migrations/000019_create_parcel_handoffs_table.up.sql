@@ -0,0 +1,24 @@
+-- A released leg waits for a new courier where the previous one left it
+ALTER TABLE parcel_legs
+ADD COLUMN released_at TIMESTAMPTZ NULL,
+ADD COLUMN released_latitude DOUBLE PRECISION NULL,
+ADD COLUMN released_longitude DOUBLE PRECISION NULL;
+
+CREATE TABLE parcel_handoffs (
+    id SERIAL PRIMARY KEY,
+    parcel_id INT NOT NULL,
+    parcel_leg_id INT NOT NULL,
+    from_motorbike_id INT NOT NULL REFERENCES users(id),
+    to_motorbike_id INT NOT NULL REFERENCES users(id),
+    status VARCHAR(20) NOT NULL, -- pending, accepted, declined, canceled or expired
+    note TEXT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    responded_at TIMESTAMPTZ NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (parcel_id) REFERENCES parcels(id) ON DELETE CASCADE,
+    FOREIGN KEY (parcel_leg_id) REFERENCES parcel_legs(id) ON DELETE CASCADE
+);
+
+-- A leg can only be offered to one courier at a time
+CREATE UNIQUE INDEX parcel_handoffs_pending_idx ON parcel_handoffs (parcel_leg_id) WHERE status = 'pending';
+CREATE INDEX parcel_handoffs_to_idx ON parcel_handoffs (to_motorbike_id, status);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS parcel_cancellations;
+
+ALTER TABLE parcels
+DROP COLUMN IF EXISTS cancel_reason;
@@ -0,0 +1,18 @@
+ALTER TABLE parcels
+ADD COLUMN cancel_reason VARCHAR(40) NULL;
+
+-- Who canceled a parcel and why, used to charge fees and to watch courier cancellation rates
+CREATE TABLE parcel_cancellations (
+    id SERIAL PRIMARY KEY,
+    parcel_id INT NOT NULL UNIQUE,
+    actor_id INT NOT NULL REFERENCES users(id),
+    actor_role VARCHAR(20) NOT NULL,
+    reason VARCHAR(40) NOT NULL,
+    note TEXT NULL,
+    fee_cents BIGINT NOT NULL DEFAULT 0,
+    distance_km DOUBLE PRECISION NULL, -- Travelled by the courier on the current leg
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (parcel_id) REFERENCES parcels(id) ON DELETE CASCADE
+);
+
+CREATE INDEX parcel_cancellations_actor_idx ON parcel_cancellations (actor_id, created_at);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS zone_speed_stats;
+
+ALTER TABLE parcels
+DROP COLUMN IF EXISTS announced_eta,
+DROP COLUMN IF EXISTS window_alerted_at;
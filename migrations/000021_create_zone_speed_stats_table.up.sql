@@ -0,0 +1,45 @@
+ALTER TABLE parcels
+ADD COLUMN announced_eta TIMESTAMPTZ NULL,
+ADD COLUMN window_alerted_at TIMESTAMPTZ NULL;
+
+-- Average speed of the legs started in a 0.05 degree grid cell at an hour of the day (UTC), used to estimate ETAs
+CREATE TABLE zone_speed_stats (
+    lat_cell INT NOT NULL,
+    lng_cell INT NOT NULL,
+    hour SMALLINT NOT NULL CHECK (hour BETWEEN 0 AND 23),
+    samples INT NOT NULL,
+    avg_speed_kmh DOUBLE PRECISION NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (lat_cell, lng_cell, hour)
+);
+
+-- Learn from the legs delivered so far
+WITH points AS (
+    SELECT l.pickup_time,
+           EXTRACT(EPOCH FROM l.delivery_time - l.pickup_time) / 3600 AS hours,
+           COALESCE(l.released_latitude, fh.latitude, p.latitude) AS from_lat,
+           COALESCE(l.released_longitude, fh.longitude, p.longitude) AS from_lng,
+           COALESCE(th.latitude, p.dropoff_latitude) AS to_lat,
+           COALESCE(th.longitude, p.dropoff_longitude) AS to_lng
+    FROM parcel_legs l
+    JOIN parcels p ON p.id = l.parcel_id
+    LEFT JOIN hubs fh ON fh.id = l.from_hub_id
+    LEFT JOIN hubs th ON th.id = l.to_hub_id
+    WHERE l.status = 'Delivered' AND l.pickup_time IS NOT NULL AND l.delivery_time > l.pickup_time
+),
+speeds AS (
+    SELECT FLOOR(from_lat / 0.05)::INT AS lat_cell,
+           FLOOR(from_lng / 0.05)::INT AS lng_cell,
+           EXTRACT(HOUR FROM pickup_time AT TIME ZONE 'UTC')::SMALLINT AS hour,
+           2 * 6371 * ASIN(SQRT(
+               POWER(SIN(RADIANS(to_lat - from_lat) / 2), 2) +
+               COS(RADIANS(from_lat)) * COS(RADIANS(to_lat)) * POWER(SIN(RADIANS(to_lng - from_lng) / 2), 2)
+           )) / hours AS speed_kmh
+    FROM points
+    WHERE to_lat IS NOT NULL AND to_lng IS NOT NULL
+)
+INSERT INTO zone_speed_stats (lat_cell, lng_cell, hour, samples, avg_speed_kmh)
+SELECT lat_cell, lng_cell, hour, COUNT(*), AVG(speed_kmh)
+FROM speeds
+WHERE speed_kmh BETWEEN 1 AND 120
+GROUP BY lat_cell, lng_cell, hour;
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS parcel_events_outside_geofence_idx;
+
+ALTER TABLE parcel_events
+DROP COLUMN IF EXISTS distance_meters,
+DROP COLUMN IF EXISTS outside_geofence;
@@ -0,0 +1,5 @@
+ALTER TABLE parcel_events
+ADD COLUMN distance_meters DOUBLE PRECISION NULL,
+ADD COLUMN outside_geofence BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX parcel_events_outside_geofence_idx ON parcel_events (created_at) WHERE outside_geofence;
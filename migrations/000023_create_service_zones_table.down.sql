@@ -0,0 +1,4 @@
+ALTER TABLE users
+DROP COLUMN IF EXISTS home_zone_id;
+
+DROP TABLE IF EXISTS service_zones;
@@ -0,0 +1,17 @@
+-- Areas we deliver in, drawn by admins as GeoJSON polygons. The bounding box skips most point-in-polygon checks.
+CREATE TABLE service_zones (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    geometry JSONB NOT NULL,
+    min_latitude DOUBLE PRECISION NOT NULL,
+    max_latitude DOUBLE PRECISION NOT NULL,
+    min_longitude DOUBLE PRECISION NOT NULL,
+    max_longitude DOUBLE PRECISION NOT NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Motorbikes only see the parcels of their home zone
+ALTER TABLE users
+ADD COLUMN home_zone_id INT NULL REFERENCES service_zones(id);
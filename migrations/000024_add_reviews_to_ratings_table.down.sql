@@ -0,0 +1,13 @@
+DROP INDEX IF EXISTS ratings_moderation_idx;
+DROP INDEX IF EXISTS ratings_parcel_direction_idx;
+
+DELETE FROM ratings WHERE direction <> 'courier';
+ALTER TABLE ratings ADD CONSTRAINT ratings_parcel_id_key UNIQUE (parcel_id);
+
+ALTER TABLE ratings
+DROP COLUMN IF EXISTS direction,
+DROP COLUMN IF EXISTS comment,
+DROP COLUMN IF EXISTS tags,
+DROP COLUMN IF EXISTS moderation_status,
+DROP COLUMN IF EXISTS moderated_by,
+DROP COLUMN IF EXISTS moderated_at;
@@ -0,0 +1,14 @@
+-- Senders rate couriers and couriers rate senders, with an optional comment reviewed by admins
+ALTER TABLE ratings
+ADD COLUMN direction VARCHAR(20) NOT NULL DEFAULT 'courier', -- Who is rated: the courier or the sender
+ADD COLUMN comment TEXT NULL,
+ADD COLUMN tags TEXT[] NOT NULL DEFAULT '{}',
+ADD COLUMN moderation_status VARCHAR(20) NULL, -- pending, approved or hidden; NULL without a comment
+ADD COLUMN moderated_by INT NULL REFERENCES users(id),
+ADD COLUMN moderated_at TIMESTAMPTZ NULL;
+
+-- Each courier of a parcel can rate its sender once, and the sender can rate the courier once
+ALTER TABLE ratings DROP CONSTRAINT IF EXISTS ratings_parcel_id_key;
+CREATE UNIQUE INDEX ratings_parcel_direction_idx ON ratings (parcel_id, direction, motorbike_id);
+
+CREATE INDEX ratings_moderation_idx ON ratings (created_at) WHERE moderation_status = 'pending';
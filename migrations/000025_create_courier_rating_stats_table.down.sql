@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS courier_rating_days;
+DROP TABLE IF EXISTS courier_rating_stats;
@@ -0,0 +1,40 @@
+-- Running totals of the ratings senders gave each courier, updated with every new rating
+CREATE TABLE courier_rating_stats (
+    courier_id INT PRIMARY KEY REFERENCES users(id),
+    ratings INT NOT NULL DEFAULT 0,
+    rating_sum INT NOT NULL DEFAULT 0,
+    stars_1 INT NOT NULL DEFAULT 0,
+    stars_2 INT NOT NULL DEFAULT 0,
+    stars_3 INT NOT NULL DEFAULT 0,
+    stars_4 INT NOT NULL DEFAULT 0,
+    stars_5 INT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- The same totals per day (UTC), summed over the last 30 or 90 days for the rolling windows
+CREATE TABLE courier_rating_days (
+    courier_id INT NOT NULL REFERENCES users(id),
+    day DATE NOT NULL,
+    ratings INT NOT NULL DEFAULT 0,
+    rating_sum INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (courier_id, day)
+);
+
+CREATE INDEX courier_rating_days_day_idx ON courier_rating_days (day);
+
+INSERT INTO courier_rating_stats (courier_id, ratings, rating_sum, stars_1, stars_2, stars_3, stars_4, stars_5)
+SELECT motorbike_id, COUNT(*), SUM(rating),
+       COUNT(*) FILTER (WHERE rating = 1),
+       COUNT(*) FILTER (WHERE rating = 2),
+       COUNT(*) FILTER (WHERE rating = 3),
+       COUNT(*) FILTER (WHERE rating = 4),
+       COUNT(*) FILTER (WHERE rating = 5)
+FROM ratings
+WHERE direction = 'courier'
+GROUP BY motorbike_id;
+
+INSERT INTO courier_rating_days (courier_id, day, ratings, rating_sum)
+SELECT motorbike_id, created_at::DATE, COUNT(*), SUM(rating)
+FROM ratings
+WHERE direction = 'courier'
+GROUP BY motorbike_id, created_at::DATE;
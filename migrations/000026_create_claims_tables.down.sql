@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS claim_updates;
+DROP TABLE IF EXISTS claim_attachments;
+DROP TABLE IF EXISTS claims;
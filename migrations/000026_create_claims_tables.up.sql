@@ -0,0 +1,48 @@
+-- Compensation requests for parcels delivered damaged, incomplete or not at all, reviewed by admins
+CREATE TABLE claims (
+    id SERIAL PRIMARY KEY,
+    parcel_id INT NOT NULL UNIQUE, -- One claim per parcel
+    sender_id INT NOT NULL REFERENCES users(id),
+    type VARCHAR(20) NOT NULL, -- damaged, lost, missing_items or other
+    description TEXT NOT NULL,
+    amount_cents BIGINT NULL CHECK (amount_cents >= 0), -- Compensation asked for by the sender
+    status VARCHAR(20) NOT NULL DEFAULT 'open', -- open, investigating, approved or rejected
+    payout_cents BIGINT NULL CHECK (payout_cents >= 0), -- Compensation paid into the sender's wallet on approval
+    resolution_note TEXT NULL,
+    reviewed_by INT NULL REFERENCES users(id),
+    resolved_at TIMESTAMPTZ NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (parcel_id) REFERENCES parcels(id) ON DELETE CASCADE
+);
+
+CREATE INDEX claims_status_idx ON claims (status, created_at);
+CREATE INDEX claims_sender_idx ON claims (sender_id, created_at);
+
+-- Photos and documents attached to a claim
+CREATE TABLE claim_attachments (
+    id SERIAL PRIMARY KEY,
+    claim_id INT NOT NULL REFERENCES claims(id) ON DELETE CASCADE,
+    uploaded_by INT NOT NULL REFERENCES users(id),
+    file_name TEXT NOT NULL,
+    content_type VARCHAR(100) NOT NULL,
+    size_bytes INT NOT NULL,
+    data BYTEA NOT NULL,
+    note TEXT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX claim_attachments_claim_idx ON claim_attachments (claim_id);
+
+-- Every status change of a claim, with who made it
+CREATE TABLE claim_updates (
+    id SERIAL PRIMARY KEY,
+    claim_id INT NOT NULL REFERENCES claims(id) ON DELETE CASCADE,
+    actor_id INT NOT NULL REFERENCES users(id),
+    status VARCHAR(20) NOT NULL,
+    payout_cents BIGINT NULL,
+    note TEXT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX claim_updates_claim_idx ON claim_updates (claim_id, created_at);
@@ -0,0 +1,25 @@
+-- A conversation between the sender of a parcel and the motorbike carrying it, open while the parcel is on its way
+CREATE TABLE conversations (
+    id SERIAL PRIMARY KEY,
+    parcel_id INT NOT NULL REFERENCES parcels(id) ON DELETE CASCADE,
+    sender_id INT NOT NULL REFERENCES users(id),
+    motorbike_id INT NOT NULL REFERENCES users(id),
+    status VARCHAR(20) NOT NULL DEFAULT 'open', -- open or closed
+    closed_at TIMESTAMPTZ NULL, -- Messages are deleted CHAT_RETENTION_DAYS after closure
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (parcel_id, motorbike_id)
+);
+
+CREATE INDEX conversations_open_idx ON conversations (parcel_id) WHERE status = 'open';
+CREATE INDEX conversations_closed_idx ON conversations (closed_at) WHERE status = 'closed';
+
+CREATE TABLE chat_messages (
+    id SERIAL PRIMARY KEY,
+    conversation_id INT NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    author_id INT NOT NULL REFERENCES users(id),
+    body TEXT NOT NULL,
+    read_at TIMESTAMPTZ NULL, -- When the other participant read it
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX chat_messages_conversation_idx ON chat_messages (conversation_id, id);
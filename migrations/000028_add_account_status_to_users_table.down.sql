@@ -0,0 +1,12 @@
+DROP INDEX IF EXISTS users_deleted_at_idx;
+DROP INDEX IF EXISTS users_password_reset_token_idx;
+
+ALTER TABLE users
+DROP COLUMN IF EXISTS status,
+DROP COLUMN IF EXISTS suspended_at,
+DROP COLUMN IF EXISTS suspension_reason,
+DROP COLUMN IF EXISTS password_reset_required,
+DROP COLUMN IF EXISTS password_reset_token_hash,
+DROP COLUMN IF EXISTS password_reset_expires_at,
+DROP COLUMN IF EXISTS tokens_valid_after,
+DROP COLUMN IF EXISTS deleted_at;
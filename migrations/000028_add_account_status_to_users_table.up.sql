@@ -0,0 +1,12 @@
+ALTER TABLE users
+ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'active', -- active or suspended
+ADD COLUMN suspended_at TIMESTAMPTZ NULL,
+ADD COLUMN suspension_reason TEXT NULL,
+ADD COLUMN password_reset_required BOOLEAN NOT NULL DEFAULT FALSE,
+ADD COLUMN password_reset_token_hash VARCHAR(64) NULL, -- SHA-256 of the one-time reset token
+ADD COLUMN password_reset_expires_at TIMESTAMPTZ NULL,
+ADD COLUMN tokens_valid_after TIMESTAMPTZ NULL, -- Tokens issued earlier are refused
+ADD COLUMN deleted_at TIMESTAMPTZ NULL; -- Soft delete, the user's parcels and ledger entries are kept
+
+CREATE UNIQUE INDEX users_password_reset_token_idx ON users (password_reset_token_hash) WHERE password_reset_token_hash IS NOT NULL;
+CREATE INDEX users_deleted_at_idx ON users (deleted_at);
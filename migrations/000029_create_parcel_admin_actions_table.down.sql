@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS parcel_admin_actions;
+
+ALTER TABLE parcels
+DROP COLUMN IF EXISTS lost_at;
@@ -0,0 +1,19 @@
+ALTER TABLE parcels
+ADD COLUMN lost_at TIMESTAMP NULL; -- Set when admin marks the parcel lost
+
+-- Every action taken by an admin on a parcel, with the reason given and what it changed
+CREATE TABLE parcel_admin_actions (
+    id SERIAL PRIMARY KEY,
+    parcel_id INT NOT NULL REFERENCES parcels(id) ON DELETE CASCADE,
+    admin_id INT NOT NULL REFERENCES users(id),
+    action VARCHAR(20) NOT NULL, -- assign, status, note or refund
+    note TEXT NOT NULL, -- Reason for the action, or the text of an internal note
+    status_before VARCHAR(50) NOT NULL,
+    status_after VARCHAR(50) NOT NULL,
+    motorbike_before INT NULL,
+    motorbike_after INT NULL,
+    amount_cents BIGINT NULL, -- Amount refunded
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX parcel_admin_actions_parcel_idx ON parcel_admin_actions (parcel_id, created_at);
@@ -0,0 +1,37 @@
+-- Security-relevant actions: who did what, to what, from where. Each entry carries the hash of the
+-- previous one, so changing or removing an entry breaks the chain.
+CREATE TABLE audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    actor_id INT NULL, -- No foreign key, entries outlive the rows they mention
+    actor_role VARCHAR(20) NULL,
+    action VARCHAR(50) NOT NULL, -- For example auth.login or parcel.cancel
+    target_type VARCHAR(30) NULL, -- user or parcel
+    target_id VARCHAR(100) NULL,
+    ip VARCHAR(45) NULL,
+    user_agent TEXT NULL,
+    before JSON NULL, -- JSON keeps the text as written, which the hash is computed on
+    after JSON NULL,
+    prev_hash CHAR(64) NOT NULL,
+    hash CHAR(64) NOT NULL UNIQUE,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX audit_log_created_at_idx ON audit_log (created_at);
+CREATE INDEX audit_log_actor_idx ON audit_log (actor_id, created_at);
+CREATE INDEX audit_log_target_idx ON audit_log (target_type, target_id, created_at);
+CREATE INDEX audit_log_action_idx ON audit_log (action, created_at);
+
+-- The log is append-only: updates, deletes and truncation are refused
+CREATE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_log_no_update_delete
+BEFORE UPDATE OR DELETE ON audit_log
+FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+
+CREATE TRIGGER audit_log_no_truncate
+BEFORE TRUNCATE ON audit_log
+FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only();
@@ -0,0 +1,13 @@
+-- Outbox of messages for users who cannot log in to read them, such as password reset tokens,
+-- picked up by the e-mail/SMS gateway
+CREATE TABLE account_notifications (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL,
+    email TEXT NOT NULL,
+    message TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    sent_at TIMESTAMPTZ NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX account_notifications_unsent_idx ON account_notifications (created_at) WHERE sent_at IS NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE cash_hand_ins
+DROP COLUMN shortfall_cents;
@@ -0,0 +1,12 @@
+-- Cash missing at a hand-in that was not already missing at the courier's previous one,
+-- so that a shortfall carried over between hand-ins is only counted once
+ALTER TABLE cash_hand_ins
+ADD COLUMN shortfall_cents BIGINT NOT NULL DEFAULT 0;
+
+UPDATE cash_hand_ins h
+SET shortfall_cents = t.shortfall_cents
+FROM (
+    SELECT id, discrepancy_cents - COALESCE(LAG(discrepancy_cents) OVER (PARTITION BY courier_id ORDER BY id), 0) AS shortfall_cents
+    FROM cash_hand_ins
+) t
+WHERE t.id = h.id;
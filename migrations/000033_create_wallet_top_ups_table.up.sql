@@ -0,0 +1,17 @@
+-- Wallet top-ups are recorded before the payment provider is charged, so that a charge is never lost
+-- when crediting the wallet fails
+CREATE TABLE wallet_top_ups (
+    id SERIAL PRIMARY KEY,
+    sender_id INT NOT NULL,
+    amount_cents BIGINT NOT NULL CHECK (amount_cents > 0),
+    idempotency_key VARCHAR(100) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending', -- pending, declined, failed, charged, credited, refunded or needs_review
+    provider_reference TEXT NULL,
+    error TEXT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (sender_id) REFERENCES users(id) ON DELETE RESTRICT,
+    UNIQUE (sender_id, idempotency_key)
+);
+
+CREATE INDEX wallet_top_ups_review_idx ON wallet_top_ups (updated_at) WHERE status IN ('failed', 'charged', 'needs_review');
@@ -0,0 +1,2 @@
+ALTER TABLE parcels
+ALTER COLUMN lost_at TYPE TIMESTAMP;
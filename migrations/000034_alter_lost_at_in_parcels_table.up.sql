@@ -0,0 +1,3 @@
+-- Store when a parcel was marked lost with its time zone
+ALTER TABLE parcels
+ALTER COLUMN lost_at TYPE TIMESTAMPTZ;